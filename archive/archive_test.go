@@ -0,0 +1,131 @@
+package archive_test
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/archive"
+	"github.com/dasdy/glover/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvents() []model.KeyEventWithTimestamp {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 123_000_000, time.UTC)
+
+	return []model.KeyEventWithTimestamp{
+		{Row: 1, Col: 2, Position: 3, Pressed: true, Timestamp: start},
+		{Row: 1, Col: 2, Position: 3, Pressed: false, Timestamp: start.Add(50 * time.Millisecond)},
+		{Row: 4, Col: 5, Position: 60, Pressed: true, Timestamp: start.Add(time.Hour), Source: "laptop", Keyboard: "glove80"},
+	}
+}
+
+func readAll(t *testing.T, data []byte, format archive.Format) ([]model.KeyEventWithTimestamp, error) {
+	t.Helper()
+
+	result := make([]model.KeyEventWithTimestamp, 0)
+
+	for e, err := range archive.Read(bytes.NewReader(data), format) {
+		if err != nil {
+			return result, err
+		}
+
+		result = append(result, e)
+	}
+
+	return result, nil
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []archive.Format{archive.FormatCSV, archive.FormatJSONL, archive.FormatParquet} {
+		t.Run(string(format), func(t *testing.T) {
+			var b bytes.Buffer
+
+			count, err := archive.WriteAll(&b, format, slices.Values(testEvents()))
+			require.NoError(t, err)
+			assert.Equal(t, 3, count)
+
+			events, err := readAll(t, b.Bytes(), format)
+			require.NoError(t, err)
+			assert.Equal(t, testEvents(), events)
+		})
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	t.Run("columns are matched by name", func(t *testing.T) {
+		input := "ts,pressed,position,col,row,source,note\n2024-03-01 10:00:00.5,True,7,1,2,laptop,x\n"
+
+		events, err := readAll(t, []byte(input), archive.FormatCSV)
+		require.NoError(t, err)
+		assert.Equal(t, []model.KeyEventWithTimestamp{{
+			Row: 2, Col: 1, Position: 7, Pressed: true,
+			Timestamp: time.Date(2024, 3, 1, 10, 0, 0, 500_000_000, time.UTC),
+			Source:    "laptop",
+		}}, events)
+	})
+
+	t.Run("source and keyboard are optional", func(t *testing.T) {
+		events, err := readAll(t, []byte("row,col,position,pressed,ts\n0,0,1,false,2024-03-01T10:00:00Z\n"), archive.FormatCSV)
+		require.NoError(t, err)
+		assert.Empty(t, events[0].Source)
+		assert.Empty(t, events[0].Keyboard)
+	})
+
+	t.Run("missing column", func(t *testing.T) {
+		_, err := readAll(t, []byte("row,col,position,ts\n"), archive.FormatCSV)
+		require.ErrorContains(t, err, "pressed")
+	})
+
+	t.Run("invalid record stops reading", func(t *testing.T) {
+		input := "row,col,position,pressed,ts\n" +
+			"0,0,1,true,2024-03-01T10:00:00Z\n" +
+			"0,0,-1,true,2024-03-01T10:00:00Z\n" +
+			"0,0,2,true,2024-03-01T10:00:00Z\n"
+
+		events, err := readAll(t, []byte(input), archive.FormatCSV)
+		require.ErrorIs(t, err, archive.ErrNegativeLocation)
+		assert.ErrorContains(t, err, "record 2")
+		assert.Len(t, events, 1)
+	})
+}
+
+func TestReadJSONL(t *testing.T) {
+	t.Run("epoch milliseconds", func(t *testing.T) {
+		input := `{"row":0,"col":0,"position":1,"pressed":false,"ts":1709287200000}`
+
+		events, err := readAll(t, []byte(input), archive.FormatJSONL)
+		require.NoError(t, err)
+		assert.Equal(t, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), events[0].Timestamp)
+	})
+
+	t.Run("missing field", func(t *testing.T) {
+		_, err := readAll(t, []byte(`{"row":0,"col":0,"pressed":false,"ts":"2024-03-01T10:00:00Z"}`), archive.FormatJSONL)
+		require.ErrorContains(t, err, "missing")
+	})
+}
+
+func TestReadParquetNeedsFile(t *testing.T) {
+	stdin := struct{ io.Reader }{strings.NewReader("PAR1")}
+
+	for _, err := range archive.Read(stdin, archive.FormatParquet) {
+		require.ErrorIs(t, err, archive.ErrNotSeekable)
+	}
+}
+
+func TestFormatFromPath(t *testing.T) {
+	f, err := archive.FormatFromPath("dump.ndjson", "")
+	require.NoError(t, err)
+	assert.Equal(t, archive.FormatJSONL, f)
+
+	f, err = archive.FormatFromPath("-", "parquet")
+	require.NoError(t, err)
+	assert.Equal(t, archive.FormatParquet, f)
+
+	_, err = archive.FormatFromPath("dump.xlsx", "")
+	require.Error(t, err)
+}
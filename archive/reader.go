@@ -0,0 +1,266 @@
+package archive
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"strconv"
+	"time"
+
+	"github.com/dasdy/glover/model"
+	"github.com/parquet-go/parquet-go"
+)
+
+var ErrNotSeekable = errors.New("parquet can only be read from a file")
+
+// Read streams validated events from the input. Iteration stops at the first error, which
+// mentions the number of the broken record. Parquet needs random access, so the input must be
+// a file or some other io.ReaderAt.
+func Read(r io.Reader, format Format) iter.Seq2[model.KeyEventWithTimestamp, error] {
+	var records iter.Seq2[Record, error]
+
+	switch format {
+	case FormatCSV:
+		records = readCSV(r)
+	case FormatJSONL:
+		records = readJSONL(r)
+	case FormatParquet:
+		records = readParquet(r)
+	default:
+		_, err := ParseFormat(string(format))
+		records = failed(err)
+	}
+
+	return func(yield func(model.KeyEventWithTimestamp, error) bool) {
+		n := 0
+
+		for record, err := range records {
+			n++
+
+			if err == nil {
+				err = record.Validate()
+			}
+
+			if err != nil {
+				yield(model.KeyEventWithTimestamp{}, fmt.Errorf("record %d: %w", n, err))
+
+				return
+			}
+
+			if !yield(record.Event(), nil) {
+				return
+			}
+		}
+	}
+}
+
+func failed(err error) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		yield(Record{}, err)
+	}
+}
+
+func readCSV(r io.Reader) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		cr := csv.NewReader(bufio.NewReader(r))
+
+		header, err := cr.Read()
+		if err != nil {
+			yield(Record{}, fmt.Errorf("could not read csv header: %w", err))
+
+			return
+		}
+
+		// Columns are looked up by name, so files with reordered or extra columns can be imported.
+		index := make(map[string]int, len(header))
+		for i, name := range header {
+			index[name] = i
+		}
+
+		for _, name := range requiredColumns {
+			if _, ok := index[name]; !ok {
+				yield(Record{}, fmt.Errorf("csv header does not have column '%s'", name))
+
+				return
+			}
+		}
+
+		for {
+			fields, err := cr.Read()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+
+			if err != nil {
+				yield(Record{}, fmt.Errorf("could not read csv: %w", err))
+
+				return
+			}
+
+			record, err := parseCSVRecord(fields, index)
+			if !yield(record, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+func parseCSVRecord(fields []string, index map[string]int) (Record, error) {
+	var (
+		record Record
+		err    error
+	)
+
+	ints := map[string]*int{"row": &record.Row, "col": &record.Col, "position": &record.Position}
+	for name, target := range ints {
+		if *target, err = strconv.Atoi(fields[index[name]]); err != nil {
+			return record, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+
+	if record.Pressed, err = strconv.ParseBool(fields[index["pressed"]]); err != nil {
+		return record, fmt.Errorf("invalid pressed: %w", err)
+	}
+
+	if record.Timestamp, err = parseTimestamp(fields[index["ts"]]); err != nil {
+		return record, err
+	}
+
+	if i, ok := index["source"]; ok {
+		record.Source = fields[i]
+	}
+
+	if i, ok := index["keyboard"]; ok {
+		record.Keyboard = fields[i]
+	}
+
+	return record, nil
+}
+
+// jsonRecord has pointer fields to tell missing values from zeros.
+type jsonRecord struct {
+	Row       *int            `json:"row"`
+	Col       *int            `json:"col"`
+	Position  *int            `json:"position"`
+	Pressed   *bool           `json:"pressed"`
+	Timestamp json.RawMessage `json:"ts"`
+	Source    string          `json:"source"`
+	Keyboard  string          `json:"keyboard"`
+}
+
+func (j *jsonRecord) record() (Record, error) {
+	if j.Row == nil || j.Col == nil || j.Position == nil || j.Pressed == nil || j.Timestamp == nil {
+		return Record{}, fmt.Errorf("one of the fields is missing, expected %v", requiredColumns)
+	}
+
+	record := Record{Row: *j.Row, Col: *j.Col, Position: *j.Position, Pressed: *j.Pressed, Source: j.Source, Keyboard: j.Keyboard}
+
+	// Timestamps are either strings or numbers of milliseconds since epoch, as pandas writes them by default.
+	var text string
+	if err := json.Unmarshal(j.Timestamp, &text); err == nil {
+		ts, err := parseTimestamp(text)
+		record.Timestamp = ts
+
+		return record, err
+	}
+
+	var millis int64
+	if err := json.Unmarshal(j.Timestamp, &millis); err != nil {
+		return record, fmt.Errorf("ts is neither a string nor a number: %s", j.Timestamp)
+	}
+
+	record.Timestamp = time.UnixMilli(millis).UTC()
+
+	return record, nil
+}
+
+func readJSONL(r io.Reader) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		decoder := json.NewDecoder(bufio.NewReader(r))
+
+		for {
+			var j jsonRecord
+
+			err := decoder.Decode(&j)
+			if errors.Is(err, io.EOF) {
+				return
+			}
+
+			if err != nil {
+				yield(Record{}, fmt.Errorf("could not decode json: %w", err))
+
+				return
+			}
+
+			record, err := j.record()
+			if !yield(record, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+func sizeOf(r io.Reader) (int64, bool) {
+	switch f := r.(type) {
+	case interface{ Size() int64 }:
+		return f.Size(), true
+	case interface{ Stat() (fs.FileInfo, error) }:
+		info, err := f.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return 0, false
+		}
+
+		return info.Size(), true
+	default:
+		return 0, false
+	}
+}
+
+func readParquet(r io.Reader) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		at, ok := r.(io.ReaderAt)
+		size, hasSize := sizeOf(r)
+
+		if !ok || !hasSize {
+			yield(Record{}, ErrNotSeekable)
+
+			return
+		}
+
+		file, err := parquet.OpenFile(at, size)
+		if err != nil {
+			yield(Record{}, fmt.Errorf("could not open parquet file: %w", err))
+
+			return
+		}
+
+		reader := parquet.NewGenericReader[Record](file)
+		defer reader.Close()
+
+		buf := make([]Record, 1024)
+
+		for {
+			n, err := reader.Read(buf)
+			for _, record := range buf[:n] {
+				if !yield(record, nil) {
+					return
+				}
+			}
+
+			if errors.Is(err, io.EOF) {
+				return
+			}
+
+			if err != nil {
+				yield(Record{}, fmt.Errorf("could not read parquet rows: %w", err))
+
+				return
+			}
+		}
+	}
+}
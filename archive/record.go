@@ -0,0 +1,129 @@
+// Package archive reads and writes raw key events in open formats, so the history can be
+// analyzed with other tools and restored from them.
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dasdy/glover/model"
+)
+
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatJSONL   Format = "jsonl"
+	FormatParquet Format = "parquet"
+)
+
+// ParseFormat checks that the format is one of the supported ones.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatCSV, FormatJSONL, FormatParquet:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format '%s': expected one of %s, %s, %s", s, FormatCSV, FormatJSONL, FormatParquet)
+	}
+}
+
+// FormatFromPath picks the format by file extension. Explicit format wins if it is not empty.
+func FormatFromPath(path string, explicit string) (Format, error) {
+	if explicit != "" {
+		return ParseFormat(explicit)
+	}
+
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	if ext == "ndjson" {
+		ext = string(FormatJSONL)
+	}
+
+	if ext == "" {
+		return "", fmt.Errorf("could not infer format of '%s': provide it explicitly", path)
+	}
+
+	return ParseFormat(ext)
+}
+
+// Record is a single event as it is stored in the files. Column names are the same as in the
+// keypresses table. New columns should be added here, so every format gets them.
+type Record struct {
+	Row       int       `json:"row"      parquet:"row"`
+	Col       int       `json:"col"      parquet:"col"`
+	Position  int       `json:"position" parquet:"position"`
+	Pressed   bool      `json:"pressed"  parquet:"pressed"`
+	Timestamp time.Time `json:"ts"       parquet:"ts,timestamp(millisecond)"`
+	Source    string    `json:"source"   parquet:"source"`
+	Keyboard  string    `json:"keyboard" parquet:"keyboard"`
+}
+
+// columns lists the names of Record fields in the order they are written to csv.
+var columns = []string{"row", "col", "position", "pressed", "ts", "source", "keyboard"}
+
+// requiredColumns must be present in the input. Files written before source and keyboard were recorded
+// do not have them.
+var requiredColumns = columns[:5]
+
+func FromEvent(e model.KeyEventWithTimestamp) Record {
+	return Record{
+		Row:       e.Row,
+		Col:       e.Col,
+		Position:  int(e.Position),
+		Pressed:   e.Pressed,
+		Timestamp: e.Timestamp.UTC(),
+		Source:    e.Source,
+		Keyboard:  e.Keyboard,
+	}
+}
+
+func (r *Record) Event() model.KeyEventWithTimestamp {
+	return model.KeyEventWithTimestamp{
+		Row:       r.Row,
+		Col:       r.Col,
+		Position:  model.KeyPosition(r.Position),
+		Pressed:   r.Pressed,
+		Timestamp: r.Timestamp,
+		Source:    r.Source,
+		Keyboard:  r.Keyboard,
+	}
+}
+
+var (
+	ErrNegativeLocation = errors.New("row, col and position must not be negative")
+	ErrMissingTimestamp = errors.New("timestamp is missing")
+)
+
+// Validate checks that the record can be stored.
+func (r *Record) Validate() error {
+	if r.Row < 0 || r.Col < 0 || r.Position < 0 {
+		return ErrNegativeLocation
+	}
+
+	if r.Timestamp.IsZero() {
+		return ErrMissingTimestamp
+	}
+
+	return nil
+}
+
+// timestampLayouts are tried in order when parsing text timestamps. Values without a zone are UTC,
+// same as in the database.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("could not parse timestamp '%s'", s)
+}
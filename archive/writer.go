@@ -0,0 +1,133 @@
+package archive
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"strconv"
+	"time"
+
+	"github.com/dasdy/glover/model"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Writer appends events to an output. Close flushes buffered data but does not close the output itself.
+type Writer interface {
+	Write(event model.KeyEventWithTimestamp) error
+	Close() error
+}
+
+func NewWriter(w io.Writer, format Format) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(columns); err != nil {
+			return nil, fmt.Errorf("could not write csv header: %w", err)
+		}
+
+		return &csvWriter{w: cw}, nil
+	case FormatJSONL:
+		return &jsonlWriter{encoder: json.NewEncoder(w)}, nil
+	case FormatParquet:
+		return &parquetWriter{w: parquet.NewGenericWriter[Record](w)}, nil
+	default:
+		_, err := ParseFormat(string(format))
+
+		return nil, err
+	}
+}
+
+// WriteAll writes every event and closes the writer. Returns amount of written events.
+func WriteAll(w io.Writer, format Format, events iter.Seq[model.KeyEventWithTimestamp]) (int, error) {
+	writer, err := NewWriter(w, format)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for e := range events {
+		if err := writer.Write(e); err != nil {
+			return count, fmt.Errorf("could not write event %d: %w", count+1, err)
+		}
+
+		count++
+	}
+
+	if err := writer.Close(); err != nil {
+		return count, fmt.Errorf("could not finish writing: %w", err)
+	}
+
+	return count, nil
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(event model.KeyEventWithTimestamp) error {
+	r := FromEvent(event)
+
+	err := c.w.Write([]string{
+		strconv.Itoa(r.Row),
+		strconv.Itoa(r.Col),
+		strconv.Itoa(r.Position),
+		strconv.FormatBool(r.Pressed),
+		r.Timestamp.Format(time.RFC3339Nano),
+		r.Source,
+		r.Keyboard,
+	})
+	if err != nil {
+		return fmt.Errorf("could not write csv record: %w", err)
+	}
+
+	return nil
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+
+	if err := c.w.Error(); err != nil {
+		return fmt.Errorf("could not flush csv: %w", err)
+	}
+
+	return nil
+}
+
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+func (j *jsonlWriter) Write(event model.KeyEventWithTimestamp) error {
+	if err := j.encoder.Encode(FromEvent(event)); err != nil {
+		return fmt.Errorf("could not encode json record: %w", err)
+	}
+
+	return nil
+}
+
+func (j *jsonlWriter) Close() error {
+	return nil
+}
+
+type parquetWriter struct {
+	w *parquet.GenericWriter[Record]
+}
+
+func (p *parquetWriter) Write(event model.KeyEventWithTimestamp) error {
+	if _, err := p.w.Write([]Record{FromEvent(event)}); err != nil {
+		return fmt.Errorf("could not write parquet row: %w", err)
+	}
+
+	return nil
+}
+
+func (p *parquetWriter) Close() error {
+	if err := p.w.Close(); err != nil {
+		return fmt.Errorf("could not close parquet writer: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package glover
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/dasdy/glover/keylog"
+	"github.com/dasdy/glover/keylog/remote"
+	"github.com/spf13/cobra"
+)
+
+var (
+	agentServer    string
+	agentSource    string
+	agentSpoolPath string
+)
+
+// agentCmd represents the agent command.
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Read the local keyboard and send key presses to another machine",
+	Long: `Connect to the keyboard the same way track does, but instead of storing key presses locally,
+send them to 'glover track --ingest-port' running on another machine. Events are kept in a spool file
+until the server accepts them, so nothing is lost while the server is unreachable.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		if agentServer == "" {
+			return fmt.Errorf("--server is required")
+		}
+
+		source := agentSource
+		if source == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return fmt.Errorf("could not get hostname, provide --source: %w", err)
+			}
+
+			source = hostname
+		}
+
+		spool, err := remote.OpenSpool(agentSpoolPath)
+		if err != nil {
+			return err
+		}
+		defer spool.Close()
+
+		if backlog := spool.Len(); backlog > 0 {
+			slog.Info("Found events from previous run, will send them first", "count", backlog)
+		}
+
+		profiles, err := keyboardProfiles()
+		if err != nil {
+			return err
+		}
+
+		lines, closeInputs, err := openInputLines(connectMode, filenames)
+		if err != nil {
+			return err
+		}
+		defer closeInputs()
+
+		agent := remote.NewAgent(agentServer, sharedSecret, source, spool)
+
+		slog.Info("Forwarding key presses", "server", agentServer, "source", source)
+
+		return agent.Run(context.Background(), keylog.DeviceEvents(lines, source, newKeyboardResolver(profiles).Keyboard))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+
+	agentCmd.Flags().StringVar(
+		&agentServer,
+		"server",
+		"",
+		"Address of 'glover track --ingest-port', e.g. http://desktop:3002")
+
+	agentCmd.Flags().StringVar(
+		&agentSource,
+		"source",
+		"",
+		"Name of this machine in the statistics. Hostname by default")
+
+	agentCmd.Flags().StringVar(
+		&agentSpoolPath,
+		"spool",
+		"./glover-spool.jsonl",
+		"File to keep events in until the server accepts them")
+
+	agentCmd.Flags().StringVar(
+		&sharedSecret,
+		"secret",
+		"",
+		"Shared secret of the server. Can also be set with GLOVER_SECRET")
+
+	agentCmd.Flags().StringSliceVarP(
+		&filenames,
+		"file",
+		"f",
+		[]string{},
+		"List of filenames to get input from",
+	)
+
+	agentCmd.Flags().VarP(&connectMode,
+		"mode",
+		"m",
+		"How to connect to keyboards: explicit, auto or monitor. See 'glover track --help'")
+
+	agentCmd.Flags().StringVar(
+		&deviceDir,
+		"device-dir",
+		"/dev/",
+		"Directory in which monitor mode looks for keyboards")
+}
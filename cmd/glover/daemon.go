@@ -0,0 +1,230 @@
+package glover
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+
+	"github.com/dasdy/glover/keylog"
+	"github.com/dasdy/glover/logging"
+	"github.com/dasdy/glover/systemd"
+	"github.com/spf13/cobra"
+)
+
+var daemonLogFormat string
+
+// backlogInterval is how often the status of the service is updated with the backlog of the spool.
+const backlogInterval = 10 * time.Second
+
+// useDaemonLogs switches logs to the format of --log-format. JSON lines on stdout are what journald
+// stores best: the level becomes the priority of the entry.
+func useDaemonLogs() error {
+	level := slog.LevelInfo
+	if verbose {
+		level = slog.LevelDebug
+	}
+
+	switch daemonLogFormat {
+	case "json":
+		slog.SetDefault(slog.New(logging.NewJournalHandler(os.Stdout, level)))
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
+	default:
+		return fmt.Errorf("unknown log format '%s', must be json or text", daemonLogFormat)
+	}
+
+	return nil
+}
+
+// activatedWebListener is the socket systemd passed for the web interface, if the service was socket-activated.
+func activatedWebListener() (net.Listener, error) {
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		return nil, fmt.Errorf("could not use sockets passed by systemd: %w", err)
+	}
+
+	if len(listeners) == 0 {
+		return nil, nil
+	}
+
+	for _, extra := range listeners[1:] {
+		slog.Warn("Only the first passed socket is used", "ignored", extra.Addr())
+		extra.Close()
+	}
+
+	if disableInterface {
+		slog.Warn("Socket passed by systemd is not used, the interface is disabled", "address", listeners[0].Addr())
+		listeners[0].Close()
+
+		return nil, nil
+	}
+
+	return listeners[0], nil
+}
+
+// reportBacklog shows in the status of the service how many events wait in the spool to be stored.
+func reportBacklog(ctx context.Context, journal *keylog.Journal) {
+	ticker := time.NewTicker(backlogInterval)
+	defer ticker.Stop()
+
+	reported := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		backlog := journal.Backlog()
+		if backlog == reported {
+			continue
+		}
+
+		reported = backlog
+
+		status := "Tracking keypresses"
+		if backlog > 0 {
+			status = fmt.Sprintf("Tracking keypresses, %d events wait to be stored", backlog)
+		}
+
+		notify(systemd.Status(status))
+	}
+}
+
+func notify(states ...string) {
+	sent, err := systemd.Notify(states...)
+	if err != nil {
+		slog.Error("Could not notify systemd", "error", err)
+
+		return
+	}
+
+	if !sent {
+		slog.Debug("Not run by systemd, nothing to notify", "states", states)
+	}
+}
+
+// daemonCmd represents the daemon command.
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Track keypresses as a systemd service",
+	Long: `Track keypresses in monitor mode the way a service is expected to: logs are JSON lines on stdout,
+readiness and watchdog pings are sent to systemd, and the web interface is served on a socket passed by
+systemd if there is one. Watchdog pings stop once key events wait too long to be handled, so systemd
+restarts a daemon that got stuck. See 'glover service install' to set it up. Outside of systemd it works
+like 'glover track --mode monitor'.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		if err := useDaemonLogs(); err != nil {
+			return err
+		}
+
+		webListener, err := activatedWebListener()
+		if err != nil {
+			return err
+		}
+
+		interval, watchdog, err := systemd.WatchdogInterval()
+		if err != nil {
+			return err
+		}
+
+		return runTrack(cmd, monitorMode, trackHooks{
+			webListener: webListener,
+			ready: func(ctx context.Context, journal *keylog.Journal, heartbeat *keylog.Heartbeat) {
+				notify(systemd.Ready, systemd.Status("Tracking keypresses"))
+
+				go reportBacklog(ctx, journal)
+
+				if watchdog {
+					slog.Info("Pinging systemd watchdog", "interval", interval)
+
+					// Pings stop once events wait for the loop longer than systemd waits for a ping.
+					alive := func() bool { return heartbeat.Alive(interval) }
+
+					go func() {
+						if err := systemd.RunWatchdog(ctx, interval, alive); err != nil {
+							slog.Error("Watchdog stopped", "error", err)
+						}
+					}()
+				}
+			},
+			stopping: func() {
+				notify(systemd.Stopping, systemd.Status("Stopping"))
+			},
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+
+	daemonCmd.Flags().StringVarP(
+		&storagePath,
+		"out",
+		"o",
+		"./keypresses.sqlite",
+		"Output path for statistics: "+storageHelp)
+
+	daemonCmd.Flags().IntVarP(
+		&port, "port", "p", 3000,
+		"Port of the web interface, when systemd does not pass a socket")
+
+	daemonCmd.Flags().BoolVar(&disableInterface,
+		"no-interface",
+		false,
+		"If provided, no web server will be run with visualization")
+
+	daemonCmd.Flags().BoolVarP(&verbose,
+		"verbose",
+		"v",
+		false,
+		"If provided, debug output will be shown")
+
+	daemonCmd.Flags().StringVar(
+		&deviceDir,
+		"device-dir",
+		"/dev/",
+		"Directory in which keyboards are looked for")
+
+	daemonCmd.Flags().IntVar(
+		&ingestPort,
+		"ingest-port",
+		0,
+		"Port on which to accept events from 'glover agent' running on other machines. Disabled by default")
+
+	daemonCmd.Flags().BoolVar(&noKeyboard,
+		"no-keyboard",
+		false,
+		"Do not connect to local keyboards, only track events received from agents")
+
+	daemonCmd.Flags().StringVar(
+		&sharedSecret,
+		"secret",
+		"",
+		"Shared secret agents must use. Can also be set with GLOVER_SECRET")
+
+	daemonCmd.Flags().StringVar(
+		&keymapFile,
+		"keymap-file",
+		"data/glove80.keymap",
+		"Path to the keymap file used for rendering the interface. Embedded copy is used if the file does not exist")
+
+	daemonCmd.Flags().StringVar(
+		&infoJSONFile,
+		"info-json-file",
+		"data/info.json",
+		"Path to the info.json file used for rendering the interface. Embedded copy is used if the file does not exist")
+
+	daemonCmd.Flags().StringVar(
+		&daemonLogFormat,
+		"log-format",
+		"json",
+		"Format of logs on stdout: json for journald, or text")
+
+	addSpoolFlag(daemonCmd)
+}
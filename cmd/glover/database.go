@@ -0,0 +1,305 @@
+package glover
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	backupDir   string
+	backupKeep  int
+	restoreFrom string
+)
+
+const (
+	defaultBackupDir  = "./backups"
+	defaultBackupKeep = 7
+)
+
+// backupGenerations reads where backups go from the [maintenance] section of the config, flags of
+// the command override it. Command is nil where there are no such flags, e.g. in track.
+func backupGenerations(cmd *cobra.Command) (db.Generations, error) {
+	dir, keep := defaultBackupDir, defaultBackupKeep
+
+	if viper.IsSet("maintenance.backup-dir") {
+		dir = viper.GetString("maintenance.backup-dir")
+	}
+
+	if viper.IsSet("maintenance.backup-keep") {
+		keep = viper.GetInt("maintenance.backup-keep")
+	}
+
+	if cmd != nil && cmd.Flags().Changed("dir") {
+		dir = backupDir
+	}
+
+	if cmd != nil && cmd.Flags().Changed("keep") {
+		keep = backupKeep
+	}
+
+	path, err := sqliteFilePath()
+	if err != nil {
+		return db.Generations{}, err
+	}
+
+	return db.GenerationsOf(path, dir, keep), nil
+}
+
+func backUp(ctx context.Context, storage db.MaintainedStorage, generations db.Generations) error {
+	path, err := generations.Backup(ctx, storage, time.Now())
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Backed up database", "backup", path, "keep", generations.Keep)
+
+	return nil
+}
+
+// runMaintenance backs up, vacuums and analyzes the database on the schedule of the [maintenance]
+// section of the config, until the context is done. Failures are logged, tracking goes on without them.
+func runMaintenance(ctx context.Context, opened db.Storage) {
+	storage, ok := opened.(db.MaintainedStorage)
+
+	tasks := []struct {
+		name  string
+		every time.Duration
+		run   func() error
+	}{
+		{"backup", viper.GetDuration("maintenance.backup-every"), func() error {
+			generations, err := backupGenerations(nil)
+			if err != nil {
+				return err
+			}
+
+			return backUp(ctx, storage, generations)
+		}},
+		{"vacuum", viper.GetDuration("maintenance.vacuum-every"), func() error { return storage.Vacuum(ctx) }},
+		{"analyze", viper.GetDuration("maintenance.analyze-every"), func() error { return storage.Analyze(ctx) }},
+	}
+
+	for _, task := range tasks {
+		if task.every <= 0 {
+			continue
+		}
+
+		if !ok {
+			slog.Warn("Database maintenance of the config is not run", "task", task.name,
+				"error", fmt.Errorf("storage %s does not support maintenance", storagePath))
+
+			continue
+		}
+
+		slog.Info("Scheduled database maintenance", "task", task.name, "every", task.every)
+
+		go func() {
+			ticker := time.NewTicker(task.every)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+
+				if err := task.run(); err != nil {
+					slog.Error("Database maintenance failed", "task", task.name, "error", err)
+				}
+			}
+		}()
+	}
+}
+
+// openForMaintenance opens the database of --storage, which must already exist.
+func openForMaintenance() (db.MaintainedStorage, error) {
+	path, err := storageFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", storagePath, err)
+	}
+
+	opened, err := openStorage(db.OpenOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := storageAs[db.MaintainedStorage](opened, "maintenance")
+	if err != nil {
+		opened.Close()
+
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// dbCmd groups commands that take care of the database.
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Back up, restore and maintain the database",
+	Long: `Take care of the database file. Backups can be taken while 'track' keeps storing events,
+and 'track' can run backup, vacuum and analyze on its own, see the [maintenance] section of the config.`,
+}
+
+var dbBackupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back up the database while it is in use",
+	Long: `Copy the database into --dir with SQLite's online backup API, checking the copy before
+keeping it. Only the newest --keep backups are kept.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		storage, err := openForMaintenance()
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+
+		generations, err := backupGenerations(cmd)
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := stopContext()
+		defer stop()
+
+		return backUp(ctx, storage, generations)
+	},
+}
+
+var dbRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Replace the database with a backup",
+	Long: `Check the integrity of the backup given with --from and copy it over the database. The current
+database, if any, is backed up into --dir first, so a restore can be undone. Stop tracking before restoring:
+a running 'track' would keep counting what it counted before.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		if restoreFrom == "" {
+			return fmt.Errorf("nothing to restore: --from is required")
+		}
+
+		path, err := sqliteFilePath()
+		if err != nil {
+			return err
+		}
+
+		// Old backups are not rotated away here: one of them may be the one that is restored.
+		generations, err := backupGenerations(cmd)
+		if err != nil {
+			return err
+		}
+
+		generations.Keep = 0
+
+		ctx, stop := stopContext()
+		defer stop()
+
+		if _, err := os.Stat(path); err == nil {
+			storage, err := openForMaintenance()
+			if err != nil {
+				return err
+			}
+
+			err = backUp(ctx, storage, generations)
+
+			storage.Close()
+
+			if err != nil {
+				return fmt.Errorf("could not back up the current database, nothing was restored "+
+					"(move it away to restore anyway): %w", err)
+			}
+		}
+
+		if err := db.Restore(ctx, restoreFrom, path); err != nil {
+			return err
+		}
+
+		slog.Info("Restored database", "from", restoreFrom, "to", storagePath)
+
+		return nil
+	},
+}
+
+var dbVacuumCmd = &cobra.Command{
+	Use:   "vacuum",
+	Short: "Give back the space of deleted rows",
+	Long: `Rebuild the database file, e.g. after 'prune' deleted raw events. It needs as much free
+space as the database takes, and events that 'track' stores meanwhile wait for it or go to the spool.
+Events keep their ids, so peers resume syncing where they stopped.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		storage, err := openForMaintenance()
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+
+		ctx, stop := stopContext()
+		defer stop()
+
+		return storage.Vacuum(ctx)
+	},
+}
+
+var dbAnalyzeCmd = &cobra.Command{
+	Use:               "analyze",
+	Short:             "Update statistics of the query planner",
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		storage, err := openForMaintenance()
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+
+		ctx, stop := stopContext()
+		defer stop()
+
+		return storage.Analyze(ctx)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbBackupCmd, dbRestoreCmd, dbVacuumCmd, dbAnalyzeCmd)
+
+	for _, cmd := range []*cobra.Command{dbBackupCmd, dbRestoreCmd, dbVacuumCmd, dbAnalyzeCmd} {
+		cmd.Flags().StringVarP(
+			&storagePath,
+			"storage",
+			"s",
+			"./keypresses.sqlite",
+			"Path to the statistics database")
+	}
+
+	for _, cmd := range []*cobra.Command{dbBackupCmd, dbRestoreCmd} {
+		cmd.Flags().StringVar(
+			&backupDir,
+			"dir",
+			defaultBackupDir,
+			"Directory of backups")
+
+		cmd.Flags().IntVar(
+			&backupKeep,
+			"keep",
+			defaultBackupKeep,
+			"How many of the newest backups to keep, 0 keeps all")
+	}
+
+	dbRestoreCmd.Flags().StringVar(
+		&restoreFrom,
+		"from",
+		"",
+		"Backup to restore")
+}
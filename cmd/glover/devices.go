@@ -0,0 +1,147 @@
+package glover
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/dasdy/glover/keylog/ports"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.bug.st/serial"
+)
+
+var devicesDir string
+
+// deviceMatcher reads device rules from the [devices] section of the config. Without match rules,
+// the default ones are used, so a config can only add reject rules.
+func deviceMatcher() (*ports.Matcher, error) {
+	if !viper.IsSet("devices") {
+		return ports.DefaultMatcher(), nil
+	}
+
+	var matcher ports.Matcher
+
+	if err := viper.UnmarshalKey("devices", &matcher); err != nil {
+		return nil, fmt.Errorf("could not read device rules from config: %w", err)
+	}
+
+	if len(matcher.Match) == 0 {
+		matcher.Match = ports.DefaultMatcher().Match
+	}
+
+	if err := matcher.Compile(); err != nil {
+		return nil, fmt.Errorf("invalid device rules in config: %w", err)
+	}
+
+	return &matcher, nil
+}
+
+// devicesCmd represents the devices command.
+var devicesCmd = &cobra.Command{
+	Use:   "devices",
+	Short: "List serial devices and whether they are taken as keyboards",
+	Long: `List serial devices of the system, and devices in --device-dir if it is given, with their USB
+identity and the rule that made them match or be rejected. Rules are set in the config file:
+
+	[[devices.match]]
+	vid = "1d50"
+	pid = "615e"
+
+	[[devices.reject]]
+	product = "Arduino*"
+
+Every field of a rule must match: path (glob on the name, or on the full path if it has a slash),
+regex (on the full path), vid, pid, serial and product (globs). A device is taken when it matches
+any match rule and no reject rule. Without match rules, tty.usbmodem* and ttyACM* are taken.
+The keyboard a device is counted for is shown in brackets when [[keyboards]] are configured.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		matcher, err := deviceMatcher()
+		if err != nil {
+			return err
+		}
+
+		profiles, err := keyboardProfiles()
+		if err != nil {
+			return err
+		}
+
+		paths, err := serial.GetPortsList()
+		if err != nil {
+			return fmt.Errorf("could not get list of serial ports: %w", err)
+		}
+
+		if devicesDir != "" {
+			entries, err := os.ReadDir(devicesDir)
+			if err != nil {
+				return fmt.Errorf("could not read %s: %w", devicesDir, err)
+			}
+
+			for _, entry := range entries {
+				devicePath := filepath.Join(devicesDir, entry.Name())
+
+				info, err := os.Stat(devicePath)
+				if err == nil && info.Mode()&os.ModeDevice != 0 && !slices.Contains(paths, devicePath) {
+					paths = append(paths, devicePath)
+				}
+			}
+		}
+
+		slices.Sort(paths)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PATH\tUSB ID\tSERIAL\tPRODUCT\tKEYBOARD\tREASON")
+
+		for _, c := range matcher.Explain(paths) {
+			usbID, keyboard := "-", "no"
+			if c.USB {
+				usbID = c.VID + ":" + c.PID
+			}
+
+			if c.Matched {
+				keyboard = "yes"
+
+				if name := profileOf(profiles, c.DeviceInfo); name != "" {
+					keyboard += " (" + name + ")"
+				}
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				c.Path, usbID, orDash(c.SerialNumber), orDash(c.Product), keyboard, c.Reason)
+		}
+
+		return w.Flush()
+	},
+}
+
+// profileOf names the configured keyboard the device belongs to, the same way tracking decides it.
+func profileOf(profiles []keyboardProfile, d ports.DeviceInfo) string {
+	for i := range profiles {
+		if matched, _ := profiles[i].Devices.Check(d); matched {
+			return profiles[i].Name
+		}
+	}
+
+	return profiles[0].Name
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+func init() {
+	rootCmd.AddCommand(devicesCmd)
+
+	devicesCmd.Flags().StringVar(
+		&devicesDir,
+		"device-dir",
+		"",
+		"Also list devices in this directory, e.g. the one of 'glover simulate-device'")
+}
@@ -0,0 +1,204 @@
+package glover
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/dasdy/glover/archive"
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/model"
+	"github.com/spf13/cobra"
+)
+
+var (
+	archiveFormat   string
+	exportSince     string
+	exportUntil     string
+	exportSources   []string
+	exportPositions []int
+	exportAfter     string
+	exportOut       string
+)
+
+// exportCmd represents the export command.
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write raw key events into a csv, jsonl or parquet file",
+	Long: `Write raw key events so they can be analyzed with other tools, e.g. pandas or duckdb,
+and imported back later. Format is picked from the output file extension unless --format is provided.
+The cursor of the last exported event is logged, pass it to --after to export only newer events later.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		format, err := archive.FormatFromPath(exportOut, archiveFormat)
+		if err != nil {
+			return err
+		}
+
+		since, err := parseTimeBound(exportSince, false)
+		if err != nil {
+			return fmt.Errorf("invalid --since: %w", err)
+		}
+
+		until, err := parseTimeBound(exportUntil, true)
+		if err != nil {
+			return fmt.Errorf("invalid --until: %w", err)
+		}
+
+		after, err := db.ParseCursor(exportAfter)
+		if err != nil {
+			return fmt.Errorf("invalid --after: %w", err)
+		}
+
+		query := db.HistoryQuery{Since: since, Until: until, Sources: exportSources, After: after}
+		for _, position := range exportPositions {
+			query.Positions = append(query.Positions, model.KeyPosition(position))
+		}
+
+		storage, err := openStorage(db.OpenOptions{})
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+
+		out, commit, discard, err := createOutputOnSuccess(exportOut)
+		if err != nil {
+			return err
+		}
+		defer discard()
+
+		writer, err := archive.NewWriter(out, format)
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := stopContext()
+		defer stop()
+
+		count := 0
+		cursor := after
+
+		for event, err := range storage.History(ctx, query) {
+			if err != nil {
+				return fmt.Errorf("could not read history: %w", err)
+			}
+
+			if err := writer.Write(event.KeyEventWithTimestamp); err != nil {
+				return fmt.Errorf("could not export event %d: %w", count+1, err)
+			}
+
+			count++
+			cursor = event.Cursor
+		}
+
+		if err := writer.Close(); err != nil {
+			return fmt.Errorf("could not finish writing: %w", err)
+		}
+
+		if err := out.Close(); err != nil {
+			return fmt.Errorf("could not finish writing %s: %w", exportOut, err)
+		}
+
+		if err := commit(); err != nil {
+			return err
+		}
+
+		slog.Info("Exported events", "count", count, "format", format, "output", exportOut, "cursor", cursor.String())
+
+		return nil
+	},
+}
+
+// createOutputOnSuccess is createOutput that writes a file next to the path first, so a failed or
+// interrupted export does not leave a partial file in place of the previous one. The written file is
+// moved to the path by commit, discard removes it unless it was committed.
+func createOutputOnSuccess(path string) (out io.WriteCloser, commit func() error, discard func(), err error) {
+	if path == "-" {
+		out, err = createOutput(path)
+
+		return out, func() error { return nil }, func() {}, err
+	}
+
+	tmp := path + ".tmp"
+
+	file, err := createOutput(tmp)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	committed := false
+
+	commit = func() error {
+		if err := os.Rename(tmp, path); err != nil {
+			return fmt.Errorf("could not move output file to %s: %w", path, err)
+		}
+
+		committed = true
+
+		return nil
+	}
+
+	discard = func() {
+		if !committed {
+			file.Close()
+			_ = os.Remove(tmp)
+		}
+	}
+
+	return file, commit, discard, nil
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringVarP(
+		&storagePath,
+		"storage",
+		"s",
+		"./keypresses.sqlite",
+		"Path to the statistics database: "+storageHelp)
+
+	exportCmd.Flags().StringVarP(
+		&exportOut,
+		"out",
+		"o",
+		"./keypresses.csv",
+		"Output path for the events. Use - to write to stdout")
+
+	exportCmd.Flags().StringVar(
+		&archiveFormat,
+		"format",
+		"",
+		"Output format: csv, jsonl or parquet. Inferred from the output path by default")
+
+	exportCmd.Flags().StringVar(
+		&exportSince,
+		"since",
+		"",
+		"Only export presses since this local date or time, e.g. 2024-03-01 or '2024-03-01 09:00'")
+
+	exportCmd.Flags().StringVar(
+		&exportUntil,
+		"until",
+		"",
+		"Only export presses before this local time. A date without time includes the whole day")
+
+	exportCmd.Flags().StringSliceVar(
+		&exportSources,
+		"source",
+		[]string{},
+		"Only export events received from these machines")
+
+	exportCmd.Flags().IntSliceVar(
+		&exportPositions,
+		"position",
+		[]int{},
+		"Only export events of keys at these positions")
+
+	exportCmd.Flags().StringVar(
+		&exportAfter,
+		"after",
+		"",
+		"Only export events after the cursor logged by a previous export")
+}
@@ -0,0 +1,220 @@
+package glover
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/model"
+	"github.com/dasdy/glover/web"
+	cs "github.com/dasdy/glover/web/components"
+	"github.com/dasdy/glover/web/export"
+	"github.com/dasdy/glover/web/routes"
+	"github.com/spf13/cobra"
+)
+
+var (
+	imagePage     string
+	imagePosition int
+	imageClip     int
+	imageScale    float64
+	imageFormat   string
+)
+
+// loadOfflineHandler opens storage and waits until trackers have scanned the history, so the
+// numbers are complete before anything gets rendered.
+func loadOfflineHandler(ctx context.Context) (*routes.ServerHandler, func(), error) {
+	storage, err := openStorage(db.OpenOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	trackers, err := newTrackerSet(db.DefaultTrackers())
+	if err == nil {
+		err = trackers.InitFrom(ctx, storage, db.HistoryQuery{})
+	}
+
+	if err != nil {
+		storage.Close()
+
+		return nil, nil, err
+	}
+
+	handler, err := web.NewServerHandler(storage, trackers, keymapFile, infoJSONFile)
+	if err != nil {
+		storage.Close()
+
+		return nil, nil, fmt.Errorf("could not load layout: %w", err)
+	}
+
+	return handler, storage.Close, nil
+}
+
+func buildRenderContext(handler *routes.ServerHandler) (*cs.RenderContext, error) {
+	var renderContext cs.RenderContext
+
+	position := model.KeyPosition(imagePosition)
+
+	switch cs.PageType(imagePage) {
+	case cs.PageTypeStats:
+		stats, err := handler.GatherStats()
+		if err != nil {
+			return nil, fmt.Errorf("could not gather stats: %w", err)
+		}
+
+		renderContext = handler.BuildStatsRenderContext(stats)
+	case cs.PageTypeCombo:
+		renderContext = handler.BuildCombosRenderContext(db.SnapshotOf(handler.ComboTracker).Combos(position), position)
+	case cs.PageTypeNeighbors:
+		renderContext = handler.BuildNeighborsRenderContext(db.SnapshotOf(handler.NeighborTracker).Combos(position), position)
+	default:
+		return nil, fmt.Errorf("unknown page %s: expected one of %s, %s, %s",
+			imagePage, cs.PageTypeStats, cs.PageTypeCombo, cs.PageTypeNeighbors)
+	}
+
+	return &renderContext, nil
+}
+
+// nopCloser keeps stdout open after the output is written.
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// createOutput opens the output file, or stdout for -. Close must be checked: a file that failed to
+// close may be cut short.
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not create output file %s: %w", path, err)
+	}
+
+	return file, nil
+}
+
+// exportImageCmd represents the export-image command.
+var exportImageCmd = &cobra.Command{
+	Use:   "export-image",
+	Short: "Render heatmap into an svg or png file",
+	Long: `Render the same heatmap as the web interface shows into a self-contained file.
+Colors are computed on the server side, so the result can be viewed without a browser.
+Format is picked from the output file extension unless --format is provided.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		format := imageFormat
+		if format == "" {
+			format = strings.TrimPrefix(filepath.Ext(outputPath), ".")
+		}
+
+		if format != "svg" && format != "png" {
+			return fmt.Errorf("unsupported image format '%s': expected svg or png", format)
+		}
+
+		ctx, stop := stopContext()
+		defer stop()
+
+		handler, closeStorage, err := loadOfflineHandler(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeStorage()
+
+		renderContext, err := buildRenderContext(handler)
+		if err != nil {
+			return err
+		}
+
+		out, err := createOutput(outputPath)
+		if err != nil {
+			return err
+		}
+
+		slog.Info("Rendering heatmap", "page", imagePage, "format", format, "output", outputPath)
+
+		if format == "png" {
+			err = export.WritePNG(out, renderContext, imageClip, imageScale)
+		} else {
+			err = export.WriteSVG(out, renderContext, imageClip)
+		}
+
+		if closeErr := out.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("could not write %s: %w", outputPath, closeErr)
+		}
+
+		if err != nil {
+			return fmt.Errorf("could not export image: %w", err)
+		}
+
+		return nil
+	},
+}
+
+var outputPath string
+
+func init() {
+	rootCmd.AddCommand(exportImageCmd)
+
+	exportImageCmd.Flags().StringVarP(
+		&storagePath,
+		"storage",
+		"s",
+		"./keypresses.sqlite",
+		"Path to the statistics database: "+storageHelp)
+
+	exportImageCmd.Flags().StringVarP(
+		&outputPath,
+		"out",
+		"o",
+		"./heatmap.svg",
+		"Output path for the image. Use - to write to stdout")
+
+	exportImageCmd.Flags().StringVar(
+		&imageFormat,
+		"format",
+		"",
+		"Image format: svg or png. Inferred from the output path by default")
+
+	exportImageCmd.Flags().StringVar(
+		&imagePage,
+		"page",
+		string(cs.PageTypeStats),
+		"Which heatmap to render: stats, combo or neighbors")
+
+	exportImageCmd.Flags().IntVar(
+		&imagePosition,
+		"position",
+		0,
+		"Key position to highlight on combo and neighbors pages")
+
+	exportImageCmd.Flags().IntVar(
+		&imageClip,
+		"clip",
+		0,
+		"Press count at which colors are clipped, same as the slider in the web interface. Max value by default")
+
+	exportImageCmd.Flags().Float64Var(
+		&imageScale,
+		"scale",
+		1,
+		"Pixels per svg unit for png output")
+
+	exportImageCmd.Flags().StringVar(
+		&keymapFile,
+		"keymap-file",
+		"data/glove80.keymap",
+		"Path to the keymap file used for rendering the interface. Embedded copy is used if the file does not exist")
+
+	exportImageCmd.Flags().StringVar(
+		&infoJSONFile,
+		"info-json-file",
+		"data/info.json",
+		"Path to the info.json file used for rendering the interface. Embedded copy is used if the file does not exist")
+}
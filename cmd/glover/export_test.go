@@ -0,0 +1,47 @@
+package glover
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateOutputOnSuccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.csv")
+	require.NoError(t, os.WriteFile(path, []byte("previous"), 0o600))
+
+	write := func(content string) (func() error, func()) {
+		out, commit, discard, err := createOutputOnSuccess(path)
+		require.NoError(t, err)
+
+		_, err = io.WriteString(out, content)
+		require.NoError(t, err)
+
+		return func() error {
+			require.NoError(t, out.Close())
+
+			return commit()
+		}, discard
+	}
+
+	_, discard := write("partial")
+	discard()
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "previous", string(content), "a failed export keeps the previous file")
+	assert.NoFileExists(t, path+".tmp")
+
+	commit, discard := write("complete")
+	require.NoError(t, commit())
+	discard()
+
+	content, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "complete", string(content))
+	assert.NoFileExists(t, path+".tmp")
+}
@@ -0,0 +1,145 @@
+package glover
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/model"
+	"github.com/dasdy/glover/web"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fsckRepair   bool
+	fsckExamples int
+)
+
+// fsckLayouts are positions of keys of every configured keyboard. Events of other keyboards belong to the
+// first one, same as when they are tracked.
+func fsckLayouts() (map[string][]model.KeyPosition, error) {
+	profiles, err := keyboardProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	layouts := make(map[string][]model.KeyPosition, len(profiles)+1)
+
+	for i, profile := range profiles {
+		layout, err := web.LoadLayout(profile.InfoJSONFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load layout of keyboard '%s': %w", profile.Name, err)
+		}
+
+		positions := slices.Sorted(maps.Keys(layout.Locations))
+		layouts[profile.Name] = positions
+
+		if i == 0 {
+			layouts[""] = positions
+		}
+	}
+
+	return layouts, nil
+}
+
+func writeCheckReport(out io.Writer, report db.CheckReport) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Checked %d events\n", report.Events)
+
+	for _, kind := range db.AnomalyKinds {
+		finding := report.Findings[kind]
+		fmt.Fprintf(w, "%s: %d\n", kind, finding.Count)
+
+		for _, a := range finding.Examples {
+			e := a.Event
+			fmt.Fprintf(w, "  row %d\t%s\tposition %d\tpressed %t\t%s\t%s\n",
+				a.Rowid, e.Timestamp.Format("2006-01-02 15:04:05.000"), e.Position, e.Pressed, orDash(e.Keyboard), a.Detail)
+		}
+	}
+
+	return w.Flush()
+}
+
+var dbFsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Check the database for anomalies of raw events, and repair them",
+	Long: `Look for presses that were never released, duplicates of events, rows that are not events of the
+layout and releases stamped before their press, and report how many there are with examples. With --repair
+missing releases are added, duplicates are removed and invalid rows are moved into the keypresses_quarantine
+table. Releases stamped before their press are only reported. Take a backup first, see 'glover db backup'.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		storage, err := openForMaintenance()
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+
+		opts := db.DefaultCheckOptions()
+		opts.Examples = fsckExamples
+
+		opts.Layouts, err = fsckLayouts()
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := stopContext()
+		defer stop()
+
+		if !fsckRepair {
+			report, err := storage.Check(ctx, opts)
+			if err != nil {
+				return err
+			}
+
+			return writeCheckReport(os.Stdout, report)
+		}
+
+		report, result, err := storage.Repair(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		if err := writeCheckReport(os.Stdout, report); err != nil {
+			return err
+		}
+
+		slog.Info("Repaired database",
+			"released", result.Released, "removed", result.Removed, "quarantined", result.Quarantined)
+
+		return nil
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(dbFsckCmd)
+
+	dbFsckCmd.Flags().StringVarP(
+		&storagePath,
+		"storage",
+		"s",
+		"./keypresses.sqlite",
+		"Path to the statistics database")
+
+	dbFsckCmd.Flags().BoolVar(&fsckRepair,
+		"repair",
+		false,
+		"Fix what was found: add missing releases, remove duplicates and quarantine invalid rows")
+
+	dbFsckCmd.Flags().IntVar(
+		&fsckExamples,
+		"examples",
+		db.DefaultCheckOptions().Examples,
+		"How many examples of every kind of anomaly to show")
+
+	dbFsckCmd.Flags().StringVar(
+		&infoJSONFile,
+		"info-json-file",
+		"data/info.json",
+		"Path to the info.json file with positions of keys. Embedded copy is used if the file does not exist")
+}
@@ -14,6 +14,10 @@ import (
 
 var cfgFile string
 
+// commandLineFlags are the flags given on the command line, before the config set any of them.
+// They are set once before a command runs and only read afterwards.
+var commandLineFlags = make(map[string]bool)
+
 // rootCmd represents the base command when called without any subcommands.
 var rootCmd = &cobra.Command{
 	Use:   "glover",
@@ -21,7 +25,7 @@ var rootCmd = &cobra.Command{
 	Long: `Glover can help you visualize your usage of ZMK-backed keyboards.
 It can build a database and visualize it as a heatmap, allowing you to take action
 and optimize your layout as you see fit.`,
-	PersistentPreRun: bindFlags,
+	PersistentPreRunE: bindFlags,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
@@ -98,7 +102,11 @@ port = 8080
 }
 
 // set values to the PFlag variables from config, if they are set. Priority is still given to explicitly provided CLI flags.
-func bindFlags(cmd *cobra.Command, _ []string) {
+func bindFlags(cmd *cobra.Command, _ []string) error {
+	cmd.Flags().Visit(func(f *pflag.Flag) { commandLineFlags[f.Name] = true })
+
+	var applyErr error
+
 	cmd.Flags().VisitAll(func(f *pflag.Flag) {
 		// If using camelCase in the config file, replace hyphens with a camelCased string.
 		// Since viper does case-insensitive comparisons, we don't need to bother fixing the case, and only need to remove the hyphens.
@@ -112,10 +120,17 @@ func bindFlags(cmd *cobra.Command, _ []string) {
 			err := cmd.Flags().Set(f.Name, fmt.Sprintf("%v", val))
 			if err != nil {
 				slog.Error("error setting flag", "key", f.Name, "error", err)
-				panic(err)
+
+				if applyErr == nil {
+					applyErr = fmt.Errorf("invalid value of %s in config: %w", configName, err)
+				}
+
+				return
 			}
 
 			slog.Info("setting flag value", "key", f.Name, "value", val)
 		}
 	})
+
+	return applyErr
 }
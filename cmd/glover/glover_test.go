@@ -0,0 +1,27 @@
+package glover
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFlagDefaults checks that commands sharing a flag variable register it with the same default,
+// a variable keeps the default registered last.
+func TestFlagDefaults(t *testing.T) {
+	var visit func(cmd *cobra.Command)
+
+	visit = func(cmd *cobra.Command) {
+		cmd.Flags().VisitAll(func(f *pflag.Flag) {
+			assert.Equal(t, f.DefValue, f.Value.String(), "default of --%s of %s", f.Name, cmd.CommandPath())
+		})
+
+		for _, sub := range cmd.Commands() {
+			visit(sub)
+		}
+	}
+
+	visit(rootCmd)
+}
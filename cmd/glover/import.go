@@ -0,0 +1,105 @@
+package glover
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/dasdy/glover/archive"
+	"github.com/dasdy/glover/db"
+	"github.com/spf13/cobra"
+)
+
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return os.Stdin, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open input file %s: %w", path, err)
+	}
+
+	return file, nil
+}
+
+func importFile(storage db.Importer, path string) error {
+	format, err := archive.FormatFromPath(path, archiveFormat)
+	if err != nil {
+		return err
+	}
+
+	in, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	result, err := storage.Import(archive.Read(in, format))
+	if err != nil {
+		return fmt.Errorf("could not import %s: %w", path, err)
+	}
+
+	slog.Info("Imported events", "file", path, "inserted", result.Inserted, "skipped", result.Skipped)
+
+	return nil
+}
+
+// importCmd represents the import command.
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Load raw key events from csv, jsonl or parquet files",
+	Long: `Load raw key events written by export or by other tools. Events that are already in the
+database are skipped, so importing the same file twice does not change the counts. Each file is
+imported in a single transaction: if any record is invalid, nothing from that file is stored.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		if len(filenames) == 0 {
+			return fmt.Errorf("no input files provided")
+		}
+
+		opened, err := openStorage(db.OpenOptions{})
+		if err != nil {
+			return err
+		}
+		defer opened.Close()
+
+		storage, err := storageAs[db.Importer](opened, "import")
+		if err != nil {
+			return err
+		}
+
+		for _, fn := range filenames {
+			if err := importFile(storage, fn); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+
+	importCmd.Flags().StringSliceVarP(
+		&filenames,
+		"file",
+		"f",
+		[]string{},
+		"List of files to import. Use - to read from stdin")
+
+	importCmd.Flags().StringVarP(
+		&storagePath,
+		"storage",
+		"s",
+		"./keypresses.sqlite",
+		"Path to the statistics database: "+storageHelp)
+
+	importCmd.Flags().StringVar(
+		&archiveFormat,
+		"format",
+		"",
+		"Input format: csv, jsonl or parquet. Inferred from the file extensions by default")
+}
@@ -0,0 +1,69 @@
+package glover
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/keylog"
+	"github.com/dasdy/glover/keylog/remote"
+	"github.com/spf13/cobra"
+)
+
+var storageSpoolPath string
+
+// openJournal puts a spool between keyboards and the database. Events that the database did not take
+// in a previous run are stored first, so trackers that scan the history afterwards count them.
+// The returned function tries to store what is left in the spool one last time and closes it.
+func openJournal(storage db.Storage) (*keylog.Journal, func(), error) {
+	path := storageSpoolPath
+	if path == "" {
+		storageFile, err := storageFilePath()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if storageFile == "" {
+			return nil, nil, fmt.Errorf("storage %s keeps nothing on disk to put the spool next to, set --spool", storagePath)
+		}
+
+		path = strings.TrimSuffix(storageFile, "/") + ".spool.jsonl"
+	}
+
+	spool, err := remote.OpenSpool(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open spool: %w", err)
+	}
+
+	journal := keylog.NewJournal(storage, spool)
+
+	if backlog := journal.Backlog(); backlog > 0 {
+		slog.Info("Found events the database did not take in a previous run, storing them first", "count", backlog)
+
+		if err := journal.Flush(); err != nil {
+			slog.Warn("Could not store spooled events, will retry", "error", err, "backlog", journal.Backlog())
+		}
+	}
+
+	closeJournal := func() {
+		if err := journal.Flush(); err != nil {
+			slog.Error("Events stay in the spool until the next run", "error", err, "backlog", journal.Backlog(), "spool", path)
+		}
+
+		if err := spool.Close(); err != nil {
+			slog.Error("Could not close spool", "error", err)
+		}
+	}
+
+	return journal, closeJournal, nil
+}
+
+func addSpoolFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(
+		&storageSpoolPath,
+		"spool",
+		"",
+		"File that keeps events the database could not take, e.g. while it is locked, until they are stored. "+
+			"Defaults to the output path with .spool.jsonl appended")
+}
@@ -0,0 +1,321 @@
+package glover
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/keylog/ports"
+	"github.com/dasdy/glover/web"
+	"github.com/spf13/viper"
+)
+
+// keyboardProfile is a keyboard from the [[keyboards]] sections of the config. Events of devices that match
+// its rules are counted for it, and the interface shows it with its own keymap and layout.
+type keyboardProfile struct {
+	Name         string   `mapstructure:"name"`
+	KeymapFile   string   `mapstructure:"keymap-file"`
+	InfoJSONFile string   `mapstructure:"info-json-file"`
+	Trackers     []string `mapstructure:"trackers"`
+
+	Devices ports.Matcher `mapstructure:",squash"`
+}
+
+// trackerNames are the trackers of the trackers list of the config, or the ones the interface has pages for.
+func trackerNames() []string {
+	return trackerNamesOf(viper.GetViper())
+}
+
+func trackerNamesOf(config *viper.Viper) []string {
+	if config.IsSet("trackers") {
+		return config.GetStringSlice("trackers")
+	}
+
+	return db.DefaultTrackers()
+}
+
+// newTrackerSet creates trackers with their options from [tracker.<name>] sections of the config.
+func newTrackerSet(names []string) (*db.TrackerSet, error) {
+	options := make(map[string]db.TrackerOptions, len(names))
+	for _, name := range names {
+		if key := "tracker." + name; viper.IsSet(key) {
+			options[name] = viper.GetStringMapString(key)
+		}
+	}
+
+	trackers, err := db.NewTrackerSetWithOptions(names, options)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trackers in config: %w", err)
+	}
+
+	return trackers, nil
+}
+
+// keyboardProfiles reads keyboards from the config. Without them there is a single keyboard without
+// a name, which takes events of every device and is shown with --keymap-file and --info-json-file.
+func keyboardProfiles() ([]keyboardProfile, error) {
+	return keyboardProfilesOf(viper.GetViper(), keymapFile, infoJSONFile)
+}
+
+// keyboardProfilesOf reads keyboards from the config, keyboards without their own files get the given ones.
+func keyboardProfilesOf(config *viper.Viper, keymapFile, infoJSONFile string) ([]keyboardProfile, error) {
+	unnamed := []keyboardProfile{{KeymapFile: keymapFile, InfoJSONFile: infoJSONFile, Trackers: trackerNamesOf(config)}}
+
+	if !config.IsSet("keyboards") {
+		return unnamed, nil
+	}
+
+	var profiles []keyboardProfile
+
+	if err := config.UnmarshalKey("keyboards", &profiles); err != nil {
+		return nil, fmt.Errorf("could not read keyboards from config: %w", err)
+	}
+
+	if len(profiles) == 0 {
+		return unnamed, nil
+	}
+
+	seen := make(map[string]bool)
+
+	for i := range profiles {
+		profile := &profiles[i]
+
+		if profile.Name == "" {
+			return nil, fmt.Errorf("keyboard %d in config has no name", i+1)
+		}
+
+		if seen[profile.Name] {
+			return nil, fmt.Errorf("keyboard '%s' is configured twice", profile.Name)
+		}
+
+		seen[profile.Name] = true
+
+		if profile.KeymapFile == "" {
+			profile.KeymapFile = keymapFile
+		}
+
+		if profile.InfoJSONFile == "" {
+			profile.InfoJSONFile = infoJSONFile
+		}
+
+		if profile.Trackers == nil {
+			profile.Trackers = trackerNamesOf(config)
+		}
+
+		if err := profile.Devices.Compile(); err != nil {
+			return nil, fmt.Errorf("invalid device rules of keyboard '%s': %w", profile.Name, err)
+		}
+	}
+
+	return profiles, nil
+}
+
+// keyboardDecisionTTL is how long the keyboard of a device is remembered. Rules on USB details need
+// a slow lookup, but after a reconnect the same path can belong to another keyboard.
+const keyboardDecisionTTL = time.Minute
+
+type keyboardDecision struct {
+	name string
+	at   time.Time
+}
+
+// keyboardResolver names the keyboard of a device: the first one with a rule that matches it. Devices
+// no keyboard takes, and stdin, belong to the first keyboard.
+type keyboardResolver struct {
+	lock      sync.Mutex
+	profiles  []keyboardProfile
+	decisions map[string]keyboardDecision
+}
+
+func newKeyboardResolver(profiles []keyboardProfile) *keyboardResolver {
+	return &keyboardResolver{profiles: profiles, decisions: make(map[string]keyboardDecision)}
+}
+
+// SetProfiles changes the rules, e.g. after the config was reloaded.
+func (r *keyboardResolver) SetProfiles(profiles []keyboardProfile) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.profiles = profiles
+	r.decisions = make(map[string]keyboardDecision)
+}
+
+func (r *keyboardResolver) Keyboard(device string) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if d, ok := r.decisions[device]; ok && time.Since(d.at) < keyboardDecisionTTL {
+		return d.name
+	}
+
+	name := r.profiles[0].Name
+
+	if device != "" {
+		for i := range r.profiles {
+			if r.profiles[i].Devices.Matches(device) {
+				name = r.profiles[i].Name
+
+				break
+			}
+		}
+	}
+
+	r.decisions[device] = keyboardDecision{name: name, at: time.Now()}
+
+	return name
+}
+
+// reloadKeyboards returns copies of tracked keyboards with new files and device rules from profiles.
+// Keyboards can not be added or removed without a restart: events are routed to trackers of the
+// keyboards known at the start. Trackers are kept as well.
+func reloadKeyboards(keyboards []trackedKeyboard, profiles []keyboardProfile) []trackedKeyboard {
+	byName := make(map[string]keyboardProfile, len(profiles))
+	for _, p := range profiles {
+		byName[p.Name] = p
+	}
+
+	result := slices.Clone(keyboards)
+
+	for i := range result {
+		name := result[i].profile.Name
+
+		if p, ok := byName[name]; ok {
+			if !slices.Equal(p.Trackers, result[i].profile.Trackers) {
+				slog.Warn("Trackers only change after restart", "keyboard", name)
+
+				p.Trackers = result[i].profile.Trackers
+			}
+
+			result[i].profile = p
+			delete(byName, name)
+		} else {
+			slog.Warn("Keyboard is not configured anymore, it is tracked until restart", "keyboard", name)
+		}
+	}
+
+	for name := range byName {
+		slog.Warn("New keyboard is only tracked after restart", "keyboard", name)
+	}
+
+	return result
+}
+
+func keyboardProfilesOfTracked(keyboards []trackedKeyboard) []keyboardProfile {
+	result := make([]keyboardProfile, len(keyboards))
+
+	for i, k := range keyboards {
+		result[i] = k.profile
+	}
+
+	return result
+}
+
+// trackedKeyboard is a keyboard with the part of the storage that belongs to it and its own trackers.
+type trackedKeyboard struct {
+	profile  keyboardProfile
+	storage  db.Storage
+	trackers *db.TrackerSet
+	// sources are views of the keyboard of every storage, when several of them are shown at once.
+	sources []trackedSource
+}
+
+// trackedSource is the part of a keyboard that belongs to one storage of a union, with its own trackers.
+type trackedSource struct {
+	name     string
+	storage  db.Storage
+	trackers *db.TrackerSet
+}
+
+// trackKeyboards starts trackers of every keyboard on its events. History is scanned in the background
+// until the context is done. Keyboards of a union of storages are tracked on every storage as well.
+func trackKeyboards(ctx context.Context, storage db.Storage, profiles []keyboardProfile) ([]trackedKeyboard, error) {
+	result := make([]trackedKeyboard, len(profiles))
+
+	for i, profile := range profiles {
+		view, trackers, err := trackKeyboard(ctx, storage, profile, i == 0)
+		if err != nil {
+			return nil, err
+		}
+
+		result[i] = trackedKeyboard{
+			profile:  profile,
+			storage:  view,
+			trackers: trackers,
+		}
+
+		union, ok := storage.(*db.Union)
+		if !ok || len(union.Names()) < 2 {
+			continue
+		}
+
+		for _, name := range union.Names() {
+			member, err := union.Only(name)
+			if err != nil {
+				return nil, err
+			}
+
+			view, trackers, err := trackKeyboard(ctx, member, profile, i == 0)
+			if err != nil {
+				return nil, err
+			}
+
+			result[i].sources = append(result[i].sources, trackedSource{name: name, storage: view, trackers: trackers})
+		}
+	}
+
+	return result, nil
+}
+
+// trackKeyboard starts trackers of the keyboard on its part of the storage.
+func trackKeyboard(ctx context.Context, storage db.Storage, profile keyboardProfile, first bool) (db.Storage, *db.TrackerSet, error) {
+	// The unnamed keyboard sees everything, so a database keeps its statistics until keyboards are configured.
+	view := storage
+	if profile.Name != "" {
+		// Events recorded before keyboards were configured are counted for the first one.
+		view = db.KeyboardView(storage, profile.Name, first)
+	}
+
+	trackers, err := newTrackerSet(profile.Trackers)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create trackers of keyboard '%s': %w", profile.Name, err)
+	}
+
+	trackers.InitInBackground(ctx, view)
+
+	return view, trackers, nil
+}
+
+func webKeyboards(keyboards []trackedKeyboard) []web.Keyboard {
+	result := make([]web.Keyboard, len(keyboards))
+
+	for i, k := range keyboards {
+		result[i] = web.Keyboard{
+			Name:         k.profile.Name,
+			Storage:      k.storage,
+			Trackers:     k.trackers,
+			KeymapFile:   k.profile.KeymapFile,
+			InfoJSONFile: k.profile.InfoJSONFile,
+		}
+
+		for _, source := range k.sources {
+			result[i].Sources = append(result[i].Sources,
+				web.Source{Name: source.name, Storage: source.storage, Trackers: source.trackers})
+		}
+	}
+
+	return result
+}
+
+func keyboardTrackers(keyboards []trackedKeyboard) map[string]*db.TrackerSet {
+	result := make(map[string]*db.TrackerSet, len(keyboards))
+
+	for _, k := range keyboards {
+		result[k.profile.Name] = k.trackers
+	}
+
+	return result
+}
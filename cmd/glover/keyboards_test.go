@@ -0,0 +1,99 @@
+package glover
+
+import (
+	"testing"
+
+	"github.com/dasdy/glover/db"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyboardProfilesOf(t *testing.T) {
+	t.Run("a single keyboard without a name without configured ones", func(t *testing.T) {
+		profiles, err := keyboardProfilesOf(configOf(t, `trackers = ["keys"]`), "a.keymap", "a.json")
+		require.NoError(t, err)
+
+		assert.Equal(t, []keyboardProfile{{KeymapFile: "a.keymap", InfoJSONFile: "a.json", Trackers: []string{"keys"}}}, profiles)
+	})
+
+	t.Run("keyboards without their own files get the given ones", func(t *testing.T) {
+		profiles, err := keyboardProfilesOf(configOf(t, `
+[[keyboards]]
+name = "glove80"
+match = [{ vid = "16c0" }]
+
+[[keyboards]]
+name = "corne"
+keymap-file = "corne.keymap"
+trackers = ["keys"]
+`), "a.keymap", "a.json")
+		require.NoError(t, err)
+		require.Len(t, profiles, 2)
+
+		assert.Equal(t, "glove80", profiles[0].Name)
+		assert.Equal(t, "a.keymap", profiles[0].KeymapFile)
+		assert.Equal(t, "a.json", profiles[0].InfoJSONFile)
+		assert.Equal(t, db.DefaultTrackers(), profiles[0].Trackers)
+		assert.Len(t, profiles[0].Devices.Match, 1)
+
+		assert.Equal(t, "corne.keymap", profiles[1].KeymapFile)
+		assert.Equal(t, "a.json", profiles[1].InfoJSONFile)
+		assert.Equal(t, []string{"keys"}, profiles[1].Trackers)
+	})
+
+	for name, config := range map[string]string{
+		"without a name":     "[[keyboards]]\nkeymap-file = \"a.keymap\"",
+		"with the same name": "[[keyboards]]\nname = \"a\"\n[[keyboards]]\nname = \"a\"",
+		"with an empty rule": "[[keyboards]]\nname = \"a\"\nmatch = [{}]",
+	} {
+		t.Run("rejects keyboards "+name, func(t *testing.T) {
+			_, err := keyboardProfilesOf(configOf(t, config), "a.keymap", "a.json")
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestConfiguredFlag(t *testing.T) {
+	cmd := &cobra.Command{}
+
+	var keymap, layout, trackers string
+
+	cmd.Flags().StringVar(&keymap, "keymap-file", "default.keymap", "")
+	cmd.Flags().StringVar(&layout, "info-json-file", "default.json", "")
+	cmd.Flags().StringVar(&trackers, "trackers-file", "default.toml", "")
+
+	require.NoError(t, cmd.Flags().Set("keymap-file", "given.keymap"))
+
+	commandLineFlags["keymap-file"] = true
+
+	t.Cleanup(func() { delete(commandLineFlags, "keymap-file") })
+
+	config := configOf(t, `
+keymapfile = "configured.keymap"
+infojsonfile = "configured.json"
+`)
+
+	assert.Equal(t, "given.keymap", configuredFlag(cmd, config, "keymap-file"), "flags given on the command line win")
+	assert.Equal(t, "configured.json", configuredFlag(cmd, config, "info-json-file"))
+	assert.Equal(t, "default.toml", configuredFlag(cmd, config, "trackers-file"))
+}
+
+func TestReloadKeyboards(t *testing.T) {
+	keyboards := []trackedKeyboard{
+		{profile: keyboardProfile{Name: "glove80", KeymapFile: "old.keymap", Trackers: []string{"keys"}}},
+		{profile: keyboardProfile{Name: "corne", KeymapFile: "corne.keymap", Trackers: []string{"keys"}}},
+	}
+
+	reloaded := reloadKeyboards(keyboards, []keyboardProfile{
+		{Name: "glove80", KeymapFile: "new.keymap", Trackers: []string{"keys", "combos"}},
+		{Name: "moonlander", KeymapFile: "moonlander.keymap"},
+	})
+
+	assert.Equal(t, []keyboardProfile{
+		{Name: "glove80", KeymapFile: "new.keymap", Trackers: []string{"keys"}},
+		{Name: "corne", KeymapFile: "corne.keymap", Trackers: []string{"keys"}},
+	}, keyboardProfilesOfTracked(reloaded), "trackers and the set of keyboards stay until restart")
+
+	assert.Equal(t, "old.keymap", keyboards[0].profile.KeymapFile, "keyboards that are in use do not change")
+}
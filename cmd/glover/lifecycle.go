@@ -0,0 +1,207 @@
+package glover
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dasdy/glover/model"
+	"github.com/dasdy/glover/web"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// inputsTimeout is how long events that are already read are waited for after inputs are closed.
+// Some inputs, like stdin, can not be interrupted, so they are given up on after it.
+const inputsTimeout = 3 * time.Second
+
+// stopContext is done on SIGINT or SIGTERM. Calling stop also makes it done.
+func stopContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// onHangup calls reload on every SIGHUP until the context is done.
+func onHangup(ctx context.Context, reload func()) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hangups)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangups:
+				reload()
+			}
+		}
+	}()
+}
+
+// reloadedConfig reads the config file into a new config, so nothing that runs sees it change midway.
+func reloadedConfig() (*viper.Viper, error) {
+	config := viper.New()
+	config.SetConfigFile(viper.ConfigFileUsed())
+
+	if cfgFile == "" {
+		config.SetConfigType("toml")
+	}
+
+	config.SetEnvPrefix("glover")
+	config.AutomaticEnv()
+
+	if err := config.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("could not read config: %w", err)
+	}
+
+	return config, nil
+}
+
+// configuredFlag is the value the flag would get from the config, unless it was given on the command line.
+func configuredFlag(cmd *cobra.Command, config *viper.Viper, name string) string {
+	f := cmd.Flags().Lookup(name)
+
+	switch {
+	case commandLineFlags[name]:
+		return f.Value.String()
+	case config.IsSet(strings.ReplaceAll(name, "-", "")):
+		return config.GetString(strings.ReplaceAll(name, "-", ""))
+	default:
+		return f.DefValue
+	}
+}
+
+// reloadedKeys are keys of the config that a reload applies, names of flags without hyphens.
+var reloadedKeys = []string{"keyboards", "keymapfile", "infojsonfile"}
+
+// ignoredChanges are keys of the config that are set, changed or unset in the reloaded one, but that
+// a reload does not apply, sorted.
+func ignoredChanges(running, reloaded *viper.Viper) []string {
+	keys := append(running.AllKeys(), reloaded.AllKeys()...)
+	slices.Sort(keys)
+
+	result := make([]string, 0)
+
+	for _, key := range slices.Compact(keys) {
+		if slices.Contains(reloadedKeys, key) {
+			continue
+		}
+
+		if running.IsSet(key) != reloaded.IsSet(key) || !reflect.DeepEqual(running.Get(key), reloaded.Get(key)) {
+			result = append(result, key)
+		}
+	}
+
+	return result
+}
+
+// reloadKeyboardsOnHangup re-reads keyboards from the config, with their keymaps and layouts, on SIGHUP.
+// Only files and device rules of keyboards change, other settings and flags keep their values until
+// restart, changes of them are logged. Trackers are kept, so nothing they counted is lost. Resolver
+// and server are optional.
+func reloadKeyboardsOnHangup(
+	ctx context.Context,
+	cmd *cobra.Command,
+	keyboards []trackedKeyboard,
+	resolver *keyboardResolver,
+	server *web.Server,
+) {
+	// Only the goroutine of onHangup uses them from now on.
+	current := slices.Clone(keyboards)
+
+	onHangup(ctx, func() {
+		slog.Info("Reloading keyboards and layouts", "config", viper.ConfigFileUsed())
+
+		config, err := reloadedConfig()
+		if err != nil {
+			slog.Error("Could not reload config, keeping the old one", "error", err)
+
+			return
+		}
+
+		if ignored := ignoredChanges(viper.GetViper(), config); len(ignored) > 0 {
+			slog.Warn("Changed settings only apply after restart", "keys", ignored)
+		}
+
+		profiles, err := keyboardProfilesOf(config,
+			configuredFlag(cmd, config, "keymap-file"),
+			configuredFlag(cmd, config, "info-json-file"))
+		if err != nil {
+			slog.Error("Could not reload keyboards, keeping the old ones", "error", err)
+
+			return
+		}
+
+		reloaded := reloadKeyboards(current, profiles)
+
+		if server != nil {
+			if err := server.Reload(webKeyboards(reloaded)); err != nil {
+				slog.Error("Could not reload layouts, keeping the old ones", "error", err)
+
+				return
+			}
+		}
+
+		if resolver != nil {
+			resolver.SetProfiles(keyboardProfilesOfTracked(reloaded))
+		}
+
+		current = reloaded
+
+		slog.Info("Reloaded keyboards and layouts")
+	})
+}
+
+// interruptible passes events on until give up is called, then closes the channel it returns even if
+// the input is not closed, e.g. because stdin can not be interrupted.
+func interruptible(in <-chan model.KeyEventWithTimestamp) (<-chan model.KeyEventWithTimestamp, func()) {
+	out := make(chan model.KeyEventWithTimestamp)
+	quit := make(chan struct{})
+
+	go func() {
+		defer close(out)
+
+		for {
+			select {
+			case <-quit:
+				return
+			case e, ok := <-in:
+				if !ok {
+					return
+				}
+
+				select {
+				case out <- e:
+				case <-quit:
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+
+	return out, func() { once.Do(func() { close(quit) }) }
+}
+
+// waitForLoop waits until events that are already read are handled. If inputs do not close in time, the loop
+// is given up on: its events end, and it is waited for until it finished the event it handles, so nothing
+// is stored after the storage is closed.
+func waitForLoop(loopDone <-chan struct{}, giveUp func()) {
+	select {
+	case <-loopDone:
+	case <-time.After(inputsTimeout):
+		slog.Warn("Inputs did not close in time, events that were not read yet are lost", "timeout", inputsTimeout)
+		giveUp()
+		<-loopDone
+	}
+}
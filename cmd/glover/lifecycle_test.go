@@ -0,0 +1,72 @@
+package glover
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/model"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterruptible(t *testing.T) {
+	// The input is never closed, like stdin that can not be interrupted.
+	in := make(chan model.KeyEventWithTimestamp)
+	events, giveUp := interruptible(in)
+
+	in <- model.KeyEventWithTimestamp{Position: 1}
+	assert.Equal(t, model.KeyPosition(1), (<-events).Position)
+
+	giveUp()
+	giveUp()
+
+	select {
+	case _, ok := <-events:
+		assert.False(t, ok, "events end once they are given up on")
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "events do not end after giving up")
+	}
+}
+
+func configOf(t *testing.T, toml string) *viper.Viper {
+	t.Helper()
+
+	config := viper.New()
+	config.SetConfigType("toml")
+	require.NoError(t, config.ReadConfig(strings.NewReader(toml)))
+
+	return config
+}
+
+func TestIgnoredChanges(t *testing.T) {
+	running := configOf(t, `
+port = 3000
+keymapfile = "old.keymap"
+
+[tracker.combos]
+timeout = "1s"
+
+[[keyboards]]
+name = "glove80"
+`)
+
+	reloaded := configOf(t, `
+port = 3000
+keymapfile = "new.keymap"
+
+[tracker.combos]
+timeout = "2s"
+
+[devices]
+match = [{ vid = "16c0" }]
+
+[[keyboards]]
+name = "glove80"
+info-json-file = "glove80.json"
+`)
+
+	assert.Equal(t, []string{"devices.match", "tracker.combos.timeout"}, ignoredChanges(running, reloaded))
+	assert.Empty(t, ignoredChanges(running, running))
+}
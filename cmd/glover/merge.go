@@ -2,40 +2,76 @@ package glover
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 
 	"github.com/dasdy/glover/db"
 	"github.com/spf13/cobra"
 )
 
+// sameFile reports whether both locations point to the same existing file or directory.
+func sameFile(a, b string) bool {
+	locationA, errA := db.ParseLocation(a)
+	locationB, errB := db.ParseLocation(b)
+
+	if errA != nil || errB != nil || locationA.Path == "" || locationB.Path == "" {
+		return false
+	}
+
+	infoA, errA := os.Stat(locationA.Path)
+	infoB, errB := os.Stat(locationB.Path)
+
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// mergeOut is the output of merge, it has a default of its own.
+var mergeOut string
+
 // mergeCmd represents the merge command.
 var mergeCmd = &cobra.Command{
-	Use:              "merge",
-	Short:            "Merge two databases into one",
-	Long:             `Given two log files, create a third one, which is just a union of input databases`,
-	PersistentPreRun: bindFlags,
+	Use:   "merge",
+	Short: "Merge databases into one",
+	Long: `Copy events from input databases into the output one, which may already exist.
+Events that are already in the output are skipped, so the same input can be merged again,
+e.g. to keep a single master database up to date with several machines.`,
+	PersistentPreRunE: bindFlags,
 	RunE: func(_ *cobra.Command, _ []string) error {
-		inputs := make([]*db.SQLiteStorage, len(filenames))
+		inputs := make([]db.Storage, len(filenames))
 		for i, fn := range filenames {
-			// TODO: this automatically also counts all the combos, but we don't need it for merging.
-			// maybe some simplified init can be created?
-			store, err := db.NewStorageFromPath(fn, false)
+			if sameFile(fn, mergeOut) {
+				return fmt.Errorf("input file %s is the same as the output", fn)
+			}
+
+			// Inputs are only read, so they are not initialized and never created by accident.
+			store, err := db.Open(fn, db.OpenOptions{ReadOnly: true})
 			if err != nil {
-				return fmt.Errorf("could not open input file %s: %w", fn, err)
+				return fmt.Errorf("could not open input: %w", err)
 			}
+			defer store.Close()
+
 			inputs[i] = store
 		}
 
-		if _, err := os.Stat(storagePath); err == nil {
-			return fmt.Errorf("output file %s already exists", storagePath)
+		opened, err := openStorageAt(mergeOut, db.OpenOptions{})
+		if err != nil {
+			return err
 		}
+		defer opened.Close()
 
-		output, err := db.NewStorageFromPath(storagePath, false)
+		output, err := storageAtAs[db.Importer](opened, mergeOut, "import")
 		if err != nil {
-			return fmt.Errorf("could not open output file %s: %w", storagePath, err)
+			return err
+		}
+
+		ctx, stop := stopContext()
+		defer stop()
+
+		results, err := db.Merge(ctx, inputs, output)
+
+		for i, result := range results {
+			slog.Info("Merged input", "file", filenames[i], "inserted", result.Inserted, "skipped", result.Skipped)
 		}
 
-		err = db.Merge(inputs, output)
 		if err != nil {
 			return fmt.Errorf("could not merge input files: %w", err)
 		}
@@ -52,13 +88,13 @@ func init() {
 		"file",
 		"f",
 		[]string{},
-		"List of filenames to merge data into",
+		"List of inputs to merge: "+storageHelp,
 	)
 
 	mergeCmd.Flags().StringVarP(
-		&storagePath,
+		&mergeOut,
 		"out",
 		"o",
 		"./merged.sqlite",
-		"Output path for statistics")
+		"Output path for statistics: "+storageHelp+". Events are added to it if it already exists")
 }
@@ -0,0 +1,158 @@
+package glover
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/keylog"
+	"github.com/dasdy/glover/keylog/replay"
+	"github.com/dasdy/glover/web"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	replaySpeed  float64
+	replayStart  string
+	replaySource string
+	replayOut    string
+	replayPort   int
+)
+
+// replayCmd represents the replay command.
+var replayCmd = &cobra.Command{
+	Use:   "replay <logfile>...",
+	Short: "Replay recorded keyboard logs with their original timing",
+	Long: `Read raw ZMK logs, e.g. saved output of both halves, and pass key events to a storage and the trackers
+the same way track does. Events get timestamps of the device, moved so that the first one happens at --start.
+Logs of several devices are merged by the clock each of them logs, they are not aligned to each other.
+With --speed 0 events are replayed as fast as possible, otherwise in real time divided by the speed.`,
+	Args:              cobra.MinimumNArgs(1),
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, args []string) error {
+		start, err := parseTimeBound(replayStart, false)
+		if err != nil {
+			return fmt.Errorf("invalid --start: %w", err)
+		}
+
+		if start.IsZero() {
+			start = time.Now()
+		}
+
+		if replaySpeed < 0 {
+			return fmt.Errorf("--speed can not be negative, got %v", replaySpeed)
+		}
+
+		events, err := replay.Load(args, start, replaySource)
+		if err != nil {
+			return err
+		}
+
+		storage, err := openStorageAt(replayOut, db.OpenOptions{Verbose: verbose})
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+
+		trackers, err := newTrackerSet(trackerNames())
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := stopContext()
+		defer stop()
+
+		// Events that are already stored must be counted before replayed ones, or combos would mix them up.
+		if err := trackers.InitFrom(ctx, storage, db.HistoryQuery{}); err != nil {
+			return err
+		}
+
+		g, ctx := errgroup.WithContext(ctx)
+
+		if !disableInterface {
+			server, err := web.NewServer(replayPort, []web.Keyboard{{
+				Storage:      storage,
+				Trackers:     trackers,
+				KeymapFile:   keymapFile,
+				InfoJSONFile: infoJSONFile,
+			}}, dev, assetsDir)
+			if err != nil {
+				return err
+			}
+
+			g.Go(func() error { return server.Run(ctx) })
+		}
+
+		slog.Info("Replaying events", "count", len(events), "speed", replaySpeed, "output-file", replayOut)
+
+		keylog.LoopEvents(
+			replay.Play(ctx, events, replaySpeed),
+			storage,
+			trackers,
+			verbose)
+
+		if !disableInterface && ctx.Err() == nil {
+			slog.Info("Replay finished, interface keeps running until interrupted", "port", replayPort)
+		}
+
+		return g.Wait()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+
+	replayCmd.Flags().StringVarP(
+		&replayOut,
+		"out",
+		"o",
+		"./replay.sqlite",
+		"Output path for statistics: "+storageHelp)
+
+	replayCmd.Flags().Float64Var(
+		&replaySpeed,
+		"speed",
+		0,
+		"Replay speed multiplier, e.g. 1 for real time or 10 for ten times faster. 0 replays as fast as possible")
+
+	replayCmd.Flags().StringVar(
+		&replayStart,
+		"start",
+		"",
+		"Time of the first event, YYYY-MM-DD[ HH:MM[:SS]] or RFC3339 in local time. Now by default")
+
+	replayCmd.Flags().StringVar(
+		&replaySource,
+		"source",
+		"",
+		"Source to record replayed events with, e.g. to tell them apart from tracked ones")
+
+	replayCmd.Flags().IntVarP(
+		&replayPort, "port", "p", 3000,
+		"Port on which server should be watching")
+
+	replayCmd.Flags().BoolVar(&disableInterface,
+		"no-interface",
+		false,
+		"If provided, no web server will be run with visualization")
+
+	replayCmd.Flags().BoolVarP(&verbose,
+		"verbose",
+		"v",
+		false,
+		"If provided, debug output will be shown")
+
+	replayCmd.Flags().StringVar(
+		&keymapFile,
+		"keymap-file",
+		"data/glove80.keymap",
+		"Path to the keymap file used for rendering the interface. Embedded copy is used if the file does not exist")
+
+	replayCmd.Flags().StringVar(
+		&infoJSONFile,
+		"info-json-file",
+		"data/info.json",
+		"Path to the info.json file used for rendering the interface. Embedded copy is used if the file does not exist")
+}
@@ -0,0 +1,94 @@
+package glover
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dasdy/glover/web/export"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportLimit int
+	reportOut   string
+)
+
+// reportCmd represents the report command.
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Write a standalone html report",
+	Long: `Write a single html file with the heatmap, top keys, combos, neighbors and presses per day.
+The file does not depend on a running server or any external assets, so it can be shared as is.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		ctx, stop := stopContext()
+		defer stop()
+
+		handler, closeStorage, err := loadOfflineHandler(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeStorage()
+
+		report, err := export.BuildReport(ctx, handler, reportLimit, time.Now())
+		if err != nil {
+			return fmt.Errorf("could not build report: %w", err)
+		}
+
+		out, err := createOutput(reportOut)
+		if err != nil {
+			return err
+		}
+
+		slog.Info("Writing report", "output", reportOut)
+
+		err = export.WriteReport(out, report)
+		if closeErr := out.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("could not write %s: %w", reportOut, closeErr)
+		}
+
+		if err != nil {
+			return fmt.Errorf("could not write report: %w", err)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+
+	reportCmd.Flags().StringVarP(
+		&storagePath,
+		"storage",
+		"s",
+		"./keypresses.sqlite",
+		"Path to the statistics database: "+storageHelp)
+
+	reportCmd.Flags().StringVarP(
+		&reportOut,
+		"out",
+		"o",
+		"./report.html",
+		"Output path for the report. Use - to write to stdout")
+
+	reportCmd.Flags().IntVarP(
+		&reportLimit,
+		"limit",
+		"n",
+		20,
+		"How many rows to show in each of the tables")
+
+	reportCmd.Flags().StringVar(
+		&keymapFile,
+		"keymap-file",
+		"data/glove80.keymap",
+		"Path to the keymap file used for rendering the interface. Embedded copy is used if the file does not exist")
+
+	reportCmd.Flags().StringVar(
+		&infoJSONFile,
+		"info-json-file",
+		"data/info.json",
+		"Path to the info.json file used for rendering the interface. Embedded copy is used if the file does not exist")
+}
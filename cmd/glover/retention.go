@@ -0,0 +1,201 @@
+package glover
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// retentionInterval is how often tracking applies the retention policy.
+const retentionInterval = 24 * time.Hour
+
+var (
+	rollupSince string
+	rollupUntil string
+	rawDays     int
+	hourlyDays  int
+)
+
+// rollupTrackers creates trackers of the config, whose combos are kept in rollups.
+func rollupTrackers() (*db.TrackerSet, error) {
+	return newTrackerSet(trackerNames())
+}
+
+// retentionPolicy reads the [retention] section of the config.
+func retentionPolicy() db.Retention {
+	return db.Retention{
+		RawDays:    viper.GetInt("retention.raw-days"),
+		HourlyDays: viper.GetInt("retention.hourly-days"),
+	}
+}
+
+func applyRetention(ctx context.Context, storage db.RetentionStorage, retention db.Retention) error {
+	result, err := storage.Prune(ctx, retention, time.Now(), rollupTrackers)
+	if err != nil {
+		return fmt.Errorf("could not apply retention policy: %w", err)
+	}
+
+	slog.Info("Applied retention policy",
+		"deleted", result.Deleted,
+		"downsampled", result.Downsampled,
+		"pruned-before", result.PrunedBefore)
+
+	return nil
+}
+
+// runRetention applies the retention policy of the config right away and then once a day, until
+// the context is done. Failures are logged, tracking goes on without them.
+func runRetention(ctx context.Context, storage db.Storage) {
+	retention := retentionPolicy()
+	if retention == (db.Retention{}) {
+		return
+	}
+
+	retained, err := storageAs[db.RetentionStorage](storage, "retention")
+	if err != nil {
+		slog.Warn("Retention policy of the config is not applied", "error", err)
+
+		return
+	}
+
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := applyRetention(ctx, retained, retention); err != nil {
+			slog.Error("Retention failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rollupCmd represents the rollup command.
+var rollupCmd = &cobra.Command{
+	Use:   "rollup",
+	Short: "Compute rollups from raw events",
+	Long: `Compute hourly rollups of keys and combos from raw events again, e.g. for events stored
+before rollups existed, or after trackers were configured differently. Key counts are kept up to
+date by tracking itself; combos are computed by this command and before raw events are pruned.
+Time whose raw events were already deleted is left as it is.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		since, err := parseTimeBound(rollupSince, false)
+		if err != nil {
+			return fmt.Errorf("invalid --since: %w", err)
+		}
+
+		until, err := parseTimeBound(rollupUntil, true)
+		if err != nil {
+			return fmt.Errorf("invalid --until: %w", err)
+		}
+
+		opened, err := openStorage(db.OpenOptions{})
+		if err != nil {
+			return err
+		}
+		defer opened.Close()
+
+		storage, err := storageAs[db.RetentionStorage](opened, "rollups and retention")
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := stopContext()
+		defer stop()
+
+		if err := storage.Backfill(ctx, since, until, rollupTrackers); err != nil {
+			return fmt.Errorf("could not compute rollups: %w", err)
+		}
+
+		return nil
+	},
+}
+
+// pruneCmd represents the prune command.
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete old raw events, keeping their rollups",
+	Long: `Apply the retention policy: raw events older than --raw-days are deleted after their rollups
+are computed, and hourly rollups older than --hourly-days are merged into days. Statistics keep
+counting deleted events from rollups. Defaults are taken from the [retention] section of the config,
+tracking applies it once a day as well.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		retention := retentionPolicy()
+		if cmd.Flags().Changed("raw-days") {
+			retention.RawDays = rawDays
+		}
+
+		if cmd.Flags().Changed("hourly-days") {
+			retention.HourlyDays = hourlyDays
+		}
+
+		if retention == (db.Retention{}) {
+			return fmt.Errorf("nothing to prune: set --raw-days or --hourly-days, or the [retention] section of the config")
+		}
+
+		opened, err := openStorage(db.OpenOptions{})
+		if err != nil {
+			return err
+		}
+		defer opened.Close()
+
+		storage, err := storageAs[db.RetentionStorage](opened, "rollups and retention")
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := stopContext()
+		defer stop()
+
+		return applyRetention(ctx, storage, retention)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollupCmd)
+	rootCmd.AddCommand(pruneCmd)
+
+	for _, cmd := range []*cobra.Command{rollupCmd, pruneCmd} {
+		cmd.Flags().StringVarP(
+			&storagePath,
+			"storage",
+			"s",
+			"./keypresses.sqlite",
+			"Path to the statistics database: "+storageHelp)
+	}
+
+	rollupCmd.Flags().StringVar(
+		&rollupSince,
+		"since",
+		"",
+		"Only compute rollups since this local date or time, e.g. 2024-03-01 or '2024-03-01 09:00'")
+
+	rollupCmd.Flags().StringVar(
+		&rollupUntil,
+		"until",
+		"",
+		"Only compute rollups until this local date or time, a date includes the whole day")
+
+	pruneCmd.Flags().IntVar(
+		&rawDays,
+		"raw-days",
+		0,
+		"Delete raw events older than this many days, 0 keeps them")
+
+	pruneCmd.Flags().IntVar(
+		&hourlyDays,
+		"hourly-days",
+		0,
+		"Merge hourly rollups older than this many days into days, 0 keeps them")
+}
@@ -0,0 +1,233 @@
+package glover
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/systemd"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const serviceName = "glover"
+
+var (
+	serviceUnitDir  string
+	serviceSocket   bool
+	serviceWatchdog time.Duration
+	serviceForce    bool
+	servicePrint    bool
+)
+
+// userUnitDir is where systemd looks for units of the user.
+func userUnitDir() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "systemd", "user"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find home directory, provide --unit-dir: %w", err)
+	}
+
+	return filepath.Join(home, ".config", "systemd", "user"), nil
+}
+
+// daemonCommand is how the service starts glover. Paths are made absolute, the service does not run
+// in the current directory.
+func daemonCommand(extraArgs []string) ([]string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("could not find glover executable: %w", err)
+	}
+
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+
+	out, err := absoluteLocation(storagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	command := []string{executable, "daemon", "--out", out, "--port", strconv.Itoa(port)}
+
+	if config := viper.ConfigFileUsed(); config != "" {
+		config, err = filepath.Abs(config)
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve path of config: %w", err)
+		}
+
+		command = append(command, "--config", config)
+	}
+
+	return append(command, extraArgs...), nil
+}
+
+// absoluteLocation is the storage location with an absolute path. Paths without a scheme stay as they are.
+func absoluteLocation(location string) (string, error) {
+	parsed, err := db.ParseLocation(location)
+	if err != nil {
+		return "", err
+	}
+
+	if parsed.Path == "" {
+		return location, nil
+	}
+
+	path, err := filepath.Abs(parsed.Path)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve path %s: %w", parsed.Path, err)
+	}
+
+	if !strings.Contains(location, "://") {
+		return path, nil
+	}
+
+	parsed.Path = path
+
+	return parsed.String(), nil
+}
+
+// writeUnit refuses to replace a unit that exists, unless --force is given.
+func writeUnit(path string, contents string) error {
+	if !serviceForce {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists, use --force to replace it", path)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("could not check %s: %w", path, err)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+
+	fmt.Println("Wrote", path)
+
+	return nil
+}
+
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Manage the systemd service that runs 'glover daemon'",
+}
+
+var serviceInstallCmd = &cobra.Command{
+	Use:   "install [-- daemon flags]",
+	Short: "Write a systemd user unit that tracks keypresses in the background",
+	Long: `Write glover.service to the systemd user unit directory. The service runs 'glover daemon' with this
+executable, the database of --out and the config file in use. Flags after -- are passed to the daemon,
+e.g. 'glover service install -- --ingest-port 3002'. With --socket, glover.socket is written too: systemd
+then listens on --port and passes the socket to the daemon.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, args []string) error {
+		command, err := daemonCommand(args)
+		if err != nil {
+			return err
+		}
+
+		service := systemd.Service{
+			Description: "Glover keypress tracker",
+			ExecStart:   command,
+			Watchdog:    serviceWatchdog,
+		}.Unit()
+		socket := systemd.SocketUnit("Glover web interface", port)
+
+		if servicePrint {
+			fmt.Printf("# %s.service\n%s", serviceName, service)
+
+			if serviceSocket {
+				fmt.Printf("\n# %s.socket\n%s", serviceName, socket)
+			}
+
+			return nil
+		}
+
+		dir := serviceUnitDir
+		if dir == "" {
+			dir, err = userUnitDir()
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("could not create %s: %w", dir, err)
+		}
+
+		if err := writeUnit(filepath.Join(dir, serviceName+".service"), service); err != nil {
+			return err
+		}
+
+		units := serviceName + ".service"
+
+		if serviceSocket {
+			if err := writeUnit(filepath.Join(dir, serviceName+".socket"), socket); err != nil {
+				return err
+			}
+
+			units = serviceName + ".socket " + units
+		}
+
+		fmt.Printf("\nStart it now and on every login with:\n\n")
+		fmt.Printf("    systemctl --user daemon-reload\n")
+		fmt.Printf("    systemctl --user enable --now %s\n\n", units)
+		fmt.Printf("Logs are shown by 'journalctl --user -u %s'.\n", serviceName)
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serviceCmd)
+	serviceCmd.AddCommand(serviceInstallCmd)
+
+	serviceInstallCmd.Flags().StringVarP(
+		&storagePath,
+		"out",
+		"o",
+		"./keypresses.sqlite",
+		"Database the service stores keypresses in")
+
+	serviceInstallCmd.Flags().IntVarP(
+		&port, "port", "p", 3000,
+		"Port of the web interface")
+
+	serviceInstallCmd.Flags().StringVar(
+		&serviceUnitDir,
+		"unit-dir",
+		"",
+		"Directory to write units to. Defaults to ~/.config/systemd/user")
+
+	serviceInstallCmd.Flags().BoolVar(
+		&serviceSocket,
+		"socket",
+		false,
+		"Also write a socket unit, so systemd listens on --port and starts the service")
+
+	serviceInstallCmd.Flags().DurationVar(
+		&serviceWatchdog,
+		"watchdog",
+		30*time.Second,
+		"Restart the service if it stops responding for this long. 0 disables the watchdog")
+
+	serviceInstallCmd.Flags().BoolVar(
+		&serviceForce,
+		"force",
+		false,
+		"Replace units that already exist")
+
+	serviceInstallCmd.Flags().BoolVar(
+		&servicePrint,
+		"print",
+		false,
+		"Print units instead of writing them")
+}
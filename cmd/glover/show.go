@@ -4,7 +4,6 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package glover
 
 import (
-	"fmt"
 	"log/slog"
 
 	"github.com/dasdy/glover/db"
@@ -13,64 +12,85 @@ import (
 	"github.com/spf13/viper"
 )
 
+// showPort is the port of show, it has a default of its own.
+var showPort int
+
 // showCmd represents the show command.
 var showCmd = &cobra.Command{
-	Use:              "show",
-	Short:            "Show collected statistics",
-	Long:             `Use log data collected by track command to show web interface with statistics.`,
-	PersistentPreRun: bindFlags,
-	RunE: func(_ *cobra.Command, _ []string) error {
+	Use:   "show",
+	Short: "Show collected statistics",
+	Long: `Use log data collected by track command to show web interface with statistics.
+Stops on SIGINT or SIGTERM. On SIGHUP, keyboards of the config, their keymaps and layouts are reloaded.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(cmd *cobra.Command, _ []string) error {
 		slog.Info("Config file: ", "file", viper.ConfigFileUsed())
 		slog.Info("Config parameters: ", "params", viper.AllSettings())
 		slog.Info("kmapfile: ", "keymap-file", viper.GetString("keymap-file"))
-		slog.Info("Output file: ", "output-file", storagePath)
+		slog.Info("Output file: ", "output-file", storagePaths)
 
-		storage, err := db.NewStorageFromPath(storagePath, true)
+		profiles, err := keyboardProfiles()
 		if err != nil {
-			return fmt.Errorf("could not open %s as sqlite file: %w", storagePath, err)
+			return err
 		}
-		comboTracker, err := db.NewComboTrackerFromDB(storage)
+
+		storage, err := openStorages(db.OpenOptions{Verbose: true})
 		if err != nil {
-			return fmt.Errorf("could not create combo tracker: %w", err)
+			return err
 		}
-		neighborTracker, err := db.NewNeighborCounterFromDb(storage)
+		defer storage.Close()
+
+		ctx, stop := stopContext()
+		defer stop()
+
+		keyboards, err := trackKeyboards(ctx, storage, profiles)
 		if err != nil {
-			return fmt.Errorf("could not create neighbor tracker: %w", err)
+			return err
 		}
-		defer storage.Close()
-		web.StartServer(port, storage, comboTracker, neighborTracker, keymapFile, infoJSONFile, dev)
 
-		return nil
+		server, err := web.NewServer(showPort, webKeyboards(keyboards), dev, assetsDir)
+		if err != nil {
+			return err
+		}
+
+		reloadKeyboardsOnHangup(ctx, cmd, keyboards, nil, server)
+
+		return server.Run(ctx)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(showCmd)
 
-	showCmd.Flags().IntVarP(&port, "port", "p", 9000,
+	showCmd.Flags().IntVarP(&showPort, "port", "p", 9000,
 		"Port on which server should be watching")
 
-	showCmd.Flags().StringVarP(
-		&storagePath,
+	showCmd.Flags().StringSliceVarP(
+		&storagePaths,
 		"storage",
 		"s",
-		"./keypresses.sqlite",
-		"Output path for statistics")
+		[]string{"./keypresses.sqlite"},
+		"Output path for statistics: "+storagesHelp)
 
 	showCmd.Flags().BoolVar(&dev,
 		"dev",
 		false,
 		"Enable developer mode")
 
+	showCmd.Flags().StringVar(
+		&assetsDir,
+		"assets-dir",
+		"",
+		"Serve css/js from this directory instead of the embedded files. Defaults to ./assets in developer mode")
+
 	showCmd.Flags().StringVar(
 		&keymapFile,
 		"keymap-file",
 		"data/glove80.keymap",
-		"Path to the keymap file used for rendering the interface")
+		"Path to the keymap file used for rendering the interface. Embedded copy is used if the file does not exist")
 
 	showCmd.Flags().StringVar(
 		&infoJSONFile,
 		"info-json-file",
 		"data/info.json",
-		"Path to the info.json file used for rendering the interface")
+		"Path to the info.json file used for rendering the interface. Embedded copy is used if the file does not exist")
 }
@@ -0,0 +1,143 @@
+package glover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/dasdy/glover/keylog/simulator"
+	"github.com/spf13/cobra"
+)
+
+var (
+	simulateDir    string
+	simulateNames  []string
+	simulateSpeed  float64
+	simulateRepeat bool
+)
+
+// loadSteps reads a recorded log if the file ends with .log, and a simulator script otherwise.
+func loadSteps(path string) ([]simulator.Step, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(path, ".log") {
+		return simulator.StepsFromLog(f)
+	}
+
+	return simulator.ParseScript(f)
+}
+
+// simulateDeviceCmd represents the simulate-device command.
+var simulateDeviceCmd = &cobra.Command{
+	Use:   "simulate-device <script-or-log>...",
+	Short: "Pretend to be a keyboard connected over USB",
+	Long: `Create a pseudo-terminal for every given file and print ZMK log lines into it, so track can be tested
+without hardware. Files ending with .log are recorded logs and are printed with their original pauses,
+other files are scripts:
+
+	press 31 2,4     key at position 31 (row 2, col 4) goes down; row and col are optional
+	release 31 2,4   and goes up
+	tap 31 2,4       press and release
+	wait 150ms       pause
+	disconnect       unplug the device
+	connect          plug it back in
+	line <text>      print text as is
+
+Devices are links named like real keyboards in --dir, point track to them with
+'glover track -m monitor --device-dir <dir>'.`,
+	Args:              cobra.MinimumNArgs(1),
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, args []string) error {
+		if len(simulateNames) > 0 && len(simulateNames) != len(args) {
+			return fmt.Errorf("got %d names for %d files", len(simulateNames), len(args))
+		}
+
+		if err := os.MkdirAll(simulateDir, 0o755); err != nil {
+			return fmt.Errorf("could not create %s: %w", simulateDir, err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		var wg sync.WaitGroup
+
+		errs := make([]error, len(args))
+
+		for i, path := range args {
+			steps, err := loadSteps(path)
+			if err != nil {
+				return err
+			}
+
+			name := fmt.Sprintf("tty.usbmodemsim%d", i+1)
+			if len(simulateNames) > 0 {
+				name = simulateNames[i]
+			}
+
+			device, err := simulator.NewDevice(simulateDir, name)
+			if err != nil {
+				return err
+			}
+			defer device.Close()
+
+			slog.Info("Simulating device", "path", device.Path(), "file", path, "steps", len(steps))
+
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				for {
+					if errs[i] = device.Run(ctx, steps, simulateSpeed); errs[i] != nil || !simulateRepeat {
+						return
+					}
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		if err := errors.Join(errs...); err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(simulateDeviceCmd)
+
+	simulateDeviceCmd.Flags().StringVar(
+		&simulateDir,
+		"dir",
+		filepath.Join(os.TempDir(), "glover-devices"),
+		"Directory to create device links in")
+
+	simulateDeviceCmd.Flags().StringSliceVar(
+		&simulateNames,
+		"name",
+		[]string{},
+		"Names of devices in the order of files. tty.usbmodemsim1, tty.usbmodemsim2 and so on by default")
+
+	simulateDeviceCmd.Flags().Float64Var(
+		&simulateSpeed,
+		"speed",
+		1,
+		"Speed multiplier for pauses, e.g. 10 for ten times faster. 0 skips pauses")
+
+	simulateDeviceCmd.Flags().BoolVar(&simulateRepeat,
+		"repeat",
+		false,
+		"Start over after the end of a file until interrupted")
+}
@@ -0,0 +1,198 @@
+package glover
+
+import (
+	"fmt"
+	"iter"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/stats"
+	"github.com/dasdy/glover/web"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statsFormat  string
+	statsSince   string
+	statsUntil   string
+	statsLimit   int
+	statsSources []string
+)
+
+// parseTimeBound reads a date or a date with time in the local time zone. Empty string means no bound.
+// Date-only until includes the whole day.
+func parseTimeBound(value string, isUntil bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		if isUntil {
+			t = t.AddDate(0, 0, 1)
+		}
+
+		return t, nil
+	}
+
+	for _, layout := range []string{time.DateTime, "2006-01-02 15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("could not parse '%s': expected YYYY-MM-DD, YYYY-MM-DD HH:MM[:SS] or RFC3339", value)
+}
+
+// statsCmd represents the stats command.
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Print top keys, combos and neighbors to the terminal",
+	Long: `Print statistics without starting the web interface: top keys, combos and neighbor pairs,
+presses per day and per hand. Output can be a table, csv or json, so it is easy to use in
+shell pipelines and scheduled reports.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		format, err := stats.ParseFormat(statsFormat)
+		if err != nil {
+			return err
+		}
+
+		since, err := parseTimeBound(statsSince, false)
+		if err != nil {
+			return fmt.Errorf("invalid --since: %w", err)
+		}
+
+		until, err := parseTimeBound(statsUntil, true)
+		if err != nil {
+			return fmt.Errorf("invalid --until: %w", err)
+		}
+
+		storage, err := openStorages(db.OpenOptions{})
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+
+		ctx, stop := stopContext()
+		defer stop()
+
+		query := db.HistoryQuery{Since: since, Until: until, Sources: statsSources}
+
+		trackers, err := newTrackerSet(db.DefaultTrackers())
+		if err != nil {
+			return err
+		}
+
+		if err := trackers.InitFrom(ctx, storage, query); err != nil {
+			return err
+		}
+
+		// Releases whose raw events were deleted are only kept in rollups.
+		rollup := &db.Rollup{}
+		if rolled, ok := storage.(db.RollupStorage); ok {
+			rollup, err = rolled.PrunedRollup(ctx, query)
+			if err != nil {
+				return err
+			}
+		}
+
+		events, failed := db.StopOnError(storage.History(ctx, query))
+
+		handler, err := web.NewServerHandler(storage, trackers, keymapFile, infoJSONFile)
+		if err != nil {
+			return fmt.Errorf("could not load layout: %w", err)
+		}
+
+		positions := slices.Sorted(maps.Keys(handler.LocationsOnGrid.Locations))
+
+		report := stats.Build(withRollup(rollup, stats.Releases(events)),
+			db.GatherAllCombos(trackers.Snapshot(db.CombosTracker), positions),
+			db.GatherAllCombos(trackers.Snapshot(db.NeighborsTracker), positions),
+			handler.KeyNames,
+			handler.LocationsOnGrid,
+			stats.Options{Since: since, Until: until, Limit: statsLimit})
+		if err := failed(); err != nil {
+			return fmt.Errorf("could not read history: %w", err)
+		}
+
+		if err := stats.Write(os.Stdout, format, report); err != nil {
+			return fmt.Errorf("could not print stats: %w", err)
+		}
+
+		return nil
+	},
+}
+
+// withRollup counts releases kept in rollups before ones of raw events.
+func withRollup(rollup *db.Rollup, counts iter.Seq[stats.Count]) iter.Seq[stats.Count] {
+	return func(yield func(stats.Count) bool) {
+		for _, key := range rollup.Keys {
+			if !yield(stats.Count{Time: key.Bucket, Position: key.Position, Count: key.Count}) {
+				return
+			}
+		}
+
+		for c := range counts {
+			if !yield(c) {
+				return
+			}
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+
+	statsCmd.Flags().StringSliceVarP(
+		&storagePaths,
+		"storage",
+		"s",
+		[]string{"./keypresses.sqlite"},
+		"Path to the statistics database: "+storagesHelp)
+
+	statsCmd.Flags().StringSliceVar(
+		&statsSources,
+		"source",
+		[]string{},
+		"Only count events of these sources: machines they were received from, or storages shown together")
+
+	statsCmd.Flags().StringVar(
+		&statsFormat,
+		"format",
+		string(stats.FormatTable),
+		"Output format: table, csv or json")
+
+	statsCmd.Flags().StringVar(
+		&statsSince,
+		"since",
+		"",
+		"Only count presses since this local date or time, e.g. 2024-03-01 or '2024-03-01 09:00'")
+
+	statsCmd.Flags().StringVar(
+		&statsUntil,
+		"until",
+		"",
+		"Only count presses before this local time. A date without time includes the whole day")
+
+	statsCmd.Flags().IntVarP(
+		&statsLimit,
+		"limit",
+		"n",
+		10,
+		"How many rows to show in top keys, combos and neighbors. 0 shows everything")
+
+	statsCmd.Flags().StringVar(
+		&keymapFile,
+		"keymap-file",
+		"data/glove80.keymap",
+		"Path to the keymap file used for key labels. Embedded copy is used if the file does not exist")
+
+	statsCmd.Flags().StringVar(
+		&infoJSONFile,
+		"info-json-file",
+		"data/info.json",
+		"Path to the info.json file used to split keys by hand. Embedded copy is used if the file does not exist")
+}
@@ -0,0 +1,146 @@
+package glover
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/dasdy/glover/db"
+	// This registers the log:// storage backend.
+	_ "github.com/dasdy/glover/db/eventlog"
+)
+
+// storageHelp describes what --storage and --out take.
+const storageHelp = "a path of an SQLite database, or a storage URL: sqlite://PATH, log://DIR or memory://"
+
+// storagesHelp describes --storage of commands that show several storages at once.
+const storagesHelp = storageHelp + ". Give it several times to show storages together, " +
+	"each is a source named after its file, or NAME=LOCATION"
+
+// storagePaths are storages of commands that show several of them at once.
+var storagePaths []string
+
+// openStorage opens the storage of --storage, or --out, with the backend its location names.
+func openStorage(opts db.OpenOptions) (db.Storage, error) {
+	return openStorageAt(storagePath, opts)
+}
+
+// openStorageAt is openStorage of a command that keeps the location in a variable of its own.
+func openStorageAt(location string, opts db.OpenOptions) (db.Storage, error) {
+	storage, err := db.Open(location, opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not open storage: %w", err)
+	}
+
+	return storage, nil
+}
+
+// storageAs is the storage as the interface of a feature, or an error if its backend does not have it.
+func storageAs[T any](storage db.Storage, feature string) (T, error) {
+	return storageAtAs[T](storage, storagePath, feature)
+}
+
+// storageAtAs is storageAs of a storage opened with openStorageAt.
+func storageAtAs[T any](storage db.Storage, location, feature string) (T, error) {
+	typed, ok := storage.(T)
+	if !ok {
+		return typed, fmt.Errorf("storage %s does not support %s", location, feature)
+	}
+
+	return typed, nil
+}
+
+// storageFilePath is the file or directory of the storage, for files kept next to it, e.g. the spool.
+// Storages that keep nothing on disk have none.
+func storageFilePath() (string, error) {
+	location, err := db.ParseLocation(storagePath)
+	if err != nil {
+		return "", err
+	}
+
+	return location.Path, nil
+}
+
+// sqliteFilePath is the file of the storage, which must be an SQLite database, e.g. to back it up.
+func sqliteFilePath() (string, error) {
+	location, err := db.ParseLocation(storagePath)
+	if err != nil {
+		return "", err
+	}
+
+	if location.Scheme != db.SQLiteScheme {
+		return "", fmt.Errorf("storage %s is not an SQLite database", storagePath)
+	}
+
+	return location.Path, nil
+}
+
+// storageNamePattern matches the name given to a storage with NAME=LOCATION.
+var storageNamePattern = regexp.MustCompile(`^([A-Za-z0-9_.-]+)=(.+)$`)
+
+// openStorages opens storages of --storage given several times as one union of them, each opened
+// read-only. Events of a storage are its source, named NAME=LOCATION or after the file of the storage.
+// A single storage is opened as it is.
+func openStorages(opts db.OpenOptions) (db.Storage, error) {
+	if len(storagePaths) == 1 {
+		storagePath = storagePaths[0]
+
+		return openStorage(opts)
+	}
+
+	opts.ReadOnly = true
+	members := make([]db.UnionMember, 0, len(storagePaths))
+
+	closeAll := func() {
+		for _, m := range members {
+			m.Storage.Close()
+		}
+	}
+
+	for _, location := range storagePaths {
+		name, location, err := storageName(location)
+		if err != nil {
+			closeAll()
+
+			return nil, err
+		}
+
+		storage, err := db.Open(location, opts)
+		if err != nil {
+			closeAll()
+
+			return nil, fmt.Errorf("could not open storage: %w", err)
+		}
+
+		members = append(members, db.UnionMember{Name: name, Storage: storage})
+	}
+
+	union, err := db.NewUnion(members...)
+	if err != nil {
+		closeAll()
+
+		return nil, err
+	}
+
+	return union, nil
+}
+
+// storageName splits NAME=LOCATION, or names the storage after its file without the extension.
+func storageName(location string) (string, string, error) {
+	if m := storageNamePattern.FindStringSubmatch(location); m != nil {
+		return m[1], m[2], nil
+	}
+
+	parsed, err := db.ParseLocation(location)
+	if err != nil {
+		return "", "", err
+	}
+
+	base := filepath.Base(strings.TrimSuffix(parsed.Path, "/"))
+	if name := strings.TrimSuffix(base, filepath.Ext(base)); name != "" && name != "." && name != "/" {
+		return name, location, nil
+	}
+
+	return "", "", fmt.Errorf("storage %s needs a name, give it as NAME=%s", location, location)
+}
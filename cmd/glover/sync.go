@@ -0,0 +1,179 @@
+package glover
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/netsync"
+	"github.com/dasdy/glover/web"
+	"github.com/spf13/cobra"
+)
+
+var (
+	syncPeer string
+	// syncPort is the port of sync serve, it has a default of its own.
+	syncPort int
+)
+
+// syncCmd groups commands that exchange events between machines.
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Exchange key events with other machines",
+	Long: `Keep databases of several machines in sync over http. One machine runs 'sync serve',
+others run 'sync push', 'sync pull' or 'sync now' against it. Each side remembers how far it has
+exchanged events with every peer, and duplicates are skipped, so the commands can be run as often as needed.`,
+}
+
+var syncServeCmd = &cobra.Command{
+	Use:               "serve",
+	Short:             "Accept pushes and pulls from other machines",
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		opened, err := openStorage(db.OpenOptions{})
+		if err != nil {
+			return err
+		}
+		defer opened.Close()
+
+		storage, err := storageAs[db.SyncStorage](opened, "sync")
+		if err != nil {
+			return err
+		}
+
+		if sharedSecret == "" {
+			slog.Warn("No secret is set, anyone who can reach the port can read and add events")
+		}
+
+		slog.Info("Starting sync server", "port", syncPort)
+
+		ctx, stop := stopContext()
+		defer stop()
+
+		// Shutting down waits for exchanges in progress, so the database is not closed under them.
+		server := &http.Server{
+			Addr:              fmt.Sprintf(":%d", syncPort),
+			Handler:           netsync.NewServer(storage, sharedSecret).Handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		return web.ServeUntilDone(ctx, server, web.ShutdownTimeout)
+	},
+}
+
+// runSyncClient opens storage and runs one of the client operations against --peer.
+func runSyncClient(run func(ctx context.Context, c *netsync.Client, storage db.SyncStorage) error) error {
+	if syncPeer == "" {
+		return fmt.Errorf("--peer is required")
+	}
+
+	opened, err := openStorage(db.OpenOptions{})
+	if err != nil {
+		return err
+	}
+	defer opened.Close()
+
+	storage, err := storageAs[db.SyncStorage](opened, "sync")
+	if err != nil {
+		return err
+	}
+
+	// A watermark is only saved after its batch is done, so an interrupted sync continues where it stopped.
+	ctx, stop := stopContext()
+	defer stop()
+
+	return run(ctx, netsync.NewClient(syncPeer, sharedSecret), storage)
+}
+
+func logSyncResult(direction string, r netsync.Result) {
+	slog.Info("Sync finished", "direction", direction, "events", r.Events, "inserted", r.Inserted, "skipped", r.Skipped)
+}
+
+var syncPushCmd = &cobra.Command{
+	Use:               "push",
+	Short:             "Send local events to the peer",
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		return runSyncClient(func(ctx context.Context, c *netsync.Client, storage db.SyncStorage) error {
+			result, err := c.Push(ctx, storage)
+			logSyncResult("push", result)
+
+			if err != nil {
+				return fmt.Errorf("could not push events: %w", err)
+			}
+
+			return nil
+		})
+	},
+}
+
+var syncPullCmd = &cobra.Command{
+	Use:               "pull",
+	Short:             "Fetch events of the peer",
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		return runSyncClient(func(ctx context.Context, c *netsync.Client, storage db.SyncStorage) error {
+			result, err := c.Pull(ctx, storage)
+			logSyncResult("pull", result)
+
+			if err != nil {
+				return fmt.Errorf("could not pull events: %w", err)
+			}
+
+			return nil
+		})
+	},
+}
+
+var syncNowCmd = &cobra.Command{
+	Use:               "now",
+	Short:             "Pull and then push, so both sides have the same events",
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		return runSyncClient(func(ctx context.Context, c *netsync.Client, storage db.SyncStorage) error {
+			pulled, pushed, err := c.Sync(ctx, storage)
+			logSyncResult("pull", pulled)
+			logSyncResult("push", pushed)
+
+			if err != nil {
+				return fmt.Errorf("could not sync events: %w", err)
+			}
+
+			return nil
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+	syncCmd.AddCommand(syncServeCmd, syncPushCmd, syncPullCmd, syncNowCmd)
+
+	for _, cmd := range []*cobra.Command{syncServeCmd, syncPushCmd, syncPullCmd, syncNowCmd} {
+		cmd.Flags().StringVarP(
+			&storagePath,
+			"storage",
+			"s",
+			"./keypresses.sqlite",
+			"Path to the statistics database: "+storageHelp)
+
+		cmd.Flags().StringVar(
+			&sharedSecret,
+			"secret",
+			"",
+			"Shared secret both sides must use. Can also be set with GLOVER_SECRET")
+	}
+
+	syncServeCmd.Flags().IntVarP(&syncPort, "port", "p", 3001,
+		"Port on which sync server should be watching")
+
+	for _, cmd := range []*cobra.Command{syncPushCmd, syncPullCmd, syncNowCmd} {
+		cmd.Flags().StringVar(
+			&syncPeer,
+			"peer",
+			"",
+			"Address of the machine running 'sync serve', e.g. http://desktop:3001")
+	}
+}
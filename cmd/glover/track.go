@@ -1,24 +1,34 @@
 package glover
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
+	"net/http"
 	"os"
 	"slices"
+	"time"
 
 	"github.com/dasdy/glover/db"
 	"github.com/dasdy/glover/keylog"
 	"github.com/dasdy/glover/keylog/ports"
+	"github.com/dasdy/glover/keylog/remote"
 	"github.com/dasdy/glover/logging"
+	"github.com/dasdy/glover/model"
 	"github.com/dasdy/glover/web"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
 )
 
 var trackLogCtx = logging.PackageCtx("track")
 
+// shouldTryConnect tells whether found devices can be used instead of the given ones that failed to open.
+// They are not when any of them is one of the given ones: that one is broken and would fail again.
 func shouldTryConnect(names1 []string, names2 []string, autoconnect bool) bool {
-	if !autoconnect || len(names1) != 2 || len(names2) != 2 {
+	if !autoconnect || len(names2) == 0 {
 		return false
 	}
 
@@ -31,15 +41,12 @@ func shouldTryConnect(names1 []string, names2 []string, autoconnect bool) bool {
 	return true
 }
 
+// GetInputsChannel opens the given devices, any number of them: halves of a split keyboard, a unibody
+// keyboard or several keyboards. Without files, all found devices are opened in auto mode, and stdin is read otherwise.
 func GetInputsChannel(opener ports.DeviceOpener, filenames []string, autoConnect bool) (*ports.RealDeviceReader, error) {
 	slog.Info("Getting inputs channel", "filenames", filenames)
-	fileCount := len(filenames)
 
-	if fileCount != 2 && fileCount != 0 {
-		return nil, fmt.Errorf("expected exactly 0 or 2 files, got %d", len(filenames))
-	}
-
-	switch fileCount {
+	switch len(filenames) {
 	case 0:
 		names, err := opener.GetAvailableDevices()
 		if err != nil {
@@ -48,7 +55,7 @@ func GetInputsChannel(opener ports.DeviceOpener, filenames []string, autoConnect
 
 		slog.InfoContext(trackLogCtx, "Suggested devices: ", "devices", names)
 
-		if autoConnect && len(names) == 2 {
+		if autoConnect && len(names) > 0 {
 			slog.InfoContext(trackLogCtx, "Will proceed to autoconnect to devices")
 
 			return GetInputsChannel(opener, names, false)
@@ -58,8 +65,8 @@ func GetInputsChannel(opener ports.DeviceOpener, filenames []string, autoConnect
 
 		return ports.NewDeviceReader(os.Stdin), nil
 
-	case 2:
-		deviceReader, err := opener.OpenMultiple(filenames[0], filenames[1])
+	default:
+		deviceReader, err := opener.OpenMultiple(filenames...)
 		if err == nil {
 			return deviceReader, nil
 		}
@@ -85,72 +92,228 @@ func GetInputsChannel(opener ports.DeviceOpener, filenames []string, autoConnect
 			return nil, fmt.Errorf("error opening files: %w. It does not seem like any keyboard is connected", err)
 		}
 	}
+}
+
+// openInputLines connects to keyboards according to --mode and --file flags.
+func openInputLines(mode connectModeEnum, files []string) (<-chan ports.Line, func(), error) {
+	matcher, err := deviceMatcher()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if mode == monitorMode {
+		reader := ports.NewMonitoringDeviceReader(deviceDir)
+		reader.SetMatcher(matcher)
+
+		lines, err := reader.Lines()
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not open monitoring channel: %w", err)
+		}
+
+		return lines, func() { _ = reader.Close() }, nil
+	}
+
+	deviceReader, err := GetInputsChannel(
+		&ports.RealDeviceOpener{Matcher: matcher},
+		files,
+		mode == oneTimeAutoConnectMode,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open inputs channel: %w", err)
+	}
+
+	slog.InfoContext(trackLogCtx, "Main loop")
 
-	return nil, fmt.Errorf("strange count of devices provided: %d: %+v", len(filenames), filenames)
+	return deviceReader.Lines(), func() { _ = deviceReader.Close() }, nil
+}
+
+// startIngestListener accepts events from agents on other machines until the context is done.
+// The channel of events is closed once the listener stops.
+func startIngestListener(ctx context.Context, g *errgroup.Group, port int, secret string) <-chan model.KeyEventWithTimestamp {
+	listener := remote.NewListener(secret)
+
+	if secret == "" {
+		slog.WarnContext(trackLogCtx, "No secret is set, anyone who can reach the ingest port can add events")
+	}
+
+	g.Go(func() error {
+		defer listener.Close()
+
+		slog.InfoContext(trackLogCtx, "Starting ingest listener", "port", port)
+
+		server := &http.Server{
+			Addr:              fmt.Sprintf(":%d", port),
+			Handler:           listener,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		return web.ServeUntilDone(ctx, server, web.ShutdownTimeout)
+	})
+
+	return listener.Events()
 }
 
 // trackCmd represents the track command.
 var trackCmd = &cobra.Command{
 	Use:   "track",
 	Short: "Connect to attached keyboard and log keypresses",
-	Long: `Provide two paths to files to connect to, or leave empty to read from stdin.
-		Will log keypresses to a sqlite file, and optionally run a web server to visualize the data.`,
-	PersistentPreRun: bindFlags,
-	RunE: func(_ *cobra.Command, _ []string) error {
-		slog.InfoContext(trackLogCtx, "Config file ", "file", viper.ConfigFileUsed())
-		slog.InfoContext(trackLogCtx, "Config parameters ", "settings", viper.AllSettings())
-		slog.InfoContext(trackLogCtx, "kmapfile ", "keymap-file", viper.GetString("keymap-file"))
-		slog.InfoContext(trackLogCtx, "connect mode ", "connect-mode", connectMode)
-		slog.InfoContext(trackLogCtx, "Output file ", "output-file", storagePath)
-
-		storage, err := db.NewStorageFromPath(storagePath, verbose)
+	Long: `Provide paths of devices to connect to, or leave empty to find them or read from stdin.
+		Will log keypresses to a sqlite file, and optionally run a web server to visualize the data.
+		Several keyboards are told apart by [[keyboards]] sections of the config, see README.
+		Stops on SIGINT or SIGTERM after storing events that are already read. On SIGHUP, keyboards of the config,
+		their keymaps and layouts are reloaded.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return runTrack(cmd, connectMode, trackHooks{})
+	},
+}
+
+// trackHooks are what the daemon does differently from track. All of them are optional.
+type trackHooks struct {
+	// webListener serves the interface instead of listening on --port, e.g. a socket passed by systemd.
+	webListener net.Listener
+	// ready is called once tracking started, with a context that is done when it stops, the
+	// journal events are stored through, and the heartbeat of the loop that handles them.
+	ready func(ctx context.Context, journal *keylog.Journal, heartbeat *keylog.Heartbeat)
+	// stopping is called when tracking starts to stop.
+	stopping func()
+}
+
+// runTrack tracks keypresses until it is stopped by a signal or inputs end.
+func runTrack(cmd *cobra.Command, mode connectModeEnum, hooks trackHooks) error {
+	slog.InfoContext(trackLogCtx, "Config file ", "file", viper.ConfigFileUsed())
+	slog.InfoContext(trackLogCtx, "Config parameters ", "settings", viper.AllSettings())
+	slog.InfoContext(trackLogCtx, "kmapfile ", "keymap-file", viper.GetString("keymap-file"))
+	slog.InfoContext(trackLogCtx, "connect mode ", "connect-mode", mode)
+	slog.InfoContext(trackLogCtx, "Output file ", "output-file", storagePath)
+
+	if noKeyboard && ingestPort == 0 {
+		return fmt.Errorf("nothing to track: --no-keyboard requires --ingest-port")
+	}
+
+	ctx, stop := stopContext()
+	defer stop()
+
+	storage, err := openStorage(db.OpenOptions{Verbose: verbose})
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	journal, closeJournal, err := openJournal(storage)
+	if err != nil {
+		return err
+	}
+	defer closeJournal()
+
+	profiles, err := keyboardProfiles()
+	if err != nil {
+		return err
+	}
+
+	keyboards, err := trackKeyboards(ctx, storage, profiles)
+	if err != nil {
+		return err
+	}
+
+	resolver := newKeyboardResolver(profiles)
+
+	// Services stop when the context is done, or when one of them fails.
+	g, ctx := errgroup.WithContext(ctx)
+
+	var server *web.Server
+
+	if !disableInterface {
+		server, err = web.NewServer(port, webKeyboards(keyboards), dev, assetsDir)
 		if err != nil {
-			return fmt.Errorf("could not open %s as sqlite file: %w", storagePath, err)
+			return err
 		}
-		defer storage.Close()
 
-		comboTracker, err := db.NewComboTrackerFromDB(storage)
-		if err != nil {
-			return fmt.Errorf("could not create combo tracker: %w", err)
+		if hooks.webListener != nil {
+			g.Go(func() error { return server.RunOn(ctx, hooks.webListener) })
+		} else {
+			g.Go(func() error { return server.Run(ctx) })
 		}
-		neighborTracker, err := db.NewNeighborCounterFromDb(storage)
+	}
+
+	g.Go(func() error {
+		runRetention(ctx, storage)
+
+		return nil
+	})
+
+	runMaintenance(ctx, storage)
+
+	g.Go(func() error {
+		journal.Run(ctx)
+
+		return nil
+	})
+
+	inputs := make([]<-chan model.KeyEventWithTimestamp, 0, 2)
+
+	if ingestPort != 0 {
+		inputs = append(inputs, startIngestListener(ctx, g, ingestPort, sharedSecret))
+	}
+
+	if !noKeyboard {
+		lines, closeInputs, err := openInputLines(mode, filenames)
 		if err != nil {
-			return fmt.Errorf("could not create neighbor tracker: %w", err)
+			stop()
+
+			return errors.Join(err, g.Wait())
 		}
 
-		trackers := []db.Tracker{comboTracker, neighborTracker}
+		// Closing devices closes their channels, which ends the loop once events in flight are stored.
+		g.Go(func() error {
+			<-ctx.Done()
+			closeInputs()
 
-		if !disableInterface {
-			go web.StartServer(port, storage, comboTracker, neighborTracker, keymapFile, infoJSONFile, dev)
-		}
+			return nil
+		})
 
-		var channel <-chan string
-
-		if connectMode != monitorMode {
-			deviceReader, err := GetInputsChannel(
-				&ports.RealDeviceOpener{},
-				filenames,
-				connectMode == oneTimeAutoConnectMode,
-			)
-			if err != nil {
-				return fmt.Errorf("could not open inputs channel: %w", err)
-			}
-
-			channel = deviceReader.Channel()
-			defer deviceReader.Close()
-			slog.InfoContext(trackLogCtx, "Main loop")
-		} else {
-			reader := ports.DefaultMonitoringDeviceReader()
+		inputs = append(inputs, keylog.DeviceEvents(lines, "", resolver.Keyboard))
+	}
+
+	reloadKeyboardsOnHangup(ctx, cmd, keyboards, resolver, server)
 
-			channel, err = reader.Channel()
-			if err != nil {
-				return fmt.Errorf("could not open monitoring channel: %w", err)
-			}
+	merged, giveUp := interruptible(keylog.MergeEvents(inputs...))
+	events, heartbeat := keylog.Watch(merged)
+	loopDone := make(chan struct{})
+
+	go func() {
+		defer close(loopDone)
+
+		// Without configured keyboards, names agents send are stored as they are.
+		if len(keyboards) == 1 && profiles[0].Name == "" {
+			keylog.LoopEvents(events, journal, keyboardTrackers(keyboards)[""], verbose)
+		} else {
+			keylog.LoopKeyboards(events, journal, keyboardTrackers(keyboards), profiles[0].Name, verbose)
 		}
-		keylog.Loop(channel, storage, trackers, verbose)
+	}()
 
-		return nil
-	},
+	if hooks.ready != nil {
+		hooks.ready(ctx, journal, heartbeat)
+	}
+
+	select {
+	case <-loopDone:
+		// Inputs ended by themselves, e.g. stdin was closed.
+		stop()
+	case <-ctx.Done():
+		slog.InfoContext(trackLogCtx, "Stopping")
+	}
+
+	if hooks.stopping != nil {
+		hooks.stopping()
+	}
+
+	err = g.Wait()
+
+	waitForLoop(loopDone, giveUp)
+	slog.InfoContext(trackLogCtx, "Stopped")
+
+	return err
 }
 
 type connectModeEnum string
@@ -188,7 +351,12 @@ var (
 	disableInterface bool
 	verbose          bool
 	dev              bool
+	assetsDir        string
 	connectMode      = oneTimeAutoConnectMode
+	ingestPort       int
+	noKeyboard       bool
+	sharedSecret     string
+	deviceDir        string
 )
 
 func init() {
@@ -207,7 +375,7 @@ func init() {
 		"out",
 		"o",
 		"./keypresses.sqlite",
-		"Output path for statistics")
+		"Output path for statistics: "+storageHelp)
 
 	trackCmd.Flags().IntVarP(
 		&port, "port", "p", 3000,
@@ -229,26 +397,57 @@ func init() {
 		false,
 		"Enable developer mode")
 
+	trackCmd.Flags().StringVar(
+		&assetsDir,
+		"assets-dir",
+		"",
+		"Serve css/js from this directory instead of the embedded files. Defaults to ./assets in developer mode")
+
 	trackCmd.Flags().VarP(&connectMode,
 		"mode",
 		"m",
 		`Configures mode in which keyboard will be tried to connect to:
 		explicit = only specified filenames will be treated as keyboards. Connection will be attempted one time and all files in 
-		the list should successfully connect. Recommended if you want to connect several keyboards to avoid mis-tracking events.
+		the list should successfully connect. Any number of files can be given, e.g. one for a unibody keyboard.
 		auto = Looks for default naming pattern that works for ZMK devices on Unix systems. Attempts connection one time, tries to connect to all
 		devices that fit the naming pattern.
 		monitor = Continuously monitors /dev folder for devices that look like a ZMK. Allows detaching and re-attaching devices dynamically. Does
 		not stop unless something catastrophic happens.`)
 
+	trackCmd.Flags().StringVar(
+		&deviceDir,
+		"device-dir",
+		"/dev/",
+		"Directory in which monitor mode looks for keyboards, e.g. the one of 'glover simulate-device'")
+
+	trackCmd.Flags().IntVar(
+		&ingestPort,
+		"ingest-port",
+		0,
+		"Port on which to accept events from 'glover agent' running on other machines. Disabled by default")
+
+	trackCmd.Flags().BoolVar(&noKeyboard,
+		"no-keyboard",
+		false,
+		"Do not connect to local keyboards, only track events received from agents")
+
+	trackCmd.Flags().StringVar(
+		&sharedSecret,
+		"secret",
+		"",
+		"Shared secret agents must use. Can also be set with GLOVER_SECRET")
+
 	trackCmd.Flags().StringVar(
 		&keymapFile,
 		"keymap-file",
 		"data/glove80.keymap",
-		"Path to the keymap file used for rendering the interface")
+		"Path to the keymap file used for rendering the interface. Embedded copy is used if the file does not exist")
 
 	trackCmd.Flags().StringVar(
 		&infoJSONFile,
 		"info-json-file",
 		"data/info.json",
-		"Path to the info.json file used for rendering the interface")
+		"Path to the info.json file used for rendering the interface. Embedded copy is used if the file does not exist")
+
+	addSpoolFlag(trackCmd)
 }
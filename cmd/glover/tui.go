@@ -0,0 +1,177 @@
+package glover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/keylog"
+	"github.com/dasdy/glover/tui"
+	"github.com/dasdy/glover/web"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tuiConnectMode = monitorMode
+	tuiLogFile     string
+)
+
+// redirectLogs keeps log output from breaking the terminal interface.
+func redirectLogs(path string) (func(), error) {
+	if path == "" {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+		return func() {}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open log file %s: %w", path, err)
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(file, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	return func() { _ = file.Close() }, nil
+}
+
+// tuiCmd represents the tui command.
+var tuiCmd = &cobra.Command{
+	Use:   "tui",
+	Short: "Track keypresses and show a live heatmap in the terminal",
+	Long: `Same as track, but instead of the web server shows the heatmap right in the terminal.
+Use arrows or hjkl to select a key, enter to see its combos, m or tab to switch between combos
+and neighbors, s to get back to the overall statistics and q to quit.`,
+	PersistentPreRunE: bindFlags,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		// Both the interface and stdin-reading tracking would want to own stdin.
+		if tuiConnectMode != monitorMode && len(filenames) == 0 {
+			return errors.New("tui needs keyboard devices: provide them with --file or use --mode monitor")
+		}
+
+		storage, err := openStorage(db.OpenOptions{})
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+
+		trackers, err := newTrackerSet(trackerNames())
+		if err != nil {
+			return err
+		}
+
+		live := tui.NewLiveCounter(nil)
+		if err := trackers.Add("live", live); err != nil {
+			return err
+		}
+
+		journal, closeJournal, err := openJournal(storage)
+		if err != nil {
+			return err
+		}
+		defer closeJournal()
+
+		// Do not start drawing until history is scanned: trackers report progress to the terminal.
+		// Signals only stop the scan, the interface handles its keys itself.
+		ctx, stop := stopContext()
+		err = trackers.InitFrom(ctx, storage, db.HistoryQuery{})
+
+		stop()
+
+		if err != nil {
+			return err
+		}
+
+		handler, err := web.NewServerHandler(storage, trackers, keymapFile, infoJSONFile)
+		if err != nil {
+			return fmt.Errorf("could not load layout: %w", err)
+		}
+
+		closeLogs, err := redirectLogs(tuiLogFile)
+		if err != nil {
+			return err
+		}
+		defer closeLogs()
+
+		profiles, err := keyboardProfiles()
+		if err != nil {
+			return err
+		}
+
+		lines, closeInputs, err := openInputLines(tuiConnectMode, filenames)
+		if err != nil {
+			return err
+		}
+
+		runCtx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go journal.Run(runCtx)
+
+		// The interface shows all keyboards together, events are still stored with their keyboards.
+		events, giveUp := interruptible(keylog.DeviceEvents(lines, "", newKeyboardResolver(profiles).Keyboard))
+		loopDone := make(chan struct{})
+
+		go func() {
+			defer close(loopDone)
+
+			keylog.LoopEvents(events, journal, trackers, false)
+		}()
+
+		err = tui.RunInTerminal(tui.NewApp(handler, live), os.Stdin)
+
+		// Closing devices ends the loop once events in flight are stored, before the journal and storage close.
+		closeInputs()
+		waitForLoop(loopDone, giveUp)
+
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tuiCmd)
+
+	tuiCmd.Flags().StringSliceVarP(
+		&filenames,
+		"file",
+		"f",
+		[]string{},
+		"List of filenames to get input from",
+	)
+
+	tuiCmd.Flags().StringVarP(
+		&storagePath,
+		"out",
+		"o",
+		"./keypresses.sqlite",
+		"Output path for statistics: "+storageHelp)
+
+	tuiCmd.Flags().VarP(&tuiConnectMode,
+		"mode",
+		"m",
+		`Configures mode in which keyboard will be tried to connect to, same as for track command.
+		Reading from stdin is not supported, since it is used for the interface itself.`)
+
+	tuiCmd.Flags().StringVar(
+		&tuiLogFile,
+		"log-file",
+		"",
+		"Write logs to this file. Logs are discarded by default, so they do not break the interface")
+
+	tuiCmd.Flags().StringVar(
+		&keymapFile,
+		"keymap-file",
+		"data/glove80.keymap",
+		"Path to the keymap file used for rendering the interface. Embedded copy is used if the file does not exist")
+
+	tuiCmd.Flags().StringVar(
+		&infoJSONFile,
+		"info-json-file",
+		"data/info.json",
+		"Path to the info.json file used for rendering the interface. Embedded copy is used if the file does not exist")
+
+	addSpoolFlag(tuiCmd)
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Schemes of built-in backends.
+const (
+	SQLiteScheme = "sqlite"
+	MemoryScheme = "memory"
+)
+
+// Location is where a storage keeps its events, written like a URL: sqlite://./keypresses.sqlite,
+// log:///var/lib/glover/events?segment-size=1048576 or memory://. A location without a scheme is the
+// path of an SQLite database, so paths given before there were backends keep working.
+type Location struct {
+	Scheme string
+	// Path is the file or directory of the storage, empty for storages that keep nothing on disk.
+	Path string
+	// Params are options of the backend, from the query of the location.
+	Params url.Values
+}
+
+// ParseLocation splits the location into the scheme, the path and options of the backend. The path
+// is taken as it is, so relative paths work: log://./events is the events directory of the working one.
+func ParseLocation(location string) (Location, error) {
+	scheme, rest, ok := strings.Cut(location, "://")
+	if !ok {
+		return Location{Scheme: SQLiteScheme, Path: location, Params: url.Values{}}, nil
+	}
+
+	if scheme == "" {
+		return Location{}, fmt.Errorf("storage location '%s' has no scheme", location)
+	}
+
+	path, query, _ := strings.Cut(rest, "?")
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return Location{}, fmt.Errorf("invalid options of storage location '%s': %w", location, err)
+	}
+
+	return Location{Scheme: scheme, Path: path, Params: params}, nil
+}
+
+func (l Location) String() string {
+	s := l.Scheme + "://" + l.Path
+	if len(l.Params) > 0 {
+		s += "?" + l.Params.Encode()
+	}
+
+	return s
+}
+
+// OpenOptions tell how a storage is opened.
+type OpenOptions struct {
+	// ReadOnly opens an existing storage without creating or changing anything in it.
+	ReadOnly bool
+	Verbose  bool
+}
+
+// Backend opens storages of its scheme.
+type Backend func(location Location, opts OpenOptions) (Storage, error)
+
+var (
+	backendsLock sync.RWMutex
+	backends     = map[string]Backend{
+		SQLiteScheme: func(location Location, opts OpenOptions) (Storage, error) {
+			if opts.ReadOnly {
+				return NewReadOnlyStorageFromPath(location.Path)
+			}
+
+			return NewStorageFromPath(location.Path, opts.Verbose)
+		},
+		MemoryScheme: func(_ Location, opts OpenOptions) (Storage, error) {
+			if opts.ReadOnly {
+				return nil, fmt.Errorf("memory storage is always empty, there is nothing to read")
+			}
+
+			return NewMemoryStorage(), nil
+		},
+	}
+)
+
+// RegisterBackend makes storages of the scheme available to Open. It panics if the scheme is taken,
+// same as RegisterTracker does.
+func RegisterBackend(scheme string, backend Backend) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+
+	if backend == nil {
+		panic("db: backend of " + scheme + " is nil")
+	}
+
+	if _, ok := backends[scheme]; ok {
+		panic("db: backend " + scheme + " is registered twice")
+	}
+
+	backends[scheme] = backend
+}
+
+// RegisteredBackends returns schemes of all backends, sorted.
+func RegisteredBackends() []string {
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+
+	schemes := make([]string, 0, len(backends))
+	for scheme := range backends {
+		schemes = append(schemes, scheme)
+	}
+
+	slices.Sort(schemes)
+
+	return schemes
+}
+
+// Open opens the storage at the location with the backend of its scheme.
+func Open(location string, opts OpenOptions) (Storage, error) {
+	parsed, err := ParseLocation(location)
+	if err != nil {
+		return nil, err
+	}
+
+	backendsLock.RLock()
+	backend, ok := backends[parsed.Scheme]
+	backendsLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown storage backend '%s' of %s, must be one of %s",
+			parsed.Scheme, location, strings.Join(RegisteredBackends(), ", "))
+	}
+
+	storage, err := backend(parsed, opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", location, err)
+	}
+
+	return storage, nil
+}
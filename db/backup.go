@@ -0,0 +1,267 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// backupRetry is how long copying waits when the source database is locked, e.g. while events are stored.
+const backupRetry = 50 * time.Millisecond
+
+// generationLayout is the time a backup was taken in its file name, so names sort in the order they were taken.
+const generationLayout = "20060102-150405"
+
+// copyDatabase copies the source database into the file at path with the online backup API. Pages
+// are copied in a single step, so the copy is consistent even while other connections store events:
+// they wait for it, or retry, for the moment it takes.
+func copyDatabase(ctx context.Context, src *sql.DB, path string) error {
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("could not open %s: got %w", path, err)
+	}
+	defer dest.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("could not open %s: got %w", path, err)
+	}
+	defer destConn.Close()
+
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("could not open source database: got %w", err)
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destRaw any) error {
+		return srcConn.Raw(func(srcRaw any) error {
+			destSQLite, ok := destRaw.(*sqlite3.SQLiteConn)
+			srcSQLite, srcOk := srcRaw.(*sqlite3.SQLiteConn)
+
+			if !ok || !srcOk {
+				return errors.New("only sqlite databases can be copied")
+			}
+
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return fmt.Errorf("could not start copying database: got %w", err)
+			}
+
+			for {
+				// Busy or locked source is not an error, the step is retried.
+				done, err := backup.Step(-1)
+				if err != nil {
+					return errors.Join(fmt.Errorf("could not copy database: got %w", err), backup.Finish())
+				}
+
+				if done {
+					break
+				}
+
+				select {
+				case <-ctx.Done():
+					return errors.Join(fmt.Errorf("stopped copying database: %w", ctx.Err()), backup.Finish())
+				case <-time.After(backupRetry):
+				}
+			}
+
+			if err := backup.Finish(); err != nil {
+				return fmt.Errorf("could not finish copying database: got %w", err)
+			}
+
+			return nil
+		})
+	})
+}
+
+// CheckIntegrity verifies that the file is an sqlite database without damage, which has key events.
+func CheckIntegrity(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("could not open path %s: got %w", path, err)
+	}
+
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("could not open path %s: got %w", path, err)
+	}
+	defer conn.Close()
+
+	rows, err := conn.QueryContext(ctx, `pragma integrity_check`)
+	if err != nil {
+		return fmt.Errorf("could not check integrity of %s: got %w", path, err)
+	}
+	defer rows.Close()
+
+	var problems []string
+
+	for rows.Next() {
+		var problem string
+		if err := rows.Scan(&problem); err != nil {
+			return fmt.Errorf("could not scan integrity check: got %w", err)
+		}
+
+		if problem != "ok" {
+			problems = append(problems, problem)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not check integrity of %s: got %w", path, err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s is damaged: %s", path, strings.Join(problems, "; "))
+	}
+
+	if !hasColumn(conn, "keypresses", "ts") {
+		return fmt.Errorf("%s has no key events", path)
+	}
+
+	return nil
+}
+
+// Backup copies the database into the file at path while it is in use. The copy is written next to
+// the path and only renamed once its integrity is checked, so a failed backup never leaves a broken file.
+func (s *SQLiteStorage) Backup(ctx context.Context, path string) error {
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp)
+
+	err := copyDatabase(ctx, s.db, tmp)
+	if err == nil {
+		err = CheckIntegrity(ctx, tmp)
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp)
+
+		return fmt.Errorf("could not back up database: %w", err)
+	}
+
+	return nil
+}
+
+// Restore replaces the database at path with the backup, once the backup passes the integrity check.
+// The backup API writes the database too, so a process that has it open is not left with a broken
+// file, although what it counted no longer matches.
+func Restore(ctx context.Context, backup, path string) error {
+	if err := CheckIntegrity(ctx, backup); err != nil {
+		return fmt.Errorf("refusing to restore: %w", err)
+	}
+
+	src, err := sql.Open("sqlite3", "file:"+backup+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("could not open path %s: got %w", backup, err)
+	}
+	defer src.Close()
+
+	if err := copyDatabase(ctx, src, path); err != nil {
+		return fmt.Errorf("could not restore database: %w", err)
+	}
+
+	if err := CheckIntegrity(ctx, path); err != nil {
+		return fmt.Errorf("restored database does not pass the check: %w", err)
+	}
+
+	return nil
+}
+
+// Generations keeps the newest backups of a database in a directory. Backups are named after the
+// database and the time they were taken, e.g. keypresses-20240301-100000.sqlite.
+type Generations struct {
+	Dir string
+	// Name is the name of the database file without extension.
+	Name string
+	// Keep is how many backups are kept, zero keeps all of them.
+	Keep int
+}
+
+// GenerationsOf keeps backups of the database at path.
+func GenerationsOf(path, dir string, keep int) Generations {
+	base := filepath.Base(path)
+
+	return Generations{Dir: dir, Name: strings.TrimSuffix(base, filepath.Ext(base)), Keep: keep}
+}
+
+// List returns paths of backups, the oldest first.
+func (g Generations) List() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(g.Dir, g.Name+"-*.sqlite"))
+	if err != nil {
+		return nil, fmt.Errorf("could not list backups: %w", err)
+	}
+
+	result := make([]string, 0, len(paths))
+
+	for _, path := range paths {
+		taken := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), g.Name+"-"), ".sqlite")
+		if _, err := time.Parse(generationLayout, taken); err == nil {
+			result = append(result, path)
+		}
+	}
+
+	slices.Sort(result)
+
+	return result, nil
+}
+
+// Backup takes a new backup of the storage and removes the oldest ones beyond Keep. Returns the
+// path of the new backup.
+func (g Generations) Backup(ctx context.Context, storage MaintainedStorage, now time.Time) (string, error) {
+	if err := os.MkdirAll(g.Dir, 0o750); err != nil {
+		return "", fmt.Errorf("could not create backup directory: %w", err)
+	}
+
+	path := filepath.Join(g.Dir, g.Name+"-"+now.UTC().Format(generationLayout)+".sqlite")
+	if err := storage.Backup(ctx, path); err != nil {
+		return "", err
+	}
+
+	if g.Keep <= 0 {
+		return path, nil
+	}
+
+	backups, err := g.List()
+	if err != nil {
+		return path, err
+	}
+
+	for _, old := range backups[:max(0, len(backups)-g.Keep)] {
+		if err := os.Remove(old); err != nil {
+			return path, fmt.Errorf("could not remove old backup: %w", err)
+		}
+	}
+
+	return path, nil
+}
+
+// Vacuum rebuilds the database file, which gives back the space of deleted rows, e.g. after
+// retention deleted raw events. Events stored meanwhile wait for it. Ids of events are kept, so
+// cursors of history and watermarks of sync peers point at the same events after it.
+func (s *SQLiteStorage) Vacuum(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `vacuum`); err != nil {
+		return fmt.Errorf("could not vacuum database: got %w", err)
+	}
+
+	return nil
+}
+
+// Analyze updates statistics the query planner picks indices by.
+func (s *SQLiteStorage) Analyze(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `analyze`); err != nil {
+		return fmt.Errorf("could not analyze database: got %w", err)
+	}
+
+	return nil
+}
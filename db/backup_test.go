@@ -0,0 +1,175 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func countEvents(t *testing.T, path string) int {
+	t.Helper()
+
+	storage, err := db.NewReadOnlyStorageFromPath(path)
+	require.NoError(t, err)
+
+	defer storage.Close()
+
+	count := 0
+
+	for _, err := range storage.History(context.Background(), db.HistoryQuery{}) {
+		require.NoError(t, err)
+
+		count++
+	}
+
+	return count
+}
+
+func TestBackup(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	storage, err := db.NewStorageFromPath(filepath.Join(dir, "keypresses.sqlite"), false)
+	require.NoError(t, err)
+
+	defer storage.Close()
+
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i := range 100 {
+		require.NoError(t, storage.StoreEvent(&model.KeyEventWithTimestamp{Position: 1, Timestamp: start.Add(time.Duration(i) * time.Second)}))
+	}
+
+	t.Run("copies the database while events are stored", func(t *testing.T) {
+		var wg sync.WaitGroup
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range 100 {
+				event := model.KeyEventWithTimestamp{Position: 2, Timestamp: start.Add(time.Hour + time.Duration(i)*time.Second)}
+				assert.NoError(t, storage.StoreEvent(&event))
+			}
+		}()
+
+		path := filepath.Join(dir, "copy.sqlite")
+		require.NoError(t, storage.Backup(ctx, path))
+
+		wg.Wait()
+
+		require.NoError(t, db.CheckIntegrity(ctx, path))
+
+		copied := countEvents(t, path)
+		assert.GreaterOrEqual(t, copied, 100)
+		assert.LessOrEqual(t, copied, 200)
+
+		_, err := os.Stat(path + ".tmp")
+		assert.True(t, os.IsNotExist(err), "temporary copy is renamed")
+	})
+
+	t.Run("keeps the newest generations", func(t *testing.T) {
+		generations := db.GenerationsOf(filepath.Join(dir, "keypresses.sqlite"), filepath.Join(dir, "backups"), 2)
+
+		var taken []string
+
+		for i := range 3 {
+			path, err := generations.Backup(ctx, storage, start.Add(time.Duration(i)*time.Hour))
+			require.NoError(t, err)
+
+			taken = append(taken, path)
+		}
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "backups", "keypresses-notes.sqlite"), nil, 0o600))
+
+		backups, err := generations.List()
+		require.NoError(t, err)
+		assert.Equal(t, taken[1:], backups)
+		assert.Equal(t, "keypresses-20240301-120000.sqlite", filepath.Base(backups[1]))
+	})
+
+	t.Run("restores only backups that pass the check", func(t *testing.T) {
+		damaged := filepath.Join(dir, "damaged.sqlite")
+		require.NoError(t, os.WriteFile(damaged, []byte("not a database"), 0o600))
+
+		target := filepath.Join(dir, "restored.sqlite")
+		require.Error(t, db.Restore(ctx, damaged, target))
+
+		empty, err := db.NewStorageFromPath(filepath.Join(dir, "empty.sqlite"), false)
+		require.NoError(t, err)
+		empty.Close()
+
+		require.NoError(t, db.Restore(ctx, filepath.Join(dir, "empty.sqlite"), target))
+		assert.Equal(t, 0, countEvents(t, target))
+
+		require.NoError(t, db.Restore(ctx, filepath.Join(dir, "copy.sqlite"), target))
+		assert.Equal(t, countEvents(t, filepath.Join(dir, "copy.sqlite")), countEvents(t, target), "existing database is replaced")
+	})
+
+	t.Run("vacuums and analyzes", func(t *testing.T) {
+		require.NoError(t, storage.Vacuum(ctx))
+		require.NoError(t, storage.Analyze(ctx))
+		require.NoError(t, db.CheckIntegrity(ctx, filepath.Join(dir, "keypresses.sqlite")))
+	})
+
+	t.Run("vacuum keeps positions of history and sync", func(t *testing.T) {
+		// Deleted rows leave gaps, vacuum may close them in tables without an integer primary key.
+		conn, err := sql.Open("sqlite3", filepath.Join(dir, "keypresses.sqlite"))
+		require.NoError(t, err)
+
+		_, err = conn.Exec(`delete from keypresses where position = 1 and ts < '2024-03-01 10:01:00'`)
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+
+		require.NoError(t, storage.SetPeerWatermark("laptop", db.Watermark{Pushed: 120, Pulled: 7}))
+
+		positions := func() ([]int64, []db.Cursor) {
+			t.Helper()
+
+			var cursors []int64
+
+			for cursor := int64(0); ; {
+				events, next, err := storage.EventsAfter(cursor, 50)
+				require.NoError(t, err)
+
+				if len(events) == 0 {
+					break
+				}
+
+				cursor = next
+				cursors = append(cursors, cursor)
+			}
+
+			var history []db.Cursor
+
+			for event, err := range storage.History(ctx, db.HistoryQuery{}) {
+				require.NoError(t, err)
+
+				history = append(history, event.Cursor)
+			}
+
+			return cursors, history
+		}
+
+		syncBefore, historyBefore := positions()
+
+		require.NoError(t, storage.Vacuum(ctx))
+
+		syncAfter, historyAfter := positions()
+		assert.Equal(t, syncBefore, syncAfter)
+		assert.Equal(t, historyBefore, historyAfter)
+
+		watermark, err := storage.PeerWatermark("laptop")
+		require.NoError(t, err)
+		assert.Equal(t, db.Watermark{Pushed: 120, Pulled: 7}, watermark)
+	})
+}
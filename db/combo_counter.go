@@ -1,9 +1,13 @@
 package db
 
 import (
+	"encoding/binary"
+	"fmt"
 	"iter"
 	"log/slog"
+	"maps"
 	"slices"
+	"strconv"
 	"sync"
 	"time"
 
@@ -11,156 +15,400 @@ import (
 	"github.com/schollz/progressbar/v3"
 )
 
-type keyState struct {
-	timeWhen time.Time
-	pressed  bool
+// ChordMode tells which keys held down together are counted as a combo.
+type ChordMode string
+
+const (
+	// ChordOverlap counts every set of keys held down together, each time a key is pressed or released.
+	ChordOverlap ChordMode = "overlap"
+	// ChordWindow counts keys pressed within Window of the first one, like ZMK combos. The chord is
+	// counted when one of its keys is released or a key is pressed after the window.
+	ChordWindow ChordMode = "window"
+	// ChordMaximal counts only the largest set of keys held down before a release, so keys released
+	// one by one do not count every smaller chord on the way.
+	ChordMaximal ChordMode = "maximal"
+)
+
+// ComboOptions configure a ComboTracker.
+type ComboOptions struct {
+	Mode ChordMode
+	// Window is how soon after the first key of a chord the others must be pressed, in window mode.
+	Window time.Duration
+	// StaleAfter is how long a key can be held before it is treated as released: releases get lost
+	// when a keyboard disconnects.
+	StaleAfter time.Duration
+	// MinKeys is the smallest number of keys that is a combo.
+	MinKeys int
+}
+
+func DefaultComboOptions() ComboOptions {
+	return ComboOptions{
+		Mode:       ChordOverlap,
+		Window:     50 * time.Millisecond,
+		StaleAfter: 10 * time.Second,
+		MinKeys:    2,
+	}
 }
 
+// ParseComboOptions reads options from the config of the combos tracker: mode, window, stale-after
+// and min-keys. Options that are not given keep their defaults.
+func ParseComboOptions(options TrackerOptions) (ComboOptions, error) {
+	result := DefaultComboOptions()
+
+	for key, value := range options {
+		var err error
+
+		switch key {
+		case "mode":
+			result.Mode = ChordMode(value)
+		case "window":
+			result.Window, err = time.ParseDuration(value)
+		case "stale-after":
+			result.StaleAfter, err = time.ParseDuration(value)
+		case "min-keys":
+			result.MinKeys, err = strconv.Atoi(value)
+		default:
+			return result, fmt.Errorf("unknown option '%s' of combos tracker", key)
+		}
+
+		if err != nil {
+			return result, fmt.Errorf("invalid %s '%s' of combos tracker: %w", key, value, err)
+		}
+	}
+
+	return result, result.validate()
+}
+
+func (o ComboOptions) validate() error {
+	switch o.Mode {
+	case ChordOverlap, ChordWindow, ChordMaximal:
+	default:
+		return fmt.Errorf("unknown chord mode '%s', must be one of %s, %s or %s", o.Mode, ChordOverlap, ChordWindow, ChordMaximal)
+	}
+
+	if o.Mode == ChordWindow && o.Window <= 0 {
+		return fmt.Errorf("window of chords must be positive, got %v", o.Window)
+	}
+
+	if o.StaleAfter <= 0 {
+		return fmt.Errorf("stale timeout must be positive, got %v", o.StaleAfter)
+	}
+
+	if o.MinKeys < 1 {
+		return fmt.Errorf("combos need at least one key, got %d", o.MinKeys)
+	}
+
+	return nil
+}
+
+// ComboTracker counts keys that are held down together.
 type ComboTracker struct {
+	options     ComboOptions
 	comboCounts map[ComboBitmask]*model.Combo
-	curState    []*keyState
-	keys        []*model.KeyPosition
-	minComboLen int
-	stateLock   sync.RWMutex
+	// index lists combos under each key of them, changed are positions whose combos were counted
+	// since the last snapshot. Only those are copied again when a snapshot is taken.
+	index    map[model.KeyPosition][]*model.Combo
+	changed  map[model.KeyPosition]bool
+	snapshot CombosByPosition
+	version  uint64
+	// timelines are keys held on every source, events of different machines are interleaved in
+	// the history and must not make combos together.
+	timelines map[string]*chordState
+	stateLock sync.RWMutex
+}
+
+// chordState is what is held down on one source.
+type chordState struct {
+	// held are keys that are held down, with the time they were pressed.
+	held map[model.KeyPosition]time.Time
+	// chord are keys pressed within the window so far, in window mode.
+	chord      []model.KeyPosition
+	chordStart time.Time
+	// grown tells that a key was pressed since the last chord was counted, in maximal mode.
+	grown bool
 }
 
-func newComboTracker(keyCount, minComboLen int) *ComboTracker {
-	return &ComboTracker{
-		comboCounts: make(map[ComboBitmask]*model.Combo),
-		curState:    make([]*keyState, keyCount),
-		keys:        make([]*model.KeyPosition, keyCount),
-		minComboLen: minComboLen,
+func NewComboTracker() *ComboTracker {
+	tracker, _ := NewComboTrackerWithOptions(DefaultComboOptions())
+
+	return tracker
+}
+
+func NewComboTrackerWithOptions(options ComboOptions) (*ComboTracker, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
 
+	tracker := &ComboTracker{
+		options:   options,
 		stateLock: sync.RWMutex{},
 	}
+	tracker.reset()
+
+	return tracker, nil
 }
 
-func NewComboTrackerFromDB(storage Storage) (*ComboTracker, error) {
-	tracker := newComboTracker(100, 2)
+// NewComboTrackerFromEvents scans the given events before returning, e.g. to count combos in a part of the history.
+func NewComboTrackerFromEvents(items iter.Seq[model.KeyEventWithTimestamp]) *ComboTracker {
+	tracker := NewComboTracker()
+	_ = tracker.Init(items)
+
+	return tracker
+}
 
-	// TODO: make the init stage a bit clearer? forbid combos page calls until this init is done?
-	go func() {
-		iterator, err := storage.AllIterator()
+func (c *ComboTracker) Init(history iter.Seq[model.KeyEventWithTimestamp]) error {
+	c.Reset()
+
+	bar := progressbar.Default(-1, "Scanning history...")
+	for item := range history {
+		err := bar.Add(1)
 		if err != nil {
-			panic(err)
+			slog.Error("could not update progress bar", "error", err)
 		}
 
-		tracker.initComboCounter(iterator)
-	}()
+		c.Handle(item)
+	}
 
-	return tracker, nil
+	err := bar.Finish()
+	if err != nil {
+		slog.Error("could not finish progress bar", "error", err)
+	}
+
+	return nil
 }
 
-func (c *ComboTracker) HandleKeyNow(position model.KeyPosition, pressed bool, verbose bool) {
-	c.handleKey(position, pressed, time.Now(), verbose)
+func (c *ComboTracker) Handle(event model.KeyEventWithTimestamp) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	c.handleKey(event.Source, event.Position, event.Pressed, event.Timestamp)
 }
 
-func (c *ComboTracker) GatherCombos(position model.KeyPosition) []model.Combo {
-	c.stateLock.RLock()
-	defer c.stateLock.RUnlock()
+// Snapshot lists each combo under every key of it. Combos of positions that did not change since
+// the last snapshot are shared with it.
+func (c *ComboTracker) Snapshot() Snapshot {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	if c.snapshot != nil && len(c.changed) == 0 {
+		return c.snapshot
+	}
+
+	result := make(CombosByPosition, len(c.index))
 
-	result := make([]model.Combo, 0, len(c.comboCounts))
+	for position, combos := range c.index {
+		if previous, ok := c.snapshot[position]; ok && !c.changed[position] {
+			result[position] = previous
+
+			continue
+		}
 
-	for _, v := range c.comboCounts {
-		if slices.Contains(v.Keys, position) {
-			result = append(result, *v)
+		copied := make([]model.Combo, len(combos))
+		for i, combo := range combos {
+			copied[i] = *combo
 		}
+
+		result[position] = copied
 	}
 
+	c.snapshot = result
+	clear(c.changed)
+
 	return result
 }
 
-func (c *ComboTracker) handleKey(position model.KeyPosition, pressed bool, timeWhen time.Time, verbose bool) {
+// Version changes every time a combo is counted.
+func (c *ComboTracker) Version() uint64 {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+
+	return c.version
+}
+
+func (c *ComboTracker) Reset() {
 	c.stateLock.Lock()
 	defer c.stateLock.Unlock()
 
-	if c.keys[position] == nil {
-		key := position
-		c.keys[position] = &key
+	c.reset()
+}
+
+func (c *ComboTracker) reset() {
+	c.comboCounts = make(map[ComboBitmask]*model.Combo)
+	c.index = make(map[model.KeyPosition][]*model.Combo)
+	c.changed = make(map[model.KeyPosition]bool)
+	c.snapshot = nil
+	c.version++
+	c.timelines = make(map[string]*chordState)
+}
+
+func (c *ComboTracker) handleKey(source string, position model.KeyPosition, pressed bool, timeWhen time.Time) {
+	if position < 0 {
+		slog.Warn("ignoring key with negative position", "position", position)
+
+		return
 	}
 
-	c.curState[position] = &keyState{pressed: pressed, timeWhen: timeWhen}
+	state, ok := c.timelines[source]
+	if !ok {
+		state = &chordState{held: make(map[model.KeyPosition]time.Time)}
+		c.timelines[source] = state
+	}
 
-	pressedKeys := make([]model.KeyPosition, 0)
+	c.dropStaleKeys(state, timeWhen)
 
-	for k, p := range c.curState {
-		if p == nil {
-			continue
-		}
-		// Ignore key states that have been "true" for too long - for cases when keypress was kost
-		if p.pressed && timeWhen.Sub(p.timeWhen) > 10*time.Second {
-			if verbose {
-				slog.Info("ignoring stale key",
-					"position", k,
-					"staleness", timeWhen.Sub(p.timeWhen))
-			}
+	switch c.options.Mode {
+	case ChordOverlap:
+		state.setHeld(position, pressed, timeWhen)
+		c.count(state.heldKeys())
 
-			p.pressed = false
+	case ChordMaximal:
+		if pressed {
+			state.grown = true
+		} else if _, ok := state.held[position]; ok && state.grown {
+			// The first release after presses: the chord was at its largest right before it.
+			c.count(state.heldKeys())
+			state.grown = false
 		}
 
-		if p.pressed {
-			pressedKeys = append(pressedKeys, *c.keys[k])
+		state.setHeld(position, pressed, timeWhen)
+
+	case ChordWindow:
+		switch {
+		case pressed && len(state.chord) > 0 && timeWhen.Sub(state.chordStart) <= c.options.Window:
+			state.chord = append(state.chord, position)
+		case pressed:
+			c.finishChord(state)
+			state.chord = []model.KeyPosition{position}
+			state.chordStart = timeWhen
+		case slices.Contains(state.chord, position):
+			c.finishChord(state)
 		}
+
+		state.setHeld(position, pressed, timeWhen)
 	}
+}
 
-	if len(pressedKeys) >= c.minComboLen {
-		id := ComboKeyID(pressedKeys)
+func (s *chordState) setHeld(position model.KeyPosition, pressed bool, timeWhen time.Time) {
+	if pressed {
+		s.held[position] = timeWhen
+	} else {
+		delete(s.held, position)
+	}
+}
 
-		v, ok := c.comboCounts[id]
-		if !ok {
-			v = &model.Combo{Keys: pressedKeys, Pressed: 1}
-			c.comboCounts[id] = v
-		} else {
-			v.Pressed++
-		}
+// dropStaleKeys treats keys that have been held for too long as released - for cases when
+// the release was lost.
+func (c *ComboTracker) dropStaleKeys(state *chordState, timeWhen time.Time) {
+	for position, since := range state.held {
+		if timeWhen.Sub(since) > c.options.StaleAfter {
+			slog.Debug("ignoring stale key",
+				"position", position,
+				"staleness", timeWhen.Sub(since))
 
-		if verbose {
-			slog.Info("combo counting",
-				"keyCount", len(pressedKeys),
-				"pressed", v.Pressed,
-				"keys", pressedKeys)
+			delete(state.held, position)
 		}
 	}
+
+	if len(state.chord) > 0 && timeWhen.Sub(state.chordStart) > c.options.StaleAfter {
+		state.chord = nil
+	}
 }
 
-func (c *ComboTracker) initComboCounter(items iter.Seq[model.KeyEventWithTimestamp]) {
-	bar := progressbar.Default(-1, "Scanning history...")
-	for item := range items {
-		err := bar.Add(1)
-		if err != nil {
-			slog.Error("could not update progress bar", "error", err)
-		}
+// heldKeys returns keys that are held down, sorted by position.
+func (s *chordState) heldKeys() []model.KeyPosition {
+	return slices.Sorted(maps.Keys(s.held))
+}
 
-		c.handleKey(item.Position, item.Pressed, item.Timestamp, false)
+func (c *ComboTracker) finishChord(state *chordState) {
+	c.count(state.chord)
+	state.chord = nil
+}
+
+func (c *ComboTracker) count(keys []model.KeyPosition) {
+	if len(keys) < c.options.MinKeys {
+		return
 	}
 
-	err := bar.Finish()
-	if err != nil {
-		slog.Error("could not finish progress bar", "error", err)
+	c.add(keys, 1)
+}
+
+// Seed adds combos kept in rollups to what was counted.
+func (c *ComboTracker) Seed(rollup *Rollup) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	for _, combo := range rollup.Combos[CombosTracker] {
+		c.add(combo.Keys, combo.Pressed)
+	}
+}
+
+func (c *ComboTracker) add(keys []model.KeyPosition, pressed int) {
+	id := ComboKeyID(keys)
+
+	v, ok := c.comboCounts[id]
+	if !ok {
+		keys = slices.Clone(keys)
+		slices.Sort(keys)
+
+		v = &model.Combo{Keys: keys, Pressed: pressed}
+		c.comboCounts[id] = v
+
+		for _, key := range keys {
+			c.index[key] = append(c.index[key], v)
+		}
+	} else {
+		v.Pressed += pressed
 	}
+
+	for _, key := range v.Keys {
+		c.changed[key] = true
+	}
+
+	c.version++
 }
 
 type ComboBitmask struct {
 	High uint64
 	Low  uint64
+	// Extra holds bits of positions from 128 on, 8 bytes per 64 positions, so keyboards of any size fit.
+	Extra string
 }
 
 // Represent combo by a bitmask. Each key present in the combo
 // will have its' bit set to 1 in the mask. Key.position is used for that.
-// Assert that Keyboard has at most 128 keys because I don't really care
-// about keyboards larger than that.
+// Positions below 128 fit into two words, which is enough for most keyboards;
+// larger ones are kept in Extra. Positions must not be negative.
 func ComboKeyID(keys []model.KeyPosition) ComboBitmask {
 	result := ComboBitmask{}
 
+	var extra []uint64
+
 	for _, key := range keys {
 		intKey := int(key)
-		if intKey < 64 {
+
+		switch {
+		case intKey < 64:
 			result.Low |= (1 << intKey)
-		} else {
-			position := intKey % 64
-			result.High |= (1 << position)
+		case intKey < 128:
+			result.High |= (1 << (intKey - 64))
+		default:
+			word := (intKey - 128) / 64
+			for len(extra) <= word {
+				extra = append(extra, 0)
+			}
+
+			extra[word] |= 1 << ((intKey - 128) % 64)
 		}
 	}
 
+	if len(extra) > 0 {
+		bytes := make([]byte, 0, 8*len(extra))
+		for _, word := range extra {
+			bytes = binary.LittleEndian.AppendUint64(bytes, word)
+		}
+
+		result.Extra = string(bytes)
+	}
+
 	return result
 }
@@ -2,9 +2,13 @@ package db_test
 
 import (
 	"cmp"
+	"context"
 	"database/sql"
+	"fmt"
 	"log/slog"
 	"slices"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -40,15 +44,25 @@ func sortCombos(result []model.Combo) {
 	})
 }
 
+// scanDB counts combos of everything stored, like tracking does when it starts.
+func scanDB(t testing.TB, storage db.Storage) *db.ComboTracker {
+	t.Helper()
+
+	history, failed := db.StopOnError(storage.History(context.Background(), db.HistoryQuery{}))
+	tracker := db.NewComboTrackerFromEvents(history)
+	require.NoError(t, failed())
+
+	return tracker
+}
+
 func TestGatherCombos(t *testing.T) {
 	t.Run("returns empty combos by default", func(t *testing.T) {
 		storage, err := db.NewStorageFromPath(":memory:", false)
 		require.NoError(t, err)
 
-		tracker, err := db.NewComboTrackerFromDB(storage)
-		require.NoError(t, err)
+		tracker := scanDB(t, storage)
 
-		items := tracker.GatherCombos(1)
+		items := tracker.Snapshot().Combos(1)
 
 		assert.Empty(t, items)
 	})
@@ -81,12 +95,9 @@ func TestGatherCombos(t *testing.T) {
 		storage, err := db.NewStorageFromConnection(conn, false)
 		require.NoError(t, err)
 
-		tracker, err := db.NewComboTrackerFromDB(storage)
-		require.NoError(t, err)
-
-		time.Sleep(120 * time.Millisecond)
+		tracker := scanDB(t, storage)
 
-		combos := tracker.GatherCombos(1)
+		combos := tracker.Snapshot().Combos(1)
 
 		assert.Equal(t, []model.Combo{
 			{
@@ -129,12 +140,9 @@ func TestGatherCombos(t *testing.T) {
 		storage, err := db.NewStorageFromConnection(conn, false)
 		require.NoError(t, err)
 
-		tracker, err := db.NewComboTrackerFromDB(storage)
-		require.NoError(t, err)
-
-		time.Sleep(120 * time.Millisecond)
+		tracker := scanDB(t, storage)
 
-		combos := tracker.GatherCombos(1)
+		combos := tracker.Snapshot().Combos(1)
 
 		assert.Equal(t, []model.Combo{
 			{
@@ -175,12 +183,9 @@ func TestGatherCombos(t *testing.T) {
 		storage, err := db.NewStorageFromConnection(conn, false)
 		require.NoError(t, err)
 
-		tracker, err := db.NewComboTrackerFromDB(storage)
-		require.NoError(t, err)
-
-		time.Sleep(120 * time.Millisecond)
+		tracker := scanDB(t, storage)
 
-		combos := tracker.GatherCombos(1)
+		combos := tracker.Snapshot().Combos(1)
 
 		sortCombos(combos)
 
@@ -216,7 +221,7 @@ func TestGatherCombos(t *testing.T) {
 			},
 		}, combos)
 
-		combos = tracker.GatherCombos(6)
+		combos = tracker.Snapshot().Combos(6)
 		assert.Empty(t, combos)
 	})
 	t.Run("ignores items that happened too long ago", func(t *testing.T) {
@@ -255,13 +260,10 @@ func TestGatherCombos(t *testing.T) {
 		storage, err := db.NewStorageFromConnection(conn, false)
 		require.NoError(t, err)
 
-		tracker, err := db.NewComboTrackerFromDB(storage)
-		require.NoError(t, err)
-
-		time.Sleep(120 * time.Millisecond)
+		tracker := scanDB(t, storage)
 
-		combos := tracker.GatherCombos(1)
-		combos = append(combos, tracker.GatherCombos(3)...)
+		combos := tracker.Snapshot().Combos(1)
+		combos = append(combos, tracker.Snapshot().Combos(3)...)
 
 		assert.ElementsMatch(t, []model.Combo{
 			{
@@ -295,9 +297,158 @@ func BenchmarkComboScan(b *testing.B) {
 	}
 
 	for b.Loop() {
-		if _, err = db.NewComboTrackerFromDB(storage); err != nil {
-			b.Fatal(err)
+		scanDB(b, storage)
+	}
+}
+
+// keyEvents turns "+1 +2 -1 -2" into presses and releases of positions, the given time apart.
+func keyEvents(start time.Time, step time.Duration, script string) []model.KeyEventWithTimestamp {
+	result := make([]model.KeyEventWithTimestamp, 0)
+
+	for i, token := range strings.Fields(script) {
+		position, err := strconv.Atoi(token[1:])
+		if err != nil {
+			panic(err)
 		}
+
+		result = append(result, model.KeyEventWithTimestamp{
+			Position:  model.KeyPosition(position),
+			Pressed:   token[0] == '+',
+			Timestamp: start.Add(time.Duration(i) * step),
+		})
+	}
+
+	return result
+}
+
+func countCombos(t *testing.T, options db.ComboOptions, events []model.KeyEventWithTimestamp) map[string]int {
+	t.Helper()
+
+	tracker, err := db.NewComboTrackerWithOptions(options)
+	require.NoError(t, err)
+	require.NoError(t, tracker.Init(slices.Values(events)))
+
+	result := make(map[string]int)
+
+	for _, combo := range db.GatherAllCombos(tracker.Snapshot(), allKeys(events)) {
+		result[fmt.Sprint(combo.Keys)] = combo.Pressed
+	}
+
+	return result
+}
+
+func allKeys(events []model.KeyEventWithTimestamp) []model.KeyPosition {
+	result := make([]model.KeyPosition, 0, len(events))
+	for _, e := range events {
+		result = append(result, e.Position)
+	}
+
+	return result
+}
+
+func TestChordModes(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Three keys rolled down and released one by one, then two keys pressed far apart.
+	script := "+1 +2 +3 -3 -2 -1 +4 +5 -4 -5"
+
+	withMode := func(mode db.ChordMode) db.ComboOptions {
+		options := db.DefaultComboOptions()
+		options.Mode = mode
+
+		return options
+	}
+
+	t.Run("overlap counts every set held together", func(t *testing.T) {
+		assert.Equal(t,
+			map[string]int{"[1 2]": 2, "[1 2 3]": 1, "[4 5]": 1},
+			countCombos(t, withMode(db.ChordOverlap), keyEvents(start, 10*time.Millisecond, script)))
+	})
+
+	t.Run("maximal does not count sub-chords", func(t *testing.T) {
+		assert.Equal(t,
+			map[string]int{"[1 2 3]": 1, "[4 5]": 1},
+			countCombos(t, withMode(db.ChordMaximal), keyEvents(start, 10*time.Millisecond, script)))
+	})
+
+	t.Run("maximal counts a new chord after keys are pressed again", func(t *testing.T) {
+		assert.Equal(t,
+			map[string]int{"[1 2]": 1, "[1 3]": 1},
+			countCombos(t, withMode(db.ChordMaximal), keyEvents(start, 10*time.Millisecond, "+1 +2 -2 +3 -3 -1")))
+	})
+
+	t.Run("window only counts keys pressed close together", func(t *testing.T) {
+		options := withMode(db.ChordWindow)
+		options.Window = 25 * time.Millisecond
+
+		assert.Equal(t,
+			map[string]int{"[1 2 3]": 1, "[4 5]": 1},
+			countCombos(t, options, keyEvents(start, 10*time.Millisecond, script)))
+
+		// Keys are 20ms apart, so only the first two fit into the window.
+		assert.Equal(t,
+			map[string]int{"[1 2]": 1},
+			countCombos(t, options, keyEvents(start, 20*time.Millisecond, "+1 +2 +3 -1 -2 -3")))
+	})
+
+	t.Run("stale keys are not part of chords", func(t *testing.T) {
+		options := withMode(db.ChordOverlap)
+		options.StaleAfter = time.Second
+
+		// Release of 1 is lost, 2 and 3 are pressed long after it.
+		events := append(
+			keyEvents(start, 0, "+1"),
+			keyEvents(start.Add(5*time.Second), 10*time.Millisecond, "+2 +3 -2 -3")...)
+
+		assert.Equal(t, map[string]int{"[2 3]": 1}, countCombos(t, options, events))
+	})
+
+	t.Run("keys of different sources are not chords", func(t *testing.T) {
+		// Two machines typed at the same time, their events are interleaved in the history.
+		home := keyEvents(start, 20*time.Millisecond, "+1 +2 -1 -2")
+		work := keyEvents(start.Add(10*time.Millisecond), 20*time.Millisecond, "+3 -3 +4 -4")
+
+		for i := range work {
+			work[i].Source = "work"
+		}
+
+		events := slices.SortedFunc(slices.Values(append(home, work...)), func(a, b model.KeyEventWithTimestamp) int {
+			return a.Timestamp.Compare(b.Timestamp)
+		})
+
+		assert.Equal(t, map[string]int{"[1 2]": 1}, countCombos(t, withMode(db.ChordOverlap), events))
+
+		neighbors := db.NewNeighborCounterFromEvents(slices.Values(events))
+		assert.Equal(t,
+			[]model.Combo{{Keys: []model.KeyPosition{2, 1}, Pressed: 1}, {Keys: []model.KeyPosition{4, 3}, Pressed: 1}},
+			db.GatherAllCombos(neighbors.Snapshot(), []model.KeyPosition{1, 2, 3, 4}))
+	})
+
+	t.Run("any keyboard size", func(t *testing.T) {
+		assert.Equal(t,
+			map[string]int{"[100 300]": 1, "[100 556]": 1},
+			countCombos(t, withMode(db.ChordMaximal), keyEvents(start, 10*time.Millisecond, "+100 +300 -300 +556 -556 -100")))
+	})
+}
+
+func TestParseComboOptions(t *testing.T) {
+	options, err := db.ParseComboOptions(db.TrackerOptions{"mode": "window", "window": "30ms", "stale-after": "5s", "min-keys": "3"})
+	require.NoError(t, err)
+	assert.Equal(t, db.ComboOptions{Mode: db.ChordWindow, Window: 30 * time.Millisecond, StaleAfter: 5 * time.Second, MinKeys: 3}, options)
+
+	options, err = db.ParseComboOptions(nil)
+	require.NoError(t, err)
+	assert.Equal(t, db.DefaultComboOptions(), options)
+
+	for _, invalid := range []db.TrackerOptions{
+		{"mode": "sometimes"},
+		{"window": "soon"},
+		{"mode": "window", "window": "0s"},
+		{"stale-after": "-1s"},
+		{"min-keys": "0"},
+		{"colour": "red"},
+	} {
+		_, err := db.ParseComboOptions(invalid)
+		assert.Error(t, err, invalid)
 	}
 }
 
@@ -375,6 +526,15 @@ func TestComboKeyId(t *testing.T) {
 		assert.Equal(t, mask1, mask2)
 	})
 
+	t.Run("keys from 128 on do not collide with smaller ones", func(t *testing.T) {
+		assert.NotEqual(t, db.ComboKeyID([]model.KeyPosition{1}), db.ComboKeyID([]model.KeyPosition{129}))
+		assert.NotEqual(t, db.ComboKeyID([]model.KeyPosition{64}), db.ComboKeyID([]model.KeyPosition{192}))
+		assert.NotEqual(t, db.ComboKeyID([]model.KeyPosition{200}), db.ComboKeyID([]model.KeyPosition{264}))
+		assert.Equal(t,
+			db.ComboKeyID([]model.KeyPosition{300, 3, 1000}),
+			db.ComboKeyID([]model.KeyPosition{1000, 300, 3}))
+	})
+
 	t.Run("different keys should produce different bitmasks", func(t *testing.T) {
 		keys1 := []model.KeyPosition{
 			model.KeyPosition(5),
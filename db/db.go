@@ -1,12 +1,13 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"iter"
 	"log"
 	"log/slog"
-	"time"
+	"os"
+	"slices"
 
 	"github.com/dasdy/glover/model"
 	// This registers sqlite3 as sql connection provider.
@@ -17,11 +18,80 @@ import (
 type SQLiteStorage struct {
 	db      *sql.DB
 	verbose bool
+	// eventColumns selects fields of model.KeyEventWithTimestamp in the order they are scanned.
+	eventColumns string
+	// sourceColumn is the source column, or an empty string for databases that have none.
+	sourceColumn string
+	// rollups tells that the database keeps rollups, read-only databases of older versions do not.
+	rollups bool
+	// timestamp is the ts column formatted as timestampLayout. Read-only databases of older versions can
+	// have timestamps in go format, so theirs are normalized and can not be looked up in the index.
+	timestamp string
+}
+
+func newSQLiteStorage(db *sql.DB, verbose bool) *SQLiteStorage {
+	// Databases created before sources and keyboards were recorded can be opened read-only, so they
+	// cannot be migrated. Events of such databases come from a single directly connected keyboard.
+	columns := "row, col, position, pressed, ts"
+	sourceColumn := "source"
+
+	for _, column := range []string{"source", "keyboard"} {
+		if hasColumn(db, "keypresses", column) {
+			columns += ", " + column
+		} else {
+			columns += ", '' as " + column
+
+			if column == "source" {
+				sourceColumn = "''"
+			}
+		}
+	}
+
+	timestamp := "datetime(ts, 'subsec')"
+	if hasNormalizedTimestamps(db) {
+		timestamp = "ts"
+	}
+
+	return &SQLiteStorage{
+		db:           db,
+		verbose:      verbose,
+		eventColumns: columns,
+		sourceColumn: sourceColumn,
+		rollups:      hasColumn(db, "rollup_keys", "count"),
+		timestamp:    timestamp,
+	}
+}
+
+// queryer reads from the database, or within a transaction that has to see its own changes.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func hasColumn(db *sql.DB, table, column string) bool {
+	var count int
+
+	err := db.QueryRow(`select count(*) from pragma_table_info(?) where name = ?`, table, column).Scan(&count)
+
+	return err == nil && count > 0
 }
 
 func NewStorageFromConnection(db *sql.DB, verbose bool) (*SQLiteStorage, error) {
 	// TODO: replace verbosity thing by structured logging config
-	return &SQLiteStorage{db: db, verbose: verbose}, nil
+	return newSQLiteStorage(db, verbose), nil
+}
+
+// NewReadOnlyStorageFromPath opens an existing storage without creating or changing anything in it.
+func NewReadOnlyStorageFromPath(path string) (*SQLiteStorage, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("could not open path %s: got %w", path, err)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("could not open path %s: got %w", path, err)
+	}
+
+	return newSQLiteStorage(db, false), nil
 }
 
 // Given a path to storage, connect to it and initialize everything.
@@ -38,7 +108,7 @@ func NewStorageFromPath(path string, verbose bool) (*SQLiteStorage, error) {
 		return nil, fmt.Errorf("could not initialize db storage: got %w", err)
 	}
 
-	return &SQLiteStorage{db: db, verbose: verbose}, nil
+	return newSQLiteStorage(db, verbose), nil
 }
 
 func (s *SQLiteStorage) Store(event *model.KeyEvent) error {
@@ -52,13 +122,43 @@ func (s *SQLiteStorage) Store(event *model.KeyEvent) error {
 	return nil
 }
 
+// StoreEvent stores the event with its own timestamp, source and keyboard, e.g. when it was received from another machine.
+func (s *SQLiteStorage) StoreEvent(event *model.KeyEventWithTimestamp) error {
+	_, err := s.db.Exec(`insert into keypresses(row, col, position, pressed, ts, source, keyboard)
+	    values(?, ?, ?, ?, ?, ?, ?)`,
+		event.Row, event.Col, event.Position, event.Pressed,
+		event.Timestamp.UTC().Format(timestampLayout), event.Source, event.Keyboard)
+	if err != nil {
+		return fmt.Errorf("could not insert keypress %+v: got %w", event, err)
+	}
+
+	return nil
+}
+
 func (s *SQLiteStorage) GatherAll() ([]model.MinimalKeyEvent, error) {
-	rows, err := s.db.Query(
-		`select row, col, position, count(*) as cnt
+	return s.gatherAll("1 = 1")
+}
+
+// gatherAll counts releases of events that match the condition. Releases whose raw events were
+// deleted are taken from rollups.
+func (s *SQLiteStorage) gatherAll(condition string, args ...any) ([]model.MinimalKeyEvent, error) {
+	query := `select row, col, position, count(*) as cnt
         from keypresses
-        where pressed = false
-        group by row, col, position
-        order by row, position`)
+        where ` + condition + ` and pressed = false
+        group by row, col, position`
+
+	if s.rollups {
+		query = `select row, col, position, sum(cnt) from (
+            ` + query + `
+            union all
+            select row, col, position, sum(count) from rollup_keys
+            where ` + condition + ` and bucket < ` + prunedBefore + `
+            group by row, col, position)
+        group by row, col, position`
+		args = append(slices.Clone(args), args...)
+	}
+
+	rows, err := s.db.Query(query+` order by row, position`, args...)
 	if err != nil {
 		return nil, fmt.Errorf("could not query keypresses: got %w", err)
 	}
@@ -85,51 +185,15 @@ func (s *SQLiteStorage) GatherAll() ([]model.MinimalKeyEvent, error) {
 	return result, nil
 }
 
-func (s *SQLiteStorage) AllIterator() (iter.Seq[model.KeyEventWithTimestamp], error) {
-	rows, err := s.db.Query("select row, col, position, pressed, ts from keypresses order by ts")
-	if err != nil {
-		return nil, fmt.Errorf("could not query keypresses: got %w", err)
-	}
-
-	// I'm not convinced that this actually does anything - the check should be done after the loop finishes(?)
-	// If so, how do I return such error?
-	if rows.Err() != nil {
-		return nil, fmt.Errorf("error from getting rows: got %w", rows.Err())
-	}
-
-	return func(yield func(model.KeyEventWithTimestamp) bool) {
-		defer rows.Close()
-
-		for rows.Next() {
-			var row, col, position int
-
-			var ts time.Time
-
-			var pressed bool
-
-			err = rows.Scan(&row, &col, &position, &pressed, &ts)
-
-			item := model.KeyEventWithTimestamp{
-				Row:       row,
-				Col:       col,
-				Position:  model.KeyPosition(position),
-				Pressed:   pressed,
-				Timestamp: ts,
-			}
-
-			if !yield(item) {
-				return
-			}
-		}
-	}, nil
-}
+// timestampLayout matches what datetime(ts, 'subsec') returns, so bounds can be compared as strings.
+const timestampLayout = "2006-01-02 15:04:05.000"
 
 func (s *SQLiteStorage) Close() {
 	s.db.Close()
 }
 
-// count total amount of events in the db.
-func (s *SQLiteStorage) count() (int, error) {
+// Count returns the total amount of events in the db.
+func (s *SQLiteStorage) Count() (int, error) {
 	rows, err := s.db.Query("select count(*) from keypresses")
 	if err != nil {
 		return -1, fmt.Errorf("could not query keypresses count: got %w", err)
@@ -154,7 +218,8 @@ func (s *SQLiteStorage) count() (int, error) {
 // Given a connection to db, set up needed tables and indices.
 func InitDBStorage(db *sql.DB) error {
 	// TODO: add indices over row-col-position?
-	sqlStmt := `create table if not exists keypresses(row int, col int, position int, pressed bool, ts datetime);`
+	sqlStmt := `create table if not exists keypresses(
+        id integer primary key autoincrement, row int, col int, position int, pressed bool, ts datetime);`
 
 	_, err := db.Exec(sqlStmt)
 	if err != nil {
@@ -163,6 +228,21 @@ func InitDBStorage(db *sql.DB) error {
 		return fmt.Errorf("could not create keypresses table: got %w", err)
 	}
 
+	for _, column := range []string{"source", "keyboard"} {
+		if hasColumn(db, "keypresses", column) {
+			continue
+		}
+
+		_, err = db.Exec(`alter table keypresses add column ` + column + ` text not null default ''`)
+		if err != nil {
+			return fmt.Errorf("could not add %s column: got %w", column, err)
+		}
+	}
+
+	if err := migrateKeypressesID(db); err != nil {
+		return err
+	}
+
 	sqlStmt = `create index if not exists keypresses_tsix on keypresses (ts ASC);`
 
 	_, err = db.Exec(sqlStmt)
@@ -172,58 +252,152 @@ func InitDBStorage(db *sql.DB) error {
 		return fmt.Errorf("could not create keypresses_tsix index: got %w", err)
 	}
 
+	if err := initRollupTables(db); err != nil {
+		return err
+	}
+
+	if err := normalizeTimestamps(db); err != nil {
+		return err
+	}
+
+	return initPeersTable(db)
+}
+
+// migrateKeypressesID gives events of databases created before they had ids the id column. Rowids
+// of a table without an integer primary key can change when the database is vacuumed, so cursors of
+// history and sync kept by peers would point at other events. Rows keep their rowids as ids, so
+// cursors stored before the migration stay valid. The table is rebuilt, which drops its index and
+// triggers, so they are created after this.
+func migrateKeypressesID(db *sql.DB) error {
+	if hasColumn(db, "keypresses", "id") {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start transaction: got %w", err)
+	}
+
+	defer tx.Rollback() //nolint:errcheck // Rollback after commit is a no-op.
+
+	for _, stmt := range []string{
+		`create table keypresses_with_id(
+            id integer primary key autoincrement, row int, col int, position int, pressed bool, ts datetime,
+            source text not null default '', keyboard text not null default '')`,
+		`insert into keypresses_with_id(id, row, col, position, pressed, ts, source, keyboard)
+            select rowid, row, col, position, pressed, ts, source, keyboard from keypresses order by rowid`,
+		`drop table keypresses`,
+		`alter table keypresses_with_id rename to keypresses`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("could not add id column to keypresses: got %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit id column of keypresses: got %w", err)
+	}
+
 	return nil
 }
 
-func Merge(inputs []*SQLiteStorage, out *SQLiteStorage) error {
+// normalizedTimestampsState is set once timestamps of all events are formatted as timestampLayout.
+const normalizedTimestampsState = "normalized-timestamps"
+
+func hasNormalizedTimestamps(db *sql.DB) bool {
+	var count int
+
+	err := db.QueryRow(`select count(*) from rollup_state where name = ?`, normalizedTimestampsState).Scan(&count)
+
+	return err == nil && count > 0
+}
+
+// normalizeTimestamps formats timestamps that merge of older versions stored in go format as
+// timestampLayout, so bounds of history can be compared with the ts column as it is. It only runs once,
+// events are stored as timestampLayout since. Timestamps sqlite can not read are left for fsck to report.
+func normalizeTimestamps(db *sql.DB) error {
+	if hasNormalizedTimestamps(db) {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start transaction: got %w", err)
+	}
+
+	defer tx.Rollback() //nolint:errcheck // Rollback after commit is a no-op.
+
+	res, err := tx.Exec(`update keypresses set ts = datetime(ts, 'subsec') where ts <> datetime(ts, 'subsec')`)
+	if err != nil {
+		return fmt.Errorf("could not normalize timestamps: got %w", err)
+	}
+
+	_, err = tx.Exec(`insert into rollup_state(name, value) values(?, datetime('now', 'subsec'))`, normalizedTimestampsState)
+	if err != nil {
+		return fmt.Errorf("could not mark timestamps as normalized: got %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit normalized timestamps: got %w", err)
+	}
+
+	if normalized, err := res.RowsAffected(); err == nil && normalized > 0 {
+		slog.Info("Normalized timestamps of events", "count", normalized)
+	}
+
+	return nil
+}
+
+// Merge imports events of every input into out. Events that out already has are skipped, so merging
+// the same input again, or into a database that was merged before, does not double the counts.
+// Results are returned in the order of inputs. Merging an input stops when the context is done, and
+// nothing of that input is stored. Progress is shown for inputs that know how many events they have.
+func Merge(ctx context.Context, inputs []Storage, out Importer) ([]ImportResult, error) {
+	results := make([]ImportResult, 0, len(inputs))
+
 	for i, input := range inputs {
-		count, err := input.count()
-		if err != nil {
-			return err
-		}
+		count := -1
 
-		rows, err := input.db.Query(`
-            select row, col, position, pressed, ts from keypresses
-        `)
-		if err != nil {
-			return fmt.Errorf("could not query keypresses from input %d: got %w", i, err)
+		if counted, ok := input.(Counted); ok {
+			var err error
+
+			count, err = counted.Count()
+			if err != nil {
+				return results, err
+			}
 		}
-		defer rows.Close()
 
 		slog.Info("processing input database", "index", i)
 
 		bar := progressbar.Default(int64(count), "Writing...")
 
-		for rows.Next() {
-			err := bar.Add(1)
-			if err != nil {
-				return fmt.Errorf("could not update progress bar: got %w", err)
-			}
+		events := func(yield func(model.KeyEventWithTimestamp, error) bool) {
+			for event, err := range KeyEvents(input.History(ctx, HistoryQuery{})) {
+				if err != nil {
+					yield(event, fmt.Errorf("could not read keypresses of input %d: got %w", i, err))
 
-			var (
-				row, col, position int
-				pressed            bool
-				ts                 time.Time
-			)
+					return
+				}
 
-			err = rows.Scan(&row, &col, &position, &pressed, &ts)
-			if err != nil {
-				return fmt.Errorf("could not scan row from input %d: got %w", i, err)
-			}
+				if err := bar.Add(1); err != nil {
+					yield(event, fmt.Errorf("could not update progress bar: got %w", err))
 
-			_, err = out.db.Exec(`
-                insert into keypresses(row, col, position, pressed, ts)
-	            values(?, ?, ?, ?, ?)`,
-				row, col, position, pressed, ts)
-			if err != nil {
-				return fmt.Errorf("could not insert keypress from input %d: got %w", i, err)
+					return
+				}
+
+				if !yield(event, nil) {
+					return
+				}
 			}
 		}
 
-		if err = rows.Err(); err != nil {
-			return fmt.Errorf("error while iterating over rows from input %d: got %w", i, err)
+		result, err := out.Import(events)
+		if err != nil {
+			return results, fmt.Errorf("could not merge input %d: got %w", i, err)
 		}
+
+		results = append(results, result)
 	}
 
-	return nil
+	return results, nil
 }
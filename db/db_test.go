@@ -1,8 +1,10 @@
 package db_test
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"iter"
 	"log/slog"
 	"os"
 	"sync"
@@ -237,7 +239,9 @@ func TestMergeDatabases(t *testing.T) {
 		output, err := db.NewStorageFromPath(file3.Name(), false)
 		require.NoError(t, err)
 
-		require.NoError(t, db.Merge([]*db.SQLiteStorage{storage1, storage2}, output))
+		results, err := db.Merge(context.Background(), []db.Storage{storage1, storage2}, output)
+		require.NoError(t, err)
+		assert.Equal(t, []db.ImportResult{{Inserted: 1, First: 1, Last: 1}, {Inserted: 1, First: 2, Last: 2}}, results)
 
 		conn, err := sql.Open("sqlite3", file3.Name())
 		require.NoError(t, err)
@@ -274,4 +278,353 @@ func TestMergeDatabases(t *testing.T) {
 
 		assert.NoError(t, rows.Err())
 	})
+
+	t.Run("merging again does not duplicate events", func(t *testing.T) {
+		dir := t.TempDir()
+
+		input, err := db.NewStorageFromPath(dir+"/input.sqlite", false)
+		require.NoError(t, err)
+
+		for _, e := range mockEvents([]int{1, 2, 1, 2}) {
+			require.NoError(t, input.Store(&e))
+		}
+
+		input.Close()
+
+		readOnly, err := db.NewReadOnlyStorageFromPath(dir + "/input.sqlite")
+		require.NoError(t, err)
+
+		defer readOnly.Close()
+
+		output, err := db.NewStorageFromPath(dir+"/output.sqlite", false)
+		require.NoError(t, err)
+
+		defer output.Close()
+
+		results, err := db.Merge(context.Background(), []db.Storage{readOnly}, output)
+		require.NoError(t, err)
+		assert.Equal(t, []db.ImportResult{{Inserted: 4, First: 1, Last: 4}}, results)
+
+		results, err = db.Merge(context.Background(), []db.Storage{readOnly, readOnly}, output)
+		require.NoError(t, err)
+		assert.Equal(t, []db.ImportResult{{Skipped: 4}, {Skipped: 4}}, results)
+
+		items, err := output.GatherAll()
+		require.NoError(t, err)
+		assert.Equal(t, []model.MinimalKeyEvent{
+			{Row: 1, Col: 1, Position: 1, Count: 1},
+			{Row: 2, Col: 2, Position: 2, Count: 1},
+		}, items)
+	})
+
+	t.Run("read-only storage does not create files", func(t *testing.T) {
+		_, err := db.NewReadOnlyStorageFromPath(t.TempDir() + "/missing.sqlite")
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestHistory(t *testing.T) {
+	path := t.TempDir() + "/range.sqlite"
+
+	conn, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+
+	_, err = conn.Exec(`create table keypresses(row int, col int, position int, pressed bool, ts datetime, source text)`)
+	require.NoError(t, err)
+
+	// Both formats are present in databases of older versions: live tracking wrote sqlite text, merge
+	// wrote go time.
+	for i, ts := range []any{
+		"2024-03-01 10:00:00.000",
+		"2024-03-02 10:00:00.500",
+		time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC),
+		"2024-03-04 10:00:00.000",
+		// Same time as the first one, but stored later.
+		"2024-03-01 10:00:00.000",
+	} {
+		_, err = conn.Exec(`insert into keypresses(row, col, position, pressed, ts, source) values(0, 0, ?, false, ?, ?)`,
+			i, ts, fmt.Sprintf("host%d", i%2))
+		require.NoError(t, err)
+	}
+
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 10, 0, 0, 0, time.UTC) }
+
+	t.Run("filters timestamps of a database that is not migrated", func(t *testing.T) {
+		readOnly, err := db.NewReadOnlyStorageFromPath(path)
+		require.NoError(t, err)
+
+		defer readOnly.Close()
+
+		var positions []model.KeyPosition
+
+		for e, err := range readOnly.History(context.Background(), db.HistoryQuery{Since: day(3)}) {
+			require.NoError(t, err)
+
+			positions = append(positions, e.Position)
+		}
+
+		assert.Equal(t, []model.KeyPosition{2, 3}, positions)
+	})
+
+	require.NoError(t, db.InitDBStorage(conn))
+
+	var legacy int
+	require.NoError(t, conn.QueryRow(`select count(*) from keypresses where length(ts) <> 23`).Scan(&legacy))
+	assert.Zero(t, legacy, "timestamps in go format are normalized")
+
+	storage, err := db.NewStorageFromConnection(conn, false)
+	require.NoError(t, err)
+
+	defer storage.Close()
+
+	read := func(ctx context.Context, query db.HistoryQuery) ([]model.KeyPosition, []db.Cursor, error) {
+		positions := make([]model.KeyPosition, 0)
+		cursors := make([]db.Cursor, 0)
+
+		for item, err := range storage.History(ctx, query) {
+			if err != nil {
+				return positions, cursors, err
+			}
+
+			positions = append(positions, item.Position)
+			cursors = append(cursors, item.Cursor)
+		}
+
+		return positions, cursors, nil
+	}
+
+	positions := func(query db.HistoryQuery) []model.KeyPosition {
+		result, _, err := read(context.Background(), query)
+		require.NoError(t, err)
+
+		return result
+	}
+
+	t.Run("filters", func(t *testing.T) {
+		assert.Equal(t, []model.KeyPosition{0, 4, 1, 2, 3}, positions(db.HistoryQuery{}))
+		assert.Equal(t, []model.KeyPosition{1, 2}, positions(db.HistoryQuery{Since: day(2), Until: day(4)}))
+		assert.Equal(t, []model.KeyPosition{2, 3}, positions(db.HistoryQuery{Since: day(3)}))
+		assert.Equal(t, []model.KeyPosition{0, 4}, positions(db.HistoryQuery{Until: day(2)}))
+		assert.Equal(t, []model.KeyPosition{0, 4, 2}, positions(db.HistoryQuery{Sources: []string{"host0"}}))
+		assert.Equal(t, []model.KeyPosition{4, 3}, positions(db.HistoryQuery{Positions: []model.KeyPosition{3, 4}}))
+		assert.Equal(t, []model.KeyPosition{4}, positions(db.HistoryQuery{Sources: []string{"host0"}, Positions: []model.KeyPosition{3, 4}}))
+	})
+
+	t.Run("reads in chunks", func(t *testing.T) {
+		for _, size := range []int{1, 2, 5, 6} {
+			assert.Equal(t, []model.KeyPosition{0, 4, 1, 2, 3}, positions(db.HistoryQuery{ChunkSize: size}), "chunk size %d", size)
+		}
+	})
+
+	t.Run("resumes after cursor", func(t *testing.T) {
+		_, cursors, err := read(context.Background(), db.HistoryQuery{})
+		require.NoError(t, err)
+
+		// Events with the same timestamp are told apart.
+		assert.Equal(t, []model.KeyPosition{4, 1, 2, 3}, positions(db.HistoryQuery{After: cursors[0]}))
+		assert.Equal(t, []model.KeyPosition{3}, positions(db.HistoryQuery{After: cursors[3], ChunkSize: 1}))
+		assert.Empty(t, positions(db.HistoryQuery{After: cursors[4]}))
+
+		parsed, err := db.ParseCursor(cursors[1].String())
+		require.NoError(t, err)
+		assert.Equal(t, cursors[1], parsed)
+
+		_, err = db.ParseCursor("not a cursor")
+		require.Error(t, err)
+	})
+
+	t.Run("stops when context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		count := 0
+
+		for _, err := range storage.History(ctx, db.HistoryQuery{ChunkSize: 2}) {
+			if err != nil {
+				require.ErrorIs(t, err, context.Canceled)
+
+				break
+			}
+
+			count++
+
+			cancel()
+		}
+
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("returns scan errors", func(t *testing.T) {
+		_, err = conn.Exec(`insert into keypresses(row, col, position, pressed, ts) values('broken', 0, 5, false, '2024-03-05 10:00:00.000')`)
+		require.NoError(t, err)
+
+		result, _, err := read(context.Background(), db.HistoryQuery{ChunkSize: 2})
+		require.Error(t, err)
+		assert.Equal(t, []model.KeyPosition{0, 4, 1, 2}, result, "events before the broken chunk are read")
+	})
+}
+
+func TestImport(t *testing.T) {
+	storage, err := db.NewStorageFromPath(t.TempDir()+"/import.sqlite", false)
+	require.NoError(t, err)
+
+	defer storage.Close()
+
+	ts := time.Date(2024, 3, 1, 10, 0, 0, 123_456_789, time.UTC)
+	events := []model.KeyEventWithTimestamp{
+		{Row: 1, Col: 1, Position: 1, Pressed: true, Timestamp: ts},
+		{Row: 1, Col: 1, Position: 1, Pressed: false, Timestamp: ts.Add(time.Second)},
+		// Same as the first one once truncated to milliseconds.
+		{Row: 1, Col: 1, Position: 1, Pressed: true, Timestamp: ts.Add(time.Microsecond)},
+	}
+
+	seq := func(items []model.KeyEventWithTimestamp) iter.Seq2[model.KeyEventWithTimestamp, error] {
+		return func(yield func(model.KeyEventWithTimestamp, error) bool) {
+			for _, e := range items {
+				if !yield(e, nil) {
+					return
+				}
+			}
+		}
+	}
+
+	result, err := storage.Import(seq(events))
+	require.NoError(t, err)
+	assert.Equal(t, db.ImportResult{Inserted: 2, Skipped: 1, First: 1, Last: 2}, result)
+
+	result, err = storage.Import(seq(events))
+	require.NoError(t, err)
+	assert.Equal(t, db.ImportResult{Inserted: 0, Skipped: 3}, result)
+
+	t.Run("broken input is rolled back", func(t *testing.T) {
+		broken := func(yield func(model.KeyEventWithTimestamp, error) bool) {
+			if yield(model.KeyEventWithTimestamp{Position: 2, Timestamp: ts}, nil) {
+				yield(model.KeyEventWithTimestamp{}, assert.AnError)
+			}
+		}
+
+		_, err := storage.Import(broken)
+		require.ErrorIs(t, err, assert.AnError)
+	})
+
+	stored := make([]model.KeyEventWithTimestamp, 0)
+	for e, err := range db.KeyEvents(storage.History(context.Background(), db.HistoryQuery{})) {
+		require.NoError(t, err)
+
+		stored = append(stored, e)
+	}
+
+	assert.Equal(t, []model.KeyEventWithTimestamp{
+		{Row: 1, Col: 1, Position: 1, Pressed: true, Timestamp: ts.Truncate(time.Millisecond)},
+		{Row: 1, Col: 1, Position: 1, Pressed: false, Timestamp: ts.Add(time.Second).Truncate(time.Millisecond)},
+	}, stored)
+}
+
+func TestKeypressesID(t *testing.T) {
+	path := t.TempDir() + "/old.sqlite"
+
+	// A database of a version whose keypresses had no ids, with a gap in rowids left by a deleted row.
+	old, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+
+	for _, stmt := range []string{
+		`create table keypresses(row int, col int, position int, pressed bool, ts datetime,
+            source text not null default '', keyboard text not null default '')`,
+		`insert into keypresses values
+            (1, 1, 1, true, '2024-03-01 10:00:00.000', '', ''),
+            (1, 1, 1, false, '2024-03-01 10:00:00.100', '', ''),
+            (2, 2, 2, true, '2024-03-01 10:00:00.200', '', ''),
+            (2, 2, 2, false, '2024-03-01 10:00:00.300', '', '')`,
+		`delete from keypresses where rowid = 2`,
+	} {
+		_, err := old.Exec(stmt)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, old.Close())
+
+	storage, err := db.NewStorageFromPath(path, false)
+	require.NoError(t, err)
+
+	defer storage.Close()
+
+	cursors := func() []int64 {
+		t.Helper()
+
+		var result []int64
+
+		for cursor := int64(0); ; {
+			events, next, err := storage.EventsAfter(cursor, 1)
+			require.NoError(t, err)
+
+			if len(events) == 0 {
+				return result
+			}
+
+			cursor = next
+			result = append(result, cursor)
+		}
+	}
+
+	t.Run("rows keep their rowids as ids", func(t *testing.T) {
+		assert.Equal(t, []int64{1, 3, 4}, cursors())
+	})
+
+	t.Run("new events get next ids", func(t *testing.T) {
+		require.NoError(t, storage.StoreEvent(&model.KeyEventWithTimestamp{
+			Position: 3, Timestamp: time.Date(2024, 3, 1, 10, 0, 1, 0, time.UTC),
+		}))
+
+		assert.Equal(t, []int64{1, 3, 4, 5}, cursors())
+	})
+
+	t.Run("index and triggers of the rebuilt table are created again", func(t *testing.T) {
+		conn, err := sql.Open("sqlite3", path)
+		require.NoError(t, err)
+
+		defer conn.Close()
+
+		var count int
+
+		require.NoError(t, conn.QueryRow(`select count(*) from sqlite_master
+            where tbl_name = 'keypresses' and type in ('index', 'trigger')`).Scan(&count))
+		assert.Equal(t, 2, count)
+	})
+}
+
+func TestForKeyboard(t *testing.T) {
+	storage, err := db.NewStorageFromPath(t.TempDir()+"/keyboards.sqlite", false)
+	require.NoError(t, err)
+
+	defer storage.Close()
+
+	ts := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	// Recorded before keyboards were configured.
+	require.NoError(t, storage.StoreEvent(&model.KeyEventWithTimestamp{Position: 1, Timestamp: ts}))
+	require.NoError(t, storage.ForKeyboard("glove80", false).StoreEvent(&model.KeyEventWithTimestamp{Position: 2, Timestamp: ts}))
+	require.NoError(t, storage.ForKeyboard("numpad", false).StoreEvent(
+		&model.KeyEventWithTimestamp{Position: 3, Timestamp: ts, Keyboard: "glove80"}))
+
+	positions := func(s db.Storage) []model.KeyPosition {
+		all, err := s.GatherAll()
+		require.NoError(t, err)
+
+		result := make([]model.KeyPosition, 0)
+		for _, e := range all {
+			result = append(result, e.Position)
+		}
+
+		return result
+	}
+
+	assert.ElementsMatch(t, []model.KeyPosition{2}, positions(storage.ForKeyboard("glove80", false)))
+	assert.ElementsMatch(t, []model.KeyPosition{1, 2}, positions(storage.ForKeyboard("glove80", true)))
+	assert.ElementsMatch(t, []model.KeyPosition{3}, positions(storage.ForKeyboard("numpad", false)))
+	assert.ElementsMatch(t, []model.KeyPosition{1, 2, 3}, positions(storage))
+
+	for e, err := range storage.ForKeyboard("numpad", false).History(context.Background(), db.HistoryQuery{}) {
+		require.NoError(t, err)
+		assert.Equal(t, "numpad", e.Keyboard)
+	}
 }
@@ -0,0 +1,711 @@
+// Package eventlog keeps key events in an append-only log of binary segments, for high rates of events
+// and fast replays of the whole history. An event takes around a dozen bytes. Events are only ever
+// appended: the log can not delete events, so it has no retention, and only one process may write it:
+// opening a log for writing locks it, read-only opens do not.
+//
+// The log is a directory of segment files named after the sequence number of their first event. Records
+// are written straight to the file, so events survive the process crashing; a record torn by a power loss
+// is cut off when the log is opened. Opening reads the whole log once, to count releases and to know
+// which time every segment covers.
+package eventlog
+
+import (
+	"cmp"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/model"
+)
+
+// Scheme of locations of event logs, e.g. log://./events.
+const Scheme = "log"
+
+// DefaultSegmentSize is the size after which the log starts a new segment.
+const DefaultSegmentSize = 64 << 20
+
+const (
+	segmentExt = ".seg"
+	peersFile  = "peers.json"
+	// lockFile is locked by the process that writes the log.
+	lockFile = "LOCK"
+)
+
+var (
+	// ErrReadOnly is returned when events are stored into a log opened read-only.
+	ErrReadOnly = errors.New("event log is opened read-only")
+	// ErrInUse is returned when a log is opened for writing while another one writes it.
+	ErrInUse = errors.New("event log is in use by another process")
+)
+
+func init() {
+	db.RegisterBackend(Scheme, func(location db.Location, opts db.OpenOptions) (db.Storage, error) {
+		options := Options{ReadOnly: opts.ReadOnly}
+
+		if value := location.Params.Get("segment-size"); value != "" {
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size <= 0 {
+				return nil, fmt.Errorf("invalid segment-size '%s', must be a positive number of bytes", value)
+			}
+
+			options.SegmentSize = size
+		}
+
+		return Open(location.Path, options)
+	})
+}
+
+// Options configure a log.
+type Options struct {
+	// SegmentSize is the size after which a new segment is started, DefaultSegmentSize if zero.
+	SegmentSize int64
+	// ReadOnly opens an existing log without changing anything in it.
+	ReadOnly bool
+}
+
+type releaseKey struct {
+	row, col int
+	position model.KeyPosition
+}
+
+// Log is a storage that appends events to segment files.
+type Log struct {
+	dir     string
+	options Options
+
+	lock     sync.RWMutex
+	segments []*segment
+	// active is the last segment opened for appending, nil when the log is read-only.
+	active  *os.File
+	encoder *encoder
+	// locked is the lock file the log holds while it is open for writing.
+	locked *os.File
+	counts map[releaseKey]int
+	peers  map[string]db.Watermark
+}
+
+// Open opens the log in the directory, creating it if it does not exist.
+func Open(dir string, options Options) (*Log, error) {
+	if options.SegmentSize <= 0 {
+		options.SegmentSize = DefaultSegmentSize
+	}
+
+	if options.ReadOnly {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("could not open event log %s: %w", dir, err)
+		}
+	} else if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("could not create event log %s: %w", dir, err)
+	}
+
+	l := &Log{
+		dir:     dir,
+		options: options,
+		counts:  make(map[releaseKey]int),
+		peers:   make(map[string]db.Watermark),
+	}
+
+	if !options.ReadOnly {
+		locked, err := lock(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		l.locked = locked
+	}
+
+	if err := l.load(); err != nil {
+		l.Close()
+
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *Log) load() error {
+	paths, err := filepath.Glob(filepath.Join(l.dir, "*"+segmentExt))
+	if err != nil {
+		return fmt.Errorf("could not list segments: %w", err)
+	}
+
+	slices.Sort(paths)
+
+	next := int64(1)
+
+	for i, path := range paths {
+		first, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(path), segmentExt), 10, 64)
+		if err != nil || first != next {
+			return fmt.Errorf("segment %s does not continue the log, expected it to start with event %d", path, next)
+		}
+
+		s, enc, err := scanSegment(path, first, l.count)
+		if err != nil {
+			return err
+		}
+
+		if info, err := os.Stat(path); err == nil && info.Size() > s.size && i < len(paths)-1 {
+			return fmt.Errorf("segment %s is damaged after %d bytes", path, s.size)
+		}
+
+		l.segments = append(l.segments, s)
+		l.encoder = enc
+		next = first + s.count
+	}
+
+	if err := l.loadPeers(); err != nil {
+		return err
+	}
+
+	if l.options.ReadOnly {
+		return nil
+	}
+
+	if len(l.segments) == 0 {
+		return l.startSegment(1)
+	}
+
+	// A torn tail is cut off, so appended records follow complete ones.
+	last := l.segments[len(l.segments)-1]
+
+	f, err := os.OpenFile(last.path, os.O_RDWR, 0o640)
+	if err != nil {
+		return fmt.Errorf("could not open segment: %w", err)
+	}
+
+	if err := f.Truncate(last.size); err != nil {
+		f.Close()
+
+		return fmt.Errorf("could not cut off torn tail of %s: %w", last.path, err)
+	}
+
+	if _, err := f.Seek(last.size, 0); err != nil {
+		f.Close()
+
+		return fmt.Errorf("could not open segment: %w", err)
+	}
+
+	l.active = f
+
+	return nil
+}
+
+func (l *Log) count(e entry) {
+	if !e.event.Pressed {
+		l.counts[releaseKey{e.event.Row, e.event.Col, e.event.Position}]++
+	}
+}
+
+// startSegment starts a new segment whose first event has the sequence number.
+func (l *Log) startSegment(first int64) error {
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", first, segmentExt))
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return fmt.Errorf("could not create segment: %w", err)
+	}
+
+	if _, err := f.Write(magic); err != nil {
+		f.Close()
+
+		return fmt.Errorf("could not write segment header: %w", err)
+	}
+
+	if l.active != nil {
+		l.active.Close()
+	}
+
+	l.active = f
+	l.encoder = newEncoder()
+	l.segments = append(l.segments, &segment{path: path, first: first, size: int64(len(magic)), sorted: true})
+
+	return nil
+}
+
+// append writes events to the end of the log, starting new segments as they fill up. Every segment
+// gets a single write, which is undone if it fails.
+func (l *Log) append(events []model.KeyEventWithTimestamp) error {
+	if l.active == nil {
+		return ErrReadOnly
+	}
+
+	for len(events) > 0 {
+		last := l.segments[len(l.segments)-1]
+		if last.size >= l.options.SegmentSize {
+			if err := l.startSegment(last.first + last.count); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// The encoder is only kept once the write succeeds.
+		encoder := &encoder{ids: make(map[string]uint64, len(l.encoder.ids)), lastTs: l.encoder.lastTs}
+		for s, id := range l.encoder.ids {
+			encoder.ids[s] = id
+		}
+
+		described := *last
+
+		var buf []byte
+
+		written := 0
+		for _, event := range events {
+			if int64(len(buf))+described.size >= l.options.SegmentSize && written > 0 {
+				break
+			}
+
+			buf = encoder.append(buf, &event)
+			described.add(event.Timestamp.UnixMilli())
+			written++
+		}
+
+		if _, err := l.active.Write(buf); err != nil {
+			if truncateErr := l.active.Truncate(last.size); truncateErr != nil {
+				return errors.Join(fmt.Errorf("could not append to event log: %w", err), truncateErr)
+			}
+
+			if _, seekErr := l.active.Seek(last.size, 0); seekErr != nil {
+				return errors.Join(fmt.Errorf("could not append to event log: %w", err), seekErr)
+			}
+
+			return fmt.Errorf("could not append to event log: %w", err)
+		}
+
+		described.size += int64(len(buf))
+		*last = described
+		l.encoder = encoder
+
+		for _, event := range events[:written] {
+			l.count(entry{event: event})
+		}
+
+		events = events[written:]
+	}
+
+	return nil
+}
+
+// stored is the event as the log keeps it.
+func stored(event model.KeyEventWithTimestamp) model.KeyEventWithTimestamp {
+	event.Timestamp = event.Timestamp.UTC().Truncate(time.Millisecond)
+
+	return event
+}
+
+func (l *Log) Store(event *model.KeyEvent) error {
+	return l.StoreEvent(&model.KeyEventWithTimestamp{
+		Row:       event.Row,
+		Col:       event.Col,
+		Position:  event.Position,
+		Pressed:   event.Pressed,
+		Timestamp: time.Now(),
+	})
+}
+
+func (l *Log) StoreEvent(event *model.KeyEventWithTimestamp) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.append([]model.KeyEventWithTimestamp{stored(*event)})
+}
+
+// GatherAll returns releases counted as the log was opened and appended to.
+func (l *Log) GatherAll() ([]model.MinimalKeyEvent, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	result := make([]model.MinimalKeyEvent, 0, len(l.counts))
+	for k, count := range l.counts {
+		result = append(result, model.MinimalKeyEvent{Row: k.row, Col: k.col, Position: k.position, Count: count})
+	}
+
+	slices.SortFunc(result, db.CompareCounts)
+
+	return result, nil
+}
+
+func (l *Log) Count() (int, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	total := 0
+	for _, s := range l.segments {
+		total += int(s.count)
+	}
+
+	return total, nil
+}
+
+// snapshot copies descriptions of segments, so they can be read while events are appended.
+func (l *Log) snapshot() []segment {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	result := make([]segment, 0, len(l.segments))
+	for _, s := range l.segments {
+		if s.count > 0 {
+			result = append(result, *s)
+		}
+	}
+
+	return result
+}
+
+// History merges segments by time. A segment is only opened once the next event may be in it, so
+// segments that were stored in order of time are read one after another. Segments outside of the time
+// range of the query are skipped.
+func (l *Log) History(ctx context.Context, query db.HistoryQuery) iter.Seq2[db.HistoryEvent, error] {
+	return func(yield func(db.HistoryEvent, error) bool) {
+		pending := make([]segment, 0)
+
+		for _, s := range l.snapshot() {
+			if !query.Since.IsZero() && s.maxTs < query.Since.UnixMilli() {
+				continue
+			}
+
+			if !query.Until.IsZero() && s.minTs >= query.Until.UnixMilli() {
+				continue
+			}
+
+			pending = append(pending, s)
+		}
+
+		slices.SortStableFunc(pending, func(a, b segment) int { return cmp.Compare(a.minTs, b.minTs) })
+
+		heads := &headHeap{}
+		defer heads.stop()
+
+		for {
+			for len(pending) > 0 && (heads.Len() == 0 || pending[0].minTs <= (*heads)[0].ts()) {
+				h := &head{}
+				h.next, h.close = iter.Pull2(pending[0].inOrder())
+				pending = pending[1:]
+
+				if h.advance() {
+					heap.Push(heads, h)
+
+					continue
+				}
+
+				h.close()
+
+				if h.err != nil {
+					yield(db.HistoryEvent{}, h.err)
+
+					return
+				}
+			}
+
+			if heads.Len() == 0 {
+				return
+			}
+
+			h := (*heads)[0]
+			e := h.current
+
+			if err := ctx.Err(); err != nil {
+				yield(db.HistoryEvent{}, fmt.Errorf("stopped reading keypresses: %w", err))
+
+				return
+			}
+
+			if query.Matches(e.event) {
+				cursor := db.CursorAt(e.event.Timestamp, e.seq)
+				if (query.After.IsZero() || cursor.Compare(query.After) > 0) &&
+					!yield(db.HistoryEvent{KeyEventWithTimestamp: e.event, Cursor: cursor}, nil) {
+					return
+				}
+			}
+
+			if h.advance() {
+				heap.Fix(heads, 0)
+			} else {
+				heap.Pop(heads)
+				h.close()
+
+				if h.err != nil {
+					yield(db.HistoryEvent{}, h.err)
+
+					return
+				}
+			}
+		}
+	}
+}
+
+// head is the next event of a segment that is being read.
+type head struct {
+	next    func() (entry, error, bool)
+	close   func()
+	current entry
+	err     error
+}
+
+func (h *head) ts() int64 {
+	return h.current.event.Timestamp.UnixMilli()
+}
+
+// advance reads the next event, false at the end of the segment or when it can not be read.
+func (h *head) advance() bool {
+	e, err, ok := h.next()
+	if !ok {
+		return false
+	}
+
+	if err != nil {
+		h.err = err
+
+		return false
+	}
+
+	h.current = e
+
+	return true
+}
+
+type headHeap []*head
+
+func (h headHeap) Len() int { return len(h) }
+
+func (h headHeap) Less(i, j int) bool {
+	a, b := h[i].current, h[j].current
+
+	return cmp.Or(a.event.Timestamp.Compare(b.event.Timestamp), cmp.Compare(a.seq, b.seq)) < 0
+}
+
+func (h headHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *headHeap) Push(x any) { *h = append(*h, x.(*head)) } //nolint:forcetypeassert // Only heads are pushed.
+
+func (h *headHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return last
+}
+
+func (h *headHeap) stop() {
+	for _, head := range *h {
+		head.close()
+	}
+}
+
+// Close closes the segment that is appended to.
+func (l *Log) Close() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.active != nil {
+		l.active.Close()
+		l.active = nil
+	}
+
+	if l.locked != nil {
+		// Closing the file releases the lock.
+		l.locked.Close()
+		l.locked = nil
+	}
+}
+
+// Import appends events that the log does not have yet, compared the same way db.SQLiteStorage.Import
+// does. The whole log is read to find them, and nothing is appended if the input turns out to be broken.
+// The log and the input are read without holding the lock, so events are stored meanwhile. Only events
+// appended since then are compared again while the lock is held.
+func (l *Log) Import(events iter.Seq2[model.KeyEventWithTimestamp, error]) (db.ImportResult, error) {
+	var result db.ImportResult
+
+	segments := l.snapshot()
+
+	scanned := int64(0)
+	if len(segments) > 0 {
+		scanned = segments[len(segments)-1].last()
+	}
+
+	seen, err := identities(segments, 0)
+	if err != nil {
+		return result, err
+	}
+
+	var added []model.KeyEventWithTimestamp
+
+	for event, err := range events {
+		if err != nil {
+			return db.ImportResult{}, err
+		}
+
+		event = stored(event)
+
+		if id := db.IdentityOf(event); seen[id] {
+			result.Skipped++
+		} else {
+			seen[id] = true
+			added = append(added, event)
+			result.Inserted++
+		}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	current := make([]segment, len(l.segments))
+	for i, s := range l.segments {
+		current[i] = *s
+	}
+
+	appended, err := identities(current, scanned)
+	if err != nil {
+		return db.ImportResult{}, err
+	}
+
+	if len(appended) > 0 {
+		unseen := added[:0]
+
+		for _, event := range added {
+			if appended[db.IdentityOf(event)] {
+				result.Skipped++
+				result.Inserted--
+			} else {
+				unseen = append(unseen, event)
+			}
+		}
+
+		added = unseen
+	}
+
+	if len(added) > 0 {
+		last := l.segments[len(l.segments)-1]
+		result.First = last.first + last.count
+		result.Last = result.First + int64(len(added)) - 1
+	}
+
+	if err := l.append(added); err != nil {
+		return db.ImportResult{}, err
+	}
+
+	return result, nil
+}
+
+// identities are identities of events of the segments after the sequence number.
+func identities(segments []segment, after int64) (map[db.EventIdentity]bool, error) {
+	result := make(map[db.EventIdentity]bool)
+
+	for _, s := range segments {
+		if s.count == 0 || s.last() <= after {
+			continue
+		}
+
+		for e, err := range s.entries() {
+			if err != nil {
+				return nil, err
+			}
+
+			if e.seq > after {
+				result[db.IdentityOf(e.event)] = true
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// EventsAfter returns events in the order they were appended, cursors are their sequence numbers.
+func (l *Log) EventsAfter(cursor int64, limit int) ([]model.KeyEventWithTimestamp, int64, error) {
+	result := make([]model.KeyEventWithTimestamp, 0, limit)
+
+	for _, s := range l.snapshot() {
+		if s.last() <= cursor {
+			continue
+		}
+
+		for e, err := range s.entries() {
+			if err != nil {
+				return nil, cursor, err
+			}
+
+			if e.seq <= cursor {
+				continue
+			}
+
+			if len(result) == limit {
+				return result, cursor, nil
+			}
+
+			result = append(result, e.event)
+			cursor = e.seq
+		}
+	}
+
+	return result, cursor, nil
+}
+
+func (l *Log) loadPeers() error {
+	data, err := os.ReadFile(filepath.Join(l.dir, peersFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not read watermarks of peers: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &l.peers); err != nil {
+		return fmt.Errorf("could not parse watermarks of peers: %w", err)
+	}
+
+	return nil
+}
+
+func (l *Log) PeerWatermark(peer string) (db.Watermark, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return l.peers[peer], nil
+}
+
+// SetPeerWatermark stores watermarks of all peers next to segments, replacing the file at once.
+func (l *Log) SetPeerWatermark(peer string, w db.Watermark) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	peers := make(map[string]db.Watermark, len(l.peers)+1)
+	for p, watermark := range l.peers {
+		peers[p] = watermark
+	}
+
+	peers[peer] = w
+
+	data, err := json.Marshal(peers)
+	if err != nil {
+		return fmt.Errorf("could not encode watermarks of peers: %w", err)
+	}
+
+	path := filepath.Join(l.dir, peersFile)
+	if err := os.WriteFile(path+".tmp", data, 0o640); err != nil {
+		return fmt.Errorf("could not store watermark of %s: %w", peer, err)
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("could not store watermark of %s: %w", peer, err)
+	}
+
+	l.peers = peers
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package eventlog_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/db/eventlog"
+	"github.com/dasdy/glover/db/storagetest"
+	"github.com/dasdy/glover/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) db.Storage {
+		storage, err := db.Open("log://"+t.TempDir()+"/events", db.OpenOptions{})
+		require.NoError(t, err)
+
+		return storage
+	})
+
+	t.Run("small segments", func(t *testing.T) {
+		storagetest.Run(t, func(t *testing.T) db.Storage {
+			storage, err := db.Open("log://"+t.TempDir()+"/events?segment-size=64", db.OpenOptions{})
+			require.NoError(t, err)
+
+			return storage
+		})
+	})
+}
+
+func positions(t *testing.T, storage db.Storage) []model.KeyPosition {
+	t.Helper()
+
+	var result []model.KeyPosition
+
+	for event, err := range storage.History(context.Background(), db.HistoryQuery{}) {
+		require.NoError(t, err)
+
+		result = append(result, event.Position)
+	}
+
+	return result
+}
+
+func TestLog(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	t.Run("keeps events after it is reopened", func(t *testing.T) {
+		dir := t.TempDir()
+
+		log, err := eventlog.Open(dir, eventlog.Options{SegmentSize: 100})
+		require.NoError(t, err)
+
+		for i := range 50 {
+			event := model.KeyEventWithTimestamp{Position: model.KeyPosition(i), Timestamp: start.Add(time.Duration(i) * time.Second), Keyboard: "glove80"}
+			require.NoError(t, log.StoreEvent(&event))
+		}
+
+		log.Close()
+
+		segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+		require.NoError(t, err)
+		assert.Greater(t, len(segments), 1, "segments roll over")
+
+		reopened, err := eventlog.Open(dir, eventlog.Options{ReadOnly: true})
+		require.NoError(t, err)
+
+		defer reopened.Close()
+
+		read := positions(t, reopened)
+		require.Len(t, read, 50)
+		assert.Equal(t, model.KeyPosition(49), read[49])
+
+		count, err := reopened.Count()
+		require.NoError(t, err)
+		assert.Equal(t, 50, count)
+
+		require.ErrorIs(t, reopened.StoreEvent(&model.KeyEventWithTimestamp{}), eventlog.ErrReadOnly)
+	})
+
+	t.Run("cuts off a torn record", func(t *testing.T) {
+		dir := t.TempDir()
+
+		log, err := eventlog.Open(dir, eventlog.Options{})
+		require.NoError(t, err)
+
+		for i := range 3 {
+			require.NoError(t, log.StoreEvent(&model.KeyEventWithTimestamp{Position: model.KeyPosition(i), Timestamp: start}))
+		}
+
+		log.Close()
+
+		segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+		require.NoError(t, err)
+		require.Len(t, segments, 1)
+
+		info, err := os.Stat(segments[0])
+		require.NoError(t, err)
+		require.NoError(t, os.Truncate(segments[0], info.Size()-2))
+
+		log, err = eventlog.Open(dir, eventlog.Options{})
+		require.NoError(t, err)
+
+		defer log.Close()
+
+		assert.Equal(t, []model.KeyPosition{0, 1}, positions(t, log))
+
+		require.NoError(t, log.StoreEvent(&model.KeyEventWithTimestamp{Position: 5, Timestamp: start}))
+		assert.Equal(t, []model.KeyPosition{0, 1, 5}, positions(t, log), "appended after the last complete record")
+	})
+
+	t.Run("merges segments stored out of order", func(t *testing.T) {
+		log, err := eventlog.Open(t.TempDir(), eventlog.Options{SegmentSize: 60})
+		require.NoError(t, err)
+
+		defer log.Close()
+
+		// Events of a second machine are imported after the ones of this one, they interleave.
+		for _, offset := range []time.Duration{0, 500 * time.Millisecond} {
+			for i := range 10 {
+				event := model.KeyEventWithTimestamp{
+					Position:  model.KeyPosition(2*i) + model.KeyPosition(offset/(500*time.Millisecond)),
+					Timestamp: start.Add(time.Duration(i)*time.Second + offset),
+				}
+				require.NoError(t, log.StoreEvent(&event))
+			}
+		}
+
+		expected := make([]model.KeyPosition, 20)
+		for i := range expected {
+			expected[i] = model.KeyPosition(i)
+		}
+
+		assert.Equal(t, expected, positions(t, log))
+
+		var since []model.KeyPosition
+
+		for event, err := range log.History(context.Background(), db.HistoryQuery{Since: start.Add(8 * time.Second)}) {
+			require.NoError(t, err)
+
+			since = append(since, event.Position)
+		}
+
+		assert.Equal(t, []model.KeyPosition{16, 17, 18, 19}, since)
+	})
+
+	t.Run("stores events while an import is read", func(t *testing.T) {
+		log, err := eventlog.Open(t.TempDir(), eventlog.Options{})
+		require.NoError(t, err)
+
+		defer log.Close()
+
+		live := model.KeyEventWithTimestamp{Position: 1, Timestamp: start}
+		imported := model.KeyEventWithTimestamp{Position: 2, Timestamp: start.Add(time.Second)}
+
+		input := func(yield func(model.KeyEventWithTimestamp, error) bool) {
+			// The input is read e.g. from a request of a peer, live events are not held up by it.
+			stored := make(chan error)
+
+			go func() { stored <- log.StoreEvent(&live) }()
+
+			select {
+			case err := <-stored:
+				require.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "event is not stored while the import is read")
+			}
+
+			_ = yield(live, nil) && yield(imported, nil)
+		}
+
+		result, err := log.Import(input)
+		require.NoError(t, err)
+		assert.Equal(t, db.ImportResult{Inserted: 1, Skipped: 1, First: 2, Last: 2}, result, "events stored meanwhile are not imported twice")
+		assert.Equal(t, []model.KeyPosition{1, 2}, positions(t, log))
+	})
+
+	t.Run("only one writer at a time", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("logs are only locked on unix")
+		}
+
+		dir := t.TempDir()
+
+		log, err := eventlog.Open(dir, eventlog.Options{})
+		require.NoError(t, err)
+
+		_, err = eventlog.Open(dir, eventlog.Options{})
+		require.ErrorIs(t, err, eventlog.ErrInUse)
+
+		reader, err := eventlog.Open(dir, eventlog.Options{ReadOnly: true})
+		require.NoError(t, err, "readers do not lock the log")
+		reader.Close()
+
+		log.Close()
+
+		log, err = eventlog.Open(dir, eventlog.Options{})
+		require.NoError(t, err, "closing releases the lock")
+		log.Close()
+	})
+}
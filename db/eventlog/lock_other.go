@@ -0,0 +1,20 @@
+//go:build !unix
+
+package eventlog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// lock creates the lock file of the log. It is not locked on this platform, so it is up to the user
+// not to write the same log from two processes.
+func lock(dir string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(dir, lockFile), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open lock file of event log %s: %w", dir, err)
+	}
+
+	return f, nil
+}
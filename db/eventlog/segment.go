@@ -0,0 +1,338 @@
+package eventlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"iter"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/dasdy/glover/model"
+)
+
+// A segment is a file of records: the magic header, then records of [uvarint length][payload][crc32c of
+// the payload]. A payload is either a string, which gets the next id of the segment's dictionary, or an
+// event whose timestamp is the difference from the previous event of the segment in milliseconds, and
+// whose source and keyboard are ids of strings defined before it. Id 0 is the empty string. Every segment
+// starts a dictionary and a timestamp of its own, so it can be read without the others.
+var magic = []byte("GLOVLOG\x01")
+
+const (
+	kindString byte = 1
+	// kindEvent is an event that was released, kindEvent|pressedBit one that was pressed.
+	kindEvent  byte = 2
+	pressedBit byte = 0x80
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// errTorn is a record that was not written completely, e.g. when the machine lost power.
+var errTorn = errors.New("torn record")
+
+// entry is an event of a segment with its sequence number.
+type entry struct {
+	event model.KeyEventWithTimestamp
+	seq   int64
+}
+
+// segment describes a segment file.
+type segment struct {
+	path string
+	// first is the sequence number of the first event of the segment, which is also its file name.
+	first int64
+	count int64
+	// size is how many bytes of the file are complete records.
+	size         int64
+	minTs, maxTs int64
+	// sorted tells that events were stored in the order of time.
+	sorted bool
+}
+
+func (s *segment) last() int64 {
+	return s.first + s.count - 1
+}
+
+// add counts an event that was appended to the segment.
+func (s *segment) add(ts int64) {
+	if s.count == 0 {
+		s.minTs, s.maxTs, s.sorted = ts, ts, true
+	} else {
+		s.sorted = s.sorted && ts >= s.maxTs
+		s.minTs, s.maxTs = min(s.minTs, ts), max(s.maxTs, ts)
+	}
+
+	s.count++
+}
+
+// encoder writes records of a segment, it keeps the dictionary and the timestamp of the last event.
+type encoder struct {
+	ids    map[string]uint64
+	lastTs int64
+}
+
+func newEncoder() *encoder {
+	return &encoder{ids: map[string]uint64{"": 0}}
+}
+
+func appendRecord(buf, payload []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(payload)))
+	buf = append(buf, payload...)
+
+	return binary.LittleEndian.AppendUint32(buf, crc32.Checksum(payload, castagnoli))
+}
+
+func (e *encoder) id(buf []byte, s string) ([]byte, uint64) {
+	if id, ok := e.ids[s]; ok {
+		return buf, id
+	}
+
+	id := uint64(len(e.ids))
+	e.ids[s] = id
+
+	payload := append([]byte{kindString}, s...)
+
+	return appendRecord(buf, payload), id
+}
+
+// append encodes the event, with strings it needs that are not in the dictionary yet.
+func (e *encoder) append(buf []byte, event *model.KeyEventWithTimestamp) []byte {
+	buf, source := e.id(buf, event.Source)
+	buf, keyboard := e.id(buf, event.Keyboard)
+
+	ts := event.Timestamp.UnixMilli()
+
+	kind := kindEvent
+	if event.Pressed {
+		kind |= pressedBit
+	}
+
+	payload := make([]byte, 0, 16)
+	payload = append(payload, kind)
+	payload = binary.AppendVarint(payload, ts-e.lastTs)
+	payload = binary.AppendVarint(payload, int64(event.Row))
+	payload = binary.AppendVarint(payload, int64(event.Col))
+	payload = binary.AppendVarint(payload, int64(event.Position))
+	payload = binary.AppendUvarint(payload, source)
+	payload = binary.AppendUvarint(payload, keyboard)
+
+	e.lastTs = ts
+
+	return appendRecord(buf, payload)
+}
+
+// decoder reads records of a segment.
+type decoder struct {
+	r       *bufio.Reader
+	strings []string
+	lastTs  int64
+	// offset is where the next record starts.
+	offset int64
+	buf    []byte
+}
+
+func newDecoder(r io.Reader) (*decoder, error) {
+	d := &decoder{r: bufio.NewReaderSize(r, 64<<10), strings: []string{""}}
+
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		return nil, fmt.Errorf("could not read segment header: %w", err)
+	}
+
+	if string(header) != string(magic) {
+		return nil, errors.New("not a segment of an event log")
+	}
+
+	d.offset = int64(len(magic))
+
+	return d, nil
+}
+
+// next reads the next event, io.EOF at the end of the segment and errTorn if the rest of it is
+// not a complete record.
+func (d *decoder) next() (model.KeyEventWithTimestamp, error) {
+	for {
+		length, err := binary.ReadUvarint(d.r)
+		if errors.Is(err, io.EOF) {
+			return model.KeyEventWithTimestamp{}, io.EOF
+		}
+
+		if err != nil || length > 1<<20 {
+			return model.KeyEventWithTimestamp{}, errTorn
+		}
+
+		d.buf = slices.Grow(d.buf[:0], int(length)+4)[:length+4]
+		if _, err := io.ReadFull(d.r, d.buf); err != nil {
+			return model.KeyEventWithTimestamp{}, errTorn
+		}
+
+		payload := d.buf[:length]
+		if binary.LittleEndian.Uint32(d.buf[length:]) != crc32.Checksum(payload, castagnoli) || length == 0 {
+			return model.KeyEventWithTimestamp{}, errTorn
+		}
+
+		d.offset += int64(uvarintLen(length)) + int64(length) + 4
+
+		if payload[0] == kindString {
+			d.strings = append(d.strings, string(payload[1:]))
+
+			continue
+		}
+
+		return d.event(payload)
+	}
+}
+
+func (d *decoder) event(payload []byte) (model.KeyEventWithTimestamp, error) {
+	var e model.KeyEventWithTimestamp
+
+	if payload[0]&^pressedBit != kindEvent {
+		return e, fmt.Errorf("unknown record kind %d", payload[0])
+	}
+
+	e.Pressed = payload[0]&pressedBit != 0
+	rest := payload[1:]
+
+	var values [4]int64
+
+	for i := range values {
+		v, n := binary.Varint(rest)
+		if n <= 0 {
+			return e, errors.New("invalid event record")
+		}
+
+		values[i], rest = v, rest[n:]
+	}
+
+	var ids [2]uint64
+
+	for i := range ids {
+		v, n := binary.Uvarint(rest)
+		if n <= 0 || v >= uint64(len(d.strings)) {
+			return e, errors.New("invalid event record")
+		}
+
+		ids[i], rest = v, rest[n:]
+	}
+
+	d.lastTs += values[0]
+	e.Timestamp = time.UnixMilli(d.lastTs).UTC()
+	e.Row, e.Col, e.Position = int(values[1]), int(values[2]), model.KeyPosition(values[3])
+	e.Source, e.Keyboard = d.strings[ids[0]], d.strings[ids[1]]
+
+	return e, nil
+}
+
+func uvarintLen(v uint64) int {
+	return len(binary.AppendUvarint(nil, v))
+}
+
+// scanSegment reads the whole segment to describe it. Events are passed to the callback, the encoder
+// is left ready to append to the segment. A torn tail is not an error: size ends before it.
+func scanSegment(path string, first int64, handle func(entry)) (*segment, *encoder, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open segment: %w", err)
+	}
+	defer f.Close()
+
+	d, err := newDecoder(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read segment %s: %w", path, err)
+	}
+
+	s := &segment{path: path, first: first, size: d.offset}
+
+	for {
+		event, err := d.next()
+		if errors.Is(err, io.EOF) || errors.Is(err, errTorn) {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read segment %s: %w", path, err)
+		}
+
+		s.add(event.Timestamp.UnixMilli())
+		s.size = d.offset
+
+		handle(entry{event: event, seq: s.last()})
+	}
+
+	enc := newEncoder()
+	for id, str := range d.strings {
+		enc.ids[str] = uint64(id)
+	}
+
+	enc.lastTs = d.lastTs
+
+	return s, enc, nil
+}
+
+// entries reads events of the segment in the order they were stored, up to its size at the time it
+// is called: events appended meanwhile are not read.
+func (s segment) entries() iter.Seq2[entry, error] {
+	return func(yield func(entry, error) bool) {
+		f, err := os.Open(s.path)
+		if err != nil {
+			yield(entry{}, fmt.Errorf("could not open segment: %w", err))
+
+			return
+		}
+		defer f.Close()
+
+		d, err := newDecoder(io.LimitReader(f, s.size))
+		if err != nil {
+			yield(entry{}, fmt.Errorf("could not read segment %s: %w", s.path, err))
+
+			return
+		}
+
+		for seq := s.first; seq < s.first+s.count; seq++ {
+			event, err := d.next()
+			if err != nil {
+				yield(entry{}, fmt.Errorf("could not read segment %s: %w", s.path, err))
+
+				return
+			}
+
+			if !yield(entry{event: event, seq: seq}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// inOrder reads events of the segment in the order of time. Segments that were not stored in that
+// order are read into memory and sorted.
+func (s segment) inOrder() iter.Seq2[entry, error] {
+	if s.sorted {
+		return s.entries()
+	}
+
+	return func(yield func(entry, error) bool) {
+		all := make([]entry, 0, s.count)
+
+		for e, err := range s.entries() {
+			if err != nil {
+				yield(e, err)
+
+				return
+			}
+
+			all = append(all, e)
+		}
+
+		slices.SortStableFunc(all, func(a, b entry) int { return a.event.Timestamp.Compare(b.event.Timestamp) })
+
+		for _, e := range all {
+			if !yield(e, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dasdy/glover/model"
+)
+
+// AnomalyKind is a class of rows that should not be among key events.
+type AnomalyKind string
+
+const (
+	// Unreleased are presses that were never released, e.g. when a keyboard disconnected while a key
+	// was held. Trackers treat them as released after a while, but their releases are not counted.
+	Unreleased AnomalyKind = "unreleased"
+	// Duplicate are copies of events stored before, e.g. by merging a database whose events were
+	// stored with timestamps of another format.
+	Duplicate AnomalyKind = "duplicate"
+	// Invalid are rows that are not key events: values that are not numbers, timestamps that can not
+	// be parsed, or positions that are not in the layout.
+	Invalid AnomalyKind = "invalid"
+	// OutOfOrder are releases stamped earlier than the press of their key stored right before them,
+	// e.g. when the clock of a machine went back while the key was held.
+	OutOfOrder AnomalyKind = "out-of-order"
+)
+
+// AnomalyKinds are all kinds, in the order they are reported.
+var AnomalyKinds = []AnomalyKind{Unreleased, Duplicate, Invalid, OutOfOrder}
+
+// syntheticHold is how long a key is held before the release that repair adds for it.
+const syntheticHold = 100 * time.Millisecond
+
+// CheckOptions configure the consistency check.
+type CheckOptions struct {
+	// Layouts are positions of keys of every keyboard by its name. Keyboards that are not in it are checked
+	// against the layout of the empty name. Without layouts positions are not checked.
+	Layouts map[string][]model.KeyPosition
+	// Examples is how many anomalies of every kind are reported.
+	Examples int
+	// StaleAfter is how long a key can be held before the last event of the database, before its
+	// press counts as unreleased. Presses followed by another press of the key are unreleased anyway.
+	StaleAfter time.Duration
+}
+
+// DefaultCheckOptions treat keys as unreleased after the same time as the combo tracker does.
+func DefaultCheckOptions() CheckOptions {
+	return CheckOptions{Examples: 5, StaleAfter: DefaultComboOptions().StaleAfter}
+}
+
+// Anomaly is a row that should not be among key events, or that misses a release.
+type Anomaly struct {
+	Rowid int64
+	// Event is what could be read of the row.
+	Event  model.KeyEventWithTimestamp
+	Detail string
+}
+
+// Finding is how many anomalies of a kind were found, with the first of them as examples.
+type Finding struct {
+	Count    int
+	Examples []Anomaly
+}
+
+// CheckReport is what the consistency check found.
+type CheckReport struct {
+	Events   int
+	Findings map[AnomalyKind]*Finding
+}
+
+// Clean tells that no anomalies were found.
+func (r CheckReport) Clean() bool {
+	for _, finding := range r.Findings {
+		if finding.Count > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RepairResult tells what repair changed.
+type RepairResult struct {
+	Released    int
+	Removed     int
+	Quarantined int
+}
+
+type quarantinedRow struct {
+	rowid  int64
+	reason string
+}
+
+// fsckScan is the report of the check, with everything repair needs to fix.
+type fsckScan struct {
+	report     CheckReport
+	examples   int
+	invalid    []quarantinedRow
+	duplicates []int64
+	releases   []model.KeyEventWithTimestamp
+}
+
+func (f *fsckScan) add(kind AnomalyKind, anomaly Anomaly) {
+	finding := f.report.Findings[kind]
+	finding.Count++
+
+	if len(finding.Examples) < f.examples {
+		finding.Examples = append(finding.Examples, anomaly)
+	}
+}
+
+// unreleased adds the release of the press, before the next event of its key if there is one.
+func (f *fsckScan) unreleased(press Anomaly, next time.Time) {
+	release := press.Event
+	release.Pressed = false
+	release.Timestamp = press.Event.Timestamp.Add(syntheticHold)
+
+	if !next.IsZero() && !release.Timestamp.Before(next) {
+		release.Timestamp = press.Event.Timestamp.Add(next.Sub(press.Event.Timestamp) / 2)
+	}
+
+	f.add(Unreleased, press)
+	f.releases = append(f.releases, release)
+}
+
+type heldKey struct {
+	keyboard, source string
+	position         model.KeyPosition
+}
+
+// scanAnomalies reads all rows in the order of their timestamps. Duplicates are found by the normalized
+// timestamp, as read-only databases that were not migrated can have rows merge stored in go format, and
+// are events of the same source and keyboard, same as Import tells them apart; the oldest copy is kept.
+// Releases are compared with the previous event of their key in the order of rowids, which is the order
+// they were stored in. Other events are not: merges and repairs store events older than the ones before them.
+func (s *SQLiteStorage) scanAnomalies(ctx context.Context, q queryer, keyboardColumn string, opts CheckOptions) (*fsckScan, error) {
+	scan := &fsckScan{
+		report:   CheckReport{Findings: make(map[AnomalyKind]*Finding, len(AnomalyKinds))},
+		examples: opts.Examples,
+	}
+
+	for _, kind := range AnomalyKinds {
+		scan.report.Findings[kind] = &Finding{}
+	}
+
+	layouts := make(map[string]map[model.KeyPosition]bool, len(opts.Layouts))
+	for keyboard, positions := range opts.Layouts {
+		layouts[keyboard] = make(map[model.KeyPosition]bool, len(positions))
+		for _, p := range positions {
+			layouts[keyboard][p] = true
+		}
+	}
+
+	rows, err := q.QueryContext(ctx, `
+        select id,
+            typeof(row) = 'integer' and typeof(col) = 'integer' and typeof(position) = 'integer'
+                and typeof(pressed) = 'integer' and pressed in (0, 1),
+            coalesce(cast(row as integer), 0), coalesce(cast(col as integer), 0),
+            coalesce(cast(position as integer), 0), coalesce(pressed = 1, false),
+            coalesce(cast(ts as text), ''), coalesce(t, ''), coalesce(src, ''), coalesce(kb, ''),
+            first_value(id) over (partition by t, row, col, position, pressed, src, kb order by id),
+            coalesce(lag(t) over stored, ''), coalesce(lag(pressed) over stored = 1, false)
+        from (
+            select rowid as id, row, col, position, pressed, ts, datetime(ts, 'subsec') as t,
+                `+s.sourceColumn+` as src, `+keyboardColumn+` as kb
+            from keypresses)
+        window stored as (partition by kb, src, position order by id)
+        order by t, id`)
+	if err != nil {
+		return nil, fmt.Errorf("could not query keypresses: got %w", err)
+	}
+	defer rows.Close()
+
+	held := make(map[heldKey]Anomaly)
+
+	var newest time.Time
+
+	for rows.Next() {
+		var (
+			a                 Anomaly
+			integral          bool
+			raw, ts, previous string
+			original          int64
+			afterPress        bool
+		)
+
+		err := rows.Scan(&a.Rowid, &integral, &a.Event.Row, &a.Event.Col, &a.Event.Position, &a.Event.Pressed,
+			&raw, &ts, &a.Event.Source, &a.Event.Keyboard, &original, &previous, &afterPress)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan row: got %w", err)
+		}
+
+		scan.report.Events++
+
+		parsed, parseErr := time.Parse(timestampLayout, ts)
+		a.Event.Timestamp = parsed
+
+		positions, ok := layouts[a.Event.Keyboard]
+		if !ok {
+			positions = layouts[""]
+		}
+
+		switch {
+		case !integral:
+			a.Detail = "row, col, position or pressed is not a number"
+		case a.Event.Position < 0:
+			a.Detail = fmt.Sprintf("position %d is negative", a.Event.Position)
+		case parseErr != nil:
+			a.Detail = fmt.Sprintf("timestamp '%s' can not be parsed", raw)
+		case positions != nil && !positions[a.Event.Position]:
+			a.Detail = fmt.Sprintf("position %d is not in the layout", a.Event.Position)
+		}
+
+		if a.Detail != "" {
+			scan.add(Invalid, a)
+			scan.invalid = append(scan.invalid, quarantinedRow{a.Rowid, a.Detail})
+
+			continue
+		}
+
+		if original != a.Rowid {
+			a.Detail = fmt.Sprintf("same as row %d", original)
+			scan.add(Duplicate, a)
+			scan.duplicates = append(scan.duplicates, a.Rowid)
+
+			continue
+		}
+
+		if !a.Event.Pressed && afterPress && ts < previous {
+			scan.add(OutOfOrder, Anomaly{
+				Rowid:  a.Rowid,
+				Event:  a.Event,
+				Detail: fmt.Sprintf("released before its press at %s", previous),
+			})
+		}
+
+		key := heldKey{a.Event.Keyboard, a.Event.Source, a.Event.Position}
+
+		if press, ok := held[key]; ok && a.Event.Pressed {
+			press.Detail = fmt.Sprintf("pressed again at %s without a release", ts)
+			scan.unreleased(press, a.Event.Timestamp)
+		}
+
+		if a.Event.Pressed {
+			held[key] = a
+		} else {
+			delete(held, key)
+		}
+
+		newest = a.Event.Timestamp
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error while iterating over rows: got %w", err)
+	}
+
+	var stale []Anomaly
+
+	for _, press := range held {
+		if newest.Sub(press.Event.Timestamp) > opts.StaleAfter {
+			stale = append(stale, press)
+		}
+	}
+
+	slices.SortFunc(stale, func(a, b Anomaly) int {
+		return cmp.Or(a.Event.Timestamp.Compare(b.Event.Timestamp), cmp.Compare(a.Rowid, b.Rowid))
+	})
+
+	for _, press := range stale {
+		press.Detail = fmt.Sprintf("never released, held for %s until the last event", newest.Sub(press.Event.Timestamp))
+		scan.unreleased(press, time.Time{})
+	}
+
+	return scan, nil
+}
+
+func (s *SQLiteStorage) keyboardColumn() string {
+	if hasColumn(s.db, "keypresses", "keyboard") {
+		return "keyboard"
+	}
+
+	return "''"
+}
+
+// Check looks for presses without releases, duplicates, invalid rows and events stored out of order.
+// Nothing is changed, so read-only databases can be checked too.
+func (s *SQLiteStorage) Check(ctx context.Context, opts CheckOptions) (CheckReport, error) {
+	scan, err := s.scanAnomalies(ctx, s.db, s.keyboardColumn(), opts)
+	if err != nil {
+		return CheckReport{}, err
+	}
+
+	return scan.report, nil
+}
+
+// Repair fixes what the check finds, in a single transaction: invalid rows are moved into the
+// keypresses_quarantine table with the reason, duplicates are removed, and unreleased presses get a
+// release shortly after them, before the next event of their key. Events stored out of order are only
+// reported: the check can not tell which timestamp is wrong. Rollup counts of removed releases are
+// taken back. Returns what was found before the repair.
+func (s *SQLiteStorage) Repair(ctx context.Context, opts CheckOptions) (CheckReport, RepairResult, error) {
+	var result RepairResult
+
+	keyboardColumn := s.keyboardColumn()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return CheckReport{}, result, fmt.Errorf("could not start transaction: got %w", err)
+	}
+
+	defer tx.Rollback() //nolint:errcheck // Rollback after commit is a no-op.
+
+	scan, err := s.scanAnomalies(ctx, tx, keyboardColumn, opts)
+	if err != nil {
+		return CheckReport{}, result, err
+	}
+
+	_, err = tx.ExecContext(ctx, `create table if not exists keypresses_quarantine(
+        row, col, position, pressed, ts, source, keyboard, reason text, quarantined datetime)`)
+	if err != nil {
+		return scan.report, result, fmt.Errorf("could not create quarantine table: got %w", err)
+	}
+
+	remove := func(rowid int64) error {
+		if s.rollups {
+			_, err := tx.ExecContext(ctx, `update rollup_keys set count = count - 1
+                where (bucket, keyboard, source, row, col, position) = (
+                    select `+bucketOf+`, keyboard, source, row, col, position
+                    from keypresses where rowid = ? and not pressed)`, rowid)
+			if err != nil {
+				return fmt.Errorf("could not update rollups: got %w", err)
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, `delete from keypresses where rowid = ?`, rowid); err != nil {
+			return fmt.Errorf("could not delete row %d: got %w", rowid, err)
+		}
+
+		return nil
+	}
+
+	for _, row := range scan.invalid {
+		_, err := tx.ExecContext(ctx, `insert into keypresses_quarantine
+            select row, col, position, pressed, ts, `+s.sourceColumn+`, `+keyboardColumn+`, ?, datetime('now', 'subsec')
+            from keypresses where rowid = ?`, row.reason, row.rowid)
+		if err != nil {
+			return scan.report, result, fmt.Errorf("could not quarantine row %d: got %w", row.rowid, err)
+		}
+
+		if err := remove(row.rowid); err != nil {
+			return scan.report, result, err
+		}
+
+		result.Quarantined++
+	}
+
+	for _, rowid := range scan.duplicates {
+		if err := remove(rowid); err != nil {
+			return scan.report, result, err
+		}
+
+		result.Removed++
+	}
+
+	// Rows with timestamps that can not be parsed were counted in rollups without an hour.
+	if s.rollups {
+		if _, err := tx.ExecContext(ctx, `delete from rollup_keys where bucket is null or count <= 0`); err != nil {
+			return scan.report, result, fmt.Errorf("could not clean up rollups: got %w", err)
+		}
+	}
+
+	for _, release := range scan.releases {
+		_, err := tx.ExecContext(ctx, `insert into keypresses(row, col, position, pressed, ts, source, keyboard)
+            values(?, ?, ?, ?, ?, ?, ?)`,
+			release.Row, release.Col, release.Position, release.Pressed,
+			release.Timestamp.UTC().Format(timestampLayout), release.Source, release.Keyboard)
+		if err != nil {
+			return scan.report, result, fmt.Errorf("could not insert release %+v: got %w", release, err)
+		}
+
+		result.Released++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return scan.report, result, fmt.Errorf("could not commit repair: got %w", err)
+	}
+
+	return scan.report, result, nil
+}
@@ -0,0 +1,134 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func findingCounts(report db.CheckReport) map[db.AnomalyKind]int {
+	result := make(map[db.AnomalyKind]int)
+	for kind, finding := range report.Findings {
+		result[kind] = finding.Count
+	}
+
+	return result
+}
+
+func TestFsck(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir() + "/fsck.sqlite"
+
+	storage, err := db.NewStorageFromPath(path, false)
+	require.NoError(t, err)
+
+	defer storage.Close()
+
+	raw, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+
+	defer raw.Close()
+
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	for _, e := range []model.KeyEventWithTimestamp{
+		{Position: 1, Pressed: true, Timestamp: at(0)},
+		{Position: 1, Timestamp: at(50 * time.Millisecond)},
+		{Position: 2, Pressed: true, Timestamp: at(time.Second)},
+		{Position: 2, Pressed: true, Timestamp: at(2 * time.Second)},
+		{Position: 2, Timestamp: at(2100 * time.Millisecond)},
+		{Position: 3, Pressed: true, Timestamp: at(3 * time.Second)},
+		{Position: 1, Pressed: true, Timestamp: at(25 * time.Second), Keyboard: "laptop"},
+		{Position: 1, Timestamp: at(24 * time.Second), Keyboard: "laptop"},
+		{Position: 1, Pressed: true, Timestamp: at(30 * time.Second)},
+		{Position: 1, Timestamp: at(30100 * time.Millisecond)},
+	} {
+		require.NoError(t, storage.StoreEvent(&e))
+	}
+
+	for _, statement := range []string{
+		// A copy of the first release, as merge wrote it.
+		`insert into keypresses(row, col, position, pressed, ts) values(0, 0, 1, false, '2024-03-01 10:00:00.05+00:00')`,
+		`insert into keypresses(row, col, position, pressed, ts) values('x', 0, 1, false, '2024-03-01 10:00:05.000')`,
+		`insert into keypresses(row, col, position, pressed, ts) values(0, 0, 1, false, 'yesterday')`,
+		`insert into keypresses(row, col, position, pressed, ts) values(0, 0, 99, false, '2024-03-01 10:00:06.000')`,
+	} {
+		_, err := raw.Exec(statement)
+		require.NoError(t, err)
+	}
+
+	opts := db.DefaultCheckOptions()
+	opts.Layouts = map[string][]model.KeyPosition{"": {1, 2, 3}}
+
+	t.Run("reports anomalies with examples", func(t *testing.T) {
+		report, err := storage.Check(ctx, opts)
+		require.NoError(t, err)
+
+		assert.Equal(t, 14, report.Events)
+		assert.Equal(t, map[db.AnomalyKind]int{
+			db.Unreleased: 2,
+			db.Duplicate:  1,
+			db.Invalid:    3,
+			db.OutOfOrder: 1,
+		}, findingCounts(report))
+
+		unreleased := report.Findings[db.Unreleased].Examples
+		require.Len(t, unreleased, 2)
+		assert.Equal(t, model.KeyPosition(2), unreleased[0].Event.Position)
+		assert.Equal(t, at(time.Second), unreleased[0].Event.Timestamp)
+		assert.Equal(t, model.KeyPosition(3), unreleased[1].Event.Position)
+
+		assert.Equal(t, "same as row 2", report.Findings[db.Duplicate].Examples[0].Detail)
+		assert.Equal(t, "laptop", report.Findings[db.OutOfOrder].Examples[0].Event.Keyboard)
+		assert.False(t, report.Clean())
+	})
+
+	t.Run("repairs what it can", func(t *testing.T) {
+		_, result, err := storage.Repair(ctx, opts)
+		require.NoError(t, err)
+		assert.Equal(t, db.RepairResult{Released: 2, Removed: 1, Quarantined: 3}, result)
+
+		report, err := storage.Check(ctx, opts)
+		require.NoError(t, err)
+		assert.Equal(t, map[db.AnomalyKind]int{db.OutOfOrder: 1}, nonZero(findingCounts(report)),
+			"events out of order are only reported")
+
+		var quarantined int
+		require.NoError(t, raw.QueryRow(`select count(*) from keypresses_quarantine`).Scan(&quarantined))
+		assert.Equal(t, 3, quarantined)
+
+		keys, err := storage.GatherAll()
+		require.NoError(t, err)
+		assert.Equal(t, []model.MinimalKeyEvent{
+			{Position: 1, Count: 3},
+			{Position: 2, Count: 2},
+			{Position: 3, Count: 1},
+		}, keys)
+
+		var rolledUp int
+		require.NoError(t, raw.QueryRow(`select sum(count) from rollup_keys`).Scan(&rolledUp))
+		assert.Equal(t, 6, rolledUp, "rollups of removed releases are taken back")
+
+		var release string
+		require.NoError(t, raw.QueryRow(`select cast(ts as text) from keypresses where position = 2 and not pressed order by ts limit 1`).
+			Scan(&release))
+		assert.Equal(t, "2024-03-01 10:00:01.100", release, "release comes before the next press")
+	})
+}
+
+func nonZero(counts map[db.AnomalyKind]int) map[db.AnomalyKind]int {
+	for kind, count := range counts {
+		if count == 0 {
+			delete(counts, kind)
+		}
+	}
+
+	return counts
+}
@@ -0,0 +1,314 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dasdy/glover/model"
+)
+
+// DefaultChunkSize is how many events are read from the database by one query.
+const DefaultChunkSize = 10000
+
+// HistoryQuery selects events of the history. Filters that are left empty do not limit anything.
+type HistoryQuery struct {
+	// Since and Until limit events to [Since, Until). Zero time leaves the bound open.
+	Since, Until time.Time
+	// Sources limits events to ones received from these machines, "" stands for keyboards connected directly.
+	Sources []string
+	// Positions limits events to these keys.
+	Positions []model.KeyPosition
+	// After resumes reading right after the event the cursor was taken from.
+	After Cursor
+	// ChunkSize is how many events are read by one query, DefaultChunkSize if zero. Rows are not kept
+	// open between chunks, so a slow reader does not keep the database busy.
+	ChunkSize int
+}
+
+// Cursor is the place of an event in the history. Zero cursor is the beginning of it.
+type Cursor struct {
+	// ts is the timestamp as it is stored, rowid tells apart events stored with the same one. It is
+	// the id of the event, see EventsAfter.
+	ts    string
+	rowid int64
+}
+
+// IsZero tells if the cursor is the beginning of the history.
+func (c Cursor) IsZero() bool {
+	return c == Cursor{}
+}
+
+// String encodes the cursor, so reading can be resumed later, e.g. by another run of export.
+func (c Cursor) String() string {
+	if c.IsZero() {
+		return ""
+	}
+
+	return strconv.FormatInt(c.rowid, 10) + ":" + c.ts
+}
+
+// ParseCursor decodes a cursor made by Cursor.String. Empty string is the beginning of the history.
+func ParseCursor(s string) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+
+	rowid, ts, ok := strings.Cut(s, ":")
+	if !ok || ts == "" {
+		return Cursor{}, fmt.Errorf("invalid cursor '%s'", s)
+	}
+
+	id, err := strconv.ParseInt(rowid, 10, 64)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor '%s': %w", s, err)
+	}
+
+	return Cursor{ts: ts, rowid: id}, nil
+}
+
+// CursorAt is the cursor of an event of a storage that is not an SQLite database. The sequence number
+// the event was stored with tells apart events with the same timestamp.
+func CursorAt(ts time.Time, seq int64) Cursor {
+	return Cursor{ts: ts.UTC().Format(timestampLayout), rowid: seq}
+}
+
+// Compare orders cursors of the same storage the way its history is ordered: negative if the
+// cursor comes before the other one.
+func (c Cursor) Compare(other Cursor) int {
+	return cmp.Or(strings.Compare(c.ts, other.ts), cmp.Compare(c.rowid, other.rowid))
+}
+
+// Matches tells if the event passes the filters of the query, for storages that filter events as
+// they read them. The cursor is not checked: it is a place in the history rather than a filter.
+func (q HistoryQuery) Matches(event model.KeyEventWithTimestamp) bool {
+	if !q.Since.IsZero() && event.Timestamp.Before(q.Since) {
+		return false
+	}
+
+	if !q.Until.IsZero() && !event.Timestamp.Before(q.Until) {
+		return false
+	}
+
+	if len(q.Sources) > 0 && !slices.Contains(q.Sources, event.Source) {
+		return false
+	}
+
+	return len(q.Positions) == 0 || slices.Contains(q.Positions, event.Position)
+}
+
+// HistoryEvent is an event read from the history with the cursor to resume reading after it.
+type HistoryEvent struct {
+	model.KeyEventWithTimestamp
+
+	Cursor Cursor
+}
+
+// HistoryReader reads the history from the start every time it is called, e.g. once for each
+// tracker that scans it.
+type HistoryReader func() iter.Seq2[HistoryEvent, error]
+
+// ReadHistory reads events of the storage that match the query until the context is done.
+func ReadHistory(ctx context.Context, storage Storage, query HistoryQuery) HistoryReader {
+	return func() iter.Seq2[HistoryEvent, error] {
+		return storage.History(ctx, query)
+	}
+}
+
+// KeyEvents drops cursors of the history events.
+func KeyEvents(history iter.Seq2[HistoryEvent, error]) iter.Seq2[model.KeyEventWithTimestamp, error] {
+	return func(yield func(model.KeyEventWithTimestamp, error) bool) {
+		for event, err := range history {
+			if !yield(event.KeyEventWithTimestamp, err) {
+				return
+			}
+		}
+	}
+}
+
+// StopOnError yields events of the history until it fails, for consumers that do not handle errors.
+// The error, if any, is returned by the second function once the events are consumed.
+func StopOnError(history iter.Seq2[HistoryEvent, error]) (iter.Seq[model.KeyEventWithTimestamp], func() error) {
+	var failure error
+
+	events := func(yield func(model.KeyEventWithTimestamp) bool) {
+		for event, err := range history {
+			if err != nil {
+				failure = err
+
+				return
+			}
+
+			if !yield(event.KeyEventWithTimestamp) {
+				return
+			}
+		}
+	}
+
+	return events, func() error { return failure }
+}
+
+// CountReleases counts releases of every key of the history, ordered the way SQLiteStorage.GatherAll
+// orders them. It is GatherAll of storages that do not keep counts.
+func CountReleases(history iter.Seq2[HistoryEvent, error]) ([]model.MinimalKeyEvent, error) {
+	counts := make(map[keyID]int)
+
+	for event, err := range history {
+		if err != nil {
+			return nil, err
+		}
+
+		if !event.Pressed {
+			counts[keyID{Row: event.Row, Col: event.Col, Position: event.Position}]++
+		}
+	}
+
+	return sortedCounts(counts), nil
+}
+
+// sortedCounts returns counts in the same order as GatherAll of an SQLite storage.
+func sortedCounts(counts map[keyID]int) []model.MinimalKeyEvent {
+	result := make([]model.MinimalKeyEvent, 0, len(counts))
+	for k, count := range counts {
+		result = append(result, model.MinimalKeyEvent{Row: k.Row, Col: k.Col, Position: k.Position, Count: count})
+	}
+
+	slices.SortFunc(result, CompareCounts)
+
+	return result
+}
+
+// CompareCounts orders counts of keys the way GatherAll of an SQLite storage returns them.
+func CompareCounts(a, b model.MinimalKeyEvent) int {
+	return cmp.Or(cmp.Compare(a.Row, b.Row), cmp.Compare(a.Position, b.Position), cmp.Compare(a.Col, b.Col))
+}
+
+// historyFilter builds the where clause of the query, extra conditions are added to it.
+func (s *SQLiteStorage) historyFilter(query HistoryQuery, extra string, extraArgs ...any) (string, []any) {
+	conditions := []string{"1 = 1"}
+	args := make([]any, 0, len(extraArgs)+len(query.Sources)+len(query.Positions)+4)
+
+	if extra != "" {
+		conditions = append(conditions, extra)
+		args = append(args, extraArgs...)
+	}
+
+	if !query.Since.IsZero() {
+		conditions = append(conditions, s.timestamp+" >= ?")
+		args = append(args, query.Since.UTC().Format(timestampLayout))
+	}
+
+	if !query.Until.IsZero() {
+		conditions = append(conditions, s.timestamp+" < ?")
+		args = append(args, query.Until.UTC().Format(timestampLayout))
+	}
+
+	if len(query.Sources) > 0 {
+		conditions = append(conditions, s.sourceColumn+" in (?"+strings.Repeat(", ?", len(query.Sources)-1)+")")
+		for _, source := range query.Sources {
+			args = append(args, source)
+		}
+	}
+
+	if len(query.Positions) > 0 {
+		conditions = append(conditions, "position in (?"+strings.Repeat(", ?", len(query.Positions)-1)+")")
+		for _, position := range query.Positions {
+			args = append(args, int(position))
+		}
+	}
+
+	return "where " + strings.Join(conditions, " and "), args
+}
+
+func (s *SQLiteStorage) History(ctx context.Context, query HistoryQuery) iter.Seq2[HistoryEvent, error] {
+	return s.history(ctx, s.db, query, "")
+}
+
+// history reads events in the order they happened, a chunk at a time. Events with the same timestamp
+// keep the order they were stored in, so cursors point at exactly one event. Chunks are queried with q.
+func (s *SQLiteStorage) history(
+	ctx context.Context, q queryer, query HistoryQuery, extra string, extraArgs ...any,
+) iter.Seq2[HistoryEvent, error] {
+	filter, args := s.historyFilter(query, extra, extraArgs...)
+
+	chunkSize := query.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	return func(yield func(HistoryEvent, error) bool) {
+		cursor := query.After
+
+		for {
+			chunk, err := s.historyChunk(ctx, q, filter, args, cursor, chunkSize)
+			if err != nil {
+				yield(HistoryEvent{}, err)
+
+				return
+			}
+
+			for _, event := range chunk {
+				if err := ctx.Err(); err != nil {
+					yield(HistoryEvent{}, fmt.Errorf("stopped reading keypresses: %w", err))
+
+					return
+				}
+
+				if !yield(event, nil) {
+					return
+				}
+			}
+
+			if len(chunk) < chunkSize {
+				return
+			}
+
+			cursor = chunk[len(chunk)-1].Cursor
+		}
+	}
+}
+
+func (s *SQLiteStorage) historyChunk(ctx context.Context, q queryer, filter string, args []any, after Cursor, limit int) ([]HistoryEvent, error) {
+	// Arguments are shared by all chunks.
+	args = slices.Clone(args)
+
+	if !after.IsZero() {
+		filter += " and (ts, rowid) > (?, ?)"
+		args = append(args, after.ts, after.rowid)
+	}
+
+	args = append(args, limit)
+
+	rows, err := q.QueryContext(ctx,
+		"select rowid, cast(ts as text), "+s.eventColumns+" from keypresses "+filter+" order by ts, rowid limit ?",
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query keypresses: got %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]HistoryEvent, 0, limit)
+
+	for rows.Next() {
+		var e HistoryEvent
+
+		err := rows.Scan(&e.Cursor.rowid, &e.Cursor.ts,
+			&e.Row, &e.Col, &e.Position, &e.Pressed, &e.Timestamp, &e.Source, &e.Keyboard)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan row: got %w", err)
+		}
+
+		result = append(result, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error while iterating over rows: got %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"fmt"
+	"iter"
+	"time"
+
+	"github.com/dasdy/glover/model"
+)
+
+type ImportResult struct {
+	Inserted int
+	Skipped  int
+	// First and Last are cursors of the first and the last inserted event, as EventsAfter returns them.
+	// Inserted events are stored one after another, no other event gets a cursor between them. Both
+	// are zero if nothing was inserted.
+	First, Last int64
+}
+
+// Import stores events that are not in the database yet. Events are the same if their location, state
+// and timestamp match, with timestamps compared at millisecond precision, same as tracking stores them.
+// Source and keyboard are compared too: two keyboards, or two machines typed on, can press the same key at once.
+// Events older than raw events that retention deleted are skipped too, they are already counted in rollups.
+// Everything is inserted in a single transaction, so nothing is stored if the input turns out to be broken.
+func (s *SQLiteStorage) Import(events iter.Seq2[model.KeyEventWithTimestamp, error]) (ImportResult, error) {
+	var result ImportResult
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return result, fmt.Errorf("could not start transaction: got %w", err)
+	}
+
+	defer tx.Rollback() //nolint:errcheck // Rollback after commit is a no-op.
+
+	pruned := "''"
+	if s.rollups {
+		pruned = "coalesce(" + prunedBefore + ", '')"
+	}
+
+	stmt, err := tx.Prepare(`
+        insert into keypresses(row, col, position, pressed, ts, source, keyboard)
+        select ?, ?, ?, ?, ?, ?, ?
+        where not exists (
+            select 1 from keypresses
+            where ts = ? and row = ? and col = ? and position = ? and pressed = ?
+                and source = ? and keyboard = ?)
+        and ? >= ` + pruned)
+	if err != nil {
+		return result, fmt.Errorf("could not prepare insert: got %w", err)
+	}
+	defer stmt.Close()
+
+	for event, err := range events {
+		if err != nil {
+			return result, err
+		}
+
+		ts := event.Timestamp.UTC().Truncate(time.Millisecond)
+		text := ts.Format(timestampLayout)
+
+		res, err := stmt.Exec(
+			event.Row, event.Col, event.Position, event.Pressed, text, event.Source, event.Keyboard,
+			text, event.Row, event.Col, event.Position, event.Pressed, event.Source, event.Keyboard, text)
+		if err != nil {
+			return result, fmt.Errorf("could not insert keypress %+v: got %w", event, err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return result, fmt.Errorf("could not check inserted rows: got %w", err)
+		}
+
+		if affected > 0 {
+			id, err := res.LastInsertId()
+			if err != nil {
+				return result, fmt.Errorf("could not get id of inserted keypress: got %w", err)
+			}
+
+			// Other connections can not insert until the transaction ends, so ids follow each other.
+			if result.Inserted == 0 {
+				result.First = id
+			}
+
+			result.Last = id
+			result.Inserted++
+		} else {
+			result.Skipped++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("could not commit import: got %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"iter"
+	"sync"
+
+	"github.com/dasdy/glover/model"
+)
+
+type keyID struct {
+	Row, Col int
+	Position model.KeyPosition
+}
+
+// KeyCounter counts releases of every key, same as Storage.GatherAll does, so pages do not
+// query the whole history on every load.
+type KeyCounter struct {
+	counts map[keyID]int
+	// counted tells that history was scanned, before that counts are not complete.
+	counted bool
+	version uint64
+	// stats are cached counts, nil when they changed since they were built.
+	stats     []model.MinimalKeyEvent
+	stateLock sync.RWMutex
+}
+
+func NewKeyCounter() *KeyCounter {
+	return &KeyCounter{
+		counts:    make(map[keyID]int),
+		stateLock: sync.RWMutex{},
+	}
+}
+
+// Init counts the history into a new map, so counts that are shown meanwhile stay whole.
+func (k *KeyCounter) Init(history iter.Seq[model.KeyEventWithTimestamp]) error {
+	counts := make(map[keyID]int)
+
+	for event := range history {
+		if !event.Pressed {
+			counts[keyID{Row: event.Row, Col: event.Col, Position: event.Position}]++
+		}
+	}
+
+	k.stateLock.Lock()
+	defer k.stateLock.Unlock()
+
+	k.counts = counts
+	k.counted = true
+	k.changed()
+
+	return nil
+}
+
+func (k *KeyCounter) Handle(event model.KeyEventWithTimestamp) {
+	if event.Pressed {
+		return
+	}
+
+	k.stateLock.Lock()
+	defer k.stateLock.Unlock()
+
+	k.counts[keyID{Row: event.Row, Col: event.Col, Position: event.Position}]++
+	k.changed()
+}
+
+// Seed adds releases kept in rollups to what was counted.
+func (k *KeyCounter) Seed(rollup *Rollup) {
+	k.stateLock.Lock()
+	defer k.stateLock.Unlock()
+
+	for _, key := range rollup.Keys {
+		k.counts[keyID{Row: key.Row, Col: key.Col, Position: key.Position}] += key.Count
+	}
+
+	k.changed()
+}
+
+// Snapshot returns single-key combos with the amount of releases of each position.
+func (k *KeyCounter) Snapshot() Snapshot {
+	stats, _ := k.Stats()
+
+	result := make(CombosByPosition, len(stats))
+	for _, key := range stats {
+		pressed := key.Count
+		if combos := result[key.Position]; len(combos) > 0 {
+			pressed += combos[0].Pressed
+		}
+
+		result[key.Position] = []model.Combo{{Keys: []model.KeyPosition{key.Position}, Pressed: pressed}}
+	}
+
+	return result
+}
+
+// Reset forgets the counts, they are not complete until history is scanned again.
+func (k *KeyCounter) Reset() {
+	k.stateLock.Lock()
+	defer k.stateLock.Unlock()
+
+	k.counts = make(map[keyID]int)
+	k.counted = false
+	k.changed()
+}
+
+// Version changes every time a key is counted.
+func (k *KeyCounter) Version() uint64 {
+	k.stateLock.RLock()
+	defer k.stateLock.RUnlock()
+
+	return k.version
+}
+
+// Stats returns counts in the same shape and order as Storage.GatherAll. They are shared between
+// callers and must not be modified. False is returned until history is scanned.
+func (k *KeyCounter) Stats() ([]model.MinimalKeyEvent, bool) {
+	k.stateLock.RLock()
+	stats, counted := k.stats, k.counted
+	k.stateLock.RUnlock()
+
+	if stats != nil {
+		return stats, counted
+	}
+
+	k.stateLock.Lock()
+	defer k.stateLock.Unlock()
+
+	if k.stats == nil {
+		k.stats = sortedCounts(k.counts)
+	}
+
+	return k.stats, k.counted
+}
+
+func (k *KeyCounter) changed() {
+	k.version++
+	k.stats = nil
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"iter"
+	"time"
+
+	"github.com/dasdy/glover/model"
+)
+
+// KeyboardStorage is a view of the storage that only sees events of one keyboard. Events stored
+// through it are assigned to that keyboard.
+type KeyboardStorage struct {
+	parent   *SQLiteStorage
+	keyboard string
+	filter   string
+	args     []any
+}
+
+// ForKeyboard returns a view of events of the keyboard. With includeUnnamed it also sees events
+// that have no keyboard, e.g. ones recorded before keyboards were configured.
+func (s *SQLiteStorage) ForKeyboard(keyboard string, includeUnnamed bool) *KeyboardStorage {
+	view := &KeyboardStorage{
+		parent:   s,
+		keyboard: keyboard,
+		filter:   "keyboard = ?",
+		args:     []any{keyboard},
+	}
+
+	if includeUnnamed && keyboard != "" {
+		view.filter = "keyboard in (?, '')"
+	}
+
+	return view
+}
+
+func (k *KeyboardStorage) Store(event *model.KeyEvent) error {
+	return k.StoreEvent(&model.KeyEventWithTimestamp{
+		Row:       event.Row,
+		Col:       event.Col,
+		Position:  event.Position,
+		Pressed:   event.Pressed,
+		Timestamp: time.Now(),
+	})
+}
+
+func (k *KeyboardStorage) StoreEvent(event *model.KeyEventWithTimestamp) error {
+	withKeyboard := *event
+	withKeyboard.Keyboard = k.keyboard
+
+	return k.parent.StoreEvent(&withKeyboard)
+}
+
+func (k *KeyboardStorage) GatherAll() ([]model.MinimalKeyEvent, error) {
+	return k.parent.gatherAll(k.filter, k.args...)
+}
+
+func (k *KeyboardStorage) History(ctx context.Context, query HistoryQuery) iter.Seq2[HistoryEvent, error] {
+	return k.parent.history(ctx, k.parent.db, query, k.filter, k.args...)
+}
+
+// Close does nothing: the database belongs to the parent storage.
+func (k *KeyboardStorage) Close() {}
+
+func (k *KeyboardStorage) PrunedRollup(ctx context.Context, query HistoryQuery) (*Rollup, error) {
+	return k.parent.prunedRollup(ctx, query, k.filter, k.args...)
+}
+
+// KeyboardView returns a view of events of the keyboard of any storage, see ForKeyboard. Storages that
+// are not SQLite databases are filtered as their history is read.
+func KeyboardView(storage Storage, keyboard string, includeUnnamed bool) Storage {
+	switch s := storage.(type) {
+	case *SQLiteStorage:
+		return s.ForKeyboard(keyboard, includeUnnamed)
+	case *Union:
+		// Members filter their own events, so SQLite databases keep filtering them in queries.
+		view := &Union{members: make([]UnionMember, len(s.members))}
+		for i, m := range s.members {
+			view.members[i] = UnionMember{Name: m.Name, Storage: KeyboardView(m.Storage, keyboard, includeUnnamed)}
+		}
+
+		return view
+	}
+
+	return &keyboardFilter{parent: storage, keyboard: keyboard, includeUnnamed: includeUnnamed}
+}
+
+type keyboardFilter struct {
+	parent         Storage
+	keyboard       string
+	includeUnnamed bool
+}
+
+func (k *keyboardFilter) Store(event *model.KeyEvent) error {
+	return k.StoreEvent(&model.KeyEventWithTimestamp{
+		Row:       event.Row,
+		Col:       event.Col,
+		Position:  event.Position,
+		Pressed:   event.Pressed,
+		Timestamp: time.Now(),
+	})
+}
+
+func (k *keyboardFilter) StoreEvent(event *model.KeyEventWithTimestamp) error {
+	withKeyboard := *event
+	withKeyboard.Keyboard = k.keyboard
+
+	return k.parent.StoreEvent(&withKeyboard)
+}
+
+func (k *keyboardFilter) GatherAll() ([]model.MinimalKeyEvent, error) {
+	return CountReleases(k.History(context.Background(), HistoryQuery{}))
+}
+
+func (k *keyboardFilter) History(ctx context.Context, query HistoryQuery) iter.Seq2[HistoryEvent, error] {
+	return func(yield func(HistoryEvent, error) bool) {
+		for event, err := range k.parent.History(ctx, query) {
+			if err == nil && event.Keyboard != k.keyboard && (!k.includeUnnamed || event.Keyboard != "") {
+				continue
+			}
+
+			if !yield(event, err) {
+				return
+			}
+		}
+	}
+}
+
+// Close does nothing: the storage belongs to the parent.
+func (k *keyboardFilter) Close() {}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dasdy/glover/model"
+)
+
+// MemoryStorage keeps events in memory, e.g. for tests. Timestamps are kept at millisecond precision,
+// same as in a database, and nothing is kept after the process ends.
+type MemoryStorage struct {
+	lock sync.RWMutex
+	// events are in the order they were stored, the sequence number of an event is its index plus one.
+	events []model.KeyEventWithTimestamp
+	peers  map[string]Watermark
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{peers: make(map[string]Watermark)}
+}
+
+func (m *MemoryStorage) Store(event *model.KeyEvent) error {
+	return m.StoreEvent(&model.KeyEventWithTimestamp{
+		Row:       event.Row,
+		Col:       event.Col,
+		Position:  event.Position,
+		Pressed:   event.Pressed,
+		Timestamp: time.Now(),
+	})
+}
+
+func (m *MemoryStorage) StoreEvent(event *model.KeyEventWithTimestamp) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.events = append(m.events, stored(*event))
+
+	return nil
+}
+
+// stored is the event as storages keep it.
+func stored(event model.KeyEventWithTimestamp) model.KeyEventWithTimestamp {
+	event.Timestamp = event.Timestamp.UTC().Truncate(time.Millisecond)
+
+	return event
+}
+
+func (m *MemoryStorage) GatherAll() ([]model.MinimalKeyEvent, error) {
+	return CountReleases(m.History(context.Background(), HistoryQuery{}))
+}
+
+func (m *MemoryStorage) History(ctx context.Context, query HistoryQuery) iter.Seq2[HistoryEvent, error] {
+	return func(yield func(HistoryEvent, error) bool) {
+		m.lock.RLock()
+
+		matched := make([]HistoryEvent, 0, len(m.events))
+
+		for i, event := range m.events {
+			cursor := CursorAt(event.Timestamp, int64(i+1))
+			if query.Matches(event) && (query.After.IsZero() || cursor.Compare(query.After) > 0) {
+				matched = append(matched, HistoryEvent{KeyEventWithTimestamp: event, Cursor: cursor})
+			}
+		}
+
+		m.lock.RUnlock()
+
+		slices.SortFunc(matched, func(a, b HistoryEvent) int { return a.Cursor.Compare(b.Cursor) })
+
+		for _, event := range matched {
+			if err := ctx.Err(); err != nil {
+				yield(HistoryEvent{}, fmt.Errorf("stopped reading keypresses: %w", err))
+
+				return
+			}
+
+			if !yield(event, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Close does nothing, events stay readable.
+func (m *MemoryStorage) Close() {}
+
+func (m *MemoryStorage) Count() (int, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return len(m.events), nil
+}
+
+// Import stores events that are not in the storage yet, compared the same way SQLiteStorage.Import does.
+// Nothing is stored if the input turns out to be broken.
+func (m *MemoryStorage) Import(events iter.Seq2[model.KeyEventWithTimestamp, error]) (ImportResult, error) {
+	var result ImportResult
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	seen := make(map[EventIdentity]bool, len(m.events))
+	for _, event := range m.events {
+		seen[IdentityOf(event)] = true
+	}
+
+	var added []model.KeyEventWithTimestamp
+
+	for event, err := range events {
+		if err != nil {
+			return ImportResult{}, err
+		}
+
+		event = stored(event)
+
+		if id := IdentityOf(event); seen[id] {
+			result.Skipped++
+		} else {
+			seen[id] = true
+			added = append(added, event)
+			result.Inserted++
+		}
+	}
+
+	if len(added) > 0 {
+		result.First, result.Last = int64(len(m.events))+1, int64(len(m.events)+len(added))
+	}
+
+	m.events = append(m.events, added...)
+
+	return result, nil
+}
+
+func (m *MemoryStorage) PeerWatermark(peer string) (Watermark, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.peers[peer], nil
+}
+
+func (m *MemoryStorage) SetPeerWatermark(peer string, w Watermark) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.peers[peer] = w
+
+	return nil
+}
+
+// EventsAfter returns events in the order they were stored, cursors are their sequence numbers.
+func (m *MemoryStorage) EventsAfter(cursor int64, limit int) ([]model.KeyEventWithTimestamp, int64, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	start := min(max(cursor, 0), int64(len(m.events)))
+	end := min(start+int64(limit), int64(len(m.events)))
+
+	return slices.Clone(m.events[start:end]), end, nil
+}
+
+// EventIdentity is what tells events apart when they are imported: their location, state, timestamp
+// at millisecond precision, source and keyboard, see SQLiteStorage.Import.
+type EventIdentity struct {
+	row, col         int
+	position         model.KeyPosition
+	pressed          bool
+	ts               int64
+	source, keyboard string
+}
+
+func IdentityOf(event model.KeyEventWithTimestamp) EventIdentity {
+	return EventIdentity{
+		event.Row, event.Col, event.Position, event.Pressed, event.Timestamp.UnixMilli(), event.Source, event.Keyboard,
+	}
+}
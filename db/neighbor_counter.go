@@ -2,98 +2,160 @@ package db
 
 import (
 	"iter"
-	"log/slog"
 	"sync"
 
 	"github.com/dasdy/glover/model"
 )
 
-// NeighborCounterImpl implements the NeighborCounter interface.
+// NeighborCounterImpl counts keys pressed one right after another.
 type NeighborCounterImpl struct {
-	lastKey   model.KeyPosition
-	counts    map[model.KeyPosition]map[model.KeyPosition]int
+	// lastKeys are keys pressed last on every source, so keys of different machines are not neighbors.
+	lastKeys map[string]model.KeyPosition
+	counts   map[model.KeyPosition]map[model.KeyPosition]int
+	// changed are positions whose neighbors were counted since the last snapshot, only they
+	// are copied again when a snapshot is taken.
+	changed   map[model.KeyPosition]bool
+	snapshot  CombosByPosition
+	version   uint64
 	stateLock sync.RWMutex
 }
 
 // NewNeighborCounter creates a new NeighborCounter.
-func newNeighborCounter() *NeighborCounterImpl {
-	return &NeighborCounterImpl{
-		lastKey:   -1,
-		counts:    make(map[model.KeyPosition]map[model.KeyPosition]int),
+func NewNeighborCounter() *NeighborCounterImpl {
+	counter := &NeighborCounterImpl{
 		stateLock: sync.RWMutex{},
 	}
+	counter.reset()
+
+	return counter
 }
 
-func NewNeighborCounterFromDb(storage Storage) (*NeighborCounterImpl, error) {
-	tracker := newNeighborCounter()
+// NewNeighborCounterFromEvents scans the given events before returning, e.g. to count neighbors in a part of the history.
+func NewNeighborCounterFromEvents(items iter.Seq[model.KeyEventWithTimestamp]) *NeighborCounterImpl {
+	tracker := NewNeighborCounter()
+	_ = tracker.Init(items)
 
-	go func() {
-		iterator, err := storage.AllIterator()
-		if err != nil {
-			panic(err)
-		}
+	return tracker
+}
 
-		tracker.initCounter(iterator)
-	}()
+// Init takes the lock for every event, so snapshots can be taken while history is scanned.
+func (nc *NeighborCounterImpl) Init(history iter.Seq[model.KeyEventWithTimestamp]) error {
+	nc.Reset()
+
+	for item := range history {
+		nc.Handle(item)
+	}
 
-	return tracker, nil
+	return nil
 }
 
-func (nc *NeighborCounterImpl) HandleKeyNow(position model.KeyPosition, pressed bool, verbose bool) {
-	nc.handleKey(position, pressed, verbose)
+func (nc *NeighborCounterImpl) Handle(event model.KeyEventWithTimestamp) {
+	nc.stateLock.Lock()
+	defer nc.stateLock.Unlock()
+
+	nc.handleKey(event.Source, event.Position, event.Pressed)
 }
 
-// GetAllNeighborCounts returns all recorded neighbor counts.
-func (nc *NeighborCounterImpl) GatherCombos(position model.KeyPosition) []model.Combo {
-	counts := nc.counts[position]
+// Snapshot lists, under each position, pairs of keys pressed right after it. Pairs of positions
+// that did not change since the last snapshot are shared with it.
+func (nc *NeighborCounterImpl) Snapshot() Snapshot {
+	nc.stateLock.Lock()
+	defer nc.stateLock.Unlock()
+
+	if nc.snapshot != nil && len(nc.changed) == 0 {
+		return nc.snapshot
+	}
+
+	result := make(CombosByPosition, len(nc.counts))
+
+	for position, counts := range nc.counts {
+		if previous, ok := nc.snapshot[position]; ok && !nc.changed[position] {
+			result[position] = previous
+
+			continue
+		}
 
-	result := make([]model.Combo, 0, len(counts))
+		combos := make([]model.Combo, 0, len(counts))
 
-	for k, v := range counts {
-		result = append(result, model.Combo{
-			Keys:    []model.KeyPosition{k, position},
-			Pressed: v,
-		})
+		for k, v := range counts {
+			combos = append(combos, model.Combo{
+				Keys:    []model.KeyPosition{k, position},
+				Pressed: v,
+			})
+		}
+
+		result[position] = combos
 	}
 
+	nc.snapshot = result
+	clear(nc.changed)
+
 	return result
 }
 
-func (nc *NeighborCounterImpl) initCounter(items iter.Seq[model.KeyEventWithTimestamp]) {
+// Version changes every time a pair of keys is counted.
+func (nc *NeighborCounterImpl) Version() uint64 {
+	nc.stateLock.RLock()
+	defer nc.stateLock.RUnlock()
+
+	return nc.version
+}
+
+func (nc *NeighborCounterImpl) Reset() {
+	nc.stateLock.Lock()
+	defer nc.stateLock.Unlock()
+
+	nc.reset()
+}
+
+func (nc *NeighborCounterImpl) reset() {
+	nc.lastKeys = make(map[string]model.KeyPosition)
+	nc.counts = make(map[model.KeyPosition]map[model.KeyPosition]int)
+	nc.changed = make(map[model.KeyPosition]bool)
+	nc.snapshot = nil
+	nc.version++
+}
+
+// Seed adds pairs kept in rollups to what was counted.
+func (nc *NeighborCounterImpl) Seed(rollup *Rollup) {
 	nc.stateLock.Lock()
 	defer nc.stateLock.Unlock()
 
-	for item := range items {
-		if !item.Pressed {
+	for _, pair := range rollup.Combos[NeighborsTracker] {
+		if len(pair.Keys) != 2 {
 			continue
 		}
 
-		nc.handleKey(item.Position, item.Pressed, false)
+		next, last := pair.Keys[0], pair.Keys[1]
+		if _, exists := nc.counts[last]; !exists {
+			nc.counts[last] = make(map[model.KeyPosition]int)
+		}
+
+		nc.counts[last][next] += pair.Pressed
+		nc.changed[last] = true
+		nc.version++
 	}
 }
 
-// RecordKeyPress records a key press and updates neighbor counts.
-func (nc *NeighborCounterImpl) handleKey(position model.KeyPosition, pressed, verbose bool) {
+// handleKey records a key press and updates neighbor counts.
+func (nc *NeighborCounterImpl) handleKey(source string, position model.KeyPosition, pressed bool) {
 	// only process keypresses, not key releases
 	if !pressed {
 		return
 	}
 
-	if nc.lastKey >= 0 {
+	if lastKey, ok := nc.lastKeys[source]; ok && lastKey >= 0 {
 		// Initialize the map for the last key if it doesn't exist
-		if _, exists := nc.counts[nc.lastKey]; !exists {
-			nc.counts[nc.lastKey] = make(map[model.KeyPosition]int)
+		if _, exists := nc.counts[lastKey]; !exists {
+			nc.counts[lastKey] = make(map[model.KeyPosition]int)
 		}
 
-		if verbose {
-			slog.Info("key press sequence",
-				"current", position,
-				"previous", nc.lastKey)
-		}
 		// Increment the count for this neighbor pair
-		nc.counts[nc.lastKey][position]++
+		nc.counts[lastKey][position]++
+		nc.changed[lastKey] = true
+		nc.version++
 	}
 
 	// Update the last key pressed
-	nc.lastKey = position
+	nc.lastKeys[source] = position
 }
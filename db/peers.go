@@ -0,0 +1,83 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/dasdy/glover/model"
+)
+
+// Watermark tells how far the history has been exchanged with a peer. Both values are cursors
+// returned by EventsAfter: Pushed on this side, Pulled on the side of the peer.
+type Watermark struct {
+	Pushed int64
+	Pulled int64
+}
+
+func initPeersTable(db *sql.DB) error {
+	_, err := db.Exec(`create table if not exists sync_peers(peer text primary key, pushed int, pulled int);`)
+	if err != nil {
+		return fmt.Errorf("could not create sync_peers table: got %w", err)
+	}
+
+	return nil
+}
+
+// PeerWatermark returns the stored watermark of the peer, or zero one if nothing was exchanged yet.
+func (s *SQLiteStorage) PeerWatermark(peer string) (Watermark, error) {
+	var w Watermark
+
+	err := s.db.QueryRow(`select pushed, pulled from sync_peers where peer = ?`, peer).Scan(&w.Pushed, &w.Pulled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Watermark{}, nil
+	}
+
+	if err != nil {
+		return w, fmt.Errorf("could not query watermark of %s: got %w", peer, err)
+	}
+
+	return w, nil
+}
+
+func (s *SQLiteStorage) SetPeerWatermark(peer string, w Watermark) error {
+	_, err := s.db.Exec(`insert into sync_peers(peer, pushed, pulled) values(?, ?, ?)
+        on conflict(peer) do update set pushed = excluded.pushed, pulled = excluded.pulled`,
+		peer, w.Pushed, w.Pulled)
+	if err != nil {
+		return fmt.Errorf("could not store watermark of %s: got %w", peer, err)
+	}
+
+	return nil
+}
+
+// EventsAfter returns at most limit events stored after the cursor, in the order they were stored,
+// and the cursor of the last returned event. Cursor 0 starts from the beginning of the history.
+// Cursors are ids of events, rowid is their alias, so they stay the same when the database is
+// vacuumed. Read-only databases of older versions have no ids, their rowids are used instead.
+func (s *SQLiteStorage) EventsAfter(cursor int64, limit int) ([]model.KeyEventWithTimestamp, int64, error) {
+	rows, err := s.db.Query(`select rowid, `+s.eventColumns+` from keypresses
+        where rowid > ? order by rowid limit ?`, cursor, limit)
+	if err != nil {
+		return nil, cursor, fmt.Errorf("could not query keypresses after %d: got %w", cursor, err)
+	}
+	defer rows.Close()
+
+	result := make([]model.KeyEventWithTimestamp, 0, limit)
+
+	for rows.Next() {
+		var e model.KeyEventWithTimestamp
+
+		if err := rows.Scan(&cursor, &e.Row, &e.Col, &e.Position, &e.Pressed, &e.Timestamp, &e.Source, &e.Keyboard); err != nil {
+			return nil, cursor, fmt.Errorf("could not scan row: got %w", err)
+		}
+
+		result = append(result, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, cursor, fmt.Errorf("error while iterating over rows: got %w", err)
+	}
+
+	return result, cursor, nil
+}
@@ -0,0 +1,575 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dasdy/glover/model"
+)
+
+// Rollups keep counts of key releases and of combos per hour, so statistics outlive raw events that
+// retention deletes. Key counts are maintained by a trigger on every insert, combos are computed by
+// trackers when rollups are backfilled and before raw events are deleted, as they depend on the order
+// of events and on options of trackers. Events do not record the active layer, so there are no
+// rollups per layer.
+
+// prunedBeforeState names the time before which raw events were deleted.
+const prunedBeforeState = "pruned-before"
+
+// bucketOf is the start of the hour of the timestamp column, formatted as timestampLayout.
+const bucketOf = "strftime('%Y-%m-%d %H:00:00.000', ts)"
+
+// prunedBefore selects the time before which raw events were deleted, NULL if they were not.
+const prunedBefore = "(select value from rollup_state where name = '" + prunedBeforeState + "')"
+
+func initRollupTables(db *sql.DB) error {
+	statements := []string{
+		`create table if not exists rollup_keys(
+            bucket text, keyboard text, source text, row int, col int, position int, count int,
+            primary key(bucket, keyboard, source, row, col, position))`,
+		`create table if not exists rollup_combos(
+            bucket text, keyboard text, tracker text, keys text, count int,
+            primary key(bucket, keyboard, tracker, keys))`,
+		`create table if not exists rollup_state(name text primary key, value text)`,
+		`create trigger if not exists keypresses_rollup_keys after insert on keypresses when not new.pressed
+        begin
+            insert into rollup_keys(bucket, keyboard, source, row, col, position, count)
+            values(strftime('%Y-%m-%d %H:00:00.000', new.ts), new.keyboard, new.source, new.row, new.col, new.position, 1)
+            on conflict(bucket, keyboard, source, row, col, position) do update set count = count + 1;
+        end`,
+	}
+
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			return fmt.Errorf("could not create rollups: got %w", err)
+		}
+	}
+
+	return nil
+}
+
+// KeyRollup is how many times a key was released during the hour, or the day once rollups are downsampled,
+// that starts at Bucket.
+type KeyRollup struct {
+	Bucket   time.Time
+	Row, Col int
+	Position model.KeyPosition
+	Count    int
+}
+
+// Rollup is what is kept of the history whose raw events were deleted. Combos are totals of
+// every tracker by its name.
+type Rollup struct {
+	Keys   []KeyRollup
+	Combos map[string][]model.Combo
+}
+
+// RollupStorage is a storage that keeps rollups.
+type RollupStorage interface {
+	// PrunedRollup returns rollups of the part of the history whose raw events were deleted, limited to
+	// the time range of the query. Sources and positions only filter key counts. Buckets are matched by
+	// the time they start.
+	PrunedRollup(ctx context.Context, query HistoryQuery) (*Rollup, error)
+}
+
+// Seeded is a tracker that can start from counts kept in rollups, so it does not lose what was
+// counted before raw events were deleted.
+type Seeded interface {
+	Seed(rollup *Rollup)
+}
+
+// Retention tells how long history is kept in detail. Zero keeps it forever.
+type Retention struct {
+	// RawDays is how many days raw events are kept, older ones are only counted in rollups.
+	RawDays int
+	// HourlyDays is how many days rollups are kept per hour, older ones are merged into days.
+	HourlyDays int
+}
+
+// PruneResult tells what retention removed.
+type PruneResult struct {
+	Deleted      int64
+	Downsampled  int64
+	PrunedBefore time.Time
+}
+
+// NewTrackers creates trackers whose combos are kept in rollups.
+type NewTrackers func() (*TrackerSet, error)
+
+// PrunedBefore returns the time before which raw events were deleted, zero if none were.
+func (s *SQLiteStorage) PrunedBefore() (time.Time, error) {
+	if !s.rollups {
+		return time.Time{}, nil
+	}
+
+	var value string
+
+	err := s.db.QueryRow(`select value from rollup_state where name = ?`, prunedBeforeState).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not query rollup state: got %w", err)
+	}
+
+	t, err := time.Parse(timestampLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time of pruned events '%s': got %w", value, err)
+	}
+
+	return t, nil
+}
+
+func (s *SQLiteStorage) PrunedRollup(ctx context.Context, query HistoryQuery) (*Rollup, error) {
+	return s.prunedRollup(ctx, query, "1 = 1")
+}
+
+func (s *SQLiteStorage) prunedRollup(ctx context.Context, query HistoryQuery, condition string, args ...any) (*Rollup, error) {
+	result := &Rollup{Combos: make(map[string][]model.Combo)}
+	if !s.rollups {
+		return result, nil
+	}
+
+	conditions := []string{condition, "bucket < " + prunedBefore}
+
+	if !query.Since.IsZero() {
+		conditions = append(conditions, "bucket >= ?")
+		args = append(args, query.Since.UTC().Format(timestampLayout))
+	}
+
+	if !query.Until.IsZero() {
+		conditions = append(conditions, "bucket < ?")
+		args = append(args, query.Until.UTC().Format(timestampLayout))
+	}
+
+	combosFilter := strings.Join(conditions, " and ")
+	combosArgs := slices.Clone(args)
+
+	if len(query.Sources) > 0 {
+		conditions = append(conditions, "source in (?"+strings.Repeat(", ?", len(query.Sources)-1)+")")
+		for _, source := range query.Sources {
+			args = append(args, source)
+		}
+	}
+
+	if len(query.Positions) > 0 {
+		conditions = append(conditions, "position in (?"+strings.Repeat(", ?", len(query.Positions)-1)+")")
+		for _, position := range query.Positions {
+			args = append(args, int(position))
+		}
+	}
+
+	keys, err := s.db.QueryContext(ctx, `select bucket, row, col, position, sum(count) from rollup_keys
+        where `+strings.Join(conditions, " and ")+`
+        group by bucket, row, col, position
+        order by bucket, row, position`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query key rollups: got %w", err)
+	}
+	defer keys.Close()
+
+	for keys.Next() {
+		var k KeyRollup
+
+		var bucket string
+
+		if err := keys.Scan(&bucket, &k.Row, &k.Col, &k.Position, &k.Count); err != nil {
+			return nil, fmt.Errorf("could not scan key rollup: got %w", err)
+		}
+
+		if k.Bucket, err = time.Parse(timestampLayout, bucket); err != nil {
+			return nil, fmt.Errorf("invalid bucket of key rollup '%s': got %w", bucket, err)
+		}
+
+		result.Keys = append(result.Keys, k)
+	}
+
+	if err := keys.Err(); err != nil {
+		return nil, fmt.Errorf("error while iterating over key rollups: got %w", err)
+	}
+
+	combos, err := s.db.QueryContext(ctx, `select tracker, keys, sum(count) from rollup_combos
+        where `+combosFilter+`
+        group by tracker, keys`, combosArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query combo rollups: got %w", err)
+	}
+	defer combos.Close()
+
+	for combos.Next() {
+		var tracker, keys string
+
+		var count int
+
+		if err := combos.Scan(&tracker, &keys, &count); err != nil {
+			return nil, fmt.Errorf("could not scan combo rollup: got %w", err)
+		}
+
+		positions, err := parseComboKeys(keys)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Combos[tracker] = append(result.Combos[tracker], model.Combo{Keys: positions, Pressed: count})
+	}
+
+	if err := combos.Err(); err != nil {
+		return nil, fmt.Errorf("error while iterating over combo rollups: got %w", err)
+	}
+
+	return result, nil
+}
+
+// comboKeys keeps the order of keys: neighbor trackers count directed pairs.
+func comboKeys(keys []model.KeyPosition) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = strconv.Itoa(int(k))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func parseComboKeys(s string) ([]model.KeyPosition, error) {
+	parts := strings.Split(s, ",")
+	result := make([]model.KeyPosition, len(parts))
+
+	for i, p := range parts {
+		k, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keys of combo rollup '%s': got %w", s, err)
+		}
+
+		result[i] = model.KeyPosition(k)
+	}
+
+	return result, nil
+}
+
+// comboDelta is how many times a combo was counted during an hour.
+type comboDelta struct {
+	bucket   string
+	keyboard string
+	tracker  string
+	keys     string
+	count    int
+}
+
+// Backfill computes rollups from raw events in [since, until) again, e.g. for events stored before
+// rollups existed. Zero time leaves the bound open; the range is widened to whole hours, and it never
+// reaches into the time whose raw events were deleted, as their rollups can not be computed again.
+func (s *SQLiteStorage) Backfill(ctx context.Context, since, until time.Time, newTrackers NewTrackers) error {
+	pruned, err := s.PrunedBefore()
+	if err != nil {
+		return err
+	}
+
+	since = since.UTC().Truncate(time.Hour)
+	if since.Before(pruned) {
+		since = pruned
+	}
+
+	if !until.IsZero() {
+		until = until.UTC().Add(time.Hour - time.Nanosecond).Truncate(time.Hour)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start transaction: got %w", err)
+	}
+
+	defer tx.Rollback() //nolint:errcheck // Rollback after commit is a no-op.
+
+	if err := s.backfill(ctx, tx, HistoryQuery{Since: since, Until: until}, newTrackers); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit rollups: got %w", err)
+	}
+
+	return nil
+}
+
+// backfill replaces rollups of the range of the query in the transaction. Rollups are deleted first,
+// which takes the write lock of the database, so raw events of the range do not change while combos
+// are replayed from them.
+func (s *SQLiteStorage) backfill(ctx context.Context, tx *sql.Tx, query HistoryQuery, newTrackers NewTrackers) error {
+	bucketRange, rangeArgs := bucketRangeFilter(query)
+	rawRange, rawArgs := s.historyFilter(query, "")
+
+	statements := []struct {
+		sql  string
+		args []any
+	}{
+		{`delete from rollup_keys where ` + bucketRange, rangeArgs},
+		{`delete from rollup_combos where ` + bucketRange, rangeArgs},
+		{`insert into rollup_keys(bucket, keyboard, source, row, col, position, count)
+            select ` + bucketOf + `, keyboard, source, row, col, position, count(*)
+            from keypresses ` + rawRange + ` and pressed = false
+            group by 1, keyboard, source, row, col, position`, rawArgs},
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.sql, statement.args...); err != nil {
+			return fmt.Errorf("could not backfill rollups: got %w", err)
+		}
+	}
+
+	deltas, err := s.comboDeltas(ctx, tx, query, newTrackers)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `insert into rollup_combos(bucket, keyboard, tracker, keys, count)
+        values(?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("could not prepare insert: got %w", err)
+	}
+	defer stmt.Close()
+
+	for _, d := range deltas {
+		if _, err := stmt.ExecContext(ctx, d.bucket, d.keyboard, d.tracker, d.keys, d.count); err != nil {
+			return fmt.Errorf("could not insert combo rollup: got %w", err)
+		}
+	}
+
+	return nil
+}
+
+func bucketRangeFilter(query HistoryQuery) (string, []any) {
+	conditions := []string{"1 = 1"}
+	args := make([]any, 0, 2)
+
+	if !query.Since.IsZero() {
+		conditions = append(conditions, "bucket >= ?")
+		args = append(args, query.Since.UTC().Format(timestampLayout))
+	}
+
+	if !query.Until.IsZero() {
+		conditions = append(conditions, "bucket < ?")
+		args = append(args, query.Until.UTC().Format(timestampLayout))
+	}
+
+	return strings.Join(conditions, " and "), args
+}
+
+// comboDeltas replays events of every keyboard through new trackers and takes what they counted
+// during each hour. Trackers are not reset between hours, so chords that span them are counted once.
+// Events are read with the queryer of the transaction, so they are the ones it rolls up and deletes.
+func (s *SQLiteStorage) comboDeltas(ctx context.Context, q queryer, query HistoryQuery, newTrackers NewTrackers) ([]comboDelta, error) {
+	rows, err := q.QueryContext(ctx, `select distinct keyboard from keypresses`)
+	if err != nil {
+		return nil, fmt.Errorf("could not query keyboards: got %w", err)
+	}
+
+	var keyboards []string
+
+	for rows.Next() {
+		var keyboard string
+		if err := rows.Scan(&keyboard); err != nil {
+			rows.Close()
+
+			return nil, fmt.Errorf("could not scan keyboard: got %w", err)
+		}
+
+		keyboards = append(keyboards, keyboard)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error while iterating over keyboards: got %w", err)
+	}
+
+	var result []comboDelta
+
+	for _, keyboard := range keyboards {
+		trackers, err := newTrackers()
+		if err != nil {
+			return nil, err
+		}
+
+		// Counts at the end of the previous hour.
+		counted := make(map[string]map[string]int)
+		bucket := ""
+
+		flush := func() {
+			for _, name := range trackers.Names() {
+				snapshot, ok := trackers.Snapshot(name).(CombosByPosition)
+				if !ok || name == KeysTracker {
+					continue
+				}
+
+				if counted[name] == nil {
+					counted[name] = make(map[string]int)
+				}
+
+				for _, combo := range GatherAllCombos(snapshot, slices.Collect(maps.Keys(snapshot))) {
+					keys := comboKeys(combo.Keys)
+					if delta := combo.Pressed - counted[name][keys]; delta > 0 {
+						result = append(result, comboDelta{bucket: bucket, keyboard: keyboard, tracker: name, keys: keys, count: delta})
+					}
+
+					counted[name][keys] = combo.Pressed
+				}
+			}
+		}
+
+		for event, err := range s.history(ctx, q, query, "keyboard = ?", keyboard) {
+			if err != nil {
+				return nil, err
+			}
+
+			eventBucket := event.Timestamp.UTC().Truncate(time.Hour).Format(timestampLayout)
+			if eventBucket != bucket && bucket != "" {
+				flush()
+			}
+
+			bucket = eventBucket
+
+			trackers.Handle(event.KeyEventWithTimestamp)
+		}
+
+		if bucket != "" {
+			flush()
+		}
+	}
+
+	return result, nil
+}
+
+// Prune applies the retention policy at the given time. Rollups of raw events that are about to be deleted
+// are computed again in the same transaction that deletes them, so nothing they counted is lost. Then hourly rollups of the time without raw
+// events that are older than the policy allows are merged into days.
+func (s *SQLiteStorage) Prune(ctx context.Context, retention Retention, now time.Time, newTrackers NewTrackers) (PruneResult, error) {
+	var result PruneResult
+
+	pruned, err := s.PrunedBefore()
+	if err != nil {
+		return result, err
+	}
+
+	result.PrunedBefore = pruned
+
+	if retention.RawDays > 0 {
+		before := now.UTC().Truncate(24*time.Hour).AddDate(0, 0, -retention.RawDays)
+		if before.After(pruned) {
+			result.Deleted, err = s.pruneRaw(ctx, HistoryQuery{Since: pruned, Until: before}, newTrackers)
+			if err != nil {
+				return result, err
+			}
+
+			result.PrunedBefore = before
+		}
+	}
+
+	if retention.HourlyDays > 0 {
+		before := now.UTC().Truncate(24*time.Hour).AddDate(0, 0, -retention.HourlyDays)
+		// Rollups that can still be computed from raw events stay hourly.
+		if before.After(result.PrunedBefore) {
+			before = result.PrunedBefore
+		}
+
+		result.Downsampled, err = s.downsample(ctx, before)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// pruneRaw computes rollups of raw events in the range of the query again and deletes them in one
+// transaction, so every deleted event is in rollups and nothing stored meanwhile is lost.
+func (s *SQLiteStorage) pruneRaw(ctx context.Context, query HistoryQuery, newTrackers NewTrackers) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not start transaction: got %w", err)
+	}
+
+	defer tx.Rollback() //nolint:errcheck // Rollback after commit is a no-op.
+
+	if err := s.backfill(ctx, tx, query, newTrackers); err != nil {
+		return 0, err
+	}
+
+	rawRange, rawArgs := s.historyFilter(query, "")
+
+	res, err := tx.ExecContext(ctx, `delete from keypresses `+rawRange, rawArgs...)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete raw events: got %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `insert into rollup_state(name, value) values(?, ?)
+        on conflict(name) do update set value = excluded.value`, prunedBeforeState, query.Until.UTC().Format(timestampLayout))
+	if err != nil {
+		return 0, fmt.Errorf("could not store rollup state: got %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not commit deletion of raw events: got %w", err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not check deleted rows: got %w", err)
+	}
+
+	return deleted, nil
+}
+
+// downsample merges hourly rollups before the time into the first hour of their day.
+func (s *SQLiteStorage) downsample(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not start transaction: got %w", err)
+	}
+
+	defer tx.Rollback() //nolint:errcheck // Rollback after commit is a no-op.
+
+	value := before.UTC().Format(timestampLayout)
+	hourly := `bucket < ? and substr(bucket, 12) != '00:00:00.000'`
+
+	var merged int64
+
+	for _, table := range []struct{ name, columns string }{
+		{"rollup_keys", "keyboard, source, row, col, position"},
+		{"rollup_combos", "keyboard, tracker, keys"},
+	} {
+		_, err := tx.ExecContext(ctx, `insert into `+table.name+`(bucket, `+table.columns+`, count)
+            select substr(bucket, 1, 10) || ' 00:00:00.000', `+table.columns+`, sum(count)
+            from `+table.name+` where `+hourly+`
+            group by 1, `+table.columns+`
+            on conflict(bucket, `+table.columns+`) do update set count = count + excluded.count`, value)
+		if err != nil {
+			return 0, fmt.Errorf("could not downsample %s: got %w", table.name, err)
+		}
+
+		res, err := tx.ExecContext(ctx, `delete from `+table.name+` where `+hourly, value)
+		if err != nil {
+			return 0, fmt.Errorf("could not delete hourly %s: got %w", table.name, err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("could not check deleted rows: got %w", err)
+		}
+
+		merged += affected
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not commit downsampling: got %w", err)
+	}
+
+	return merged, nil
+}
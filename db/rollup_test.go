@@ -0,0 +1,195 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func comboCounts(snapshot db.Snapshot) map[string]int {
+	result := make(map[string]int)
+
+	for _, combo := range db.GatherAllCombos(snapshot, []model.KeyPosition{1, 2, 3}) {
+		result[fmt.Sprint(combo.Keys)] = combo.Pressed
+	}
+
+	return result
+}
+
+func initTrackers(t *testing.T, storage db.Storage) *db.TrackerSet {
+	t.Helper()
+
+	trackers, err := db.NewTrackerSet(db.CombosTracker, db.KeysTracker)
+	require.NoError(t, err)
+	require.NoError(t, trackers.InitFrom(context.Background(), storage, db.HistoryQuery{}))
+
+	return trackers
+}
+
+func TestRetention(t *testing.T) {
+	storage, err := db.NewStorageFromPath(t.TempDir()+"/rollup.sqlite", false)
+	require.NoError(t, err)
+
+	defer storage.Close()
+
+	old := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	recent := time.Date(2024, 3, 20, 10, 0, 0, 0, time.UTC)
+
+	var events []model.KeyEventWithTimestamp
+
+	events = append(events, keyEvents(old, 10*time.Millisecond, "+1 +2 -1 -2")...)
+	events = append(events, keyEvents(old.Add(time.Hour), 10*time.Millisecond, "+1 +2 -2 -1 +3 -3")...)
+	events = append(events, keyEvents(recent, 10*time.Millisecond, "+1 +2 -1 -2")...)
+
+	for _, e := range events {
+		e.Row, e.Col = int(e.Position), int(e.Position)
+		require.NoError(t, storage.StoreEvent(&e))
+	}
+
+	keys, err := storage.GatherAll()
+	require.NoError(t, err)
+	assert.Equal(t, []model.MinimalKeyEvent{
+		{Row: 1, Col: 1, Position: 1, Count: 3},
+		{Row: 2, Col: 2, Position: 2, Count: 3},
+		{Row: 3, Col: 3, Position: 3, Count: 1},
+	}, keys, "rollups of events that were not pruned are not counted twice")
+
+	combos := comboCounts(initTrackers(t, storage).Snapshot(db.CombosTracker))
+
+	newTrackers := func() (*db.TrackerSet, error) { return db.NewTrackerSet(db.CombosTracker) }
+	now := time.Date(2024, 3, 25, 12, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	t.Run("deletes raw events and keeps counting them", func(t *testing.T) {
+		result, err := storage.Prune(ctx, db.Retention{RawDays: 10}, now, newTrackers)
+		require.NoError(t, err)
+		assert.Equal(t, db.PruneResult{Deleted: 10, PrunedBefore: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)}, result)
+
+		remaining := 0
+		for _, err := range storage.History(ctx, db.HistoryQuery{}) {
+			require.NoError(t, err)
+
+			remaining++
+		}
+
+		assert.Equal(t, 4, remaining)
+
+		gathered, err := storage.GatherAll()
+		require.NoError(t, err)
+		assert.Equal(t, keys, gathered)
+
+		trackers := initTrackers(t, storage)
+		assert.Equal(t, combos, comboCounts(trackers.Snapshot(db.CombosTracker)))
+
+		counted, ok := trackers.Get(db.KeysTracker).(*db.KeyCounter).Stats()
+		require.True(t, ok)
+		assert.Equal(t, keys, counted)
+	})
+
+	t.Run("reads hourly rollups of a time range", func(t *testing.T) {
+		rollup, err := storage.PrunedRollup(ctx, db.HistoryQuery{Since: old.Add(time.Hour), Until: recent})
+		require.NoError(t, err)
+		assert.Equal(t, []db.KeyRollup{
+			{Bucket: old.Add(time.Hour), Row: 1, Col: 1, Position: 1, Count: 1},
+			{Bucket: old.Add(time.Hour), Row: 2, Col: 2, Position: 2, Count: 1},
+			{Bucket: old.Add(time.Hour), Row: 3, Col: 3, Position: 3, Count: 1},
+		}, rollup.Keys)
+		assert.Equal(t, []model.Combo{{Keys: []model.KeyPosition{1, 2}, Pressed: 1}}, rollup.Combos[db.CombosTracker])
+	})
+
+	t.Run("skips imported events that were pruned", func(t *testing.T) {
+		result, err := storage.Import(func(yield func(model.KeyEventWithTimestamp, error) bool) {
+			yield(events[1], nil)
+		})
+		require.NoError(t, err)
+		assert.Equal(t, db.ImportResult{Skipped: 1}, result)
+	})
+
+	t.Run("backfill leaves pruned time alone", func(t *testing.T) {
+		require.NoError(t, storage.Backfill(ctx, time.Time{}, time.Time{}, newTrackers))
+
+		gathered, err := storage.GatherAll()
+		require.NoError(t, err)
+		assert.Equal(t, keys, gathered)
+		assert.Equal(t, combos, comboCounts(initTrackers(t, storage).Snapshot(db.CombosTracker)))
+	})
+
+	t.Run("merges old hours into days", func(t *testing.T) {
+		result, err := storage.Prune(ctx, db.Retention{RawDays: 10, HourlyDays: 20}, now, newTrackers)
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), result.Downsampled, "hourly rows: five of keys and two of combos")
+
+		rollup, err := storage.PrunedRollup(ctx, db.HistoryQuery{})
+		require.NoError(t, err)
+
+		day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+		assert.True(t, slices.ContainsFunc(rollup.Keys, func(k db.KeyRollup) bool { return k.Bucket.Equal(day) }))
+		assert.False(t, slices.ContainsFunc(rollup.Keys, func(k db.KeyRollup) bool { return !k.Bucket.Equal(day) }))
+
+		gathered, err := storage.GatherAll()
+		require.NoError(t, err)
+		assert.Equal(t, keys, gathered)
+		assert.Equal(t, combos, comboCounts(initTrackers(t, storage).Snapshot(db.CombosTracker)))
+	})
+}
+
+func TestPruneWhileStoring(t *testing.T) {
+	conn, err := sql.Open("sqlite3", t.TempDir()+"/rollup.sqlite")
+	require.NoError(t, err)
+	require.NoError(t, db.InitDBStorage(conn))
+
+	// With a single connection, anything the prune reads outside of its transaction waits for it forever.
+	conn.SetMaxOpenConns(1)
+
+	storage, err := db.NewStorageFromConnection(conn, false)
+	require.NoError(t, err)
+
+	defer storage.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	old := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	recent := time.Date(2024, 3, 20, 10, 0, 0, 0, time.UTC)
+
+	for _, e := range keyEvents(old, 10*time.Millisecond, "+1 +2 -1 -2 +1 +2 -2 -1") {
+		require.NoError(t, storage.StoreEvent(&e))
+	}
+
+	stored := make(chan error, 1)
+
+	var once sync.Once
+
+	// Trackers are created while the transaction of the prune is open, the event is stored meanwhile.
+	newTrackers := func() (*db.TrackerSet, error) {
+		once.Do(func() {
+			go func() {
+				stored <- storage.StoreEvent(&model.KeyEventWithTimestamp{Position: 3, Timestamp: recent})
+			}()
+		})
+
+		return db.NewTrackerSet(db.CombosTracker)
+	}
+
+	result, err := storage.Prune(ctx, db.Retention{RawDays: 10}, time.Date(2024, 3, 25, 12, 0, 0, 0, time.UTC), newTrackers)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), result.Deleted)
+	require.NoError(t, <-stored)
+
+	keys, err := storage.GatherAll()
+	require.NoError(t, err)
+	assert.Equal(t, []model.MinimalKeyEvent{{Position: 1, Count: 2}, {Position: 2, Count: 2}, {Position: 3, Count: 1}}, keys)
+
+	rollup, err := storage.PrunedRollup(ctx, db.HistoryQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []model.Combo{{Keys: []model.KeyPosition{1, 2}, Pressed: 2}}, rollup.Combos[db.CombosTracker])
+}
@@ -0,0 +1,46 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/db/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) db.Storage {
+		storage, err := db.Open("sqlite://"+t.TempDir()+"/conformance.sqlite", db.OpenOptions{})
+		require.NoError(t, err)
+
+		return storage
+	})
+}
+
+func TestMemoryStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) db.Storage {
+		storage, err := db.Open("memory://", db.OpenOptions{})
+		require.NoError(t, err)
+
+		return storage
+	})
+}
+
+func TestParseLocation(t *testing.T) {
+	for location, expected := range map[string]db.Location{
+		"./keypresses.sqlite":           {Scheme: db.SQLiteScheme, Path: "./keypresses.sqlite"},
+		"sqlite:///var/lib/k.sqlite":    {Scheme: db.SQLiteScheme, Path: "/var/lib/k.sqlite"},
+		"log://./events?segment-size=1": {Scheme: "log", Path: "./events"},
+		"memory://":                     {Scheme: db.MemoryScheme},
+	} {
+		parsed, err := db.ParseLocation(location)
+		require.NoError(t, err)
+
+		assert.Equal(t, expected.Scheme, parsed.Scheme, location)
+		assert.Equal(t, expected.Path, parsed.Path, location)
+	}
+
+	_, err := db.Open("nosuch://x", db.OpenOptions{})
+	require.ErrorContains(t, err, "unknown storage backend 'nosuch'")
+}
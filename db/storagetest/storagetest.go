@@ -0,0 +1,332 @@
+// Package storagetest checks that storages behave the same, whatever keeps their events. Tests of every
+// backend run the suite against it.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Opener creates an empty storage for a single test. The suite closes it.
+type Opener func(t *testing.T) db.Storage
+
+var start = time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+func at(d time.Duration) time.Time {
+	return start.Add(d)
+}
+
+func open(t *testing.T, opener Opener, events ...model.KeyEventWithTimestamp) db.Storage {
+	t.Helper()
+
+	storage := opener(t)
+	t.Cleanup(storage.Close)
+
+	for _, e := range events {
+		require.NoError(t, storage.StoreEvent(&e))
+	}
+
+	return storage
+}
+
+func readAll(t *testing.T, history iter.Seq2[db.HistoryEvent, error]) []db.HistoryEvent {
+	t.Helper()
+
+	var result []db.HistoryEvent
+
+	for event, err := range history {
+		require.NoError(t, err)
+
+		result = append(result, event)
+	}
+
+	return result
+}
+
+func positionsOf(events []db.HistoryEvent) []model.KeyPosition {
+	result := make([]model.KeyPosition, len(events))
+	for i, e := range events {
+		result[i] = e.Position
+	}
+
+	return result
+}
+
+// taps are press and release of every position, 100ms apart.
+func taps(positions ...model.KeyPosition) []model.KeyEventWithTimestamp {
+	result := make([]model.KeyEventWithTimestamp, 0, 2*len(positions))
+
+	for i, p := range positions {
+		for _, pressed := range []bool{true, false} {
+			result = append(result, model.KeyEventWithTimestamp{
+				Row:       int(p),
+				Col:       int(p),
+				Position:  p,
+				Pressed:   pressed,
+				Timestamp: at(time.Duration(len(result)+i) * 100 * time.Millisecond),
+			})
+		}
+	}
+
+	return result
+}
+
+// Run checks that the storage keeps the contract of db.Storage, and of the optional interfaces it implements:
+// db.Importer, db.Counted and db.SyncStorage.
+func Run(t *testing.T, opener Opener) {
+	t.Run("reads events in the order they happened", func(t *testing.T) {
+		latest := model.KeyEventWithTimestamp{
+			Row: 1, Col: 2, Position: 2, Pressed: true,
+			Timestamp: at(2*time.Second + 123456789), Source: "laptop", Keyboard: "glove80",
+		}
+
+		storage := open(t, opener,
+			latest,
+			model.KeyEventWithTimestamp{Position: 1, Timestamp: at(0)},
+			model.KeyEventWithTimestamp{Position: 3, Timestamp: at(time.Second)},
+			model.KeyEventWithTimestamp{Position: 4, Timestamp: at(time.Second)})
+
+		events := readAll(t, storage.History(context.Background(), db.HistoryQuery{}))
+		require.Equal(t, []model.KeyPosition{1, 3, 4, 2}, positionsOf(events), "same timestamps keep the order they were stored in")
+
+		read := events[3].KeyEventWithTimestamp
+		assert.True(t, at(2*time.Second+123*time.Millisecond).Equal(read.Timestamp),
+			"timestamps are kept at millisecond precision, got %s", read.Timestamp)
+
+		read.Timestamp = latest.Timestamp
+		assert.Equal(t, latest, read)
+	})
+
+	t.Run("stamps events with the time they are stored", func(t *testing.T) {
+		storage := open(t, opener)
+
+		before := time.Now().Truncate(time.Millisecond)
+		require.NoError(t, storage.Store(&model.KeyEvent{Position: 5, Pressed: true}))
+		after := time.Now()
+
+		events := readAll(t, storage.History(context.Background(), db.HistoryQuery{}))
+		require.Len(t, events, 1)
+		assert.Equal(t, model.KeyPosition(5), events[0].Position)
+		assert.False(t, events[0].Timestamp.Before(before) || events[0].Timestamp.After(after),
+			"%s is not between %s and %s", events[0].Timestamp, before, after)
+	})
+
+	t.Run("counts releases", func(t *testing.T) {
+		storage := open(t, opener, taps(2, 1, 2)...)
+
+		counts, err := storage.GatherAll()
+		require.NoError(t, err)
+		assert.Equal(t, []model.MinimalKeyEvent{
+			{Row: 1, Col: 1, Position: 1, Count: 1},
+			{Row: 2, Col: 2, Position: 2, Count: 2},
+		}, counts)
+	})
+
+	t.Run("filters the history", func(t *testing.T) {
+		events := taps(1, 2, 3, 4)
+		events[2].Source = "laptop"
+		events[3].Source = "laptop"
+
+		storage := open(t, opener, events...)
+		ctx := context.Background()
+
+		for name, c := range map[string]struct {
+			query    db.HistoryQuery
+			expected []model.KeyPosition
+		}{
+			"since is inclusive, until is not": {
+				db.HistoryQuery{Since: events[2].Timestamp, Until: events[6].Timestamp},
+				[]model.KeyPosition{2, 2, 3, 3},
+			},
+			"sources": {db.HistoryQuery{Sources: []string{"laptop"}}, []model.KeyPosition{2, 2}},
+			"directly connected keyboards": {
+				db.HistoryQuery{Sources: []string{""}, Positions: []model.KeyPosition{1, 2, 4}},
+				[]model.KeyPosition{1, 1, 4, 4},
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				assert.Equal(t, c.expected, positionsOf(readAll(t, storage.History(ctx, c.query))))
+			})
+		}
+	})
+
+	t.Run("resumes reading after a cursor", func(t *testing.T) {
+		var events []model.KeyEventWithTimestamp
+
+		// Pairs of events with the same timestamp, cursors must tell them apart.
+		for i := range 10 {
+			events = append(events, model.KeyEventWithTimestamp{
+				Position:  model.KeyPosition(i),
+				Timestamp: at(time.Duration(i/2) * time.Second),
+			})
+		}
+
+		storage := open(t, opener, events...)
+		ctx := context.Background()
+
+		all := readAll(t, storage.History(ctx, db.HistoryQuery{ChunkSize: 3}))
+		require.Equal(t, []model.KeyPosition{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, positionsOf(all))
+
+		cursor, err := db.ParseCursor(all[4].Cursor.String())
+		require.NoError(t, err)
+
+		rest := readAll(t, storage.History(ctx, db.HistoryQuery{After: cursor, ChunkSize: 3}))
+		assert.Equal(t, []model.KeyPosition{5, 6, 7, 8, 9}, positionsOf(rest))
+	})
+
+	t.Run("stops reading when the context is done", func(t *testing.T) {
+		storage := open(t, opener, taps(1, 2)...)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		var failure error
+
+		for _, err := range storage.History(ctx, db.HistoryQuery{}) {
+			if err != nil {
+				failure = err
+
+				break
+			}
+		}
+
+		assert.Error(t, failure)
+	})
+
+	t.Run("stores events from several goroutines", func(t *testing.T) {
+		storage := open(t, opener)
+
+		var wg sync.WaitGroup
+
+		for g := range 4 {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				for i := range 50 {
+					event := model.KeyEventWithTimestamp{Position: model.KeyPosition(g), Timestamp: at(time.Duration(i) * time.Millisecond)}
+					assert.NoError(t, storage.StoreEvent(&event))
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		assert.Len(t, readAll(t, storage.History(context.Background(), db.HistoryQuery{})), 200)
+
+		if counted, ok := storage.(db.Counted); ok {
+			count, err := counted.Count()
+			require.NoError(t, err)
+			assert.Equal(t, 200, count)
+		}
+	})
+
+	t.Run("imports events it does not have", func(t *testing.T) {
+		storage := open(t, opener, taps(1)...)
+
+		importer, ok := storage.(db.Importer)
+		if !ok {
+			t.Skip("storage does not import events")
+		}
+
+		input := taps(1, 2)
+		input = append(input, input[3])
+
+		result, err := importer.Import(func(yield func(model.KeyEventWithTimestamp, error) bool) {
+			for _, e := range input {
+				if !yield(e, nil) {
+					return
+				}
+			}
+		})
+		require.NoError(t, err)
+		assert.Equal(t, db.ImportResult{Inserted: 2, Skipped: 3, First: 3, Last: 4}, result)
+
+		broken := errors.New("broken input")
+
+		_, err = importer.Import(func(yield func(model.KeyEventWithTimestamp, error) bool) {
+			if yield(model.KeyEventWithTimestamp{Position: 9, Timestamp: at(time.Hour)}, nil) {
+				yield(model.KeyEventWithTimestamp{}, broken)
+			}
+		})
+		require.ErrorIs(t, err, broken)
+
+		assert.Equal(t, []model.KeyPosition{1, 1, 2, 2},
+			positionsOf(readAll(t, storage.History(context.Background(), db.HistoryQuery{}))),
+			"nothing of a broken input is stored")
+	})
+
+	t.Run("imports presses of other keyboards and sources at the same time", func(t *testing.T) {
+		storage := open(t, opener, taps(1)...)
+
+		importer, ok := storage.(db.Importer)
+		if !ok {
+			t.Skip("storage does not import events")
+		}
+
+		press := taps(1)[0]
+		otherKeyboard, otherSource := press, press
+		otherKeyboard.Keyboard = "numpad"
+		otherSource.Source = "laptop"
+
+		result, err := importer.Import(func(yield func(model.KeyEventWithTimestamp, error) bool) {
+			for _, e := range []model.KeyEventWithTimestamp{press, otherKeyboard, otherSource} {
+				if !yield(e, nil) {
+					return
+				}
+			}
+		})
+		require.NoError(t, err)
+		assert.Equal(t, db.ImportResult{Inserted: 2, Skipped: 1, First: 3, Last: 4}, result)
+	})
+
+	t.Run("exchanges events with peers", func(t *testing.T) {
+		storage := open(t, opener,
+			model.KeyEventWithTimestamp{Position: 1, Timestamp: at(time.Second)},
+			model.KeyEventWithTimestamp{Position: 2, Timestamp: at(0)},
+			model.KeyEventWithTimestamp{Position: 3, Timestamp: at(2 * time.Second)})
+
+		syncStorage, ok := storage.(db.SyncStorage)
+		if !ok {
+			t.Skip("storage does not exchange events")
+		}
+
+		first, cursor, err := syncStorage.EventsAfter(0, 2)
+		require.NoError(t, err)
+
+		rest, last, err := syncStorage.EventsAfter(cursor, 10)
+		require.NoError(t, err)
+
+		positions := make([]model.KeyPosition, 0, 3)
+		for _, e := range append(first, rest...) {
+			positions = append(positions, e.Position)
+		}
+
+		assert.Equal(t, []model.KeyPosition{1, 2, 3}, positions, "events come in the order they were stored")
+
+		none, after, err := syncStorage.EventsAfter(last, 10)
+		require.NoError(t, err)
+		assert.Empty(t, none)
+		assert.Equal(t, last, after)
+
+		watermark, err := syncStorage.PeerWatermark("laptop")
+		require.NoError(t, err)
+		assert.Equal(t, db.Watermark{}, watermark)
+
+		require.NoError(t, syncStorage.SetPeerWatermark("laptop", db.Watermark{Pushed: last, Pulled: 7}))
+
+		watermark, err = syncStorage.PeerWatermark("laptop")
+		require.NoError(t, err)
+		assert.Equal(t, db.Watermark{Pushed: last, Pulled: 7}, watermark)
+	})
+}
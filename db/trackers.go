@@ -0,0 +1,415 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dasdy/glover/model"
+)
+
+// Names of built-in trackers.
+const (
+	CombosTracker    = "combos"
+	NeighborsTracker = "neighbors"
+	KeysTracker      = "keys"
+)
+
+// TrackerOptions configure a tracker, e.g. from its section of the config. Each tracker
+// parses the values it knows and rejects the others.
+type TrackerOptions map[string]string
+
+// TrackerFactory creates a tracker that has not counted anything yet.
+type TrackerFactory func(options TrackerOptions) (Tracker, error)
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[string]TrackerFactory{
+		CombosTracker: func(options TrackerOptions) (Tracker, error) {
+			comboOptions, err := ParseComboOptions(options)
+			if err != nil {
+				return nil, err
+			}
+
+			return NewComboTrackerWithOptions(comboOptions)
+		},
+		NeighborsTracker: func(options TrackerOptions) (Tracker, error) {
+			if len(options) > 0 {
+				return nil, fmt.Errorf("neighbors tracker has no options")
+			}
+
+			return NewNeighborCounter(), nil
+		},
+		KeysTracker: func(options TrackerOptions) (Tracker, error) {
+			if len(options) > 0 {
+				return nil, fmt.Errorf("keys tracker has no options")
+			}
+
+			return NewKeyCounter(), nil
+		},
+	}
+)
+
+// DefaultTrackers are the trackers the interface shows pages of.
+func DefaultTrackers() []string {
+	return []string{KeysTracker, CombosTracker, NeighborsTracker}
+}
+
+// RegisterTracker makes a tracker available by name, e.g. in the trackers list of the config.
+// It panics if the name is taken, like registering a second database driver with the same name does.
+func RegisterTracker(name string, factory TrackerFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if factory == nil {
+		panic("db: tracker factory of " + name + " is nil")
+	}
+
+	if _, ok := registry[name]; ok {
+		panic("db: tracker " + name + " is registered twice")
+	}
+
+	registry[name] = factory
+}
+
+// RegisteredTrackers returns names of all trackers that can be created, sorted.
+func RegisteredTrackers() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// TrackerSet is the trackers of one keyboard by their names. Events that arrive while history is being
+// scanned are kept and handled after it, so trackers see every event once and in order.
+type TrackerSet struct {
+	names    []string
+	trackers map[string]Tracker
+
+	lock         sync.Mutex
+	initializing bool
+	pending      []model.KeyEventWithTimestamp
+	ready        chan struct{}
+	readyOnce    sync.Once
+}
+
+// NewTrackerSet creates registered trackers with the given names and default options.
+func NewTrackerSet(names ...string) (*TrackerSet, error) {
+	return NewTrackerSetWithOptions(names, nil)
+}
+
+// NewTrackerSetWithOptions creates registered trackers with the given names. Options are looked up
+// by the name of the tracker, trackers without them get defaults.
+func NewTrackerSetWithOptions(names []string, options map[string]TrackerOptions) (*TrackerSet, error) {
+	set := &TrackerSet{
+		trackers: make(map[string]Tracker, len(names)),
+		ready:    make(chan struct{}),
+	}
+
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	for _, name := range names {
+		factory, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown tracker '%s', known ones are %v", name, RegisteredTrackers())
+		}
+
+		tracker, err := factory(options[name])
+		if err != nil {
+			return nil, fmt.Errorf("could not create tracker '%s': %w", name, err)
+		}
+
+		if err := set.Add(name, tracker); err != nil {
+			return nil, err
+		}
+	}
+
+	return set, nil
+}
+
+// Add puts a tracker that is not registered into the set, e.g. one that feeds an interface.
+// Trackers must be added before Init.
+func (s *TrackerSet) Add(name string, tracker Tracker) error {
+	if _, ok := s.trackers[name]; ok {
+		return fmt.Errorf("tracker '%s' is added twice", name)
+	}
+
+	s.names = append(s.names, name)
+	s.trackers[name] = tracker
+
+	return nil
+}
+
+// Names returns names of trackers in the order they were added.
+func (s *TrackerSet) Names() []string {
+	return slices.Clone(s.names)
+}
+
+// Get returns the tracker with the name, or nil if it is not in the set.
+func (s *TrackerSet) Get(name string) Tracker {
+	return s.trackers[name]
+}
+
+// Snapshot is the snapshot of the tracker with the name, or an empty one if it is not in the set.
+func (s *TrackerSet) Snapshot(name string) Snapshot {
+	return SnapshotOf(s.Get(name))
+}
+
+// Init scans the history with every tracker. History is read once per tracker, up to the last event
+// the first tracker saw, so events stored meanwhile are not scanned by some trackers only. If it
+// fails, trackers forget what they scanned, so they only count events handled after Init.
+func (s *TrackerSet) Init(history HistoryReader) error {
+	s.lock.Lock()
+	s.initializing = true
+	s.lock.Unlock()
+
+	return s.init(history, nil, nil)
+}
+
+// InitFrom is Init with the history of the storage that matches the query. Trackers that can be seeded
+// start from rollups of the part of it whose raw events were deleted, if the storage keeps them.
+// Events are stored before they are handled, so events handled while history is scanned can be in
+// it too. Such events are looked up in the storage and handled only if the scan did not count them.
+func (s *TrackerSet) InitFrom(ctx context.Context, storage Storage, query HistoryQuery) error {
+	s.lock.Lock()
+	s.initializing = true
+	s.lock.Unlock()
+
+	return s.initFrom(ctx, storage, query)
+}
+
+// InitInBackground is InitFrom the whole history that returns right away, Ready is closed once history
+// is scanned. Scanning stops when the context is done.
+func (s *TrackerSet) InitInBackground(ctx context.Context, storage Storage) {
+	s.lock.Lock()
+	s.initializing = true
+	s.lock.Unlock()
+
+	go func() {
+		if err := s.initFrom(ctx, storage, HistoryQuery{}); err != nil {
+			slog.Error("Could not scan history, only new events are counted", "error", err)
+		}
+	}()
+}
+
+func (s *TrackerSet) initFrom(ctx context.Context, storage Storage, query HistoryQuery) error {
+	var rollup *Rollup
+
+	if rollups, ok := storage.(RollupStorage); ok {
+		var err error
+
+		rollup, err = rollups.PrunedRollup(ctx, query)
+		if err != nil {
+			// Trackers still have to be reset and pending events handled.
+			return s.init(failedHistory(err), nil, nil)
+		}
+	}
+
+	// Pending events were stored shortly before they were handled, so history since the earliest
+	// of them is enough to find ones that were scanned.
+	stored := func(since time.Time) iter.Seq2[HistoryEvent, error] {
+		q := query
+		if q.Since.IsZero() || since.After(q.Since) {
+			q.Since = since
+		}
+
+		return storage.History(ctx, q)
+	}
+
+	return s.init(ReadHistory(ctx, storage, query), rollup, stored)
+}
+
+func failedHistory(err error) HistoryReader {
+	return func() iter.Seq2[HistoryEvent, error] {
+		return func(yield func(HistoryEvent, error) bool) { yield(HistoryEvent{}, err) }
+	}
+}
+
+// through yields events of the history up to the one the cursor was taken from. Histories without
+// cursors, like ones of a union, are read to their end.
+func through(history iter.Seq2[HistoryEvent, error], end Cursor) iter.Seq2[HistoryEvent, error] {
+	return func(yield func(HistoryEvent, error) bool) {
+		for event, err := range history {
+			if err == nil && event.Cursor.Compare(end) > 0 {
+				return
+			}
+
+			if !yield(event, err) {
+				return
+			}
+		}
+	}
+}
+
+// init scans the history with every tracker and handles pending events. stored reads the history
+// since the time, it is nil if pending events can not be looked up in it.
+func (s *TrackerSet) init(history HistoryReader, rollup *Rollup, stored func(since time.Time) iter.Seq2[HistoryEvent, error]) error {
+	var (
+		initErr error
+		// end is the cursor of the last event the first tracker scanned.
+		end     Cursor
+		scanned bool
+	)
+
+	for _, name := range s.names {
+		events := history()
+		if scanned {
+			events = through(events, end)
+		} else {
+			first := events
+			events = func(yield func(HistoryEvent, error) bool) {
+				for event, err := range first {
+					if err == nil {
+						end = event.Cursor
+					}
+
+					if !yield(event, err) {
+						return
+					}
+				}
+			}
+		}
+
+		scanned = true
+		keyEvents, failed := StopOnError(events)
+
+		err := s.trackers[name].Init(keyEvents)
+		if err == nil {
+			err = failed()
+		}
+
+		if seeded, ok := s.trackers[name].(Seeded); ok && err == nil && rollup != nil {
+			seeded.Seed(rollup)
+		}
+
+		if err != nil {
+			initErr = fmt.Errorf("could not scan history with tracker '%s': %w", name, err)
+
+			break
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if initErr != nil {
+		for _, name := range s.names {
+			s.trackers[name].Reset()
+		}
+	}
+
+	pending := s.pending
+	if initErr == nil && stored != nil && len(pending) > 0 {
+		var err error
+
+		pending, err = unscanned(pending, stored, end)
+		if err != nil {
+			// Counting an event twice is better than losing it.
+			slog.Error("Could not look up events handled while history was scanned", "error", err)
+
+			pending = s.pending
+		}
+	}
+
+	for _, event := range pending {
+		s.handle(event)
+	}
+
+	s.pending = nil
+	s.initializing = false
+	s.readyOnce.Do(func() { close(s.ready) })
+
+	return initErr
+}
+
+// unscanned drops pending events that are in the history up to the end cursor, trackers scanned them.
+// Events that were not stored at all are kept too, trackers count every event they are given.
+func unscanned(
+	pending []model.KeyEventWithTimestamp, stored func(since time.Time) iter.Seq2[HistoryEvent, error], end Cursor,
+) ([]model.KeyEventWithTimestamp, error) {
+	since := pending[0].Timestamp
+	waiting := make(map[EventIdentity]int, len(pending))
+
+	for _, event := range pending {
+		if event.Timestamp.Before(since) {
+			since = event.Timestamp
+		}
+
+		waiting[IdentityOf(event)]++
+	}
+
+	scanned := make(map[EventIdentity]int)
+
+	for event, err := range through(stored(since.Truncate(time.Millisecond)), end) {
+		if err != nil {
+			return nil, err
+		}
+
+		if id := IdentityOf(event.KeyEventWithTimestamp); scanned[id] < waiting[id] {
+			scanned[id]++
+		}
+	}
+
+	result := make([]model.KeyEventWithTimestamp, 0, len(pending))
+
+	for _, event := range pending {
+		if id := IdentityOf(event); scanned[id] > 0 {
+			scanned[id]--
+
+			continue
+		}
+
+		result = append(result, event)
+	}
+
+	return result, nil
+}
+
+// Ready returns a channel that is closed once Init is done.
+func (s *TrackerSet) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Handle passes the event to every tracker, or keeps it until history is scanned.
+func (s *TrackerSet) Handle(event model.KeyEventWithTimestamp) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.initializing {
+		s.pending = append(s.pending, event)
+
+		return
+	}
+
+	s.handle(event)
+}
+
+func (s *TrackerSet) handle(event model.KeyEventWithTimestamp) {
+	for _, name := range s.names {
+		s.trackers[name].Handle(event)
+	}
+}
+
+// Reset makes every tracker forget what it counted.
+func (s *TrackerSet) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pending = nil
+
+	for _, name := range s.names {
+		s.trackers[name].Reset()
+	}
+}
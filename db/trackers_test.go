@@ -0,0 +1,418 @@
+package db_test
+
+import (
+	"context"
+	"iter"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// presses are key presses and releases of the positions, one after another.
+func presses(start time.Time, positions ...model.KeyPosition) []model.KeyEventWithTimestamp {
+	result := make([]model.KeyEventWithTimestamp, 0, 2*len(positions))
+
+	for i, p := range positions {
+		at := start.Add(time.Duration(i) * time.Second)
+		result = append(result,
+			model.KeyEventWithTimestamp{Position: p, Pressed: true, Timestamp: at},
+			model.KeyEventWithTimestamp{Position: p, Pressed: false, Timestamp: at.Add(50 * time.Millisecond)})
+	}
+
+	return result
+}
+
+func historyOf(events []model.KeyEventWithTimestamp) db.HistoryReader {
+	return func() iter.Seq2[db.HistoryEvent, error] {
+		return func(yield func(db.HistoryEvent, error) bool) {
+			for _, e := range events {
+				if !yield(db.HistoryEvent{KeyEventWithTimestamp: e}, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func TestTrackerSetKeepsEventsWhileScanning(t *testing.T) {
+	set, err := db.NewTrackerSet(db.NeighborsTracker)
+	require.NoError(t, err)
+
+	scanning := make(chan struct{})
+	release := make(chan struct{})
+	start := time.Now()
+
+	history := func() iter.Seq2[db.HistoryEvent, error] {
+		return func(yield func(db.HistoryEvent, error) bool) {
+			close(scanning)
+			<-release
+
+			for e, err := range historyOf(presses(start, 1, 2))() {
+				if !yield(e, err) {
+					return
+				}
+			}
+		}
+	}
+
+	done := make(chan error)
+
+	go func() { done <- set.Init(history) }()
+
+	<-scanning
+
+	// Live events arrive while history is scanned, they are counted after it.
+	for _, e := range presses(start.Add(time.Minute), 3) {
+		set.Handle(e)
+	}
+
+	assert.Empty(t, set.Snapshot(db.NeighborsTracker).Combos(2))
+
+	close(release)
+	require.NoError(t, <-done)
+	<-set.Ready()
+
+	snapshot := set.Snapshot(db.NeighborsTracker)
+	assert.Equal(t, []model.Combo{{Keys: []model.KeyPosition{2, 1}, Pressed: 1}}, snapshot.Combos(1))
+	assert.Equal(t, []model.Combo{{Keys: []model.KeyPosition{3, 2}, Pressed: 1}}, snapshot.Combos(2))
+}
+
+// beforeScan is a key counter that calls a function before it scans the history.
+type beforeScan struct {
+	*db.KeyCounter
+	before func()
+}
+
+func (b beforeScan) Init(history iter.Seq[model.KeyEventWithTimestamp]) error {
+	b.before()
+
+	return b.KeyCounter.Init(history)
+}
+
+func TestTrackerSetCountsEventsStoredWhileScanningOnce(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name string
+		// during is the tracker whose scan the event is stored and handled before.
+		during string
+	}{
+		{name: "stored before the history ends", during: "first"},
+		{name: "stored after the first tracker scanned history", during: "second"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			storage, err := db.NewStorageFromPath(t.TempDir()+"/trackers.sqlite", false)
+			require.NoError(t, err)
+
+			defer storage.Close()
+
+			for _, e := range presses(start, 1) {
+				require.NoError(t, storage.StoreEvent(&e))
+			}
+
+			set, err := db.NewTrackerSet()
+			require.NoError(t, err)
+
+			// Live events are stored, then handled, like tracking does.
+			live := func() {
+				for _, e := range presses(start.Add(time.Minute), 2) {
+					require.NoError(t, storage.StoreEvent(&e))
+					set.Handle(e)
+				}
+			}
+
+			counters := map[string]*db.KeyCounter{}
+
+			for _, name := range []string{"first", "second"} {
+				counters[name] = db.NewKeyCounter()
+				before := func() {}
+
+				if name == tc.during {
+					before = live
+				}
+
+				require.NoError(t, set.Add(name, beforeScan{KeyCounter: counters[name], before: before}))
+			}
+
+			require.NoError(t, set.InitFrom(context.Background(), storage, db.HistoryQuery{}))
+
+			for name, counter := range counters {
+				stats, _ := counter.Stats()
+				assert.Equal(t, []model.MinimalKeyEvent{{Position: 1, Count: 1}, {Position: 2, Count: 1}}, stats, name)
+			}
+		})
+	}
+}
+
+func TestTrackerSetSnapshotsDoNotChange(t *testing.T) {
+	set, err := db.NewTrackerSet(db.DefaultTrackers()...)
+	require.NoError(t, err)
+	require.NoError(t, set.Init(historyOf(nil)))
+
+	start := time.Now()
+	chord := []model.KeyEventWithTimestamp{
+		{Position: 1, Pressed: true, Timestamp: start},
+		{Position: 2, Pressed: true, Timestamp: start.Add(10 * time.Millisecond)},
+		{Position: 1, Pressed: false, Timestamp: start.Add(20 * time.Millisecond)},
+		{Position: 2, Pressed: false, Timestamp: start.Add(30 * time.Millisecond)},
+	}
+
+	for _, e := range chord {
+		set.Handle(e)
+	}
+
+	before := set.Snapshot(db.CombosTracker)
+
+	for _, e := range chord {
+		e.Timestamp = e.Timestamp.Add(time.Minute)
+		set.Handle(e)
+	}
+
+	combo := []model.KeyPosition{1, 2}
+	assert.Equal(t, []model.Combo{{Keys: combo, Pressed: 1}}, before.Combos(2))
+	assert.Equal(t, []model.Combo{{Keys: combo, Pressed: 2}}, set.Snapshot(db.CombosTracker).Combos(2))
+
+	set.Reset()
+	assert.Empty(t, set.Snapshot(db.CombosTracker).Combos(2))
+	assert.Empty(t, set.Snapshot(db.NeighborsTracker).Combos(1))
+}
+
+func TestTrackerSetSnapshotWhileHandling(t *testing.T) {
+	set, err := db.NewTrackerSet(db.DefaultTrackers()...)
+	require.NoError(t, err)
+	require.NoError(t, set.Init(historyOf(nil)))
+
+	var wg sync.WaitGroup
+
+	wg.Go(func() {
+		for _, e := range presses(time.Now(), 1, 2, 3, 1, 2, 3, 1, 2, 3) {
+			set.Handle(e)
+		}
+	})
+
+	for range 100 {
+		for _, name := range set.Names() {
+			set.Snapshot(name).Combos(1)
+		}
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, []model.Combo{{Keys: []model.KeyPosition{2, 1}, Pressed: 3}},
+		set.Snapshot(db.NeighborsTracker).Combos(1))
+}
+
+// releaseCounter is a tracker the way other packages would add one.
+type releaseCounter struct {
+	lock   sync.Mutex
+	counts db.CombosByPosition
+}
+
+func (r *releaseCounter) Init(history iter.Seq[model.KeyEventWithTimestamp]) error {
+	r.Reset()
+
+	for e := range history {
+		r.Handle(e)
+	}
+
+	return nil
+}
+
+func (r *releaseCounter) Handle(event model.KeyEventWithTimestamp) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if event.Pressed {
+		return
+	}
+
+	pressed := 0
+	if combos := r.counts[event.Position]; len(combos) > 0 {
+		pressed = combos[0].Pressed
+	}
+
+	r.counts[event.Position] = []model.Combo{{Keys: []model.KeyPosition{event.Position}, Pressed: pressed + 1}}
+}
+
+func (r *releaseCounter) Snapshot() db.Snapshot {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	result := make(db.CombosByPosition, len(r.counts))
+	for k, v := range r.counts {
+		result[k] = append([]model.Combo(nil), v...)
+	}
+
+	return result
+}
+
+func (r *releaseCounter) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.counts = make(db.CombosByPosition)
+}
+
+func TestRegisterTracker(t *testing.T) {
+	factory := func(_ db.TrackerOptions) (db.Tracker, error) { return &releaseCounter{}, nil }
+	db.RegisterTracker("test-releases", factory)
+
+	assert.Contains(t, db.RegisteredTrackers(), "test-releases")
+	assert.Panics(t, func() { db.RegisterTracker("test-releases", factory) })
+
+	set, err := db.NewTrackerSet(db.CombosTracker, "test-releases")
+	require.NoError(t, err)
+	assert.Equal(t, []string{db.CombosTracker, "test-releases"}, set.Names())
+	assert.Nil(t, set.Get(db.NeighborsTracker))
+	assert.Empty(t, set.Snapshot(db.NeighborsTracker).Combos(1))
+
+	require.NoError(t, set.Init(historyOf(presses(time.Now(), 4, 4))))
+	assert.Equal(t, []model.Combo{{Keys: []model.KeyPosition{4}, Pressed: 2}}, set.Snapshot("test-releases").Combos(4))
+
+	_, err = db.NewTrackerSet("missing")
+	assert.ErrorContains(t, err, "unknown tracker 'missing'")
+}
+
+func TestKeyCounter(t *testing.T) {
+	storage, err := db.NewStorageFromPath(":memory:", false)
+	require.NoError(t, err)
+
+	defer storage.Close()
+
+	events := presses(time.Now(), 1, 2, 1, 70, 1)
+	for i := range events {
+		events[i].Row = int(events[i].Position) / 10
+		require.NoError(t, storage.StoreEvent(&events[i]))
+	}
+
+	counter := db.NewKeyCounter()
+
+	_, counted := counter.Stats()
+	assert.False(t, counted, "counts are not complete before history is scanned")
+
+	history, failed := db.StopOnError(storage.History(context.Background(), db.HistoryQuery{}))
+	require.NoError(t, counter.Init(history))
+	require.NoError(t, failed())
+
+	expected, err := storage.GatherAll()
+	require.NoError(t, err)
+
+	first, counted := counter.Stats()
+	assert.True(t, counted)
+	assert.Equal(t, expected, first)
+	assert.Equal(t, []model.Combo{{Keys: []model.KeyPosition{1}, Pressed: 3}}, counter.Snapshot().Combos(1))
+
+	version := counter.Version()
+
+	counter.Handle(model.KeyEventWithTimestamp{Position: 2, Pressed: true})
+	assert.Equal(t, version, counter.Version(), "presses are not counted")
+
+	counter.Handle(model.KeyEventWithTimestamp{Position: 2, Pressed: false})
+	assert.NotEqual(t, version, counter.Version())
+
+	stats, _ := counter.Stats()
+	assert.Equal(t, []model.MinimalKeyEvent{{Position: 1, Count: 3}, {Position: 2, Count: 2}, {Row: 7, Position: 70, Count: 1}}, stats)
+	assert.Equal(t, 1, first[1].Count, "stats given out before do not change")
+}
+
+func TestSnapshotsShareUnchangedPositions(t *testing.T) {
+	for _, name := range []string{db.CombosTracker, db.NeighborsTracker} {
+		t.Run(name, func(t *testing.T) {
+			set, err := db.NewTrackerSet(name)
+			require.NoError(t, err)
+
+			start := time.Now()
+			require.NoError(t, set.Init(historyOf(keyEvents(start, time.Millisecond, "+1 +2 -1 -2 +3 +4 -3 -4"))))
+
+			tracker := set.Get(name)
+			version, ok := db.VersionOf(tracker)
+			require.True(t, ok)
+
+			before, ok := tracker.Snapshot().(db.CombosByPosition)
+			require.True(t, ok)
+			require.NotEmpty(t, before[1])
+			require.NotEmpty(t, before[3])
+
+			again, ok := tracker.Snapshot().(db.CombosByPosition)
+			require.True(t, ok)
+			assert.Same(t, &before[3][0], &again[3][0], "nothing is copied when nothing changed")
+
+			for _, event := range keyEvents(start.Add(time.Second), time.Millisecond, "+3 +4 -3 -4") {
+				set.Handle(event)
+			}
+
+			newVersion, _ := db.VersionOf(tracker)
+			assert.NotEqual(t, version, newVersion)
+
+			after, ok := tracker.Snapshot().(db.CombosByPosition)
+			require.True(t, ok)
+			assert.Same(t, &before[1][0], &after[1][0], "positions that did not change are shared")
+			assert.NotEqual(t, before[3], after[3])
+			assert.Equal(t, before[3], again[3], "snapshots taken before do not change")
+		})
+	}
+}
+
+func TestTrackerSetInitFails(t *testing.T) {
+	set, err := db.NewTrackerSet(db.NeighborsTracker)
+	require.NoError(t, err)
+
+	history := func() iter.Seq2[db.HistoryEvent, error] {
+		return func(yield func(db.HistoryEvent, error) bool) {
+			for e := range historyOf(presses(time.Now(), 1, 2))() {
+				if !yield(e, nil) {
+					return
+				}
+			}
+
+			yield(db.HistoryEvent{}, assert.AnError)
+		}
+	}
+
+	require.ErrorIs(t, set.Init(history), assert.AnError)
+	assert.Empty(t, set.Snapshot(db.NeighborsTracker).Combos(1), "trackers forget the history they could not finish")
+
+	for _, e := range presses(time.Now(), 3, 4) {
+		set.Handle(e)
+	}
+
+	assert.Equal(t, []model.Combo{{Keys: []model.KeyPosition{4, 3}, Pressed: 1}}, set.Snapshot(db.NeighborsTracker).Combos(3))
+}
+
+func TestTrackerSetInitFailsAfterKeysAreCounted(t *testing.T) {
+	set, err := db.NewTrackerSet(db.KeysTracker, db.CombosTracker)
+	require.NoError(t, err)
+
+	scans := 0
+	history := func() iter.Seq2[db.HistoryEvent, error] {
+		scans++
+		scan := scans
+
+		return func(yield func(db.HistoryEvent, error) bool) {
+			for e := range historyOf(presses(time.Now(), 1, 2))() {
+				if !yield(e, nil) {
+					return
+				}
+			}
+
+			// Keys are counted, history fails while combos scan it.
+			if scan > 1 {
+				yield(db.HistoryEvent{}, assert.AnError)
+			}
+		}
+	}
+
+	require.ErrorIs(t, set.Init(history), assert.AnError)
+
+	counter, ok := set.Get(db.KeysTracker).(*db.KeyCounter)
+	require.True(t, ok)
+
+	stats, counted := counter.Stats()
+	assert.Empty(t, stats)
+	assert.False(t, counted, "counts of a reset counter are not complete, pages have to ask the storage")
+}
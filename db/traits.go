@@ -1,20 +1,135 @@
 package db
 
 import (
+	"context"
+	"fmt"
 	"iter"
+	"time"
 
 	"github.com/dasdy/glover/model"
 )
 
-// NeighborCounter tracks and counts keys pressed directly before or after each other.
+// Tracker counts something about key events as they happen, e.g. combos or keys pressed one after
+// another. Init and Handle are called from a single goroutine, Snapshot from any.
 type Tracker interface {
-	HandleKeyNow(position model.KeyPosition, pressed bool, verbose bool)
-	GatherCombos(position model.KeyPosition) []model.Combo
+	// Init forgets what was counted and scans the history, before live events are handled.
+	Init(history iter.Seq[model.KeyEventWithTimestamp]) error
+	// Handle counts one event. Its timestamp is the time the key was pressed or released, which can
+	// be long ago when events are replayed or received from another machine.
+	Handle(event model.KeyEventWithTimestamp)
+	// Snapshot copies what was counted so far. It does not change while tracking goes on.
+	Snapshot() Snapshot
+	// Reset forgets what was counted.
+	Reset()
+}
+
+// Versioned is a tracker that tells when what it counted changes, so things built from its
+// snapshots can be reused until then.
+type Versioned interface {
+	// Version is different every time what is counted changes.
+	Version() uint64
+}
+
+// VersionOf is the version of the tracker, false if it is not tracked or has no versions.
+func VersionOf(tracker Tracker) (uint64, bool) {
+	versioned, ok := tracker.(Versioned)
+	if !ok {
+		return 0, false
+	}
+
+	return versioned.Version(), true
+}
+
+// Snapshot is what a tracker counted at some moment.
+type Snapshot interface {
+	// Combos returns counted combos shown for the position. They can be shared between snapshots
+	// and must not be modified.
+	Combos(position model.KeyPosition) []model.Combo
+}
+
+// CombosByPosition is a Snapshot that lists each combo under every position it is shown for.
+type CombosByPosition map[model.KeyPosition][]model.Combo
+
+func (c CombosByPosition) Combos(position model.KeyPosition) []model.Combo {
+	return c[position]
+}
+
+// SnapshotOf is the snapshot of the tracker, or an empty one if it is not tracked.
+func SnapshotOf(tracker Tracker) Snapshot {
+	if tracker == nil {
+		return CombosByPosition(nil)
+	}
+
+	return tracker.Snapshot()
 }
 
 type Storage interface {
 	Store(event *model.KeyEvent) error
+	StoreEvent(event *model.KeyEventWithTimestamp) error
 	GatherAll() ([]model.MinimalKeyEvent, error)
-	AllIterator() (iter.Seq[model.KeyEventWithTimestamp], error)
+	// History reads events that match the query in the order they happened. Errors are yielded
+	// and end the reading, so does the context being done.
+	History(ctx context.Context, query HistoryQuery) iter.Seq2[HistoryEvent, error]
 	Close()
 }
+
+// Importer is a storage that takes events of other storages, skipping the ones it already has.
+type Importer interface {
+	Import(events iter.Seq2[model.KeyEventWithTimestamp, error]) (ImportResult, error)
+}
+
+// Counted is a storage that knows how many events it keeps, e.g. to show progress of reading them.
+type Counted interface {
+	Count() (int, error)
+}
+
+// SyncStorage is a storage that exchanges events with other machines. Its events can be read in the
+// order they were stored, and it remembers how far the exchange with every peer went.
+type SyncStorage interface {
+	Storage
+	Importer
+	PeerWatermark(peer string) (Watermark, error)
+	SetPeerWatermark(peer string, w Watermark) error
+	EventsAfter(cursor int64, limit int) ([]model.KeyEventWithTimestamp, int64, error)
+}
+
+// RetentionStorage is a storage that can delete old raw events, keeping rollups of them.
+type RetentionStorage interface {
+	Storage
+	RollupStorage
+	Backfill(ctx context.Context, since, until time.Time, newTrackers NewTrackers) error
+	Prune(ctx context.Context, retention Retention, now time.Time, newTrackers NewTrackers) (PruneResult, error)
+}
+
+// MaintainedStorage is a database that can be backed up while in use, compacted and checked.
+type MaintainedStorage interface {
+	Storage
+	Backup(ctx context.Context, path string) error
+	Vacuum(ctx context.Context) error
+	Analyze(ctx context.Context) error
+	Check(ctx context.Context, opts CheckOptions) (CheckReport, error)
+	Repair(ctx context.Context, opts CheckOptions) (CheckReport, RepairResult, error)
+}
+
+// GatherAllCombos collects combos of the snapshot for every given position. Combos that
+// contain several of the positions are only returned once.
+func GatherAllCombos(snapshot Snapshot, positions []model.KeyPosition) []model.Combo {
+	seen := make(map[string]bool)
+	result := make([]model.Combo, 0)
+
+	for _, position := range positions {
+		for _, combo := range snapshot.Combos(position) {
+			// Order of keys matters: neighbor trackers return directed pairs.
+			id := fmt.Sprint(combo.Keys)
+			if seen[id] {
+				continue
+			}
+
+			seen[id] = true
+
+			result = append(result, combo)
+		}
+	}
+
+	return result
+}
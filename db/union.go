@@ -0,0 +1,279 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+
+	"github.com/dasdy/glover/model"
+)
+
+// ErrUnionReadOnly is returned when events are stored into a union of storages.
+var ErrUnionReadOnly = errors.New("a union of storages is read-only")
+
+// UnionMember is a storage of a union with the name its events are told apart by.
+type UnionMember struct {
+	Name    string
+	Storage Storage
+}
+
+// Union shows several storages as one, e.g. databases of home and work, without merging them. Events
+// of a member are its source: events of keyboards connected directly get the name of the member,
+// and events it received from other machines get the name and their source, e.g. work/laptop. So
+// queries filter members by sources, and trackers keep their timelines apart.
+//
+// History is read in the order events happened across all members. It can not be resumed after a
+// cursor: members number their events on their own, so events of a union have no cursors.
+type Union struct {
+	members []UnionMember
+	// owned tells that closing the union closes its members, views of a part of it do not.
+	owned bool
+}
+
+// NewUnion takes over the storages, closing the union closes them. Names must be unique, and must not
+// be empty or contain a slash.
+func NewUnion(members ...UnionMember) (*Union, error) {
+	if len(members) == 0 {
+		return nil, fmt.Errorf("a union needs at least one storage")
+	}
+
+	seen := make(map[string]bool, len(members))
+
+	for _, m := range members {
+		if m.Name == "" || strings.Contains(m.Name, "/") {
+			return nil, fmt.Errorf("invalid name '%s' of a storage in a union: it must not be empty or contain '/'", m.Name)
+		}
+
+		if seen[m.Name] {
+			return nil, fmt.Errorf("storage '%s' is in the union twice, give it another name", m.Name)
+		}
+
+		seen[m.Name] = true
+	}
+
+	return &Union{members: slices.Clone(members), owned: true}, nil
+}
+
+// Names returns names of members in the order they were given.
+func (u *Union) Names() []string {
+	names := make([]string, len(u.members))
+	for i, m := range u.members {
+		names[i] = m.Name
+	}
+
+	return names
+}
+
+// Only is a view of the named members. Closing it does nothing, the members belong to the union.
+func (u *Union) Only(names ...string) (*Union, error) {
+	view := &Union{}
+
+	for _, name := range names {
+		i := slices.IndexFunc(u.members, func(m UnionMember) bool { return m.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown storage '%s' of the union, must be one of %s",
+				name, strings.Join(u.Names(), ", "))
+		}
+
+		view.members = append(view.members, u.members[i])
+	}
+
+	return view, nil
+}
+
+// UnionSource is the source of an event of the member in a union.
+func UnionSource(member, source string) string {
+	if source == "" {
+		return member
+	}
+
+	return member + "/" + source
+}
+
+// memberQuery is the query as the member sees it, false if none of the sources of the query are its.
+func memberQuery(member string, query HistoryQuery) (HistoryQuery, bool) {
+	if len(query.Sources) == 0 {
+		return query, true
+	}
+
+	sources := make([]string, 0, len(query.Sources))
+
+	for _, source := range query.Sources {
+		if source == member {
+			sources = append(sources, "")
+		} else if rest, ok := strings.CutPrefix(source, member+"/"); ok {
+			sources = append(sources, rest)
+		}
+	}
+
+	query.Sources = sources
+
+	return query, len(sources) > 0
+}
+
+func (u *Union) Store(*model.KeyEvent) error {
+	return ErrUnionReadOnly
+}
+
+func (u *Union) StoreEvent(*model.KeyEventWithTimestamp) error {
+	return ErrUnionReadOnly
+}
+
+// GatherAll adds up counts of members, so releases members keep in rollups are counted too.
+func (u *Union) GatherAll() ([]model.MinimalKeyEvent, error) {
+	counts := make(map[keyID]int)
+
+	for _, m := range u.members {
+		events, err := m.Storage.GatherAll()
+		if err != nil {
+			return nil, fmt.Errorf("could not count keypresses of '%s': %w", m.Name, err)
+		}
+
+		for _, e := range events {
+			counts[keyID{Row: e.Row, Col: e.Col, Position: e.Position}] += e.Count
+		}
+	}
+
+	return sortedCounts(counts), nil
+}
+
+// unionHead is the next event of a member while histories are merged.
+type unionHead struct {
+	member string
+	event  HistoryEvent
+	next   func() (HistoryEvent, error, bool)
+	stop   func()
+}
+
+func (u *Union) History(ctx context.Context, query HistoryQuery) iter.Seq2[HistoryEvent, error] {
+	return func(yield func(HistoryEvent, error) bool) {
+		if !query.After.IsZero() {
+			yield(HistoryEvent{}, fmt.Errorf("could not resume reading keypresses: a union of storages has no cursors"))
+
+			return
+		}
+
+		heads := make([]*unionHead, 0, len(u.members))
+
+		defer func() {
+			for _, h := range heads {
+				h.stop()
+			}
+		}()
+
+		for _, m := range u.members {
+			q, ok := memberQuery(m.Name, query)
+			if !ok {
+				continue
+			}
+
+			next, stop := iter.Pull2(m.Storage.History(ctx, q))
+			heads = append(heads, &unionHead{member: m.Name, next: next, stop: stop})
+		}
+
+		// advance reads the next event of the head, false once the member has no more of them.
+		advance := func(h *unionHead) (bool, error) {
+			event, err, ok := h.next()
+			if !ok {
+				return false, nil
+			}
+
+			if err != nil {
+				return false, fmt.Errorf("could not read keypresses of '%s': %w", h.member, err)
+			}
+
+			event.Source = UnionSource(h.member, event.Source)
+			event.Cursor = Cursor{}
+			h.event = event
+
+			return true, nil
+		}
+
+		live := make([]*unionHead, 0, len(heads))
+
+		for _, h := range heads {
+			ok, err := advance(h)
+			if err != nil {
+				yield(HistoryEvent{}, err)
+
+				return
+			}
+
+			if ok {
+				live = append(live, h)
+			}
+		}
+
+		// Members are few, so the earliest head is looked up rather than kept in a heap. Events with
+		// the same timestamp come in the order of members.
+		for len(live) > 0 {
+			first := 0
+
+			for i, h := range live[1:] {
+				if h.event.Timestamp.Before(live[first].event.Timestamp) {
+					first = i + 1
+				}
+			}
+
+			h := live[first]
+			if !yield(h.event, nil) {
+				return
+			}
+
+			ok, err := advance(h)
+			if err != nil {
+				yield(HistoryEvent{}, err)
+
+				return
+			}
+
+			if !ok {
+				live = slices.Delete(live, first, first+1)
+			}
+		}
+	}
+}
+
+// PrunedRollup adds up rollups of members that keep them.
+func (u *Union) PrunedRollup(ctx context.Context, query HistoryQuery) (*Rollup, error) {
+	result := &Rollup{Combos: make(map[string][]model.Combo)}
+
+	for _, m := range u.members {
+		rollups, ok := m.Storage.(RollupStorage)
+		if !ok {
+			continue
+		}
+
+		q, ok := memberQuery(m.Name, query)
+		if !ok {
+			continue
+		}
+
+		rollup, err := rollups.PrunedRollup(ctx, q)
+		if err != nil {
+			return nil, fmt.Errorf("could not read rollups of '%s': %w", m.Name, err)
+		}
+
+		result.Keys = append(result.Keys, rollup.Keys...)
+
+		for tracker, combos := range rollup.Combos {
+			result.Combos[tracker] = append(result.Combos[tracker], combos...)
+		}
+	}
+
+	return result, nil
+}
+
+// Close closes every member, unless the union is a view of a part of another one.
+func (u *Union) Close() {
+	if !u.owned {
+		return
+	}
+
+	for _, m := range u.members {
+		m.Storage.Close()
+	}
+}
@@ -0,0 +1,147 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnion(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	// Home and work were typed on at the same time: chords of one are interleaved with the other.
+	home := db.NewMemoryStorage()
+	work, err := db.NewStorageFromPath(t.TempDir()+"/work.sqlite", false)
+	require.NoError(t, err)
+
+	store := func(storage db.Storage, source string, at time.Duration, position model.KeyPosition, pressed bool) {
+		require.NoError(t, storage.StoreEvent(&model.KeyEventWithTimestamp{
+			Position: position, Pressed: pressed, Timestamp: start.Add(at), Source: source,
+		}))
+	}
+
+	store(home, "", 0, 1, true)
+	store(work, "", 10*time.Millisecond, 3, true)
+	store(home, "", 20*time.Millisecond, 2, true)
+	store(work, "", 30*time.Millisecond, 3, false)
+	store(home, "", 40*time.Millisecond, 1, false)
+	store(home, "", 50*time.Millisecond, 2, false)
+	store(work, "laptop", 60*time.Millisecond, 4, true)
+	store(work, "laptop", 70*time.Millisecond, 4, false)
+
+	union, err := db.NewUnion(db.UnionMember{Name: "home", Storage: home}, db.UnionMember{Name: "work", Storage: work})
+	require.NoError(t, err)
+
+	defer union.Close()
+
+	read := func(storage db.Storage, query db.HistoryQuery) []string {
+		t.Helper()
+
+		var result []string
+
+		for event, err := range storage.History(ctx, query) {
+			require.NoError(t, err)
+
+			sign := "-"
+			if event.Pressed {
+				sign = "+"
+			}
+
+			result = append(result, event.Source+sign+string(rune('0'+event.Position)))
+		}
+
+		return result
+	}
+
+	t.Run("history is merged in the order events happened", func(t *testing.T) {
+		assert.Equal(t,
+			[]string{"home+1", "work+3", "home+2", "work-3", "home-1", "home-2", "work/laptop+4", "work/laptop-4"},
+			read(union, db.HistoryQuery{}))
+	})
+
+	t.Run("sources pick members", func(t *testing.T) {
+		assert.Equal(t, []string{"work+3", "work-3"}, read(union, db.HistoryQuery{Sources: []string{"work"}}))
+		assert.Equal(t,
+			[]string{"home+1", "home+2", "home-1", "home-2", "work/laptop+4", "work/laptop-4"},
+			read(union, db.HistoryQuery{Sources: []string{"home", "work/laptop"}}))
+		assert.Empty(t, read(union, db.HistoryQuery{Sources: []string{"office"}}))
+	})
+
+	t.Run("only is a view of some members", func(t *testing.T) {
+		view, err := union.Only("work")
+		require.NoError(t, err)
+
+		// Members belong to the union.
+		view.Close()
+
+		assert.Equal(t, []string{"work+3", "work-3", "work/laptop+4", "work/laptop-4"}, read(view, db.HistoryQuery{}))
+
+		_, err = union.Only("office")
+		require.ErrorContains(t, err, "unknown storage 'office'")
+	})
+
+	t.Run("trackers keep timelines apart", func(t *testing.T) {
+		trackers, err := db.NewTrackerSet(db.DefaultTrackers()...)
+		require.NoError(t, err)
+		require.NoError(t, trackers.InitFrom(ctx, union, db.HistoryQuery{}))
+
+		combos := db.GatherAllCombos(trackers.Snapshot(db.CombosTracker), []model.KeyPosition{1, 2, 3, 4})
+		assert.Equal(t, []model.Combo{{Keys: []model.KeyPosition{1, 2}, Pressed: 1}}, combos)
+
+		counts, err := union.GatherAll()
+		require.NoError(t, err)
+		assert.Len(t, counts, 4)
+	})
+
+	t.Run("counts releases members keep in rollups", func(t *testing.T) {
+		pruned, err := db.NewStorageFromPath(t.TempDir()+"/pruned.sqlite", false)
+		require.NoError(t, err)
+
+		for _, e := range keyEvents(start.AddDate(0, 0, -30), 10*time.Millisecond, "+1 -1 +2 -2") {
+			require.NoError(t, pruned.StoreEvent(&e))
+		}
+
+		store(pruned, "", 0, 1, true)
+		store(pruned, "", 10*time.Millisecond, 1, false)
+
+		newTrackers := func() (*db.TrackerSet, error) { return db.NewTrackerSet(db.CombosTracker) }
+		result, err := pruned.Prune(ctx, db.Retention{RawDays: 10}, start, newTrackers)
+		require.NoError(t, err)
+		require.EqualValues(t, 4, result.Deleted)
+
+		recent := db.NewMemoryStorage()
+		store(recent, "", 0, 2, true)
+		store(recent, "", 10*time.Millisecond, 2, false)
+
+		both, err := db.NewUnion(db.UnionMember{Name: "recent", Storage: recent}, db.UnionMember{Name: "old", Storage: pruned})
+		require.NoError(t, err)
+
+		defer both.Close()
+
+		counts, err := both.GatherAll()
+		require.NoError(t, err)
+		assert.Equal(t, []model.MinimalKeyEvent{{Position: 1, Count: 2}, {Position: 2, Count: 2}}, counts)
+	})
+
+	t.Run("is read-only", func(t *testing.T) {
+		require.ErrorIs(t, union.Store(&model.KeyEvent{Position: 1}), db.ErrUnionReadOnly)
+
+		for _, err := range union.History(ctx, db.HistoryQuery{After: db.CursorAt(start, 1)}) {
+			require.Error(t, err)
+		}
+	})
+
+	t.Run("names must tell members apart", func(t *testing.T) {
+		_, err := db.NewUnion(db.UnionMember{Name: "home", Storage: home}, db.UnionMember{Name: "home", Storage: work})
+		require.ErrorContains(t, err, "twice")
+
+		_, err = db.NewUnion(db.UnionMember{Name: "a/b", Storage: home})
+		require.ErrorContains(t, err, "invalid name")
+	})
+}
@@ -0,0 +1,41 @@
+package keylog
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/dasdy/glover/model"
+)
+
+// Heartbeat tells whether an event loop keeps taking events, e.g. for the watchdog of a service.
+type Heartbeat struct {
+	// waiting is since when an event waits for the loop to take it, in unix nanoseconds, zero if none does.
+	waiting atomic.Int64
+}
+
+// Watch passes events on to the loop one at a time, and keeps track of how long it takes the loop to take them.
+// The returned channel is closed once the input is closed.
+func Watch(in <-chan model.KeyEventWithTimestamp) (<-chan model.KeyEventWithTimestamp, *Heartbeat) {
+	out := make(chan model.KeyEventWithTimestamp)
+	heartbeat := &Heartbeat{}
+
+	go func() {
+		defer close(out)
+
+		for e := range in {
+			heartbeat.waiting.Store(time.Now().UnixNano())
+			out <- e
+			heartbeat.waiting.Store(0)
+		}
+	}()
+
+	return out, heartbeat
+}
+
+// Alive is false once an event has waited for the loop longer than the limit, e.g. because storing or
+// counting the previous one got stuck. A loop that waits for events is alive.
+func (h *Heartbeat) Alive(limit time.Duration) bool {
+	since := h.waiting.Load()
+
+	return since == 0 || time.Since(time.Unix(0, since)) <= limit
+}
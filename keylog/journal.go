@@ -0,0 +1,152 @@
+package keylog
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/keylog/remote"
+	"github.com/dasdy/glover/model"
+)
+
+// journalBatch is how many spooled events are stored before the spool file is rewritten.
+const journalBatch = 1000
+
+// Journal is a storage that does not lose events when the storage under it fails, e.g. while the
+// database is locked by merge or the disk is full. Events it could not store are kept in a spool
+// file and stored later in the same order, retried with growing delays by Run. New events go to
+// the spool too while it is not empty, so they are never stored before older ones.
+type Journal struct {
+	db.Storage
+
+	spool *remote.Spool
+	// lock keeps events that are stored and flushed in order.
+	lock sync.Mutex
+	wake chan struct{}
+
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// NewJournal stores events into the storage through the spool. Events left in the spool by a previous
+// run are stored by the first Flush.
+func NewJournal(storage db.Storage, spool *remote.Spool) *Journal {
+	return &Journal{
+		Storage:    storage,
+		spool:      spool,
+		lock:       sync.Mutex{},
+		wake:       make(chan struct{}, 1),
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+	}
+}
+
+func (j *Journal) Store(event *model.KeyEvent) error {
+	return j.StoreEvent(&model.KeyEventWithTimestamp{
+		Row:       event.Row,
+		Col:       event.Col,
+		Position:  event.Position,
+		Pressed:   event.Pressed,
+		Timestamp: time.Now(),
+	})
+}
+
+// StoreEvent stores the event, or spools it if the storage fails or there are older events in the spool.
+// Error is only returned when the event could not be spooled either.
+func (j *Journal) StoreEvent(event *model.KeyEventWithTimestamp) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.spool.Len() == 0 {
+		err := j.Storage.StoreEvent(event)
+		if err == nil {
+			return nil
+		}
+
+		slog.Warn("Could not store event, spooling it", "error", err)
+	}
+
+	if err := j.spool.Append(*event); err != nil {
+		return fmt.Errorf("could not store or spool event: %w", err)
+	}
+
+	select {
+	case j.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Backlog returns amount of events waiting in the spool to be stored.
+func (j *Journal) Backlog() int {
+	return j.spool.Len()
+}
+
+// Flush stores spooled events until the spool is empty or the storage fails. Events that were stored
+// before a failure are removed from the spool, so they are not stored twice.
+func (j *Journal) Flush() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	for {
+		events := j.spool.Peek(journalBatch)
+		if len(events) == 0 {
+			return nil
+		}
+
+		for i := range events {
+			if err := j.Storage.StoreEvent(&events[i]); err != nil {
+				if dropErr := j.spool.Drop(i); dropErr != nil {
+					return dropErr
+				}
+
+				return fmt.Errorf("could not store spooled event: %w", err)
+			}
+		}
+
+		if err := j.spool.Drop(len(events)); err != nil {
+			return err
+		}
+	}
+}
+
+// Run flushes the spool whenever events get into it, until the context is done. Failed flushes are
+// retried with growing delays, up to MaxBackoff.
+func (j *Journal) Run(ctx context.Context) {
+	backoff := j.MinBackoff
+
+	for {
+		if j.Backlog() == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-j.wake:
+			}
+
+			continue
+		}
+
+		err := j.Flush()
+		if err == nil {
+			slog.Info("Stored spooled events")
+
+			backoff = j.MinBackoff
+
+			continue
+		}
+
+		slog.Warn("Could not store spooled events, will retry", "error", err, "backlog", j.Backlog(), "retryIn", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, j.MaxBackoff)
+	}
+}
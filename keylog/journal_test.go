@@ -0,0 +1,194 @@
+package keylog_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/keylog"
+	"github.com/dasdy/glover/keylog/remote"
+	"github.com/dasdy/glover/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errLocked = errors.New("database is locked")
+
+// faultyStorage keeps stored events in memory, and fails to store them while it is broken, or after
+// it took the given amount of events.
+type faultyStorage struct {
+	db.Storage
+
+	lock     sync.Mutex
+	broken   bool
+	failFrom int
+	stored   []model.KeyEventWithTimestamp
+	attempts int
+}
+
+func newFaultyStorage() *faultyStorage {
+	return &faultyStorage{failFrom: -1}
+}
+
+func (f *faultyStorage) StoreEvent(event *model.KeyEventWithTimestamp) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.attempts++
+
+	if f.broken || len(f.stored) == f.failFrom {
+		return errLocked
+	}
+
+	f.stored = append(f.stored, *event)
+
+	return nil
+}
+
+func (f *faultyStorage) setBroken(broken bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.broken = broken
+}
+
+func (f *faultyStorage) positions() []model.KeyPosition {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	result := make([]model.KeyPosition, len(f.stored))
+	for i, e := range f.stored {
+		result[i] = e.Position
+	}
+
+	return result
+}
+
+func openTestSpool(t *testing.T, path string) *remote.Spool {
+	t.Helper()
+
+	spool, err := remote.OpenSpool(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = spool.Close() })
+
+	return spool
+}
+
+func storeAll(t *testing.T, journal *keylog.Journal, positions ...model.KeyPosition) {
+	t.Helper()
+
+	for _, p := range positions {
+		require.NoError(t, journal.StoreEvent(&model.KeyEventWithTimestamp{Position: p, Timestamp: time.Now()}))
+	}
+}
+
+func TestJournal(t *testing.T) {
+	t.Run("spools events while storage fails and keeps their order", func(t *testing.T) {
+		storage := newFaultyStorage()
+		journal := keylog.NewJournal(storage, openTestSpool(t, t.TempDir()+"/spool.jsonl"))
+
+		storeAll(t, journal, 1)
+
+		storage.setBroken(true)
+		storeAll(t, journal, 2, 3)
+		assert.Equal(t, 2, journal.Backlog())
+
+		storage.setBroken(false)
+		storeAll(t, journal, 4)
+		assert.Equal(t, 3, journal.Backlog(), "newer events wait for older ones")
+		assert.Equal(t, []model.KeyPosition{1}, storage.positions())
+
+		require.NoError(t, journal.Flush())
+		assert.Equal(t, 0, journal.Backlog())
+		assert.Equal(t, []model.KeyPosition{1, 2, 3, 4}, storage.positions())
+	})
+
+	t.Run("replays spool of a previous run", func(t *testing.T) {
+		path := t.TempDir() + "/spool.jsonl"
+
+		broken := newFaultyStorage()
+		broken.setBroken(true)
+
+		spool, err := remote.OpenSpool(path)
+		require.NoError(t, err)
+
+		storeAll(t, keylog.NewJournal(broken, spool), 1, 2)
+		require.NoError(t, spool.Close())
+
+		storage := newFaultyStorage()
+		journal := keylog.NewJournal(storage, openTestSpool(t, path))
+		assert.Equal(t, 2, journal.Backlog())
+
+		require.NoError(t, journal.Flush())
+		assert.Equal(t, []model.KeyPosition{1, 2}, storage.positions())
+		assert.Equal(t, 0, openTestSpool(t, path).Len(), "stored events are removed from the file")
+	})
+
+	t.Run("does not store events twice when flush fails halfway", func(t *testing.T) {
+		storage := newFaultyStorage()
+		journal := keylog.NewJournal(storage, openTestSpool(t, t.TempDir()+"/spool.jsonl"))
+
+		storage.setBroken(true)
+		storeAll(t, journal, 1, 2, 3)
+		storage.setBroken(false)
+
+		storage.failFrom = 2
+		require.ErrorIs(t, journal.Flush(), errLocked)
+		assert.Equal(t, 1, journal.Backlog())
+
+		storage.failFrom = -1
+		require.NoError(t, journal.Flush())
+		assert.Equal(t, []model.KeyPosition{1, 2, 3}, storage.positions())
+	})
+
+	t.Run("retries with backoff until storage recovers", func(t *testing.T) {
+		storage := newFaultyStorage()
+		journal := keylog.NewJournal(storage, openTestSpool(t, t.TempDir()+"/spool.jsonl"))
+		journal.MinBackoff = time.Millisecond
+		journal.MaxBackoff = 4 * time.Millisecond
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+
+			journal.Run(ctx)
+		}()
+
+		storage.setBroken(true)
+		storeAll(t, journal, 1, 2)
+
+		require.Eventually(t, func() bool {
+			storage.lock.Lock()
+			defer storage.lock.Unlock()
+
+			return storage.attempts > 4
+		}, time.Second, time.Millisecond, "failed flushes are retried")
+
+		storage.setBroken(false)
+
+		require.Eventually(t, func() bool { return journal.Backlog() == 0 }, time.Second, time.Millisecond)
+		assert.Equal(t, []model.KeyPosition{1, 2}, storage.positions())
+
+		cancel()
+		<-done
+	})
+
+	t.Run("fails when the event can not be spooled either", func(t *testing.T) {
+		storage := newFaultyStorage()
+		storage.setBroken(true)
+
+		spool, err := remote.OpenSpool(t.TempDir() + "/spool.jsonl")
+		require.NoError(t, err)
+		require.NoError(t, spool.Close())
+
+		journal := keylog.NewJournal(storage, spool)
+		require.Error(t, journal.StoreEvent(&model.KeyEventWithTimestamp{Position: 1}))
+	})
+}
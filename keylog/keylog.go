@@ -3,32 +3,145 @@ package keylog
 import (
 	"errors"
 	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/dasdy/glover/db"
 	"github.com/dasdy/glover/keylog/parser"
+	"github.com/dasdy/glover/keylog/ports"
+	"github.com/dasdy/glover/model"
 )
 
-func Loop(ch <-chan string, storage db.Storage, trackers []db.Tracker, enableLogs bool) {
-	for line := range ch {
-		parsed, err := parser.ParseLine(line)
-		if err != nil && !errors.Is(err, parser.ErrEmptyLine) {
-			slog.Error("Failed to parse line", "error", err, "line", line)
-		}
+// Events parses lines read from keyboards and stamps events with the time they were read.
+// Lines that are not key events are skipped. The channel is closed once the input is closed.
+func Events(ch <-chan string, source string) <-chan model.KeyEventWithTimestamp {
+	out := make(chan model.KeyEventWithTimestamp, 5)
+
+	go func() {
+		defer close(out)
 
-		if parsed != nil {
-			if enableLogs {
-				slog.Info("Got keypress", "col", parsed.Col, "row", parsed.Row, "postition", parsed.Position)
+		for line := range ch {
+			if event := parseEvent(line, source); event != nil {
+				out <- *event
 			}
+		}
+	}()
+
+	return out
+}
+
+// DeviceEvents is Events for lines that know the device they were read from. keyboard names the
+// keyboard a device belongs to, it is called for every event.
+func DeviceEvents(ch <-chan ports.Line, source string, keyboard func(device string) string) <-chan model.KeyEventWithTimestamp {
+	out := make(chan model.KeyEventWithTimestamp, 5)
 
-			if storage.Store(parsed) != nil {
-				slog.Error("Failed to log item", "error", err)
+	go func() {
+		defer close(out)
+
+		for line := range ch {
+			if event := parseEvent(line.Text, source); event != nil {
+				event.Keyboard = keyboard(line.Device)
+				out <- *event
 			}
+		}
+	}()
+
+	return out
+}
+
+func parseEvent(line string, source string) *model.KeyEventWithTimestamp {
+	parsed, err := parser.ParseLine(line)
+	if err != nil && !errors.Is(err, parser.ErrEmptyLine) {
+		slog.Error("Failed to parse line", "error", err, "line", line)
+	}
+
+	if parsed == nil {
+		return nil
+	}
+
+	return &model.KeyEventWithTimestamp{
+		Row:       parsed.Row,
+		Col:       parsed.Col,
+		Position:  parsed.Position,
+		Pressed:   parsed.Pressed,
+		Timestamp: time.Now(),
+		Source:    source,
+	}
+}
+
+// MergeEvents forwards events of all inputs into one channel, which is closed once all inputs are closed.
+func MergeEvents(inputs ...<-chan model.KeyEventWithTimestamp) <-chan model.KeyEventWithTimestamp {
+	out := make(chan model.KeyEventWithTimestamp, 5)
+
+	var wg sync.WaitGroup
+
+	for _, in := range inputs {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
 
-			for _, tracker := range trackers {
-				tracker.HandleKeyNow(parsed.Position, parsed.Pressed, enableLogs)
+			for e := range in {
+				out <- e
 			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+func Loop(ch <-chan string, storage db.Storage, trackers *db.TrackerSet, enableLogs bool) {
+	LoopEvents(Events(ch, ""), storage, trackers, enableLogs)
+}
+
+// LoopEvents stores events and passes them to trackers until the channel is closed. Events of all
+// sources go through a single loop, so trackers see them one at a time.
+func LoopEvents(ch <-chan model.KeyEventWithTimestamp, storage db.Storage, trackers *db.TrackerSet, enableLogs bool) {
+	for event := range ch {
+		handleEvent(&event, storage, trackers, enableLogs)
+	}
+
+	slog.Info("Channel closed; bailing out")
+}
+
+// LoopKeyboards is LoopEvents for several keyboards: every event goes to trackers of its keyboard.
+// Events of keyboards that have no trackers, e.g. unnamed ones, are stored and counted as events of fallback.
+func LoopKeyboards(
+	ch <-chan model.KeyEventWithTimestamp,
+	storage db.Storage,
+	trackers map[string]*db.TrackerSet,
+	fallback string,
+	enableLogs bool,
+) {
+	for event := range ch {
+		keyboardTrackers, ok := trackers[event.Keyboard]
+		if !ok {
+			event.Keyboard = fallback
+			keyboardTrackers = trackers[fallback]
 		}
+
+		handleEvent(&event, storage, keyboardTrackers, enableLogs)
 	}
 
 	slog.Info("Channel closed; bailing out")
 }
+
+func handleEvent(event *model.KeyEventWithTimestamp, storage db.Storage, trackers *db.TrackerSet, enableLogs bool) {
+	if enableLogs {
+		slog.Info("Got keypress",
+			"col", event.Col, "row", event.Row, "postition", event.Position, "source", event.Source, "keyboard", event.Keyboard)
+	}
+
+	if err := storage.StoreEvent(event); err != nil {
+		slog.Error("Failed to store event", "error", err, "event", *event)
+	}
+
+	if trackers != nil {
+		trackers.Handle(*event)
+	}
+}
@@ -0,0 +1,211 @@
+package keylog_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/keylog"
+	"github.com/dasdy/glover/keylog/ports"
+	"github.com/dasdy/glover/keylog/replay"
+	"github.com/dasdy/glover/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var recordedLogs = []string{
+	"../test-inputs/12301.log",
+	"../test-inputs/12301_2.log",
+	"../test-inputs/12401.log",
+}
+
+// replayLogs runs recorded logs through LoopEvents into a fresh storage and trackers.
+func replayLogs(t *testing.T, start time.Time) (*db.SQLiteStorage, *db.TrackerSet) {
+	t.Helper()
+
+	storage, err := db.NewStorageFromPath(t.TempDir()+"/replay.sqlite", false)
+	require.NoError(t, err)
+	t.Cleanup(storage.Close)
+
+	trackers, err := db.NewTrackerSet(db.DefaultTrackers()...)
+	require.NoError(t, err)
+	require.NoError(t, trackers.Init(db.ReadHistory(context.Background(), storage, db.HistoryQuery{})))
+
+	events, err := replay.Load(recordedLogs, start, "")
+	require.NoError(t, err)
+
+	keylog.LoopEvents(
+		replay.Play(context.Background(), events, 0),
+		storage,
+		trackers,
+		false)
+
+	return storage, trackers
+}
+
+func TestLoopEventsReplay(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	storage, trackers := replayLogs(t, start)
+
+	t.Run("stores events with device timestamps", func(t *testing.T) {
+		var count int
+
+		var last time.Time
+
+		for event, err := range storage.History(context.Background(), db.HistoryQuery{}) {
+			require.NoError(t, err)
+
+			count++
+			last = event.Timestamp
+		}
+
+		assert.Equal(t, 450, count)
+		assert.Equal(t, start.Add(3*time.Minute+59057*time.Millisecond), last)
+	})
+
+	t.Run("counts presses", func(t *testing.T) {
+		all, err := storage.GatherAll()
+		require.NoError(t, err)
+
+		var total int
+		for _, key := range all {
+			total += key.Count
+		}
+
+		assert.Equal(t, 225, total)
+	})
+
+	t.Run("counts combos", func(t *testing.T) {
+		combos := db.GatherAllCombos(trackers.Snapshot(db.CombosTracker), allPositions())
+
+		assert.Len(t, combos, 57)
+		assert.Contains(t, combos, model.Combo{Keys: []model.KeyPosition{25, 26}, Pressed: 6})
+		assert.Contains(t, combos, model.Combo{Keys: []model.KeyPosition{29, 30, 31, 56}, Pressed: 1})
+		assert.Contains(t, combos, model.Combo{Keys: []model.KeyPosition{75, 76}, Pressed: 4})
+	})
+
+	t.Run("counts neighbors", func(t *testing.T) {
+		assert.ElementsMatch(t, []model.Combo{
+			{Keys: []model.KeyPosition{73, 31}, Pressed: 1},
+			{Keys: []model.KeyPosition{32, 31}, Pressed: 1},
+			{Keys: []model.KeyPosition{30, 31}, Pressed: 2},
+			{Keys: []model.KeyPosition{29, 31}, Pressed: 1},
+		}, trackers.Snapshot(db.NeighborsTracker).Combos(31))
+	})
+}
+
+func TestLoopReadsLines(t *testing.T) {
+	storage, err := db.NewStorageFromPath(t.TempDir()+"/loop.sqlite", false)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	lines := make(chan string, 5)
+
+	go func() {
+		defer close(lines)
+
+		lines <- "Port:/dev/tty.usbmodem12401"
+		lines <- "[22:56:47.123,352] \x1b[0m<dbg> zmk: zmk_kscan_process_msgq: Row: 2, col: 4, position: 31, pressed: true\x1b[0m"
+		lines <- "[22:56:47.232,421] \x1b[0m<dbg> zmk: zmk_kscan_process_msgq: Row: 2, col: 4, position: 31, pressed: false\x1b[0m"
+	}()
+
+	keylog.Loop(lines, storage, nil, false)
+
+	all, err := storage.GatherAll()
+	require.NoError(t, err)
+	assert.Equal(t, []model.MinimalKeyEvent{{Row: 2, Col: 4, Position: 31, Count: 1}}, all)
+}
+
+func TestLoopKeyboards(t *testing.T) {
+	storage, err := db.NewStorageFromPath(t.TempDir()+"/keyboards.sqlite", false)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	tap := func(lines chan<- ports.Line, device string, position int) {
+		for _, pressed := range []bool{true, false} {
+			lines <- ports.Line{Device: device, Text: fmt.Sprintf(
+				"[22:56:47.123,352] \x1b[0m<dbg> zmk: zmk_kscan_process_msgq: Row: 0, col: 0, position: %d, pressed: %t\x1b[0m",
+				position, pressed)}
+		}
+	}
+
+	lines := make(chan ports.Line, 5)
+
+	go func() {
+		defer close(lines)
+
+		tap(lines, "/dev/glove80-left", 1)
+		tap(lines, "/dev/glove80-right", 2)
+		tap(lines, "/dev/numpad", 3)
+		tap(lines, "/dev/unknown", 4)
+	}()
+
+	keyboards := map[string]string{"/dev/glove80-left": "glove80", "/dev/glove80-right": "glove80", "/dev/numpad": "numpad"}
+	events := keylog.DeviceEvents(lines, "", func(device string) string { return keyboards[device] })
+
+	glove80, err := db.NewTrackerSet(db.NeighborsTracker)
+	require.NoError(t, err)
+
+	numpad, err := db.NewTrackerSet(db.NeighborsTracker)
+	require.NoError(t, err)
+
+	keylog.LoopKeyboards(events, storage, map[string]*db.TrackerSet{
+		"glove80": glove80,
+		"numpad":  numpad,
+	}, "glove80", false)
+
+	positions := func(s db.Storage) []model.KeyPosition {
+		all, err := s.GatherAll()
+		require.NoError(t, err)
+
+		result := make([]model.KeyPosition, 0)
+		for _, e := range all {
+			result = append(result, e.Position)
+		}
+
+		return result
+	}
+
+	t.Run("stores events of every keyboard", func(t *testing.T) {
+		assert.ElementsMatch(t, []model.KeyPosition{1, 2, 4}, positions(storage.ForKeyboard("glove80", false)))
+		assert.ElementsMatch(t, []model.KeyPosition{3}, positions(storage.ForKeyboard("numpad", false)))
+	})
+
+	t.Run("passes events to trackers of their keyboard", func(t *testing.T) {
+		// Keys of the numpad are not neighbors of keys of the other keyboard.
+		assert.Equal(t, []model.Combo{{Keys: []model.KeyPosition{2, 1}, Pressed: 1}}, glove80.Snapshot(db.NeighborsTracker).Combos(1))
+		assert.Equal(t, []model.Combo{{Keys: []model.KeyPosition{4, 2}, Pressed: 1}}, glove80.Snapshot(db.NeighborsTracker).Combos(2))
+		assert.Empty(t, numpad.Snapshot(db.NeighborsTracker).Combos(3))
+	})
+}
+
+func allPositions() []model.KeyPosition {
+	result := make([]model.KeyPosition, 80)
+	for i := range result {
+		result[i] = model.KeyPosition(i)
+	}
+
+	return result
+}
+
+func TestHeartbeat(t *testing.T) {
+	in := make(chan model.KeyEventWithTimestamp)
+	out, heartbeat := keylog.Watch(in)
+
+	assert.True(t, heartbeat.Alive(10*time.Millisecond), "a loop that waits for events is alive")
+
+	in <- model.KeyEventWithTimestamp{Position: 1}
+
+	// The loop is stuck and does not take the event.
+	assert.Eventually(t, func() bool { return !heartbeat.Alive(10 * time.Millisecond) }, time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, model.KeyPosition(1), (<-out).Position)
+	assert.Eventually(t, func() bool { return heartbeat.Alive(10 * time.Millisecond) }, time.Second, 5*time.Millisecond)
+
+	close(in)
+
+	_, ok := <-out
+	assert.False(t, ok)
+}
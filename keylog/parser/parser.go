@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dasdy/glover/model"
 )
@@ -81,3 +82,32 @@ func ParseLine(line string) (*model.KeyEvent, error) {
 
 	return nil, ErrEmptyLine
 }
+
+var ErrNoTimestamp = errors.New("line does not start with a device timestamp")
+
+// ParseTimestamp reads device uptime from the start of a ZMK log line, e.g. [22:53:26.616,638]
+// is 22 hours, 53 minutes, 26 seconds, 616 milliseconds and 638 microseconds.
+func ParseTimestamp(line string) (time.Duration, error) {
+	line = strings.TrimLeft(line, "\x00\r\n ")
+	if !strings.HasPrefix(line, "[") {
+		return 0, ErrNoTimestamp
+	}
+
+	end := strings.IndexByte(line, ']')
+	if end < 0 {
+		return 0, ErrNoTimestamp
+	}
+
+	var hours, minutes, seconds, millis, micros int
+
+	n, err := fmt.Sscanf(line[1:end], "%d:%d:%d.%d,%d", &hours, &minutes, &seconds, &millis, &micros)
+	if err != nil || n != 5 {
+		return 0, fmt.Errorf("%w: '%s'", ErrNoTimestamp, line[:end+1])
+	}
+
+	return time.Duration(hours)*time.Hour +
+		time.Duration(minutes)*time.Minute +
+		time.Duration(seconds)*time.Second +
+		time.Duration(millis)*time.Millisecond +
+		time.Duration(micros)*time.Microsecond, nil
+}
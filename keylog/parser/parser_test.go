@@ -2,6 +2,7 @@ package parser_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/dasdy/glover/keylog/parser"
 	"github.com/dasdy/glover/model"
@@ -91,3 +92,15 @@ func BenchmarkParseLine(b *testing.B) {
 
 	result = r
 }
+
+func TestParseTimestamp(t *testing.T) {
+	d, err := parser.ParseTimestamp("[22:53:26.616,638] \x1b[0m<dbg> zmk: zmk_kscan_process_msgq: Row: 2, col: 1")
+	require.NoError(t, err)
+	assert.Equal(t, 22*time.Hour+53*time.Minute+26*time.Second+616*time.Millisecond+638*time.Microsecond, d)
+
+	_, err = parser.ParseTimestamp("Port:/dev/cu.usbmodem12301")
+	require.ErrorIs(t, err, parser.ErrNoTimestamp)
+
+	_, err = parser.ParseTimestamp("[22:53] something")
+	require.ErrorIs(t, err, parser.ErrNoTimestamp)
+}
@@ -0,0 +1,256 @@
+package ports
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"go.bug.st/serial/enumerator"
+)
+
+// Rule matches a device when every field that is set matches. Path, Serial and Product are globs,
+// Path is compared with the base name unless it contains a slash. VID and PID are hex numbers.
+type Rule struct {
+	Path    string `mapstructure:"path"`
+	Regex   string `mapstructure:"regex"`
+	VID     string `mapstructure:"vid"`
+	PID     string `mapstructure:"pid"`
+	Serial  string `mapstructure:"serial"`
+	Product string `mapstructure:"product"`
+
+	regex *regexp.Regexp
+}
+
+func (r *Rule) String() string {
+	fields := make([]string, 0, 6)
+
+	for _, f := range []struct{ name, value string }{
+		{"path", r.Path}, {"regex", r.Regex}, {"vid", r.VID}, {"pid", r.PID}, {"serial", r.Serial}, {"product", r.Product},
+	} {
+		if f.value != "" {
+			fields = append(fields, f.name+"="+f.value)
+		}
+	}
+
+	return strings.Join(fields, " ")
+}
+
+func (r *Rule) usesUSB() bool {
+	return r.VID != "" || r.PID != "" || r.Serial != "" || r.Product != ""
+}
+
+func (r *Rule) matches(d DeviceInfo) bool {
+	if r.Path != "" {
+		name := filepath.Base(d.Path)
+		if strings.Contains(r.Path, "/") {
+			name = d.Path
+		}
+
+		if ok, _ := filepath.Match(r.Path, name); !ok {
+			return false
+		}
+	}
+
+	if r.regex != nil && !r.regex.MatchString(d.Path) {
+		return false
+	}
+
+	if r.usesUSB() && !d.USB {
+		return false
+	}
+
+	if r.VID != "" && normalizeID(r.VID) != normalizeID(d.VID) {
+		return false
+	}
+
+	if r.PID != "" && normalizeID(r.PID) != normalizeID(d.PID) {
+		return false
+	}
+
+	if ok, _ := filepath.Match(r.Serial, d.SerialNumber); r.Serial != "" && !ok {
+		return false
+	}
+
+	if ok, _ := filepath.Match(r.Product, d.Product); r.Product != "" && !ok {
+		return false
+	}
+
+	return true
+}
+
+func normalizeID(id string) string {
+	return strings.TrimPrefix(strings.ToLower(id), "0x")
+}
+
+// DeviceInfo is what is known about a serial device. USB fields are empty for other devices,
+// or when the system does not tell them.
+type DeviceInfo struct {
+	Path         string
+	USB          bool
+	VID          string
+	PID          string
+	SerialNumber string
+	Product      string
+}
+
+// Candidate is a device with the decision of a Matcher about it.
+type Candidate struct {
+	DeviceInfo
+	Matched bool
+	Reason  string
+}
+
+// Matcher decides which serial devices are keyboards. A device is taken when any of Match rules
+// matches it and none of Reject rules do.
+type Matcher struct {
+	Match  []Rule `mapstructure:"match"`
+	Reject []Rule `mapstructure:"reject"`
+}
+
+// DefaultMatcher takes devices named like ZMK keyboards: tty.usbmodem* on macOS and ttyACM* on Linux.
+func DefaultMatcher() *Matcher {
+	return &Matcher{
+		Match: []Rule{
+			{Path: "tty.usbmodem*"},
+			{Path: "ttyACM*"},
+			{Path: "tty.ACM*"},
+		},
+	}
+}
+
+// Compile checks the rules. It must be called before a matcher read from a config is used.
+func (m *Matcher) Compile() error {
+	for _, rules := range [][]Rule{m.Match, m.Reject} {
+		for i := range rules {
+			rule := &rules[i]
+
+			if rule.String() == "" {
+				return fmt.Errorf("rule %d has no fields set, it would match every device", i+1)
+			}
+
+			for _, pattern := range []string{rule.Path, rule.Serial, rule.Product} {
+				if _, err := filepath.Match(pattern, ""); err != nil {
+					return fmt.Errorf("invalid pattern '%s' in rule '%s': %w", pattern, rule, err)
+				}
+			}
+
+			if rule.Regex != "" {
+				regex, err := regexp.Compile(rule.Regex)
+				if err != nil {
+					return fmt.Errorf("invalid regex in rule '%s': %w", rule, err)
+				}
+
+				rule.regex = regex
+			}
+		}
+	}
+
+	return nil
+}
+
+// Check decides about a single device and explains why.
+func (m *Matcher) Check(d DeviceInfo) (bool, string) {
+	for i := range m.Reject {
+		if m.Reject[i].matches(d) {
+			return false, fmt.Sprintf("rejected by '%s'", &m.Reject[i])
+		}
+	}
+
+	for i := range m.Match {
+		if m.Match[i].matches(d) {
+			return true, fmt.Sprintf("matched '%s'", &m.Match[i])
+		}
+	}
+
+	return false, "no rule matched"
+}
+
+func (m *Matcher) usesUSB() bool {
+	for _, rules := range [][]Rule{m.Match, m.Reject} {
+		for i := range rules {
+			if rules[i].usesUSB() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Explain describes devices at the given paths, including their USB details, and decides about each of them.
+func (m *Matcher) Explain(paths []string) []Candidate {
+	return m.explain(paths, true)
+}
+
+func (m *Matcher) explain(paths []string, withUSB bool) []Candidate {
+	usb := make(map[string]*enumerator.PortDetails)
+
+	if withUSB {
+		usb = usbDetails()
+	}
+
+	result := make([]Candidate, len(paths))
+
+	for i, path := range paths {
+		info := DeviceInfo{Path: path}
+
+		details, ok := usb[path]
+		if !ok {
+			// Links, e.g. of simulated devices or udev names, are described by the device they point to.
+			if target, err := filepath.EvalSymlinks(path); err == nil {
+				details, ok = usb[target]
+			}
+		}
+
+		if ok && details.IsUSB {
+			info.USB = true
+			info.VID = details.VID
+			info.PID = details.PID
+			info.SerialNumber = details.SerialNumber
+			info.Product = details.Product
+		}
+
+		matched, reason := m.Check(info)
+		result[i] = Candidate{DeviceInfo: info, Matched: matched, Reason: reason}
+	}
+
+	return result
+}
+
+// Filter keeps paths of devices that match.
+func (m *Matcher) Filter(paths []string) []string {
+	result := make([]string, 0)
+
+	// Looking USB devices up is slow, so it is only done when some rule needs it.
+	for _, c := range m.explain(paths, m.usesUSB()) {
+		if c.Matched {
+			result = append(result, c.Path)
+		}
+	}
+
+	return result
+}
+
+// Matches decides about a single path.
+func (m *Matcher) Matches(path string) bool {
+	return len(m.Filter([]string{path})) == 1
+}
+
+func usbDetails() map[string]*enumerator.PortDetails {
+	result := make(map[string]*enumerator.PortDetails)
+
+	list, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		slog.Warn("Could not get USB details of serial ports, rules on them will not match", "error", err)
+
+		return result
+	}
+
+	for _, details := range list {
+		result[details.Name] = details
+	}
+
+	return result
+}
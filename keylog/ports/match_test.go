@@ -0,0 +1,105 @@
+package ports_test
+
+import (
+	"testing"
+
+	"github.com/dasdy/glover/keylog/ports"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatcherCheck(t *testing.T) {
+	keyboard := ports.DeviceInfo{
+		Path: "/dev/ttyACM0", USB: true, VID: "1d50", PID: "615e", SerialNumber: "8F3A1C", Product: "Glove80 Left",
+	}
+	arduino := ports.DeviceInfo{
+		Path: "/dev/ttyACM1", USB: true, VID: "2341", PID: "0043", SerialNumber: "1234", Product: "Arduino Uno",
+	}
+	unknown := ports.DeviceInfo{Path: "/dev/ttyACM2"}
+
+	matcher := &ports.Matcher{
+		Match: []ports.Rule{
+			{VID: "0x1D50", PID: "615E"},
+			{Regex: "^/dev/serial/by-id/"},
+		},
+		Reject: []ports.Rule{
+			{Product: "* Right"},
+		},
+	}
+	require.NoError(t, matcher.Compile())
+
+	testCases := []struct {
+		name     string
+		device   ports.DeviceInfo
+		expected bool
+		reason   string
+	}{
+		{"vid and pid", keyboard, true, "matched 'vid=0x1D50 pid=615E'"},
+		{"other gadget", arduino, false, "no rule matched"},
+		{"no usb details", unknown, false, "no rule matched"},
+		{"regex", ports.DeviceInfo{Path: "/dev/serial/by-id/usb-ZMK"}, true, "matched 'regex=^/dev/serial/by-id/'"},
+		{
+			"rejected",
+			ports.DeviceInfo{Path: "/dev/ttyACM3", USB: true, VID: "1d50", PID: "615e", Product: "Glove80 Right"},
+			false,
+			"rejected by 'product=* Right'",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			matched, reason := matcher.Check(tc.device)
+			assert.Equal(t, tc.expected, matched)
+			assert.Equal(t, tc.reason, reason)
+		})
+	}
+}
+
+func TestMatcherPathRules(t *testing.T) {
+	matcher := &ports.Matcher{
+		Match: []ports.Rule{
+			{Path: "ttyACM*", Serial: "8F*"},
+			{Path: "/tmp/devices/*"},
+		},
+	}
+	require.NoError(t, matcher.Compile())
+
+	matched, _ := matcher.Check(ports.DeviceInfo{Path: "/dev/ttyACM0", USB: true, SerialNumber: "8F3A"})
+	assert.True(t, matched)
+
+	// Every field of a rule must match.
+	matched, _ = matcher.Check(ports.DeviceInfo{Path: "/dev/ttyACM0", USB: true, SerialNumber: "11"})
+	assert.False(t, matched)
+
+	// Patterns with a slash are matched against the whole path.
+	matched, _ = matcher.Check(ports.DeviceInfo{Path: "/tmp/devices/left"})
+	assert.True(t, matched)
+
+	matched, _ = matcher.Check(ports.DeviceInfo{Path: "/tmp/other/left"})
+	assert.False(t, matched)
+}
+
+func TestMatcherCompile(t *testing.T) {
+	testCases := []struct {
+		name    string
+		matcher ports.Matcher
+	}{
+		{"empty rule", ports.Matcher{Match: []ports.Rule{{}}}},
+		{"invalid glob", ports.Matcher{Match: []ports.Rule{{Path: "tty["}}}},
+		{"invalid regex", ports.Matcher{Reject: []ports.Rule{{Regex: "("}}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Error(t, tc.matcher.Compile())
+		})
+	}
+
+	require.NoError(t, ports.DefaultMatcher().Compile())
+}
+
+func TestMatcherFilter(t *testing.T) {
+	assert.Equal(t,
+		[]string{"/dev/tty.usbmodem12301", "/dev/ttyACM0"},
+		ports.DefaultMatcher().Filter([]string{"/dev/tty.usbmodem12301", "/dev/ttyS0", "/dev/ttyACM0"}))
+}
@@ -1,6 +1,8 @@
 package ports
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -8,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"go.bug.st/serial"
 )
 
@@ -17,42 +20,85 @@ type MonitoringDeviceReader struct {
 	devicesList map[string]*RealDeviceReader
 	lock        sync.RWMutex
 
-	opener *RealDeviceOpener
+	opener  *RealDeviceOpener
+	matcher *Matcher
 
 	pollingInterval time.Duration
+	// debounce is how long a new device is left alone before opening, as device nodes appear
+	// before they are readable. Failed opens are retried maxOpenAttempts times with growing delays.
+	debounce time.Duration
+	watch    bool
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
 }
 
+const maxOpenAttempts = 5
+
 func DefaultMonitoringDeviceReader() *MonitoringDeviceReader {
-	return &MonitoringDeviceReader{
-		pathToLookup:    "/dev/",
-		devicesList:     make(map[string]*RealDeviceReader),
-		lock:            sync.RWMutex{},
-		opener:          &RealDeviceOpener{},
-		pollingInterval: 5 * time.Second,
-	}
+	return NewMonitoringDeviceReader("/dev/")
 }
 
 func NewMonitoringDeviceReader(pathToLookup string) *MonitoringDeviceReader {
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &MonitoringDeviceReader{
 		pathToLookup:    pathToLookup,
 		devicesList:     make(map[string]*RealDeviceReader),
 		lock:            sync.RWMutex{},
 		opener:          &RealDeviceOpener{},
+		matcher:         DefaultMatcher(),
 		pollingInterval: 5 * time.Second,
+		debounce:        500 * time.Millisecond,
+		watch:           true,
+		ctx:             ctx,
+		cancel:          cancel,
+		workers:         sync.WaitGroup{},
 	}
 }
 
+// SetPollingInterval changes how often new devices are looked for when the directory can not be watched.
+// It must be called before Channel.
+func (r *MonitoringDeviceReader) SetPollingInterval(interval time.Duration) {
+	r.pollingInterval = interval
+}
+
+// SetMatcher changes which devices are taken as keyboards. It must be called before Channel.
+func (r *MonitoringDeviceReader) SetMatcher(matcher *Matcher) {
+	r.matcher = matcher
+}
+
+// SetDebounce changes how long new devices are left alone before they are opened. It must be called before Channel.
+func (r *MonitoringDeviceReader) SetDebounce(debounce time.Duration) {
+	r.debounce = debounce
+}
+
+// SetWatch turns watching the directory for changes on or off. When it is off, or the directory can
+// not be watched, it is polled instead. It must be called before Channel.
+func (r *MonitoringDeviceReader) SetWatch(watch bool) {
+	r.watch = watch
+}
+
+// Close stops looking for devices, closes the open ones and waits until the channel is closed.
 func (r *MonitoringDeviceReader) Close() error {
+	r.cancel()
+
 	r.lock.Lock()
-	defer r.lock.Unlock()
+
+	var closeErr error
 
 	for i, device := range r.devicesList {
-		if err := device.Close(); err != nil {
-			return fmt.Errorf("error closing device %s: %w", i, err)
+		if err := device.Close(); err != nil && closeErr == nil {
+			closeErr = fmt.Errorf("error closing device %s: %w", i, err)
 		}
 	}
 
-	return nil
+	r.lock.Unlock()
+
+	r.workers.Wait()
+
+	return closeErr
 }
 
 func (r *MonitoringDeviceReader) CloseDevice(devicePath string) error {
@@ -77,10 +123,14 @@ func (r *MonitoringDeviceReader) CloseDevice(devicePath string) error {
 	return nil
 }
 
-func (r *MonitoringDeviceReader) AddDevice(devicePath string, out chan string) error {
+func (r *MonitoringDeviceReader) AddDevice(devicePath string, out chan Line) error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
+	if r.ctx.Err() != nil {
+		return fmt.Errorf("monitoring is stopped: %w", r.ctx.Err())
+	}
+
 	if _, exists := r.devicesList[devicePath]; exists {
 		slog.Debug("Device already exists, skipping", "path", devicePath)
 
@@ -94,14 +144,21 @@ func (r *MonitoringDeviceReader) AddDevice(devicePath string, out chan string) e
 
 	r.devicesList[devicePath] = device
 
+	r.workers.Add(1)
+
 	go func() {
+		defer r.workers.Done()
+
 		// TODO: is repeat-closing ok?
 		slog.Info("Device loop started", "path", devicePath)
 
 		defer device.Close()
 
-		for line := range device.Channel() {
-			out <- line
+		for line := range device.Lines() {
+			select {
+			case out <- line:
+			case <-r.ctx.Done():
+			}
 		}
 
 		slog.Info("Device closed", "path", devicePath)
@@ -133,97 +190,290 @@ func (r *MonitoringDeviceReader) FindDevices() ([]string, error) {
 	newDevices := make(map[string]bool)
 
 	for _, devicePath := range serialDevices {
-		shouldOpen := r.shouldOpenDevice(devicePath)
-		if shouldOpen {
+		if !r.isOpen(devicePath) {
 			newDevices[devicePath] = true
 		}
 	}
 
 	for _, entry := range entries {
-		shouldOpen, devicePath := r.shouldOpenFile(entry)
-		if !shouldOpen {
-			continue
+		devicePath := path.Join(r.pathToLookup, entry.Name())
+		if !entry.IsDir() && isDevice(devicePath) && !r.isOpen(devicePath) {
+			newDevices[devicePath] = true
 		}
+	}
 
-		slog.Info("Found device", "path", devicePath)
+	candidates := make([]string, 0, len(newDevices))
+	for k := range newDevices {
+		candidates = append(candidates, k)
+	}
 
-		newDevices[devicePath] = true
+	found := r.matcher.Filter(candidates)
+	for _, devicePath := range found {
+		slog.Info("Found device", "path", devicePath)
 	}
 
-	keys := make([]string, len(newDevices))
+	return found, nil
+}
 
-	i := 0
-	for k := range newDevices {
-		keys[i] = k
-		i++
+// Channel starts looking for devices and returns the lines read from all of them. The channel
+// is closed after Close.
+func (r *MonitoringDeviceReader) Channel() (<-chan string, error) {
+	lines, err := r.Lines()
+	if err != nil {
+		return nil, err
 	}
 
-	return keys, nil
+	return Texts(lines), nil
 }
 
-func (r *MonitoringDeviceReader) Channel() (<-chan string, error) {
+// Lines is Channel that also tells which device every line was read from.
+func (r *MonitoringDeviceReader) Lines() (<-chan Line, error) {
 	slog.Info("Starting monitoring", "path", r.pathToLookup)
 
-	outputChan := make(chan string, 5)
+	outputChan := make(chan Line, 5)
+
+	var watcher *fsnotify.Watcher
+
+	if r.watch {
+		var err error
+
+		watcher, err = r.newWatcher()
+		if err != nil {
+			slog.Warn("Could not watch for devices, falling back to polling",
+				"path", r.pathToLookup, "interval", r.pollingInterval, "error", err)
+		}
+	}
+
+	r.workers.Add(1)
 
 	go func() {
-		slog.Info("Monitoring started", "path", r.pathToLookup)
+		defer r.workers.Done()
+
+		slog.Info("Monitoring started", "path", r.pathToLookup, "watching", watcher != nil)
 
 		defer slog.Info("End monitoring", "path", r.pathToLookup)
 
-		for {
-			// log.Printf("Polling for devices in path: %s", r.pathToLookup)
-			devices, err := r.FindDevices()
-			if err != nil {
-				slog.Error("Error finding devices", "error", err)
+		if watcher != nil {
+			r.watchLoop(watcher, outputChan)
+		}
+
+		if r.ctx.Err() == nil {
+			r.pollLoop(outputChan)
+		}
+	}()
+
+	// Closing only after all devices are done, so none of them writes into a closed channel.
+	go func() {
+		<-r.ctx.Done()
+		r.workers.Wait()
+		close(outputChan)
+	}()
+
+	slog.Info("Returning monitoring channel")
 
+	return outputChan, nil
+}
+
+func (r *MonitoringDeviceReader) newWatcher() (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("could not create watcher: %w", err)
+	}
+
+	if err := watcher.Add(r.pathToLookup); err != nil {
+		watcher.Close()
+
+		return nil, fmt.Errorf("could not watch %s: %w", r.pathToLookup, err)
+	}
+
+	return watcher, nil
+}
+
+// addAll opens every device that is not open yet.
+func (r *MonitoringDeviceReader) addAll(out chan Line) {
+	devices, err := r.FindDevices()
+	if err != nil {
+		slog.Error("Error finding devices", "error", err)
+
+		return
+	}
+
+	for _, devicePath := range devices {
+		slog.Info("Processing device", "path", devicePath)
+
+		err := r.AddDevice(devicePath, out)
+		if err != nil {
+			slog.Error("Could not add device", "path", devicePath, "error", err)
+		}
+	}
+}
+
+func (r *MonitoringDeviceReader) pollLoop(out chan Line) {
+	ticker := time.NewTicker(r.pollingInterval)
+	defer ticker.Stop()
+
+	for {
+		r.addAll(out)
+
+		select {
+		case <-ticker.C:
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// pendingDevice is a device that appeared in the directory and waits to be opened.
+type pendingDevice struct {
+	timer    *time.Timer
+	attempts int
+}
+
+func (r *MonitoringDeviceReader) watchLoop(watcher *fsnotify.Watcher, out chan Line) {
+	defer watcher.Close()
+
+	// Devices that were there before the watcher started never get an event.
+	r.addAll(out)
+
+	pending := make(map[string]*pendingDevice)
+	ready := make(chan string)
+
+	schedule := func(devicePath string, delay time.Duration) {
+		p, ok := pending[devicePath]
+		if !ok {
+			p = &pendingDevice{}
+			pending[devicePath] = p
+		}
+
+		if p.timer != nil {
+			p.timer.Stop()
+		}
+
+		p.timer = time.AfterFunc(delay, func() {
+			select {
+			case ready <- devicePath:
+			case <-r.ctx.Done():
+			}
+		})
+	}
+
+	defer func() {
+		for _, p := range pending {
+			p.timer.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				slog.Warn("Watching for devices stopped, falling back to polling", "path", r.pathToLookup)
+
+				return
+			}
+
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Chmod) {
 				continue
 			}
 
-			time.Sleep(r.pollingInterval)
+			// USB details might not be known until the device settles, so such rules are checked later.
+			// A device that is still open might be replaced by a new one, e.g. after a quick reconnect,
+			// so whether it is open is also checked later.
+			if !r.matcher.usesUSB() && !r.matcher.Matches(event.Name) {
+				continue
+			}
+
+			slog.Debug("Device appeared", "path", event.Name, "op", event.Op)
 
-			for _, devicePath := range devices {
-				slog.Info("Processing device", "path", devicePath)
+			// Every new event restarts the wait, so a device is opened once it settles.
+			schedule(event.Name, r.debounce)
 
-				err := r.AddDevice(devicePath, outputChan)
-				if err != nil {
-					slog.Error("Could not add device", "path", devicePath, "error", err)
+		case devicePath := <-ready:
+			p := pending[devicePath]
+			if p == nil {
+				continue
+			}
+
+			p.attempts++
+
+			err := r.openIfDevice(devicePath, out)
+			if err == nil || errors.Is(err, errNotMatched) {
+				delete(pending, devicePath)
+
+				continue
+			}
+
+			if p.attempts >= maxOpenAttempts {
+				if errors.Is(err, errAlreadyOpen) {
+					slog.Debug("Device is already open", "path", devicePath)
+				} else {
+					slog.Error("Could not add device", "path", devicePath, "attempts", p.attempts, "error", err)
 				}
+
+				delete(pending, devicePath)
+
+				continue
 			}
-		}
-	}()
 
-	slog.Info("Returning monitoring channel")
+			slog.Debug("Device is not ready yet", "path", devicePath, "attempts", p.attempts, "error", err)
+			schedule(devicePath, r.debounce*time.Duration(p.attempts+1))
 
-	return outputChan, nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				slog.Warn("Watching for devices stopped, falling back to polling", "path", r.pathToLookup)
+
+				return
+			}
+
+			// Events might have been lost, so look at everything again.
+			slog.Error("Error watching for devices", "path", r.pathToLookup, "error", err)
+			r.addAll(out)
+		}
+	}
 }
 
-func (r *MonitoringDeviceReader) shouldOpenFile(entry os.DirEntry) (bool, string) {
-	if entry.IsDir() || entry.Type()&os.ModeDevice == 0 {
-		return false, ""
+var (
+	errAlreadyOpen = errors.New("device is already open")
+	errNotMatched  = errors.New("device does not match")
+)
+
+// openIfDevice adds the device unless it is not a device yet, or its previous instance has not been closed yet.
+func (r *MonitoringDeviceReader) openIfDevice(devicePath string, out chan Line) error {
+	if r.isOpen(devicePath) {
+		return errAlreadyOpen
 	}
 
-	devicePath := path.Join(r.pathToLookup, entry.Name())
+	info, err := os.Stat(devicePath)
+	if err != nil {
+		return fmt.Errorf("could not stat device %s: %w", devicePath, err)
+	}
+
+	if info.Mode()&os.ModeDevice == 0 {
+		return fmt.Errorf("%s is not a device", devicePath)
+	}
 
-	resp := r.shouldOpenDevice(devicePath)
-	if resp {
-		return resp, devicePath
+	if !r.matcher.Matches(devicePath) {
+		return errNotMatched
 	}
 
-	return false, ""
+	return r.AddDevice(devicePath, out)
 }
 
-func (r *MonitoringDeviceReader) shouldOpenDevice(devicePath string) bool {
-	if !LooksLikeZMKDevice(devicePath) {
-		return false
-	}
+// isDevice follows links, so devices can also be found by stable names like the ones udev creates.
+func isDevice(devicePath string) bool {
+	info, err := os.Stat(devicePath)
+
+	return err == nil && info.Mode()&os.ModeDevice != 0
+}
 
+func (r *MonitoringDeviceReader) isOpen(devicePath string) bool {
 	r.lock.RLock()
 	defer r.lock.RUnlock()
 
-	if _, ok := r.devicesList[devicePath]; ok {
-		return false
-	}
+	_, ok := r.devicesList[devicePath]
 
-	return true
+	return ok
 }
@@ -0,0 +1,157 @@
+package ports_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/keylog/parser"
+	"github.com/dasdy/glover/keylog/ports"
+	"github.com/dasdy/glover/keylog/simulator"
+	"github.com/dasdy/glover/model"
+	"github.com/stretchr/testify/require"
+)
+
+// nextEvent reads lines until one of them is a key event.
+func nextEvent(t *testing.T, lines <-chan string) model.KeyEvent {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case line := <-lines:
+			if event, err := parser.ParseLine(line); err == nil {
+				return *event
+			}
+		case <-timeout:
+			t.Fatal("no event came from the devices")
+		}
+	}
+}
+
+// keepPressing sends the event until it comes out of lines: devices are only picked up on the next poll,
+// and lines written before that are lost. Other events, e.g. repeated earlier ones, are skipped.
+func keepPressing(t *testing.T, device *simulator.Device, lines <-chan string, event model.KeyEvent) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		require.NoError(t, device.Key(event))
+
+		select {
+		case line := <-lines:
+			if got, err := parser.ParseLine(line); err == nil && *got == event {
+				return
+			}
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	t.Fatalf("device %s was not picked up", device.Path())
+}
+
+func TestMonitoringDeviceReader(t *testing.T) {
+	for _, watch := range []bool{true, false} {
+		t.Run(fmt.Sprintf("watch=%t", watch), func(t *testing.T) {
+			dir := t.TempDir()
+			left := simulator.NewTestDevice(t, dir, "tty.usbmodem12301")
+
+			reader := ports.NewMonitoringDeviceReader(dir)
+			reader.SetWatch(watch)
+			reader.SetPollingInterval(10 * time.Millisecond)
+			reader.SetDebounce(10 * time.Millisecond)
+
+			defer reader.Close()
+
+			lines, err := reader.Channel()
+			require.NoError(t, err)
+
+			// The right half is plugged in after monitoring started.
+			right := simulator.NewTestDevice(t, dir, "tty.usbmodem12401")
+
+			t.Run("reads both halves", func(t *testing.T) {
+				keepPressing(t, left, lines, model.KeyEvent{Row: 2, Col: 4, Position: 31, Pressed: true})
+				keepPressing(t, right, lines, model.KeyEvent{Row: 1, Col: 0, Position: 56, Pressed: true})
+			})
+
+			t.Run("reconnects after a disconnect", func(t *testing.T) {
+				require.NoError(t, right.Disconnect())
+				require.NoError(t, right.Connect())
+
+				keepPressing(t, right, lines, model.KeyEvent{Row: 1, Col: 0, Position: 56})
+			})
+
+			t.Run("keeps reading the other half", func(t *testing.T) {
+				require.NoError(t, left.Key(model.KeyEvent{Row: 2, Col: 4, Position: 31}))
+
+				for {
+					if event := nextEvent(t, lines); event.Position == 31 && !event.Pressed {
+						break
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestMonitoringDeviceReaderClose(t *testing.T) {
+	dir := t.TempDir()
+	device := simulator.NewTestDevice(t, dir, "tty.usbmodem12301")
+
+	reader := ports.NewMonitoringDeviceReader(dir)
+	reader.SetDebounce(10 * time.Millisecond)
+
+	lines, err := reader.Channel()
+	require.NoError(t, err)
+
+	keepPressing(t, device, lines, model.KeyEvent{Position: 1, Pressed: true})
+
+	closed := make(chan error)
+
+	go func() { closed <- reader.Close() }()
+
+	select {
+	case err := <-closed:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not stop monitoring")
+	}
+
+	// The channel is closed once monitoring stops.
+	for range lines {
+	}
+}
+
+func TestMonitoringDeviceReaderLines(t *testing.T) {
+	dir := t.TempDir()
+	device := simulator.NewTestDevice(t, dir, "tty.usbmodem12301")
+
+	reader := ports.NewMonitoringDeviceReader(dir)
+	reader.SetDebounce(10 * time.Millisecond)
+
+	defer reader.Close()
+
+	lines, err := reader.Lines()
+	require.NoError(t, err)
+
+	event := model.KeyEvent{Position: 1, Pressed: true}
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		require.NoError(t, device.Key(event))
+
+		select {
+		case line := <-lines:
+			if got, err := parser.ParseLine(line.Text); err == nil && *got == event {
+				require.Equal(t, device.Path(), line.Device)
+
+				return
+			}
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	t.Fatalf("device %s was not picked up", device.Path())
+}
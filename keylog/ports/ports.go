@@ -6,8 +6,6 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
@@ -16,6 +14,14 @@ import (
 
 type RealDeviceReader struct {
 	ports []io.ReadCloser
+	// names of ports, empty when they were not opened by path, e.g. stdin.
+	names []string
+}
+
+// Line is a line read from a device, together with the path of the device.
+type Line struct {
+	Device string
+	Text   string
 }
 
 type DeviceReader interface {
@@ -28,10 +34,18 @@ type DeviceOpener interface {
 	GetAvailableDevices() ([]string, error)
 }
 
-type RealDeviceOpener struct{}
+type RealDeviceOpener struct {
+	// Matcher decides which devices GetAvailableDevices suggests. DefaultMatcher is used when it is nil.
+	Matcher *Matcher
+}
 
 func NewDeviceReader(devices ...io.ReadCloser) *RealDeviceReader {
-	return &RealDeviceReader{ports: devices}
+	return &RealDeviceReader{ports: devices, names: make([]string, len(devices))}
+}
+
+// NewNamedDeviceReader is NewDeviceReader for devices that have paths, which are then told in Lines.
+func NewNamedDeviceReader(names []string, devices ...io.ReadCloser) *RealDeviceReader {
+	return &RealDeviceReader{ports: devices, names: names}
 }
 
 func (r *RealDeviceReader) Close() error {
@@ -54,9 +68,14 @@ func (r *RealDeviceReader) Close() error {
 }
 
 func (r *RealDeviceReader) Channel() <-chan string {
+	return Texts(r.Lines())
+}
+
+// Lines is Channel that also tells which device every line was read from.
+func (r *RealDeviceReader) Lines() <-chan Line {
 	slog.Info("opening a channel")
 
-	outputChan := make(chan string, 5)
+	outputChan := make(chan Line, 5)
 
 	var wg sync.WaitGroup
 
@@ -67,7 +86,7 @@ func (r *RealDeviceReader) Channel() <-chan string {
 
 		go func() {
 			for v := range ch {
-				outputChan <- v
+				outputChan <- Line{Device: r.names[i], Text: v}
 			}
 
 			wg.Done()
@@ -105,7 +124,7 @@ func (r *RealDeviceOpener) Open(path string) (*RealDeviceReader, error) {
 		return nil, fmt.Errorf("error on setting read timeout for port %s: %w", path, err)
 	}
 
-	return NewDeviceReader(port), nil
+	return NewNamedDeviceReader([]string{path}, port), nil
 }
 
 func CloseReaders(outerError error, itemsToClose []io.ReadCloser) error {
@@ -150,7 +169,22 @@ func (r *RealDeviceOpener) OpenMultiple(paths ...string) (*RealDeviceReader, err
 		ports[i] = reader.ports[0]
 	}
 
-	return NewDeviceReader(ports...), nil
+	return NewNamedDeviceReader(paths, ports...), nil
+}
+
+// Texts drops device paths of lines.
+func Texts(lines <-chan Line) <-chan string {
+	out := make(chan string, 5)
+
+	go func() {
+		defer close(out)
+
+		for line := range lines {
+			out <- line.Text
+		}
+	}()
+
+	return out
 }
 
 func ReadFile(r io.Reader) <-chan string {
@@ -173,9 +207,9 @@ func ReadFile(r io.Reader) <-chan string {
 	return ch1
 }
 
+// LooksLikeZMKDevice checks the path with rules of DefaultMatcher.
 func LooksLikeZMKDevice(path string) bool {
-	return strings.HasPrefix(filepath.Base(path), "tty.usbmodem") || // macos convention
-		strings.HasPrefix(filepath.Base(path), "tty.ACM") // linux convention
+	return DefaultMatcher().Matches(path)
 }
 
 func (r *RealDeviceOpener) GetAvailableDevices() ([]string, error) {
@@ -188,13 +222,10 @@ func (r *RealDeviceOpener) GetAvailableDevices() ([]string, error) {
 
 	slog.Info("Found these devices: ", "names", names)
 
-	result := make([]string, 0)
-
-	for _, n := range names {
-		if LooksLikeZMKDevice(n) {
-			result = append(result, n)
-		}
+	matcher := r.Matcher
+	if matcher == nil {
+		matcher = DefaultMatcher()
 	}
 
-	return result, nil
+	return matcher.Filter(names), nil
 }
@@ -92,6 +92,31 @@ func TestReadTwoFiles(t *testing.T) {
 	})
 }
 
+func TestLines(t *testing.T) {
+	t.Run("tells the device of every line", func(t *testing.T) {
+		c := ports.NewNamedDeviceReader([]string{"/dev/left", "/dev/right"}, sReader("a\nb\n"), sReader("c\n"))
+
+		lines := make([]ports.Line, 0)
+		for line := range c.Lines() {
+			lines = append(lines, line)
+		}
+
+		sort.Slice(lines, func(i, j int) bool { return lines[i].Text < lines[j].Text })
+
+		assert.Equal(t, []ports.Line{
+			{Device: "/dev/left", Text: "a"},
+			{Device: "/dev/left", Text: "b"},
+			{Device: "/dev/right", Text: "c"},
+		}, lines)
+	})
+
+	t.Run("devices without paths have empty names", func(t *testing.T) {
+		c := ports.NewDeviceReader(sReader("a\n"))
+
+		assert.Equal(t, ports.Line{Text: "a"}, <-c.Lines())
+	})
+}
+
 func TestLooksLikeZMKDevice(t *testing.T) {
 	testCases := []struct {
 		path     string
@@ -100,6 +125,7 @@ func TestLooksLikeZMKDevice(t *testing.T) {
 		{"/dev/tty.usbmodem12301", true},
 		{"/dev/tty.usbmodem12401", true},
 		{"/dev/tty.usbmodem11400", true},
+		{"/dev/ttyACM0", true},
 		{"/dev/ttyp1", false},
 		{"/home/user/tty.usbmodem12301/ttyp1", false},
 	}
@@ -0,0 +1,167 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dasdy/glover/archive"
+	"github.com/dasdy/glover/model"
+)
+
+// Agent sends events to a Listener of another glover instance. Every event goes to the spool
+// first and is removed from it only after the server has accepted it.
+type Agent struct {
+	URL           string
+	Secret        string
+	Source        string
+	BatchSize     int
+	FlushInterval time.Duration
+	MaxBackoff    time.Duration
+	HTTP          *http.Client
+
+	spool *Spool
+	// pending is how many oldest events of the spool the current batch holds, zero until it is sent
+	// for the first time. A retry sends the same events, as the server skips ones it has received.
+	pending int
+	// delivered is how many oldest events of the spool were accepted but could not be dropped yet.
+	delivered int
+}
+
+func NewAgent(url, secret, source string, spool *Spool) *Agent {
+	return &Agent{
+		URL:           strings.TrimSuffix(url, "/") + IngestPath,
+		Secret:        secret,
+		Source:        source,
+		BatchSize:     1000,
+		FlushInterval: time.Second,
+		MaxBackoff:    time.Minute,
+		HTTP:          &http.Client{Timeout: 30 * time.Second},
+		spool:         spool,
+	}
+}
+
+// send delivers one batch. Events of a batch stay first in the spool until it is delivered, so the
+// server recognizes the ones it has received when they are sent again, even by a restarted agent.
+func (a *Agent) send(ctx context.Context, events []model.KeyEventWithTimestamp) error {
+	var body bytes.Buffer
+	if _, err := archive.WriteAll(&body, archive.FormatJSONL, slices.Values(events)); err != nil {
+		return fmt.Errorf("could not encode events: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, &body)
+	if err != nil {
+		return fmt.Errorf("could not build request: %w", err)
+	}
+
+	req.Header.Set(SourceHeader, a.Source)
+
+	if a.Secret != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Secret)
+	}
+
+	resp, err := a.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach %s: %w", a.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		return fmt.Errorf("%s responded with %s: %s", a.URL, resp.Status, bytes.TrimSpace(message))
+	}
+
+	return nil
+}
+
+// Flush sends spooled events in batches until the spool is empty or delivery fails.
+func (a *Agent) Flush(ctx context.Context) error {
+	for {
+		if a.delivered > 0 {
+			if err := a.spool.Drop(a.delivered); err != nil {
+				return err
+			}
+
+			a.delivered = 0
+		}
+
+		size := a.BatchSize
+		if a.pending > 0 {
+			size = a.pending
+		}
+
+		events := a.spool.Peek(size)
+		if len(events) == 0 {
+			return nil
+		}
+
+		a.pending = len(events)
+
+		if err := a.send(ctx, events); err != nil {
+			return err
+		}
+
+		a.pending = 0
+		a.delivered = len(events)
+	}
+}
+
+// Run spools incoming events and flushes them periodically. Failed deliveries are retried with
+// growing delays. Returns once events channel is closed and one last flush was attempted, or
+// when the context is cancelled. Undelivered events stay in the spool for the next run.
+func (a *Agent) Run(ctx context.Context, events <-chan model.KeyEventWithTimestamp) error {
+	ticker := time.NewTicker(a.FlushInterval)
+	defer ticker.Stop()
+
+	backoff := a.FlushInterval
+	nextAttempt := time.Time{}
+
+	flush := func() {
+		if time.Now().Before(nextAttempt) {
+			return
+		}
+
+		if err := a.Flush(ctx); err != nil {
+			slog.Warn("Could not deliver events, will retry", "error", err, "backlog", a.spool.Len(), "retryIn", backoff)
+
+			nextAttempt = time.Now().Add(backoff)
+			backoff = min(backoff*2, a.MaxBackoff)
+
+			return
+		}
+
+		backoff = a.FlushInterval
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e, ok := <-events:
+			if !ok {
+				nextAttempt = time.Time{}
+				flush()
+
+				return nil
+			}
+
+			e.Source = a.Source
+			if err := a.spool.Append(e); err != nil {
+				return err
+			}
+
+			if a.spool.Len() >= a.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Package remote forwards key events from machines with a keyboard to a central glover instance.
+package remote
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/dasdy/glover/archive"
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/model"
+)
+
+const (
+	IngestPath   = "/ingest"
+	SourceHeader = "X-Glover-Source"
+
+	maxBatchBodySize = 16 << 20
+)
+
+// Listener receives events from agents and passes them on with the source of the agent. It is
+// meant to be merged with local keyboards, so remote events go through the same keylog pipeline.
+type Listener struct {
+	secret string
+	out    chan model.KeyEventWithTimestamp
+
+	lock    sync.Mutex
+	sources map[string]*sourceState
+	// handlers are requests that may still pass events on, Close waits for them.
+	handlers sync.WaitGroup
+}
+
+// sourceState is what the listener knows about one agent. Batches of an agent are handled one at a time,
+// agents do not wait for each other.
+type sourceState struct {
+	lock sync.Mutex
+	// last are events of the batch that was passed on last. An agent that does not know whether they
+	// arrived, e.g. because the response got lost or it was restarted, sends them again first.
+	last map[db.EventIdentity]bool
+}
+
+// NewListener requires agents to send the secret as a bearer token unless it is empty.
+func NewListener(secret string) *Listener {
+	return &Listener{
+		secret:  secret,
+		out:     make(chan model.KeyEventWithTimestamp, 100),
+		lock:    sync.Mutex{},
+		sources: make(map[string]*sourceState),
+	}
+}
+
+// Events returns the channel with received events. It is closed by Close.
+func (l *Listener) Events() <-chan model.KeyEventWithTimestamp {
+	return l.out
+}
+
+// Close closes the channel of events. It must be called after the server stopped passing requests
+// to the listener, e.g. after http.Server.Shutdown returned.
+func (l *Listener) Close() {
+	l.handlers.Wait()
+
+	close(l.out)
+}
+
+// ServeHTTP passes on events of a batch that were not received before. Events an agent sends again
+// are recognized by their content, the same way Import compares them.
+func (l *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.handlers.Add(1)
+	defer l.handlers.Done()
+
+	if r.Method != http.MethodPost || r.URL.Path != IngestPath {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	if l.secret != "" {
+		expected := []byte("Bearer " + l.secret)
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+	}
+
+	source := r.Header.Get(SourceHeader)
+	if source == "" {
+		http.Error(w, SourceHeader+" header is required", http.StatusBadRequest)
+
+		return
+	}
+
+	// The whole batch is read first, so a broken batch is rejected without passing any of its events on.
+	// Nothing is locked meanwhile, a slow agent does not hold up others.
+	events := make([]model.KeyEventWithTimestamp, 0)
+
+	for e, err := range archive.Read(http.MaxBytesReader(w, r.Body, maxBatchBodySize), archive.FormatJSONL) {
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid events: %v", err), http.StatusBadRequest)
+
+			return
+		}
+
+		e.Source = source
+		events = append(events, e)
+	}
+
+	// Batches from one agent are sent one at a time, so holding its lock keeps their order and
+	// makes the duplicate check reliable.
+	state := l.source(source)
+
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	fresh := events
+	for len(fresh) > 0 && state.last[db.IdentityOf(fresh[0])] {
+		fresh = fresh[1:]
+	}
+
+	if skipped := len(events) - len(fresh); skipped > 0 {
+		slog.Info("Skipping events that were already received", "source", source, "count", skipped)
+	}
+
+	if len(fresh) == 0 {
+		return
+	}
+
+	// The batch is passed on as a whole or not at all, a retry would pass on events of a part twice.
+	select {
+	case l.out <- fresh[0]:
+	case <-r.Context().Done():
+		http.Error(w, "request cancelled", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	for _, e := range fresh[1:] {
+		l.out <- e
+	}
+
+	state.last = make(map[db.EventIdentity]bool, len(events))
+	for _, e := range events {
+		state.last[db.IdentityOf(e)] = true
+	}
+
+	slog.Info("Received events", "source", source, "count", len(fresh))
+}
+
+func (l *Listener) source(name string) *sourceState {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	state, ok := l.sources[name]
+	if !ok {
+		state = &sourceState{}
+		l.sources[name] = state
+	}
+
+	return state
+}
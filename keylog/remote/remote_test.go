@@ -0,0 +1,338 @@
+package remote_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/keylog/remote"
+	"github.com/dasdy/glover/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvents(n int) []model.KeyEventWithTimestamp {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	result := make([]model.KeyEventWithTimestamp, n)
+
+	for i := range result {
+		result[i] = model.KeyEventWithTimestamp{
+			Position:  model.KeyPosition(i),
+			Pressed:   i%2 == 0,
+			Timestamp: start.Add(time.Duration(i) * time.Millisecond),
+		}
+
+		// Keyboards named by the agent are passed on as they are.
+		if i%3 == 0 {
+			result[i].Keyboard = "glove80"
+		}
+	}
+
+	return result
+}
+
+func received(l *remote.Listener) []model.KeyEventWithTimestamp {
+	result := make([]model.KeyEventWithTimestamp, 0)
+
+	for {
+		select {
+		case e := <-l.Events():
+			result = append(result, e)
+		default:
+			return result
+		}
+	}
+}
+
+func withSource(events []model.KeyEventWithTimestamp, source string) []model.KeyEventWithTimestamp {
+	for i := range events {
+		events[i].Source = source
+	}
+
+	return events
+}
+
+func newAgent(t *testing.T, url, secret string) (*remote.Agent, *remote.Spool, string) {
+	t.Helper()
+
+	path := t.TempDir() + "/spool.jsonl"
+
+	spool, err := remote.OpenSpool(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { spool.Close() })
+
+	agent := remote.NewAgent(url, secret, "laptop", spool)
+	agent.BatchSize = 3
+	agent.FlushInterval = 10 * time.Millisecond
+
+	return agent, spool, path
+}
+
+func TestAgentDelivers(t *testing.T) {
+	listener := remote.NewListener("s3cret")
+	server := httptest.NewServer(listener)
+
+	defer server.Close()
+
+	agent, spool, _ := newAgent(t, server.URL, "s3cret")
+
+	events := make(chan model.KeyEventWithTimestamp)
+
+	go func() {
+		for _, e := range testEvents(5) {
+			events <- e
+		}
+
+		close(events)
+	}()
+
+	require.NoError(t, agent.Run(context.Background(), events))
+
+	assert.Equal(t, withSource(testEvents(5), "laptop"), received(listener))
+	assert.Zero(t, spool.Len())
+}
+
+func TestListenerClose(t *testing.T) {
+	listener := remote.NewListener("")
+	server := httptest.NewServer(listener)
+
+	agent, _, _ := newAgent(t, server.URL, "")
+
+	events := make(chan model.KeyEventWithTimestamp, 2)
+	events <- testEvents(1)[0]
+	close(events)
+
+	require.NoError(t, agent.Run(context.Background(), events))
+
+	server.Close()
+	listener.Close()
+
+	// Events received before closing are still passed on.
+	received := make([]model.KeyEventWithTimestamp, 0)
+	for e := range listener.Events() {
+		received = append(received, e)
+	}
+
+	assert.Equal(t, withSource(testEvents(1), "laptop"), received)
+}
+
+func TestAgentSpoolsWhileOffline(t *testing.T) {
+	listener := remote.NewListener("")
+
+	var online atomic.Bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !online.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+
+			return
+		}
+
+		listener.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	agent, spool, path := newAgent(t, server.URL, "")
+
+	for _, e := range testEvents(4) {
+		require.NoError(t, spool.Append(e))
+	}
+
+	require.Error(t, agent.Flush(context.Background()))
+	assert.Equal(t, 4, spool.Len())
+	require.NoError(t, spool.Close())
+
+	// Restart of the agent picks up events from the file.
+	reopened, err := remote.OpenSpool(path)
+	require.NoError(t, err)
+
+	defer reopened.Close()
+
+	assert.Equal(t, 4, reopened.Len())
+
+	online.Store(true)
+
+	agent = remote.NewAgent(server.URL, "", "laptop", reopened)
+	require.NoError(t, agent.Flush(context.Background()))
+
+	assert.Equal(t, withSource(testEvents(4), "laptop"), received(listener))
+	assert.Zero(t, reopened.Len())
+}
+
+func TestSpoolCutsOffTornLine(t *testing.T) {
+	_, spool, path := newAgent(t, "", "")
+
+	for _, e := range testEvents(2) {
+		require.NoError(t, spool.Append(e))
+	}
+
+	require.NoError(t, spool.Close())
+
+	// The machine went down while the third event was written.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"row":0,"col":2,"posi`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	reopened, err := remote.OpenSpool(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, testEvents(2), reopened.Peek(10))
+
+	event := testEvents(3)[2]
+	require.NoError(t, reopened.Append(event))
+	require.NoError(t, reopened.Close())
+
+	again, err := remote.OpenSpool(path)
+	require.NoError(t, err)
+
+	defer again.Close()
+
+	assert.Equal(t, testEvents(3), again.Peek(10), "events are appended after the last whole line")
+}
+
+func TestListenerSkipsResentBatch(t *testing.T) {
+	listener := remote.NewListener("")
+
+	var calls atomic.Int32
+
+	// The first response gets lost after the listener has accepted the batch.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			listener.ServeHTTP(httptest.NewRecorder(), r)
+			http.Error(w, "connection reset", http.StatusBadGateway)
+
+			return
+		}
+
+		listener.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	agent, spool, _ := newAgent(t, server.URL, "")
+
+	for _, e := range testEvents(2) {
+		require.NoError(t, spool.Append(e))
+	}
+
+	require.Error(t, agent.Flush(context.Background()))
+
+	// An event that arrives before the retry goes to the next batch, not into the one that was received.
+	require.NoError(t, spool.Append(testEvents(3)[2]))
+	require.NoError(t, agent.Flush(context.Background()))
+
+	assert.Equal(t, withSource(testEvents(3), "laptop"), received(listener))
+	assert.Zero(t, spool.Len())
+}
+
+func TestListenerSkipsBatchResentAfterRestart(t *testing.T) {
+	listener := remote.NewListener("")
+
+	var calls atomic.Int32
+
+	// The response gets lost, and the agent is restarted before it retries.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			listener.ServeHTTP(httptest.NewRecorder(), r)
+			http.Error(w, "connection reset", http.StatusBadGateway)
+
+			return
+		}
+
+		listener.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	agent, spool, path := newAgent(t, server.URL, "")
+
+	for _, e := range testEvents(2) {
+		require.NoError(t, spool.Append(e))
+	}
+
+	require.Error(t, agent.Flush(context.Background()))
+	require.NoError(t, spool.Append(testEvents(3)[2]))
+	require.NoError(t, spool.Close())
+
+	reopened, err := remote.OpenSpool(path)
+	require.NoError(t, err)
+
+	defer reopened.Close()
+
+	// The new agent sends the events of the lost batch together with the one that came after it.
+	agent = remote.NewAgent(server.URL, "", "laptop", reopened)
+	require.NoError(t, agent.Flush(context.Background()))
+
+	assert.Equal(t, withSource(testEvents(3), "laptop"), received(listener))
+	assert.Zero(t, reopened.Len())
+}
+
+func TestListenerDoesNotWaitForSlowAgents(t *testing.T) {
+	listener := remote.NewListener("")
+
+	post := func(source string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, remote.IngestPath, body)
+		req.Header.Set(remote.SourceHeader, source)
+
+		w := httptest.NewRecorder()
+		listener.ServeHTTP(w, req)
+
+		return w
+	}
+
+	valid := `{"row":0,"col":0,"position":1,"pressed":true,"ts":"2024-03-01T10:00:00Z"}` + "\n"
+
+	// The body of the slow agent has not arrived completely yet.
+	body, slow := io.Pipe()
+	slowDone := make(chan int)
+
+	go func() { slowDone <- post("slow", body).Code }()
+
+	_, err := slow.Write([]byte(valid))
+	require.NoError(t, err)
+
+	fast := make(chan int)
+
+	go func() { fast <- post("fast", strings.NewReader(valid)).Code }()
+
+	select {
+	case code := <-fast:
+		assert.Equal(t, http.StatusOK, code)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "batch of one agent waits for the body of another")
+	}
+
+	require.NoError(t, slow.Close())
+	assert.Equal(t, http.StatusOK, <-slowDone)
+	assert.Len(t, received(listener), 2)
+}
+
+func TestListenerRejects(t *testing.T) {
+	listener := remote.NewListener("s3cret")
+
+	post := func(secret, source, body string) int {
+		req := httptest.NewRequest(http.MethodPost, remote.IngestPath, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+secret)
+		req.Header.Set(remote.SourceHeader, source)
+
+		w := httptest.NewRecorder()
+		listener.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	valid := `{"row":0,"col":0,"position":1,"pressed":true,"ts":"2024-03-01T10:00:00Z"}`
+
+	assert.Equal(t, http.StatusUnauthorized, post("wrong", "laptop", valid))
+	assert.Equal(t, http.StatusBadRequest, post("s3cret", "", valid))
+	assert.Equal(t, http.StatusBadRequest, post("s3cret", "laptop", valid+"\n{\"row\":0}"))
+	assert.Empty(t, received(listener), "broken batch is rejected as a whole")
+	assert.Equal(t, http.StatusOK, post("s3cret", "laptop", valid))
+	assert.Len(t, received(listener), 1)
+}
@@ -0,0 +1,172 @@
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/dasdy/glover/archive"
+	"github.com/dasdy/glover/model"
+)
+
+// Spool keeps events that were not delivered yet in a json lines file, so they survive restarts
+// of the agent and periods without network, or, in a Journal, periods when the database fails.
+type Spool struct {
+	path    string
+	file    *os.File
+	pending []model.KeyEventWithTimestamp
+	lock    sync.Mutex
+}
+
+// OpenSpool loads events left from previous runs and opens the file for appending. A last line torn
+// by a crash while it was written is cut off, events before it are kept.
+func OpenSpool(path string) (*Spool, error) {
+	pending := make([]model.KeyEventWithTimestamp, 0)
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not open spool %s: %w", path, err)
+	}
+
+	// Every event is written with its line end, so whatever follows the last one was torn.
+	if complete := bytes.LastIndexByte(data, '\n') + 1; complete < len(data) {
+		slog.Warn("Cutting off torn last line of spool", "path", path, "bytes", len(data)-complete)
+
+		if err := os.Truncate(path, int64(complete)); err != nil {
+			return nil, fmt.Errorf("could not cut off torn line of spool %s: %w", path, err)
+		}
+
+		data = data[:complete]
+	}
+
+	for e, err := range archive.Read(bytes.NewReader(data), archive.FormatJSONL) {
+		if err != nil {
+			return nil, fmt.Errorf("could not read spool %s: %w", path, err)
+		}
+
+		pending = append(pending, e)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open spool %s for writing: %w", path, err)
+	}
+
+	return &Spool{path: path, file: file, pending: pending, lock: sync.Mutex{}}, nil
+}
+
+// Append writes the event and syncs the file, so the event is not lost if the machine goes down.
+func (s *Spool) Append(e model.KeyEventWithTimestamp) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := archive.WriteAll(s.file, archive.FormatJSONL, slices.Values([]model.KeyEventWithTimestamp{e})); err != nil {
+		return fmt.Errorf("could not write to spool: %w", err)
+	}
+
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("could not sync spool: %w", err)
+	}
+
+	s.pending = append(s.pending, e)
+
+	return nil
+}
+
+// Peek returns up to n oldest events without removing them.
+func (s *Spool) Peek(n int) []model.KeyEventWithTimestamp {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return slices.Clone(s.pending[:min(n, len(s.pending))])
+}
+
+// Drop removes n oldest events after they were delivered. The file is rewritten with the rest,
+// which is usually empty.
+func (s *Spool) Drop(n int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	rest := s.pending[min(n, len(s.pending)):]
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("could not rewrite spool: %w", err)
+	}
+
+	if _, err := archive.WriteAll(tmp, archive.FormatJSONL, slices.Values(rest)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return fmt.Errorf("could not rewrite spool: %w", err)
+	}
+
+	// The rest is synced before it replaces the spool, and the directory after, so a crash leaves
+	// either the old spool or the new one.
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return fmt.Errorf("could not sync spool: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not rewrite spool: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("could not replace spool: %w", err)
+	}
+
+	if err := syncDir(filepath.Dir(s.path)); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not reopen spool: %w", err)
+	}
+
+	s.file.Close()
+	s.file = file
+	s.pending = slices.Clone(rest)
+
+	return nil
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open directory of spool: %w", err)
+	}
+	defer dir.Close()
+
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("could not sync directory of spool: %w", err)
+	}
+
+	return nil
+}
+
+// Len returns amount of events waiting to be delivered.
+func (s *Spool) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.pending)
+}
+
+func (s *Spool) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("could not close spool: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+// Package replay plays key events of recorded ZMK logs back with the timing of the device.
+package replay
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/dasdy/glover/keylog/parser"
+	"github.com/dasdy/glover/model"
+)
+
+// Event is a key event with the uptime of the device when it was logged.
+type Event struct {
+	model.KeyEvent
+	Offset time.Duration
+}
+
+// Device clocks are printed as hours of the day in some firmware builds, so a jump back
+// by more than this is treated as crossing midnight rather than reordered output.
+const wrapThreshold = 12 * time.Hour
+
+// ReadEvents collects key events of a log. Events whose line has no readable timestamp,
+// e.g. because output of both halves got interleaved, get the timestamp of the previous line.
+// Events before the first readable timestamp, e.g. of a log that starts mid-line, get that one.
+func ReadEvents(r io.Reader) ([]Event, error) {
+	scanner := bufio.NewScanner(r)
+	result := make([]Event, 0)
+
+	var last, wrap time.Duration
+
+	stamped := false
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if offset, err := parser.ParseTimestamp(line); err == nil {
+			if stamped && last-(offset+wrap) > wrapThreshold {
+				wrap += 24 * time.Hour
+			}
+
+			last = offset + wrap
+
+			if !stamped {
+				for i := range result {
+					result[i].Offset = last
+				}
+
+				stamped = true
+			}
+		}
+
+		parsed, err := parser.ParseLine(line)
+		if errors.Is(err, parser.ErrEmptyLine) {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("could not parse line: %w", err)
+		}
+
+		result = append(result, Event{KeyEvent: *parsed, Offset: last})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read log: %w", err)
+	}
+
+	return result, nil
+}
+
+// Load reads logs of several devices recorded at the same time, e.g. both halves of a split
+// keyboard, and orders their events by device time. The earliest event happens at start.
+// Every device logs its own clock, and they are compared as they are: logs of devices whose
+// clocks differ, e.g. halves that were powered on at different times, are not aligned.
+func Load(paths []string, start time.Time, source string) ([]model.KeyEventWithTimestamp, error) {
+	events := make([]Event, 0)
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not open log %s: %w", path, err)
+		}
+
+		fileEvents, err := ReadEvents(f)
+		f.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("could not read log %s: %w", path, err)
+		}
+
+		events = append(events, fileEvents...)
+	}
+
+	return Timestamped(events, start, source), nil
+}
+
+// Timestamped orders events by device time and moves them so that the earliest one happens at start.
+func Timestamped(events []Event, start time.Time, source string) []model.KeyEventWithTimestamp {
+	slices.SortStableFunc(events, func(a, b Event) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+
+	result := make([]model.KeyEventWithTimestamp, len(events))
+
+	for i, e := range events {
+		result[i] = model.KeyEventWithTimestamp{
+			Row:       e.Row,
+			Col:       e.Col,
+			Position:  e.Position,
+			Pressed:   e.Pressed,
+			Timestamp: start.Add(e.Offset - events[0].Offset),
+			Source:    source,
+		}
+	}
+
+	return result
+}
+
+// Play sends events into the returned channel, which is closed when all of them are sent or
+// ctx is cancelled. With speed 0 events are sent as fast as they are consumed, otherwise
+// Play waits between events for their time difference divided by speed.
+func Play(ctx context.Context, events []model.KeyEventWithTimestamp, speed float64) <-chan model.KeyEventWithTimestamp {
+	out := make(chan model.KeyEventWithTimestamp, 5)
+
+	go func() {
+		defer close(out)
+
+		for i, e := range events {
+			if speed > 0 && i > 0 {
+				delay := time.Duration(float64(e.Timestamp.Sub(events[i-1].Timestamp)) / speed)
+
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
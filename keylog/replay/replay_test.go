@@ -0,0 +1,129 @@
+package replay_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/keylog/replay"
+	"github.com/dasdy/glover/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleLog = "Port:/dev/tty.usbmodem12401\n" +
+	"[22:56:47.123,229] \x1b[0m<dbg> zmk: kscan_matrix_read: Sending event at 2,4 state on\x1b[0m\r\n" +
+	"[22:56:47.123,352] \x1b[0m<dbg> zmk: zmk_kscan_process_msgq: Row: 2, col: 4, position: 31, pressed: true\x1b[0m\r\n" +
+	"[22:56:47.232,421] \x1b[0m<dbg> zmk: zmk_kscan_process_msgq: Row: 2, col: 4, position: 31, pressed: false\x1b[0m\r\n" +
+	"[22:56garbled<dbg> zmk: zmk_kscan_process_msgq: Row: 1, col: 0, position: 56, pressed: true\x1b[0m\r\n" +
+	"Read ended\n"
+
+func TestReadEvents(t *testing.T) {
+	events, err := replay.ReadEvents(strings.NewReader(sampleLog))
+	require.NoError(t, err)
+
+	base := 22*time.Hour + 56*time.Minute + 47*time.Second
+
+	assert.Equal(t, []replay.Event{
+		{KeyEvent: model.KeyEvent{Row: 2, Col: 4, Position: 31, Pressed: true}, Offset: base + 123352*time.Microsecond},
+		{KeyEvent: model.KeyEvent{Row: 2, Col: 4, Position: 31, Pressed: false}, Offset: base + 232421*time.Microsecond},
+		// The timestamp of this line is broken, so it is taken from the previous one.
+		{KeyEvent: model.KeyEvent{Row: 1, Col: 0, Position: 56, Pressed: true}, Offset: base + 232421*time.Microsecond},
+	}, events)
+}
+
+func TestReadEventsStartingWithBrokenTimestamp(t *testing.T) {
+	// Recording started in the middle of a line, like in test-inputs/12401.log.
+	log := "[22:56:39listener: Row: 0, col: 0, position: 1, pressed: true\n" +
+		"[22:56:47.100,000] Row: 0, col: 0, position: 1, pressed: false\n" +
+		"[22:56:47.200,000] Row: 0, col: 0, position: 2, pressed: true\n"
+
+	events, err := replay.ReadEvents(strings.NewReader(log))
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+
+	first := 22*time.Hour + 56*time.Minute + 47*time.Second + 100*time.Millisecond
+	assert.Equal(t, []time.Duration{first, first, first + 100*time.Millisecond},
+		[]time.Duration{events[0].Offset, events[1].Offset, events[2].Offset})
+}
+
+func TestReadEventsAcrossMidnight(t *testing.T) {
+	log := "[23:59:59.900,000] Row: 0, col: 0, position: 1, pressed: true\n" +
+		"[00:00:00.100,000] Row: 0, col: 0, position: 1, pressed: false\n"
+
+	events, err := replay.ReadEvents(strings.NewReader(log))
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, 200*time.Millisecond, events[1].Offset-events[0].Offset)
+}
+
+func TestTimestamped(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	events := replay.Timestamped([]replay.Event{
+		{KeyEvent: model.KeyEvent{Position: 2}, Offset: 5 * time.Second},
+		{KeyEvent: model.KeyEvent{Position: 1}, Offset: 3 * time.Second},
+	}, start, "lap")
+
+	assert.Equal(t, []model.KeyEventWithTimestamp{
+		{Position: 1, Timestamp: start, Source: "lap"},
+		{Position: 2, Timestamp: start.Add(2 * time.Second), Source: "lap"},
+	}, events)
+}
+
+func collect(ch <-chan model.KeyEventWithTimestamp) []model.KeyEventWithTimestamp {
+	result := make([]model.KeyEventWithTimestamp, 0)
+	for e := range ch {
+		result = append(result, e)
+	}
+
+	return result
+}
+
+func TestPlay(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	events := []model.KeyEventWithTimestamp{
+		{Position: 1, Timestamp: start},
+		{Position: 2, Timestamp: start.Add(100 * time.Millisecond)},
+		{Position: 3, Timestamp: start.Add(200 * time.Millisecond)},
+	}
+
+	t.Run("as fast as possible", func(t *testing.T) {
+		began := time.Now()
+
+		assert.Equal(t, events, collect(replay.Play(context.Background(), events, 0)))
+		assert.Less(t, time.Since(began), 100*time.Millisecond)
+	})
+
+	t.Run("with speed multiplier", func(t *testing.T) {
+		began := time.Now()
+
+		assert.Equal(t, events, collect(replay.Play(context.Background(), events, 4)))
+		assert.GreaterOrEqual(t, time.Since(began), 50*time.Millisecond)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		assert.Less(t, len(collect(replay.Play(ctx, events, 0.001))), len(events))
+	})
+}
+
+func TestLoad(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	events, err := replay.Load([]string{"../../test-inputs/12301.log", "../../test-inputs/12401.log"}, start, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+
+	assert.Equal(t, start, events[0].Timestamp)
+
+	for i := 1; i < len(events); i++ {
+		assert.False(t, events[i].Timestamp.Before(events[i-1].Timestamp), "events are not ordered at %d", i)
+	}
+
+	_, err = replay.Load([]string{"../../test-inputs/missing.log"}, start, "")
+	require.Error(t, err)
+}
@@ -0,0 +1,208 @@
+package simulator
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dasdy/glover/keylog/parser"
+	"github.com/dasdy/glover/model"
+)
+
+type StepKind int
+
+const (
+	KeyStep StepKind = iota
+	LineStep
+	WaitStep
+	DisconnectStep
+	ConnectStep
+)
+
+// Step is a single action of a simulated device.
+type Step struct {
+	Kind  StepKind
+	Event model.KeyEvent
+	Line  string
+	Wait  time.Duration
+}
+
+// ParseScript reads a script with one step per line. Empty lines and lines starting with # are skipped.
+//
+//	press 31 2,4     key at position 31 (row 2, col 4) goes down; row and col are optional
+//	release 31 2,4   and goes up
+//	tap 31 2,4       press and release
+//	wait 150ms       pause, any time.ParseDuration value
+//	disconnect       unplug the device
+//	connect          plug it back in
+//	line <text>      print text as is
+func ParseScript(r io.Reader) ([]Step, error) {
+	scanner := bufio.NewScanner(r)
+	result := make([]Step, 0)
+
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		command, rest, _ := strings.Cut(line, " ")
+
+		steps, err := parseCommand(command, strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+
+		result = append(result, steps...)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read script: %w", err)
+	}
+
+	return result, nil
+}
+
+func parseCommand(command, args string) ([]Step, error) {
+	switch command {
+	case "press", "release", "tap":
+		event, err := parseKey(args)
+		if err != nil {
+			return nil, err
+		}
+
+		press, release := event, event
+		press.Pressed = true
+
+		switch command {
+		case "press":
+			return []Step{{Kind: KeyStep, Event: press}}, nil
+		case "release":
+			return []Step{{Kind: KeyStep, Event: release}}, nil
+		default:
+			return []Step{{Kind: KeyStep, Event: press}, {Kind: KeyStep, Event: release}}, nil
+		}
+
+	case "wait":
+		wait, err := time.ParseDuration(args)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse wait: %w", err)
+		}
+
+		return []Step{{Kind: WaitStep, Wait: wait}}, nil
+
+	case "disconnect":
+		return []Step{{Kind: DisconnectStep}}, nil
+
+	case "connect":
+		return []Step{{Kind: ConnectStep}}, nil
+
+	case "line":
+		return []Step{{Kind: LineStep, Line: args}}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown command '%s'", command)
+	}
+}
+
+// parseKey reads "position" or "position row,col".
+func parseKey(args string) (model.KeyEvent, error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 || len(fields) > 2 {
+		return model.KeyEvent{}, fmt.Errorf("expected position and optional row,col, got '%s'", args)
+	}
+
+	position, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return model.KeyEvent{}, fmt.Errorf("could not parse position: %w", err)
+	}
+
+	event := model.KeyEvent{Position: model.KeyPosition(position)}
+
+	if len(fields) == 2 {
+		row, col, found := strings.Cut(fields[1], ",")
+		if !found {
+			return model.KeyEvent{}, fmt.Errorf("expected row,col, got '%s'", fields[1])
+		}
+
+		if event.Row, err = strconv.Atoi(row); err != nil {
+			return model.KeyEvent{}, fmt.Errorf("could not parse row: %w", err)
+		}
+
+		if event.Col, err = strconv.Atoi(col); err != nil {
+			return model.KeyEvent{}, fmt.Errorf("could not parse col: %w", err)
+		}
+	}
+
+	return event, nil
+}
+
+// StepsFromLog turns a recorded log into steps that print its lines with the original pauses between them.
+func StepsFromLog(r io.Reader) ([]Step, error) {
+	scanner := bufio.NewScanner(r)
+	result := make([]Step, 0)
+
+	var last time.Duration
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if offset, err := parser.ParseTimestamp(line); err == nil {
+			if last != 0 && offset > last {
+				result = append(result, Step{Kind: WaitStep, Wait: offset - last})
+			}
+
+			last = offset
+		}
+
+		result = append(result, Step{Kind: LineStep, Line: line})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read log: %w", err)
+	}
+
+	return result, nil
+}
+
+// Run performs steps on the device. Waits are divided by speed; with speed 0 they are skipped.
+func (d *Device) Run(ctx context.Context, steps []Step, speed float64) error {
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("simulation stopped: %w", err)
+		}
+
+		var err error
+
+		switch step.Kind {
+		case KeyStep:
+			err = d.Key(step.Event)
+		case LineStep:
+			err = d.WriteLine(step.Line)
+		case DisconnectStep:
+			err = d.Disconnect()
+		case ConnectStep:
+			err = d.Connect()
+		case WaitStep:
+			if speed <= 0 {
+				continue
+			}
+
+			select {
+			case <-time.After(time.Duration(float64(step.Wait) / speed)):
+			case <-ctx.Done():
+				return fmt.Errorf("simulation stopped: %w", ctx.Err())
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("could not simulate %s: %w", d.path, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+// Package simulator pretends to be a ZMK keyboard connected over USB, so device handling can be tested
+// without hardware. Every simulated device is a pseudo-terminal with a tty.usbmodem* link pointing to it.
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/dasdy/glover/model"
+	"golang.org/x/term"
+)
+
+var ErrDisconnected = errors.New("device is disconnected")
+
+// Device is a simulated keyboard half. It is connected when created.
+type Device struct {
+	path string
+	boot time.Time
+
+	lock   sync.Mutex
+	master *os.File
+	slave  *os.File
+}
+
+// NewDevice creates a device at dir/name, e.g. /tmp/devices/tty.usbmodem12301.
+func NewDevice(dir, name string) (*Device, error) {
+	d := &Device{path: filepath.Join(dir, name), boot: time.Now(), lock: sync.Mutex{}}
+
+	if err := d.Connect(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Path is where readers should open the device.
+func (d *Device) Path() string {
+	return d.path
+}
+
+// Connected reports whether the device is plugged in.
+func (d *Device) Connected() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.master != nil
+}
+
+// Connect plugs the device back in. The new terminal is different from the previous one,
+// as it would be with a real keyboard. Connecting a connected device does nothing.
+func (d *Device) Connect() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.master != nil {
+		return nil
+	}
+
+	master, slave, err := pty.Open()
+	if err != nil {
+		return fmt.Errorf("could not create pseudo-terminal: %w", err)
+	}
+
+	// Nobody reads the output of the terminal, so it must not echo lines back, or writes block
+	// once the buffer is full.
+	if _, err := term.MakeRaw(int(slave.Fd())); err != nil {
+		master.Close()
+		slave.Close()
+
+		return fmt.Errorf("could not switch %s to raw mode: %w", slave.Name(), err)
+	}
+
+	// Replace the link atomically, so whoever watches the directory never sees a broken one.
+	tmp := d.path + ".tmp"
+	_ = os.Remove(tmp)
+
+	if err := os.Symlink(slave.Name(), tmp); err != nil {
+		master.Close()
+		slave.Close()
+
+		return fmt.Errorf("could not link %s: %w", slave.Name(), err)
+	}
+
+	if err := os.Rename(tmp, d.path); err != nil {
+		master.Close()
+		slave.Close()
+
+		return fmt.Errorf("could not link %s: %w", d.path, err)
+	}
+
+	d.master = master
+	d.slave = slave
+
+	return nil
+}
+
+// Disconnect unplugs the device: readers get an error and the link disappears.
+func (d *Device) Disconnect() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.master == nil {
+		return nil
+	}
+
+	err := errors.Join(os.Remove(d.path), d.master.Close(), d.slave.Close())
+	d.master = nil
+	d.slave = nil
+
+	if err != nil {
+		return fmt.Errorf("could not disconnect %s: %w", d.path, err)
+	}
+
+	return nil
+}
+
+// Close disconnects the device for good.
+func (d *Device) Close() error {
+	return d.Disconnect()
+}
+
+// WriteLine sends a raw line as the firmware would print it.
+func (d *Device) WriteLine(line string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.master == nil {
+		return ErrDisconnected
+	}
+
+	if _, err := io.WriteString(d.master, line+"\r\n"); err != nil {
+		return fmt.Errorf("could not write to %s: %w", d.path, err)
+	}
+
+	return nil
+}
+
+// Key prints the lines ZMK logs when the key matrix reports a key change.
+func (d *Device) Key(event model.KeyEvent) error {
+	state := "off"
+	if event.Pressed {
+		state = "on"
+	}
+
+	uptime := d.uptime()
+
+	lines := []string{
+		fmt.Sprintf("%s \x1b[0m<dbg> zmk: kscan_matrix_read: Sending event at %d,%d state %s\x1b[0m",
+			uptime, event.Row, event.Col, state),
+		fmt.Sprintf("%s \x1b[0m<dbg> zmk: zmk_kscan_process_msgq: Row: %d, col: %d, position: %d, pressed: %t\x1b[0m",
+			uptime, event.Row, event.Col, event.Position, event.Pressed),
+	}
+
+	for _, line := range lines {
+		if err := d.WriteLine(line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// uptime formats time since the device was created the way ZMK prefixes its log lines.
+func (d *Device) uptime() string {
+	elapsed := time.Since(d.boot)
+
+	return fmt.Sprintf("[%02d:%02d:%02d.%03d,%03d]",
+		int(elapsed.Hours()),
+		int(elapsed.Minutes())%60,
+		int(elapsed.Seconds())%60,
+		elapsed.Milliseconds()%1000,
+		elapsed.Microseconds()%1000)
+}
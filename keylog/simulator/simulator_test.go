@@ -0,0 +1,149 @@
+package simulator_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/keylog/parser"
+	"github.com/dasdy/glover/keylog/ports"
+	"github.com/dasdy/glover/keylog/simulator"
+	"github.com/dasdy/glover/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nextEvent reads lines until one of them is a key event.
+func nextEvent(t *testing.T, lines <-chan string) *model.KeyEvent {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case line, ok := <-lines:
+			require.True(t, ok, "device was closed before an event came")
+
+			event, err := parser.ParseLine(line)
+			if err == nil {
+				_, err = parser.ParseTimestamp(line)
+				require.NoError(t, err)
+
+				return event
+			}
+		case <-timeout:
+			t.Fatal("no event came from the device")
+		}
+	}
+}
+
+func TestDevice(t *testing.T) {
+	device := simulator.NewTestDevice(t, t.TempDir(), "tty.usbmodem12301")
+
+	reader, err := (&ports.RealDeviceOpener{}).Open(device.Path())
+	require.NoError(t, err)
+
+	lines := reader.Channel()
+
+	require.NoError(t, device.Key(model.KeyEvent{Row: 2, Col: 4, Position: 31, Pressed: true}))
+	assert.Equal(t, &model.KeyEvent{Row: 2, Col: 4, Position: 31, Pressed: true}, nextEvent(t, lines))
+
+	require.NoError(t, device.Disconnect())
+	assert.False(t, device.Connected())
+	assert.ErrorIs(t, device.Key(model.KeyEvent{Position: 1}), simulator.ErrDisconnected)
+
+	select {
+	case _, ok := <-lines:
+		for ok {
+			_, ok = <-lines
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reader was not closed after disconnect")
+	}
+
+	require.NoError(t, device.Connect())
+
+	reader, err = (&ports.RealDeviceOpener{}).Open(device.Path())
+	require.NoError(t, err)
+	defer reader.Close()
+
+	require.NoError(t, device.Key(model.KeyEvent{Row: 2, Col: 4, Position: 31, Pressed: false}))
+	assert.Equal(t, &model.KeyEvent{Row: 2, Col: 4, Position: 31, Pressed: false}, nextEvent(t, reader.Channel()))
+}
+
+func TestParseScript(t *testing.T) {
+	script := `
+# left half
+press 31 2,4
+wait 150ms
+release 31
+tap 56
+disconnect
+connect
+line Read ended
+`
+
+	steps, err := simulator.ParseScript(strings.NewReader(script))
+	require.NoError(t, err)
+
+	assert.Equal(t, []simulator.Step{
+		{Kind: simulator.KeyStep, Event: model.KeyEvent{Row: 2, Col: 4, Position: 31, Pressed: true}},
+		{Kind: simulator.WaitStep, Wait: 150 * time.Millisecond},
+		{Kind: simulator.KeyStep, Event: model.KeyEvent{Position: 31}},
+		{Kind: simulator.KeyStep, Event: model.KeyEvent{Position: 56, Pressed: true}},
+		{Kind: simulator.KeyStep, Event: model.KeyEvent{Position: 56}},
+		{Kind: simulator.DisconnectStep},
+		{Kind: simulator.ConnectStep},
+		{Kind: simulator.LineStep, Line: "Read ended"},
+	}, steps)
+
+	_, err = simulator.ParseScript(strings.NewReader("press 1\njump 2\n"))
+	require.ErrorContains(t, err, "line 2")
+
+	_, err = simulator.ParseScript(strings.NewReader("press 1 2\n"))
+	require.Error(t, err)
+}
+
+func TestStepsFromLog(t *testing.T) {
+	log := "Port:/dev/tty.usbmodem12401\n" +
+		"[22:56:47.123,229] <dbg> zmk: kscan_matrix_read: Sending event at 2,4 state on\r\n" +
+		"[22:56:47.232,238] <dbg> zmk: kscan_matrix_read: Sending event at 2,4 state off\r\n"
+
+	steps, err := simulator.StepsFromLog(strings.NewReader(log))
+	require.NoError(t, err)
+
+	assert.Equal(t, []simulator.Step{
+		{Kind: simulator.LineStep, Line: "Port:/dev/tty.usbmodem12401"},
+		{Kind: simulator.LineStep, Line: "[22:56:47.123,229] <dbg> zmk: kscan_matrix_read: Sending event at 2,4 state on"},
+		{Kind: simulator.WaitStep, Wait: 109009 * time.Microsecond},
+		{Kind: simulator.LineStep, Line: "[22:56:47.232,238] <dbg> zmk: kscan_matrix_read: Sending event at 2,4 state off"},
+	}, steps)
+}
+
+func TestRun(t *testing.T) {
+	device := simulator.NewTestDevice(t, t.TempDir(), "tty.usbmodem12301")
+
+	reader, err := (&ports.RealDeviceOpener{}).Open(device.Path())
+	require.NoError(t, err)
+	defer reader.Close()
+
+	lines := reader.Channel()
+
+	steps, err := simulator.ParseScript(strings.NewReader("tap 31 2,4\nwait 1h\ndisconnect\n"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// The wait is cut short by the context.
+	require.ErrorIs(t, device.Run(ctx, steps, 1), context.DeadlineExceeded)
+	assert.True(t, device.Connected())
+
+	assert.Equal(t, &model.KeyEvent{Row: 2, Col: 4, Position: 31, Pressed: true}, nextEvent(t, lines))
+	assert.Equal(t, &model.KeyEvent{Row: 2, Col: 4, Position: 31}, nextEvent(t, lines))
+
+	// Without speed, waits are skipped.
+	require.NoError(t, device.Run(context.Background(), steps[2:], 0))
+	assert.False(t, device.Connected())
+}
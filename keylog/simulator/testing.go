@@ -0,0 +1,20 @@
+package simulator
+
+import (
+	"testing"
+)
+
+// NewTestDevice creates a device that is closed when the test finishes. Devices of several halves
+// are usually created in the same dir, so it is passed explicitly; use t.TempDir() for one.
+func NewTestDevice(t testing.TB, dir, name string) *Device {
+	t.Helper()
+
+	device, err := NewDevice(dir, name)
+	if err != nil {
+		t.Fatalf("could not create simulated device: %v", err)
+	}
+
+	t.Cleanup(func() { _ = device.Close() })
+
+	return device
+}
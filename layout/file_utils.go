@@ -3,39 +3,38 @@ package layout
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
-)
-
-func GetBinaryPath() string {
-	// TODO: parameterize;
-	//nolint:dogsled
-	_, b, _, _ := runtime.Caller(0)
 
-	// Root folder of this project
-	fp := filepath.Join(filepath.Dir(b), "..")
+	"github.com/dasdy/glover/model"
+)
 
-	return fp
-}
+// Resolve picks the file system to read a layout file from. Files that exist on disk (absolute paths or
+// paths relative to the working directory) take priority, otherwise the path is looked up in fallback,
+// which is normally the set of files embedded into the binary. Returned name is valid within returned fs.
+func Resolve(path string, fallback fs.FS) (fs.FS, string) {
+	if _, err := os.Stat(path); err == nil {
+		abs, err := filepath.Abs(path)
+		if err == nil {
+			slog.Info("Opening file from disk", "path", abs)
 
-func OpenPath(path string) (*os.File, error) {
-	var err error
+			return os.DirFS(filepath.Dir(abs)), filepath.Base(abs)
+		}
+	}
 
-	var file *os.File
+	slog.Info("Opening embedded file", "path", path)
 
-	if filepath.IsAbs(path) {
-		slog.Info("Opening absolute path", "path", path)
-		file, err = os.Open(path)
-	} else {
-		slog.Info("Opening relative path", "path", path)
-		file, err = os.Open(filepath.Join(GetBinaryPath(), path))
-	}
+	return fallback, filepath.ToSlash(filepath.Clean(path))
+}
 
+// Open opens file from the provided file system.
+func Open(fsys fs.FS, name string) (fs.File, error) {
+	file, err := fsys.Open(name)
 	if err != nil {
-		return nil, fmt.Errorf("could not open file %s: %w", path, err)
+		return nil, fmt.Errorf("could not open file %s: %w", name, err)
 	}
 
 	return file, nil
@@ -89,8 +88,17 @@ var labels = map[string]string{
 	"LS(LALT)": "⇧+⌥",
 }
 
-func GetKeyLabels(filename string) ([]string, error) {
-	file, err := OpenPath(filename)
+// KeyLabel returns label of the key at position, or its number if the keymap does not have it.
+func KeyLabel(labels []string, position model.KeyPosition) string {
+	if position >= 0 && int(position) < len(labels) {
+		return labels[position]
+	}
+
+	return fmt.Sprintf("#%d", position)
+}
+
+func GetKeyLabels(fsys fs.FS, filename string) ([]string, error) {
+	file, err := Open(fsys, filename)
 	if err != nil {
 		return nil, fmt.Errorf("could not open keymap file %s. %w", filename, err)
 	}
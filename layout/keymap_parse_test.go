@@ -2,23 +2,16 @@ package layout_test
 
 import (
 	"log/slog"
-	"os"
-	"path/filepath"
-	"runtime"
 	"testing"
 
+	"github.com/dasdy/glover"
 	"github.com/dasdy/glover/layout"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestGlove80ParseLayout(t *testing.T) {
 	t.Run("Parses glove80 file", func(t *testing.T) {
-		_, b, _, _ := runtime.Caller(0)
-
-		// Root folder of this project
-		fp := filepath.Join(filepath.Dir(b), "..")
-
-		file, err := os.Open(filepath.Join(fp, "data", "glove80.keymap"))
+		file, err := glover.Data.Open("data/glove80.keymap")
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// Syslog priorities journald reads from the <N> prefix of a line.
+const (
+	priorityError = 3
+	priorityWarn  = 4
+	priorityInfo  = 6
+	priorityDebug = 7
+)
+
+func priority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return priorityError
+	case level >= slog.LevelWarn:
+		return priorityWarn
+	case level >= slog.LevelInfo:
+		return priorityInfo
+	default:
+		return priorityDebug
+	}
+}
+
+// journalWriter puts the priority of the record being written in front of its line.
+type journalWriter struct {
+	lock     sync.Mutex
+	out      io.Writer
+	priority int
+}
+
+func (w *journalWriter) Write(p []byte) (int, error) {
+	line := append(fmt.Appendf(nil, "<%d>", w.priority), p...)
+
+	if _, err := w.out.Write(line); err != nil {
+		return 0, fmt.Errorf("could not write log line: %w", err)
+	}
+
+	return len(p), nil
+}
+
+// JournalHandler writes a JSON object per line, prefixed with its syslog priority, e.g.
+// <4>{"level":"WARN","msg":"..."}. journald takes the priority from the prefix and adds the time
+// itself, so the time is not written.
+type JournalHandler struct {
+	slog.Handler
+	w *journalWriter
+}
+
+func NewJournalHandler(out io.Writer, level slog.Leveler) JournalHandler {
+	w := &journalWriter{out: out}
+
+	return JournalHandler{
+		Handler: slog.NewJSONHandler(w, &slog.HandlerOptions{
+			Level: level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+
+				return a
+			},
+		}),
+		w: w,
+	}
+}
+
+func (h JournalHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.w.lock.Lock()
+	defer h.w.lock.Unlock()
+
+	h.w.priority = priority(r.Level)
+
+	return h.Handler.Handle(ctx, r) //nolint:wrapcheck // Errors of the writer are already wrapped.
+}
+
+func (h JournalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return JournalHandler{Handler: h.Handler.WithAttrs(attrs), w: h.w}
+}
+
+func (h JournalHandler) WithGroup(name string) slog.Handler {
+	return JournalHandler{Handler: h.Handler.WithGroup(name), w: h.w}
+}
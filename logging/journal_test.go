@@ -0,0 +1,29 @@
+package logging_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/dasdy/glover/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalHandler(t *testing.T) {
+	var out bytes.Buffer
+
+	logger := slog.New(logging.NewJournalHandler(&out, slog.LevelInfo))
+
+	logger.Debug("hidden")
+	logger.Info("started", "port", 3000)
+	logger.With("keyboard", "glove80").Warn("device lost")
+	logger.WithGroup("db").Error("could not store", "rows", 2)
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Equal(t, []string{
+		`<6>{"level":"INFO","msg":"started","port":3000}`,
+		`<4>{"level":"WARN","msg":"device lost","keyboard":"glove80"}`,
+		`<3>{"level":"ERROR","msg":"could not store","db":{"rows":2}}`,
+	}, lines)
+}
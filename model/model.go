@@ -20,6 +20,11 @@ type KeyEventWithTimestamp struct {
 	Position  KeyPosition
 	Pressed   bool
 	Timestamp time.Time
+	// Source names the machine the event came from. Empty for keyboards connected directly.
+	Source string
+	// Keyboard names the configured keyboard of the device the event came from. Empty when no keyboards
+	// are configured.
+	Keyboard string
 }
 
 type MinimalKeyEvent struct {
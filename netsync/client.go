@@ -0,0 +1,240 @@
+package netsync
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dasdy/glover/archive"
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/model"
+)
+
+// Result sums up one push or pull.
+type Result struct {
+	Events   int
+	Inserted int
+	Skipped  int
+}
+
+// Client exchanges events between the local storage and a peer. Progress is kept in the local
+// storage per peer, so only new events are sent or fetched next time.
+type Client struct {
+	Peer      string
+	Secret    string
+	BatchSize int
+	HTTP      *http.Client
+}
+
+func NewClient(peer, secret string) *Client {
+	return &Client{
+		Peer:      strings.TrimSuffix(peer, "/"),
+		Secret:    secret,
+		BatchSize: defaultBatchSize,
+		HTTP:      http.DefaultClient,
+	}
+}
+
+func (c *Client) request(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.Peer+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("could not build request: %w", err)
+	}
+
+	if c.Secret != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Secret)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach %s: %w", c.Peer, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		return nil, fmt.Errorf("%s responded with %s: %s", c.Peer, resp.Status, bytes.TrimSpace(message))
+	}
+
+	return resp, nil
+}
+
+// Push sends local events that the peer has not received from this machine yet.
+func (c *Client) Push(ctx context.Context, storage db.SyncStorage) (Result, error) {
+	return c.push(ctx, storage, nil)
+}
+
+// push is Push that does not send events the peer is known to have, e.g. ones just pulled from it.
+func (c *Client) push(ctx context.Context, storage db.SyncStorage, known map[db.EventIdentity]bool) (Result, error) {
+	var result Result
+
+	watermark, err := storage.PeerWatermark(c.Peer)
+	if err != nil {
+		return result, err
+	}
+
+	for {
+		events, cursor, err := storage.EventsAfter(watermark.Pushed, cmp.Or(c.BatchSize, defaultBatchSize))
+		if err != nil {
+			return result, err
+		}
+
+		if len(events) == 0 {
+			return result, nil
+		}
+
+		events = slices.DeleteFunc(events, func(e model.KeyEventWithTimestamp) bool { return known[db.IdentityOf(e)] })
+
+		if len(events) == 0 {
+			watermark.Pushed = cursor
+			if err := storage.SetPeerWatermark(c.Peer, watermark); err != nil {
+				return result, err
+			}
+
+			continue
+		}
+
+		var body bytes.Buffer
+		if _, err := archive.WriteAll(&body, archive.FormatJSONL, slices.Values(events)); err != nil {
+			return result, fmt.Errorf("could not encode events: %w", err)
+		}
+
+		query := url.Values{"after": {strconv.FormatInt(watermark.Pulled, 10)}}
+
+		resp, err := c.request(ctx, http.MethodPost, EventsPath+"?"+query.Encode(), &body)
+		if err != nil {
+			return result, err
+		}
+
+		var pushed PushResult
+
+		err = json.NewDecoder(resp.Body).Decode(&pushed)
+		resp.Body.Close()
+
+		if err != nil {
+			return result, fmt.Errorf("could not decode response of %s: %w", c.Peer, err)
+		}
+
+		result.Events += len(events)
+		result.Inserted += pushed.Inserted
+		result.Skipped += pushed.Skipped
+
+		// Watermark only moves after the peer has stored the batch, so a failed push is retried as a whole.
+		watermark.Pushed = cursor
+
+		// The peer stored the batch right after the events pulled so far, pulling them back is not needed.
+		if pushed.Cursor > watermark.Pulled {
+			watermark.Pulled = pushed.Cursor
+		}
+		if err := storage.SetPeerWatermark(c.Peer, watermark); err != nil {
+			return result, err
+		}
+
+		slog.Info("Pushed events", "peer", c.Peer, "count", len(events), "inserted", pushed.Inserted)
+	}
+}
+
+// Pull fetches events of the peer that were not fetched before and stores the new ones locally.
+func (c *Client) Pull(ctx context.Context, storage db.SyncStorage) (Result, error) {
+	return c.pull(ctx, storage, nil)
+}
+
+// pull is Pull that adds every fetched event to received, if it is not nil.
+func (c *Client) pull(ctx context.Context, storage db.SyncStorage, received map[db.EventIdentity]bool) (Result, error) {
+	var result Result
+
+	watermark, err := storage.PeerWatermark(c.Peer)
+	if err != nil {
+		return result, err
+	}
+
+	for {
+		query := url.Values{
+			"after": {strconv.FormatInt(watermark.Pulled, 10)},
+			"limit": {strconv.Itoa(cmp.Or(c.BatchSize, defaultBatchSize))},
+		}
+
+		resp, err := c.request(ctx, http.MethodGet, EventsPath+"?"+query.Encode(), nil)
+		if err != nil {
+			return result, err
+		}
+
+		cursor, err := strconv.ParseInt(resp.Header.Get(CursorHeader), 10, 64)
+		if err != nil {
+			resp.Body.Close()
+
+			return result, fmt.Errorf("%s responded without a valid cursor: %w", c.Peer, err)
+		}
+
+		events := archive.Read(resp.Body, archive.FormatJSONL)
+		if received != nil {
+			events = recording(events, received)
+		}
+
+		imported, err := storage.Import(events)
+		resp.Body.Close()
+
+		if err != nil {
+			return result, fmt.Errorf("could not store events from %s: %w", c.Peer, err)
+		}
+
+		if cursor <= watermark.Pulled {
+			return result, nil
+		}
+
+		result.Events += imported.Inserted + imported.Skipped
+		result.Inserted += imported.Inserted
+		result.Skipped += imported.Skipped
+
+		watermark.Pulled = cursor
+		if err := storage.SetPeerWatermark(c.Peer, watermark); err != nil {
+			return result, err
+		}
+
+		slog.Info("Pulled events", "peer", c.Peer, "count", imported.Inserted+imported.Skipped, "inserted", imported.Inserted)
+	}
+}
+
+// Sync pulls and then pushes, so both sides end up with the same events. Events that were pulled
+// are stored after the ones that were not pushed yet, they are not sent back to the peer.
+func (c *Client) Sync(ctx context.Context, storage db.SyncStorage) (pulled, pushed Result, err error) {
+	received := make(map[db.EventIdentity]bool)
+
+	pulled, err = c.pull(ctx, storage, received)
+	if err != nil {
+		return pulled, pushed, err
+	}
+
+	pushed, err = c.push(ctx, storage, received)
+
+	return pulled, pushed, err
+}
+
+// recording adds identities of events that are read to received.
+func recording(
+	events iter.Seq2[model.KeyEventWithTimestamp, error], received map[db.EventIdentity]bool,
+) iter.Seq2[model.KeyEventWithTimestamp, error] {
+	return func(yield func(model.KeyEventWithTimestamp, error) bool) {
+		for e, err := range events {
+			if err == nil {
+				received[db.IdentityOf(e)] = true
+			}
+
+			if !yield(e, err) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package netsync_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/model"
+	"github.com/dasdy/glover/netsync"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStorage(t *testing.T, positions ...model.KeyPosition) *db.SQLiteStorage {
+	t.Helper()
+
+	storage, err := db.NewStorageFromPath(t.TempDir()+"/keypresses.sqlite", false)
+	require.NoError(t, err)
+	t.Cleanup(storage.Close)
+
+	for _, p := range positions {
+		for _, pressed := range []bool{true, false} {
+			require.NoError(t, storage.Store(&model.KeyEvent{Row: 1, Col: int(p), Position: p, Pressed: pressed}))
+		}
+	}
+
+	return storage
+}
+
+func counts(t *testing.T, storage *db.SQLiteStorage) map[model.KeyPosition]int {
+	t.Helper()
+
+	stats, err := storage.GatherAll()
+	require.NoError(t, err)
+
+	result := make(map[model.KeyPosition]int)
+	for _, s := range stats {
+		result[s.Position] += s.Count
+	}
+
+	return result
+}
+
+func TestSync(t *testing.T) {
+	central := newStorage(t, 1, 2)
+	laptop := newStorage(t, 3)
+
+	server := httptest.NewServer(netsync.NewServer(central, "").Handler())
+	defer server.Close()
+
+	client := netsync.NewClient(server.URL, "")
+	client.BatchSize = 1
+
+	pulled, pushed, err := client.Sync(context.Background(), laptop)
+	require.NoError(t, err)
+	assert.Equal(t, netsync.Result{Events: 4, Inserted: 4}, pulled)
+	assert.Equal(t, netsync.Result{Events: 2, Inserted: 2}, pushed, "pulled events are not sent back")
+
+	expected := map[model.KeyPosition]int{1: 1, 2: 1, 3: 1}
+	assert.Equal(t, expected, counts(t, central))
+	assert.Equal(t, expected, counts(t, laptop))
+
+	pulled, pushed, err = client.Sync(context.Background(), laptop)
+	require.NoError(t, err)
+	assert.Equal(t, netsync.Result{}, pulled, "events pushed by the laptop are not pulled back")
+	assert.Equal(t, netsync.Result{}, pushed)
+
+	assert.Equal(t, expected, counts(t, central))
+	assert.Equal(t, expected, counts(t, laptop))
+}
+
+func TestPushAfterEventsOfPeer(t *testing.T) {
+	central := newStorage(t, 1)
+	laptop := newStorage(t)
+
+	server := httptest.NewServer(netsync.NewServer(central, "").Handler())
+	defer server.Close()
+
+	client := netsync.NewClient(server.URL, "")
+
+	_, _, err := client.Sync(context.Background(), laptop)
+	require.NoError(t, err)
+
+	require.NoError(t, central.Store(&model.KeyEvent{Row: 1, Col: 2, Position: 2, Pressed: true}))
+	require.NoError(t, laptop.Store(&model.KeyEvent{Row: 1, Col: 3, Position: 3, Pressed: true}))
+
+	pushed, err := client.Push(context.Background(), laptop)
+	require.NoError(t, err)
+	assert.Equal(t, netsync.Result{Events: 1, Inserted: 1}, pushed)
+
+	pulled, err := client.Pull(context.Background(), laptop)
+	require.NoError(t, err)
+	assert.Equal(t, netsync.Result{Events: 2, Inserted: 1, Skipped: 1}, pulled,
+		"events the peer stored before the push are still pulled")
+}
+
+func TestSyncSecret(t *testing.T) {
+	central := newStorage(t, 1)
+	laptop := newStorage(t)
+
+	server := httptest.NewServer(netsync.NewServer(central, "s3cret").Handler())
+	defer server.Close()
+
+	_, err := netsync.NewClient(server.URL, "wrong").Pull(context.Background(), laptop)
+	require.ErrorContains(t, err, "401")
+
+	result, err := netsync.NewClient(server.URL+"/", "s3cret").Pull(context.Background(), laptop)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Inserted)
+}
@@ -0,0 +1,167 @@
+// Package netsync exchanges key events between glover databases over http, so several machines
+// can keep the same history without copying sqlite files around.
+package netsync
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/dasdy/glover/archive"
+	"github.com/dasdy/glover/db"
+)
+
+const (
+	EventsPath   = "/sync/events"
+	CursorHeader = "X-Glover-Cursor"
+
+	defaultBatchSize = 5000
+	maxBatchSize     = 50000
+	maxPushBodySize  = 64 << 20
+)
+
+// PushResult is the response to a push request.
+type PushResult struct {
+	Inserted int `json:"inserted"`
+	Skipped  int `json:"skipped"`
+	// Cursor is set when the pushed events directly follow the cursor given in the "after" query
+	// parameter. The client can continue pulling from it without downloading its own events back.
+	Cursor int64 `json:"cursor,omitempty"`
+}
+
+type Server struct {
+	storage db.SyncStorage
+	secret  string
+}
+
+// NewServer serves events of the storage. Requests must carry the secret as a bearer token
+// unless it is empty.
+func NewServer(storage db.SyncStorage, secret string) *Server {
+	return &Server{storage: storage, secret: secret}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+EventsPath, s.authorized(s.handlePull))
+	mux.HandleFunc("POST "+EventsPath, s.authorized(s.handlePush))
+
+	return mux
+}
+
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.secret != "" {
+			expected := []byte("Bearer " + s.secret)
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+func queryInt(r *http.Request, name string, fallback int64) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid %s '%s'", name, value)
+	}
+
+	return parsed, nil
+}
+
+// handlePull returns a batch of events after the cursor as json lines. The cursor of the last
+// event is returned in a header, so the client can continue from it.
+func (s *Server) handlePull(w http.ResponseWriter, r *http.Request) {
+	after, err := queryInt(r, "after", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	limit, err := queryInt(r, "limit", defaultBatchSize)
+	if err != nil || limit == 0 {
+		http.Error(w, "invalid limit", http.StatusBadRequest)
+
+		return
+	}
+
+	events, cursor, err := s.storage.EventsAfter(after, int(min(limit, maxBatchSize)))
+	if err != nil {
+		slog.Error("could not read events", "error", err)
+		http.Error(w, "could not read events", http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set(CursorHeader, strconv.FormatInt(cursor, 10))
+
+	if _, err := archive.WriteAll(w, archive.FormatJSONL, slices.Values(events)); err != nil {
+		slog.Error("could not write events", "error", err)
+	}
+}
+
+// handlePush stores events from the request body, skipping the ones that are already known.
+func (s *Server) handlePush(w http.ResponseWriter, r *http.Request) {
+	after, err := queryInt(r, "after", -1)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxPushBodySize)
+
+	result, err := s.storage.Import(archive.Read(body, archive.FormatJSONL))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not import events: %v", err), http.StatusBadRequest)
+
+		return
+	}
+
+	slog.Info("Received events", "inserted", result.Inserted, "skipped", result.Skipped, "remote", r.RemoteAddr)
+
+	pushed := PushResult{Inserted: result.Inserted, Skipped: result.Skipped}
+
+	if after >= 0 && result.Inserted > 0 {
+		pushed.Cursor, err = s.cursorPast(after, result)
+		if err != nil {
+			slog.Error("could not read events", "error", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	err = json.NewEncoder(w).Encode(pushed)
+	if err != nil {
+		slog.Error("could not write response", "error", err)
+	}
+}
+
+// cursorPast returns the cursor of the last imported event if the imported events are the first
+// ones after the given cursor, and zero otherwise.
+func (s *Server) cursorPast(after int64, result db.ImportResult) (int64, error) {
+	_, next, err := s.storage.EventsAfter(after, 1)
+	if err != nil {
+		return 0, fmt.Errorf("could not read event after cursor %d: %w", after, err)
+	}
+
+	if next != result.First {
+		return 0, nil
+	}
+
+	return result.Last, nil
+}
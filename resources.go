@@ -0,0 +1,16 @@
+// Package glover holds resources that are embedded into the binary, so it does not depend on
+// the working directory or the source tree being around.
+package glover
+
+import "embed"
+
+// Assets contains css and js files served by the web interface.
+//
+//go:embed assets/css/*.css assets/js/*.js
+var Assets embed.FS
+
+// Data contains default keymap and layout files. Paths are the same as the default values
+// of --keymap-file and --info-json-file flags.
+//
+//go:embed data/glove80.keymap data/info.json
+var Data embed.FS
@@ -0,0 +1,125 @@
+package stats
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dasdy/glover/model"
+)
+
+type Format string
+
+const (
+	FormatTable Format = "table"
+	FormatCSV   Format = "csv"
+	FormatJSON  Format = "json"
+)
+
+type section struct {
+	name  string
+	title string
+	rows  []Row
+}
+
+func (r *Report) sections() []section {
+	return []section{
+		{name: "keys", title: "Top keys", rows: r.Keys},
+		{name: "combos", title: "Top combos", rows: r.Combos},
+		{name: "neighbors", title: "Top neighbors", rows: r.Neighbors},
+		{name: "daily", title: "Presses per day", rows: r.Daily},
+		{name: "hands", title: "Presses per hand", rows: r.Hands},
+	}
+}
+
+func positions(keys []model.KeyPosition) string {
+	result := make([]string, len(keys))
+	for i, k := range keys {
+		result[i] = strconv.Itoa(int(k))
+	}
+
+	return strings.Join(result, " ")
+}
+
+// ParseFormat checks that the format is one of the supported ones.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatTable, FormatCSV, FormatJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format '%s': expected one of %s, %s, %s", s, FormatTable, FormatCSV, FormatJSON)
+	}
+}
+
+// Write prints the report in the given format.
+func Write(w io.Writer, format Format, r *Report) error {
+	switch format {
+	case FormatTable:
+		return writeTable(w, r)
+	case FormatCSV:
+		return writeCSV(w, r)
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(r); err != nil {
+			return fmt.Errorf("could not encode report: %w", err)
+		}
+
+		return nil
+	default:
+		_, err := ParseFormat(string(format))
+
+		return err
+	}
+}
+
+func writeTable(w io.Writer, r *Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Total presses: %d\n", r.Total)
+
+	for _, s := range r.sections() {
+		fmt.Fprintf(tw, "\n%s\n", s.title)
+
+		if len(s.rows) == 0 {
+			fmt.Fprintln(tw, "  (none)")
+		}
+
+		for _, row := range s.rows {
+			fmt.Fprintf(tw, "%s\t%d\n", row.Label, row.Count)
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("could not write table: %w", err)
+	}
+
+	return nil
+}
+
+// writeCSV puts all sections into a single table, so it can be filtered by the first column.
+func writeCSV(w io.Writer, r *Report) error {
+	cw := csv.NewWriter(w)
+
+	records := [][]string{
+		{"section", "label", "positions", "count"},
+		{"total", "total", "", strconv.Itoa(r.Total)},
+	}
+
+	for _, s := range r.sections() {
+		for _, row := range s.rows {
+			records = append(records, []string{s.name, row.Label, positions(row.Positions), strconv.Itoa(row.Count)})
+		}
+	}
+
+	if err := cw.WriteAll(records); err != nil {
+		return fmt.Errorf("could not write csv: %w", err)
+	}
+
+	return nil
+}
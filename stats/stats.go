@@ -0,0 +1,180 @@
+// Package stats summarizes the history for the command line, without going through the web interface.
+package stats
+
+import (
+	"cmp"
+	"iter"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dasdy/glover/layout"
+	"github.com/dasdy/glover/model"
+)
+
+const (
+	HandLeft    = "left"
+	HandRight   = "right"
+	HandUnknown = "unknown"
+)
+
+// Row is a single line of any of the report sections.
+type Row struct {
+	Label     string              `json:"label"`
+	Positions []model.KeyPosition `json:"positions,omitempty"`
+	Count     int                 `json:"count"`
+}
+
+type Report struct {
+	Since     *time.Time `json:"since,omitempty"`
+	Until     *time.Time `json:"until,omitempty"`
+	Total     int        `json:"total"`
+	Keys      []Row      `json:"keys"`
+	Combos    []Row      `json:"combos"`
+	Neighbors []Row      `json:"neighbors"`
+	Daily     []Row      `json:"daily"`
+	Hands     []Row      `json:"hands"`
+}
+
+// Options limit what gets into the report. Zero times leave the range open, zero limit keeps all rows.
+type Options struct {
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+// Hand tells which half of the keyboard the key belongs to. Split keyboards have an even
+// amount of columns, so the left half is everything before the middle.
+func Hand(l *model.KeyboardLayout, position model.KeyPosition) string {
+	if l == nil {
+		return HandUnknown
+	}
+
+	loc, ok := l.Locations[position]
+	if !ok {
+		return HandUnknown
+	}
+
+	if loc.Col < l.Cols/2 {
+		return HandLeft
+	}
+
+	return HandRight
+}
+
+func label(names []string, keys []model.KeyPosition, separator string) string {
+	labels := make([]string, len(keys))
+	for i, k := range keys {
+		labels[i] = layout.KeyLabel(names, k)
+	}
+
+	return strings.Join(labels, separator)
+}
+
+func top(rows []Row, limit int) []Row {
+	slices.SortStableFunc(rows, func(a, b Row) int {
+		return cmp.Or(-cmp.Compare(a.Count, b.Count), cmp.Compare(a.Label, b.Label))
+	})
+
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	return rows
+}
+
+func comboRows(names []string, combos []model.Combo, separator string, limit int) []Row {
+	rows := make([]Row, 0, len(combos))
+	for _, c := range combos {
+		rows = append(rows, Row{Label: label(names, c.Keys, separator), Positions: c.Keys, Count: c.Pressed})
+	}
+
+	return top(rows, limit)
+}
+
+// Count is how many times a key was released during a period that starts at Time: a single event,
+// or an hour or a day kept in rollups.
+type Count struct {
+	Time     time.Time
+	Position model.KeyPosition
+	Count    int
+}
+
+// Releases counts releases of the events, same as SQLiteStorage.GatherAll does.
+func Releases(events iter.Seq[model.KeyEventWithTimestamp]) iter.Seq[Count] {
+	return func(yield func(Count) bool) {
+		for e := range events {
+			if !e.Pressed && !yield(Count{Time: e.Timestamp, Position: e.Position, Count: 1}) {
+				return
+			}
+		}
+	}
+}
+
+// Build sums key releases and formats combos gathered by trackers over the same history.
+// Neighbors are expected in the tracker order, {next, previous}, and are reported in press order.
+// Days are taken in the local time zone, by the time periods of counts start.
+func Build(
+	counts iter.Seq[Count],
+	combos, neighbors []model.Combo,
+	names []string,
+	l *model.KeyboardLayout,
+	opts Options,
+) *Report {
+	perKey := make(map[model.KeyPosition]int)
+	perDay := make(map[string]int)
+	perHand := make(map[string]int)
+	total := 0
+
+	for c := range counts {
+		total += c.Count
+		perKey[c.Position] += c.Count
+		perDay[c.Time.Local().Format(time.DateOnly)] += c.Count
+		perHand[Hand(l, c.Position)] += c.Count
+	}
+
+	keys := make([]Row, 0, len(perKey))
+	for pos, count := range perKey {
+		keys = append(keys, Row{Label: layout.KeyLabel(names, pos), Positions: []model.KeyPosition{pos}, Count: count})
+	}
+
+	daily := make([]Row, 0, len(perDay))
+	for day, count := range perDay {
+		daily = append(daily, Row{Label: day, Count: count})
+	}
+
+	slices.SortFunc(daily, func(a, b Row) int { return cmp.Compare(a.Label, b.Label) })
+
+	hands := make([]Row, 0, len(perHand))
+	for _, hand := range []string{HandLeft, HandRight, HandUnknown} {
+		if count, ok := perHand[hand]; ok || hand != HandUnknown {
+			hands = append(hands, Row{Label: hand, Count: count})
+		}
+	}
+
+	inPressOrder := make([]model.Combo, len(neighbors))
+	for i, n := range neighbors {
+		pair := slices.Clone(n.Keys)
+		slices.Reverse(pair)
+		inPressOrder[i] = model.Combo{Keys: pair, Pressed: n.Pressed}
+	}
+
+	return &Report{
+		Since:     optionalTime(opts.Since),
+		Until:     optionalTime(opts.Until),
+		Total:     total,
+		Keys:      top(keys, opts.Limit),
+		Combos:    comboRows(names, combos, " + ", opts.Limit),
+		Neighbors: comboRows(names, inPressOrder, " -> ", opts.Limit),
+		Daily:     daily,
+		Hands:     hands,
+	}
+}
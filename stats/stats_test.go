@@ -0,0 +1,132 @@
+package stats_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/model"
+	"github.com/dasdy/glover/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLayout() *model.KeyboardLayout {
+	return &model.KeyboardLayout{
+		Locations: map[model.KeyPosition]model.Location{
+			0: {RowCol: model.RowCol{Row: 0, Col: 0}},
+			1: {RowCol: model.RowCol{Row: 0, Col: 3}},
+		},
+		Rows: 1,
+		Cols: 4,
+	}
+}
+
+func release(position model.KeyPosition, ts time.Time) model.KeyEventWithTimestamp {
+	return model.KeyEventWithTimestamp{Position: position, Pressed: false, Timestamp: ts}
+}
+
+func testReport(limit int) *stats.Report {
+	day1 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+
+	events := []model.KeyEventWithTimestamp{
+		{Position: 0, Pressed: true, Timestamp: day1},
+		release(0, day1),
+		release(0, day1),
+		release(1, day2),
+		release(5, day2),
+	}
+
+	combos := []model.Combo{{Keys: []model.KeyPosition{0, 1}, Pressed: 2}}
+	neighbors := []model.Combo{
+		{Keys: []model.KeyPosition{1, 0}, Pressed: 3},
+		{Keys: []model.KeyPosition{0, 1}, Pressed: 1},
+	}
+
+	return stats.Build(stats.Releases(slices.Values(events)), combos, neighbors, []string{"A", "B"}, testLayout(),
+		stats.Options{Limit: limit})
+}
+
+func TestBuild(t *testing.T) {
+	r := testReport(0)
+
+	assert.Equal(t, 4, r.Total)
+	assert.Equal(t, []stats.Row{
+		{Label: "A", Positions: []model.KeyPosition{0}, Count: 2},
+		{Label: "#5", Positions: []model.KeyPosition{5}, Count: 1},
+		{Label: "B", Positions: []model.KeyPosition{1}, Count: 1},
+	}, r.Keys)
+	assert.Equal(t, []stats.Row{{Label: "A + B", Positions: []model.KeyPosition{0, 1}, Count: 2}}, r.Combos)
+	assert.Equal(t, []stats.Row{
+		{Label: "A -> B", Positions: []model.KeyPosition{0, 1}, Count: 3},
+		{Label: "B -> A", Positions: []model.KeyPosition{1, 0}, Count: 1},
+	}, r.Neighbors)
+	assert.Equal(t, []stats.Row{{Label: "2024-03-01", Count: 2}, {Label: "2024-03-02", Count: 2}}, r.Daily)
+	assert.Equal(t, []stats.Row{
+		{Label: stats.HandLeft, Count: 2},
+		{Label: stats.HandRight, Count: 1},
+		{Label: stats.HandUnknown, Count: 1},
+	}, r.Hands)
+	assert.Nil(t, r.Since)
+}
+
+func TestBuildCounts(t *testing.T) {
+	hour := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	counts := []stats.Count{
+		{Time: hour, Position: 0, Count: 5},
+		{Time: hour.AddDate(0, 0, 1), Position: 0, Count: 1},
+	}
+
+	r := stats.Build(slices.Values(counts), nil, nil, []string{"A"}, testLayout(), stats.Options{})
+
+	assert.Equal(t, 6, r.Total)
+	assert.Equal(t, []stats.Row{{Label: "A", Positions: []model.KeyPosition{0}, Count: 6}}, r.Keys)
+	assert.Equal(t, []stats.Row{{Label: "2024-03-01", Count: 5}, {Label: "2024-03-02", Count: 1}}, r.Daily)
+}
+
+func TestBuildLimit(t *testing.T) {
+	r := testReport(1)
+
+	assert.Len(t, r.Keys, 1)
+	assert.Len(t, r.Neighbors, 1)
+	assert.Len(t, r.Daily, 2, "limit only applies to top tables")
+}
+
+func TestWrite(t *testing.T) {
+	r := testReport(0)
+
+	t.Run("csv", func(t *testing.T) {
+		var b bytes.Buffer
+		require.NoError(t, stats.Write(&b, stats.FormatCSV, r))
+
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		assert.Equal(t, "section,label,positions,count", lines[0])
+		assert.Equal(t, "total,total,,4", lines[1])
+		assert.Contains(t, lines, "combos,A + B,0 1,2")
+		assert.Contains(t, lines, "hands,left,,2")
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var b bytes.Buffer
+		require.NoError(t, stats.Write(&b, stats.FormatJSON, r))
+
+		var decoded stats.Report
+		require.NoError(t, json.Unmarshal(b.Bytes(), &decoded))
+		assert.Equal(t, *r, decoded)
+	})
+
+	t.Run("table", func(t *testing.T) {
+		var b bytes.Buffer
+		require.NoError(t, stats.Write(&b, stats.FormatTable, r))
+
+		assert.Contains(t, b.String(), "Top neighbors\nA -> B")
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		require.Error(t, stats.Write(&bytes.Buffer{}, "xml", r))
+	})
+}
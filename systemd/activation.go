@@ -0,0 +1,57 @@
+//go:build linux
+
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd, after stdin, stdout and stderr.
+const listenFDsStart = 3
+
+// Listeners returns sockets passed by systemd with socket activation, in the order of the socket
+// unit. It returns none when the process was not socket-activated. Environment variables are
+// cleared, so child processes do not take the sockets for their own.
+func Listeners() ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+
+	listeners := make([]net.Listener, 0, count)
+
+	for fd := listenFDsStart; fd < listenFDsStart+count; fd++ {
+		syscall.CloseOnExec(fd)
+
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+
+		listener, err := net.FileListener(file)
+		// FileListener duplicates the descriptor, so the original one is not needed either way.
+		file.Close()
+
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+
+			return nil, fmt.Errorf("passed file descriptor %d is not a listening socket: %w", fd, err)
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
@@ -0,0 +1,99 @@
+// Package systemd talks to systemd the way services are expected to: readiness and watchdog
+// notifications, sockets passed by socket activation, and unit files to install the service.
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// States sent with Notify.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Status describes what the service does, it is shown by systemctl status.
+func Status(status string) string {
+	return "STATUS=" + status
+}
+
+// Notify sends states to the socket systemd gave in NOTIFY_SOCKET. It returns false when the
+// service is not run by systemd, or was not asked to notify.
+func Notify(states ...string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// Names starting with @ are in the abstract namespace.
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("could not connect to notify socket %s: %w", socket, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return false, fmt.Errorf("could not notify systemd: %w", err)
+	}
+
+	return true, nil
+}
+
+// WatchdogInterval is how often systemd expects watchdog pings. It returns false when the watchdog
+// is not enabled for this process.
+func WatchdogInterval() (time.Duration, bool, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, false, nil
+	}
+
+	// The variable can be inherited from a parent, then it is not meant for us.
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false, nil
+	}
+
+	value, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || value <= 0 {
+		return 0, false, fmt.Errorf("invalid WATCHDOG_USEC '%s'", usec)
+	}
+
+	return time.Duration(value) * time.Microsecond, true, nil
+}
+
+// RunWatchdog pings systemd twice per interval until the context is done, as systemd recommends.
+// A ping is only sent while alive returns true, so systemd restarts a service that got stuck.
+func RunWatchdog(ctx context.Context, interval time.Duration, alive func() bool) error {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		if !alive() {
+			slog.Warn("Service does not respond, watchdog is not pinged")
+		} else if _, err := Notify(Watchdog); err != nil {
+			slog.Error("Could not ping watchdog", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil
+			}
+
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package systemd_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dasdy/glover/systemd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// notifySocket listens where systemd would, and points NOTIFY_SOCKET to it.
+func notifySocket(t *testing.T, name string) *net.UnixConn {
+	t.Helper()
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	t.Setenv("NOTIFY_SOCKET", name)
+
+	return conn
+}
+
+func receive(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	conn := notifySocket(t, t.TempDir()+"/notify.sock")
+
+	sent, err := systemd.Notify(systemd.Ready, systemd.Status("Tracking 2 keyboards"))
+	require.NoError(t, err)
+	assert.True(t, sent)
+	assert.Equal(t, "READY=1\nSTATUS=Tracking 2 keyboards", receive(t, conn))
+}
+
+func TestNotifyAbstractSocket(t *testing.T) {
+	name := fmt.Sprintf("@glover-test-%d", os.Getpid())
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: "\x00" + name[1:], Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", name)
+
+	sent, err := systemd.Notify(systemd.Stopping)
+	require.NoError(t, err)
+	assert.True(t, sent)
+	assert.Equal(t, "STOPPING=1", receive(t, conn))
+}
+
+func TestNotifyWithoutSystemd(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	sent, err := systemd.Notify(systemd.Ready)
+	require.NoError(t, err)
+	assert.False(t, sent)
+}
+
+func TestNotifyMissingSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", t.TempDir()+"/missing.sock")
+
+	_, err := systemd.Notify(systemd.Ready)
+	assert.Error(t, err)
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	_, enabled, err := systemd.WatchdogInterval()
+	require.NoError(t, err)
+	assert.False(t, enabled)
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	interval, enabled, err := systemd.WatchdogInterval()
+	require.NoError(t, err)
+	assert.True(t, enabled)
+	assert.Equal(t, 30*time.Second, interval)
+
+	// Inherited from a parent that is watched.
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	_, enabled, err = systemd.WatchdogInterval()
+	require.NoError(t, err)
+	assert.False(t, enabled)
+
+	t.Setenv("WATCHDOG_PID", "")
+	t.Setenv("WATCHDOG_USEC", "soon")
+	_, _, err = systemd.WatchdogInterval()
+	assert.Error(t, err)
+}
+
+func TestRunWatchdog(t *testing.T) {
+	conn := notifySocket(t, t.TempDir()+"/notify.sock")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	var alive atomic.Bool
+
+	alive.Store(true)
+
+	go func() {
+		done <- systemd.RunWatchdog(ctx, 20*time.Millisecond, alive.Load)
+	}()
+
+	for range 3 {
+		assert.Equal(t, "WATCHDOG=1", receive(t, conn))
+	}
+
+	t.Run("a stuck service is not pinged", func(t *testing.T) {
+		alive.Store(false)
+		// A ping may have been sent before.
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(15*time.Millisecond)))
+		_, _ = conn.Read(make([]byte, 4096))
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+		_, err := conn.Read(make([]byte, 4096))
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+		alive.Store(true)
+		assert.Equal(t, "WATCHDOG=1", receive(t, conn))
+	})
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestListenersWithoutActivation(t *testing.T) {
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+
+	listeners, err := systemd.Listeners()
+	require.NoError(t, err)
+	assert.Empty(t, listeners)
+}
+
+// TestListeners passes a socket to a child process like systemd does: as file descriptor 3. The child
+// is this test binary, which serves on it in TestActivatedHelper.
+func TestListeners(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("sockets are only passed by systemd on linux")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	file, err := listener.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer file.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestActivatedHelper$")
+	cmd.Env = append(os.Environ(), "GLOVER_ACTIVATED_HELPER=1", "LISTEN_FDS=1")
+	cmd.ExtraFiles = []*os.File{file}
+	require.NoError(t, cmd.Start())
+	defer cmd.Process.Kill() //nolint:errcheck // The helper exits by itself when the test passes.
+
+	resp, err := http.Get("http://" + listener.Addr().String())
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+
+	require.NoError(t, cmd.Wait())
+}
+
+func TestActivatedHelper(t *testing.T) {
+	if os.Getenv("GLOVER_ACTIVATED_HELPER") == "" {
+		t.Skip("only run by TestListeners")
+	}
+
+	// systemd sets it after the fork, the helper does it for itself.
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+
+	listeners, err := systemd.Listeners()
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	assert.Empty(t, os.Getenv("LISTEN_FDS"))
+
+	conn, err := listeners[0].Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("HTTP/1.1 418 I'm a teapot\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+}
+
+func TestServiceUnit(t *testing.T) {
+	unit := systemd.Service{
+		Description: "Glover keypress tracker",
+		ExecStart:   []string{"/opt/my apps/glover", "daemon", "--out", "/home/me/100%/keys.sqlite"},
+		Watchdog:    30 * time.Second,
+	}.Unit()
+
+	assert.Contains(t, unit, "Type=notify\n")
+	assert.Contains(t, unit, `ExecStart="/opt/my apps/glover" daemon --out /home/me/100%%/keys.sqlite`+"\n")
+	assert.Contains(t, unit, "WatchdogSec=30\n")
+	assert.Contains(t, unit, "WantedBy=default.target\n")
+
+	assert.NotContains(t, systemd.Service{ExecStart: []string{"glover"}}.Unit(), "WatchdogSec")
+}
+
+func TestSocketUnit(t *testing.T) {
+	unit := systemd.SocketUnit("Glover web interface", 3000)
+
+	assert.Contains(t, unit, "ListenStream=3000\n")
+	assert.Contains(t, unit, "WantedBy=sockets.target\n")
+}
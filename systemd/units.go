@@ -0,0 +1,78 @@
+package systemd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Service is a user service that runs a command of this program and notifies systemd when it is ready.
+type Service struct {
+	Description string
+	// ExecStart is the command with its arguments, they are quoted when written.
+	ExecStart []string
+	// Watchdog restarts the service if it does not ping for this long. Zero disables it.
+	Watchdog time.Duration
+}
+
+// Unit is the contents of the service unit file.
+func (s Service) Unit() string {
+	var b strings.Builder
+
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=%s\n", s.Description)
+	b.WriteString("Documentation=https://github.com/dasdy/glover\n")
+	b.WriteString("\n[Service]\n")
+	b.WriteString("Type=notify\n")
+	fmt.Fprintf(&b, "ExecStart=%s\n", quoteCommand(s.ExecStart))
+	b.WriteString("ExecReload=/bin/kill -HUP $MAINPID\n")
+
+	if s.Watchdog > 0 {
+		fmt.Fprintf(&b, "WatchdogSec=%d\n", int(s.Watchdog.Seconds()))
+	}
+
+	b.WriteString("Restart=on-failure\n")
+	b.WriteString("RestartSec=5\n")
+	b.WriteString("\n[Install]\n")
+	b.WriteString("WantedBy=default.target\n")
+
+	return b.String()
+}
+
+// SocketUnit is a socket that starts the service with the same name and passes it the port to listen on.
+func SocketUnit(description string, port int) string {
+	var b strings.Builder
+
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=%s\n", description)
+	b.WriteString("\n[Socket]\n")
+	fmt.Fprintf(&b, "ListenStream=%d\n", port)
+	b.WriteString("\n[Install]\n")
+	b.WriteString("WantedBy=sockets.target\n")
+
+	return b.String()
+}
+
+// quoteCommand quotes arguments the way systemd splits command lines. Specifiers and variables are
+// escaped, so paths are taken literally.
+func quoteCommand(args []string) string {
+	quoted := make([]string, len(args))
+
+	for i, arg := range args {
+		arg = strings.ReplaceAll(arg, "%", "%%")
+		arg = strings.ReplaceAll(arg, "$", "$$")
+
+		if arg != "" && !strings.ContainsAny(arg, " \t\n\"'\\;") {
+			quoted[i] = arg
+
+			continue
+		}
+
+		arg = strings.ReplaceAll(arg, `\`, `\\`)
+		arg = strings.ReplaceAll(arg, `"`, `\"`)
+		arg = strings.ReplaceAll(arg, "\n", `\n`)
+		quoted[i] = `"` + arg + `"`
+	}
+
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,307 @@
+package tui
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/layout"
+	"github.com/dasdy/glover/model"
+	cs "github.com/dasdy/glover/web/components"
+	"github.com/dasdy/glover/web/routes"
+)
+
+const topCombosCount = 5
+
+// Key is a parsed key press from the terminal: either a printable character or a name of a special key.
+type Key string
+
+const (
+	KeyUp    Key = "up"
+	KeyDown  Key = "down"
+	KeyLeft  Key = "left"
+	KeyRight Key = "right"
+	KeyEnter Key = "enter"
+	KeyTab   Key = "tab"
+	KeyEsc   Key = "esc"
+	KeyCtrlC Key = "ctrl+c"
+)
+
+// App holds the state of the terminal interface. It reuses render contexts of the web interface,
+// so numbers on the screen are the same as on the corresponding web pages.
+type App struct {
+	handler  *routes.ServerHandler
+	live     *LiveCounter
+	mode     cs.PageType
+	selected model.KeyPosition
+}
+
+func NewApp(handler *routes.ServerHandler, live *LiveCounter) *App {
+	return &App{
+		handler:  handler,
+		live:     live,
+		mode:     cs.PageTypeStats,
+		selected: firstPosition(handler.LocationsOnGrid),
+	}
+}
+
+func firstPosition(l *model.KeyboardLayout) model.KeyPosition {
+	positions := make([]model.KeyPosition, 0, len(l.Locations))
+	for p := range l.Locations {
+		positions = append(positions, p)
+	}
+
+	if len(positions) == 0 {
+		return 0
+	}
+
+	return slices.Min(positions)
+}
+
+// Mode returns current page type.
+func (a *App) Mode() cs.PageType {
+	return a.mode
+}
+
+// Selected returns position of the key under cursor.
+func (a *App) Selected() model.KeyPosition {
+	return a.selected
+}
+
+// nextMode cycles modes in the same order as the web interface links them: stats -> combo <-> neighbors.
+func (a *App) nextMode() {
+	switch a.mode {
+	case cs.PageTypeStats, cs.PageTypeNeighbors:
+		a.mode = cs.PageTypeCombo
+	case cs.PageTypeCombo:
+		a.mode = cs.PageTypeNeighbors
+	}
+}
+
+// move selects the closest key in the given direction. Only one of dRow, dCol is expected to be non-zero.
+func (a *App) move(dRow, dCol int) {
+	current, ok := a.handler.LocationsOnGrid.Locations[a.selected]
+	if !ok {
+		return
+	}
+
+	best := a.selected
+	// Keys at the same distance are told apart by position, so the map order does not pick one.
+	bestDistance := [3]int{-1, -1, -1}
+
+	for pos, loc := range a.handler.LocationsOnGrid.Locations {
+		rowDiff := (loc.Row - current.Row) * cmp.Or(dRow, 1)
+		colDiff := (loc.Col - current.Col) * cmp.Or(dCol, 1)
+
+		var distance [3]int
+
+		switch {
+		case dCol != 0 && loc.Row == current.Row && colDiff > 0:
+			distance = [3]int{colDiff, 0, int(pos)}
+		case dRow != 0 && rowDiff > 0:
+			distance = [3]int{rowDiff, abs(loc.Col - current.Col), int(pos)}
+		default:
+			continue
+		}
+
+		if bestDistance[0] < 0 || slices.Compare(distance[:], bestDistance[:]) < 0 {
+			best, bestDistance = pos, distance
+		}
+	}
+
+	a.selected = best
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
+
+// HandleKey updates the state according to the key press. Returns true if the app should exit.
+func (a *App) HandleKey(k Key) bool {
+	switch k {
+	case "q", KeyCtrlC:
+		return true
+	case KeyUp, "k":
+		a.move(-1, 0)
+	case KeyDown, "j":
+		a.move(1, 0)
+	case KeyLeft, "h":
+		a.move(0, -1)
+	case KeyRight, "l":
+		a.move(0, 1)
+	case KeyTab, "m":
+		a.nextMode()
+	case KeyEnter:
+		if a.mode == cs.PageTypeStats {
+			a.mode = cs.PageTypeCombo
+		}
+	case KeyEsc, "s":
+		a.mode = cs.PageTypeStats
+	}
+
+	return false
+}
+
+func (a *App) keyLabel(position model.KeyPosition) string {
+	return layout.KeyLabel(a.handler.KeyNames, position)
+}
+
+// topCombos formats most frequent combos. Keys are sorted by the caller.
+func (a *App) topCombos(combos []model.Combo, separator string) []string {
+	result := make([]string, 0, topCombosCount)
+
+	for _, c := range combos[:min(len(combos), topCombosCount)] {
+		labels := make([]string, len(c.Keys))
+		for i, k := range c.Keys {
+			labels[i] = a.keyLabel(k)
+		}
+
+		result = append(result, fmt.Sprintf("%-20s %d", strings.Join(labels, separator), c.Pressed))
+	}
+
+	return result
+}
+
+func sortedByPresses(combos []model.Combo) []model.Combo {
+	combos = slices.Clone(combos)
+	slices.SortFunc(combos, func(a, b model.Combo) int {
+		return -cmp.Compare(a.Pressed, b.Pressed)
+	})
+
+	return combos
+}
+
+func (a *App) renderContext() cs.RenderContext {
+	switch a.mode {
+	case cs.PageTypeCombo:
+		return a.handler.BuildCombosRenderContext(db.SnapshotOf(a.handler.ComboTracker).Combos(a.selected), a.selected)
+	case cs.PageTypeNeighbors:
+		return a.handler.BuildNeighborsRenderContext(db.SnapshotOf(a.handler.NeighborTracker).Combos(a.selected), a.selected)
+	default:
+		return a.handler.BuildStatsRenderContext(a.live.Stats())
+	}
+}
+
+// Frame renders the whole screen.
+func (a *App) Frame() string {
+	var b strings.Builder
+
+	c := a.renderContext()
+
+	b.WriteString(clearAll)
+	fmt.Fprintf(&b, "glover · mode: %s · arrows/hjkl: move · enter: combos · m/tab: switch mode · s: stats · q: quit",
+		a.mode)
+	b.WriteString(newline)
+	b.WriteString(newline)
+
+	renderGrid(&b, &c, a.selected)
+
+	b.WriteString(newline)
+
+	presses := a.live.Snapshot().Combos(a.selected)[0].Pressed
+	fmt.Fprintf(&b, "Selected: %s (position %d), %d presses", a.keyLabel(a.selected), a.selected, presses)
+	b.WriteString(newline)
+	b.WriteString(newline)
+
+	neighbors := sortedByPresses(db.SnapshotOf(a.handler.NeighborTracker).Combos(a.selected))
+	for i := range neighbors {
+		// Neighbor tracker reports pairs as {next, previous}
+		neighbors[i].Keys = []model.KeyPosition{neighbors[i].Keys[1], neighbors[i].Keys[0]}
+	}
+
+	renderCombos(&b, "Top combos:", a.topCombos(sortedByPresses(db.SnapshotOf(a.handler.ComboTracker).Combos(a.selected)), " + "))
+	renderCombos(&b, "Top neighbors:", a.topCombos(neighbors, " → "))
+
+	return b.String()
+}
+
+// ParseKeys splits raw terminal input into key presses.
+func ParseKeys(input []byte) []Key {
+	escapes := map[string]Key{
+		"\x1b[A": KeyUp,
+		"\x1b[B": KeyDown,
+		"\x1b[C": KeyRight,
+		"\x1b[D": KeyLeft,
+	}
+
+	result := make([]Key, 0, len(input))
+
+	for i := 0; i < len(input); i++ {
+		if i+3 <= len(input) {
+			if k, ok := escapes[string(input[i:i+3])]; ok {
+				result = append(result, k)
+				i += 2
+
+				continue
+			}
+		}
+
+		switch input[i] {
+		case '\r', '\n':
+			result = append(result, KeyEnter)
+		case '\t':
+			result = append(result, KeyTab)
+		case 0x1b:
+			result = append(result, KeyEsc)
+		case 0x03:
+			result = append(result, KeyCtrlC)
+		default:
+			result = append(result, Key(input[i:i+1]))
+		}
+	}
+
+	return result
+}
+
+// ReadKeys reads terminal input and sends parsed key presses to the channel until the input is closed.
+func ReadKeys(r io.Reader) <-chan Key {
+	ch := make(chan Key, 5)
+
+	go func() {
+		defer close(ch)
+
+		reader := bufio.NewReader(r)
+		buf := make([]byte, 64)
+
+		for {
+			n, err := reader.Read(buf)
+			for _, k := range ParseKeys(buf[:n]) {
+				ch <- k
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Run draws the interface and handles input until the user quits or the input is closed. Screen is
+// redrawn on every key press and whenever new key presses are counted.
+func (a *App) Run(in io.Reader, out io.Writer) error {
+	keys := ReadKeys(in)
+
+	for {
+		if _, err := io.WriteString(out, a.Frame()); err != nil {
+			return fmt.Errorf("could not draw frame: %w", err)
+		}
+
+		select {
+		case k, ok := <-keys:
+			if !ok || a.HandleKey(k) {
+				return nil
+			}
+		case <-a.live.Updated():
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package tui_test
+
+import (
+	"bytes"
+	"iter"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/model"
+	"github.com/dasdy/glover/tui"
+	"github.com/dasdy/glover/web/components"
+	"github.com/dasdy/glover/web/routes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type trackerStub struct {
+	combos map[model.KeyPosition][]model.Combo
+}
+
+func (t *trackerStub) Init(_ iter.Seq[model.KeyEventWithTimestamp]) error { return nil }
+
+func (t *trackerStub) Handle(_ model.KeyEventWithTimestamp) {}
+
+func (t *trackerStub) Reset() {}
+
+func (t *trackerStub) Snapshot() db.Snapshot {
+	return db.CombosByPosition(t.combos)
+}
+
+// tap presses and releases the key.
+func tap(tracker db.Tracker, position model.KeyPosition) {
+	tracker.Handle(model.KeyEventWithTimestamp{Position: position, Pressed: true})
+	tracker.Handle(model.KeyEventWithTimestamp{Position: position, Pressed: false})
+}
+
+// Layout of the test keyboard:
+//
+//	A B .
+//	C . D
+func setupApp(t *testing.T) (*tui.App, *tui.LiveCounter) {
+	t.Helper()
+
+	handler := &routes.ServerHandler{
+		KeyNames: []string{"A", "B", "C", "D"},
+		LocationsOnGrid: &model.KeyboardLayout{
+			Locations: map[model.KeyPosition]model.Location{
+				0: {RowCol: model.RowCol{Row: 0, Col: 0}},
+				1: {RowCol: model.RowCol{Row: 0, Col: 1}},
+				2: {RowCol: model.RowCol{Row: 1, Col: 0}},
+				3: {RowCol: model.RowCol{Row: 1, Col: 2}},
+			},
+			Rows: 2,
+			Cols: 3,
+		},
+		ComboTracker: &trackerStub{combos: map[model.KeyPosition][]model.Combo{
+			0: {{Keys: []model.KeyPosition{0, 1}, Pressed: 3}, {Keys: []model.KeyPosition{0, 3}, Pressed: 7}},
+		}},
+		NeighborTracker: &trackerStub{combos: map[model.KeyPosition][]model.Combo{
+			0: {{Keys: []model.KeyPosition{2, 0}, Pressed: 4}},
+		}},
+	}
+
+	live := tui.NewLiveCounter([]model.MinimalKeyEvent{{Position: 0, Count: 10}, {Position: 3, Count: 2}})
+
+	return tui.NewApp(handler, live), live
+}
+
+func TestParseKeys(t *testing.T) {
+	assert.Equal(t,
+		[]tui.Key{tui.KeyUp, "j", tui.KeyEnter, tui.KeyLeft, tui.KeyTab, tui.KeyEsc, tui.KeyCtrlC, "q"},
+		tui.ParseKeys([]byte("\x1b[Aj\r\x1b[D\t\x1b\x03q")))
+}
+
+func TestAppNavigation(t *testing.T) {
+	app, _ := setupApp(t)
+
+	require.Equal(t, model.KeyPosition(0), app.Selected())
+
+	app.HandleKey(tui.KeyRight)
+	assert.Equal(t, model.KeyPosition(1), app.Selected())
+
+	app.HandleKey(tui.KeyRight)
+	assert.Equal(t, model.KeyPosition(1), app.Selected(), "nothing to the right, stay in place")
+
+	app.HandleKey("j")
+	assert.Equal(t, model.KeyPosition(2), app.Selected(), "closest key in the row below")
+
+	app.HandleKey("l")
+	assert.Equal(t, model.KeyPosition(3), app.Selected(), "skips empty cells")
+
+	app.HandleKey(tui.KeyUp)
+	assert.Equal(t, model.KeyPosition(1), app.Selected())
+}
+
+func TestAppModes(t *testing.T) {
+	app, _ := setupApp(t)
+
+	assert.Equal(t, components.PageTypeStats, app.Mode())
+
+	app.HandleKey(tui.KeyEnter)
+	assert.Equal(t, components.PageTypeCombo, app.Mode())
+
+	app.HandleKey("m")
+	assert.Equal(t, components.PageTypeNeighbors, app.Mode())
+
+	app.HandleKey(tui.KeyTab)
+	assert.Equal(t, components.PageTypeCombo, app.Mode())
+
+	app.HandleKey(tui.KeyEsc)
+	assert.Equal(t, components.PageTypeStats, app.Mode())
+
+	assert.False(t, app.HandleKey("x"))
+	assert.True(t, app.HandleKey("q"))
+}
+
+func TestAppFrame(t *testing.T) {
+	app, live := setupApp(t)
+
+	frame := app.Frame()
+
+	assert.Contains(t, frame, "mode: stats")
+	assert.Contains(t, frame, "Selected: A (position 0), 10 presses")
+	// Combos are sorted by press count, neighbors are shown in order of pressing
+	assert.Regexp(t, `(?s)Top combos:.*A \+ D\s+7.*A \+ B\s+3.*Top neighbors:.*A → C\s+4`, frame)
+
+	tap(live, 0)
+
+	assert.Contains(t, app.Frame(), "Selected: A (position 0), 11 presses")
+
+	app.HandleKey(tui.KeyEnter)
+
+	// Combo page counts presses of each key together with the selected one
+	frame = app.Frame()
+	assert.Contains(t, frame, "mode: combo")
+	assert.Contains(t, frame, "10    ")
+}
+
+func TestLiveCounter(t *testing.T) {
+	live := tui.NewLiveCounter(nil)
+
+	live.Handle(model.KeyEventWithTimestamp{Position: 1, Pressed: true})
+	assert.Empty(t, live.Updated(), "key press is not counted until release")
+
+	live.Handle(model.KeyEventWithTimestamp{Position: 1, Pressed: false})
+	live.Handle(model.KeyEventWithTimestamp{Position: 1, Pressed: false})
+
+	assert.Len(t, live.Updated(), 1, "notifications do not pile up")
+	assert.Equal(t, []model.MinimalKeyEvent{{Position: 1, Count: 2}}, live.Stats())
+
+	snapshot := live.Snapshot()
+	tap(live, 1)
+	assert.Equal(t, []model.Combo{{Keys: []model.KeyPosition{1}, Pressed: 2}}, snapshot.Combos(1))
+	assert.Equal(t, []model.Combo{{Keys: []model.KeyPosition{2}, Pressed: 0}}, snapshot.Combos(2))
+
+	require.NoError(t, live.Init(slices.Values([]model.KeyEventWithTimestamp{
+		{Position: 4, Pressed: true},
+		{Position: 4, Pressed: false},
+	})))
+	assert.Equal(t, []model.MinimalKeyEvent{{Position: 4, Count: 1}}, live.Stats(), "history replaces counts")
+}
+
+func TestAppRun(t *testing.T) {
+	app, _ := setupApp(t)
+
+	var out bytes.Buffer
+
+	require.NoError(t, app.Run(strings.NewReader("lq"), &out))
+
+	assert.Contains(t, out.String(), "Selected: B (position 1)")
+}
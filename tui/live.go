@@ -0,0 +1,123 @@
+package tui
+
+import (
+	"iter"
+	"maps"
+	"sync"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/model"
+)
+
+// LiveCounter counts key presses as they arrive from keylog.Loop. It implements db.Tracker so
+// it can be added to the same tracker set as combo and neighbor trackers.
+type LiveCounter struct {
+	counts  map[model.KeyPosition]int
+	lock    sync.RWMutex
+	updated chan struct{}
+}
+
+func NewLiveCounter(initial []model.MinimalKeyEvent) *LiveCounter {
+	counts := make(map[model.KeyPosition]int)
+	for _, e := range initial {
+		counts[e.Position] += e.Count
+	}
+
+	return &LiveCounter{
+		counts:  counts,
+		lock:    sync.RWMutex{},
+		updated: make(chan struct{}, 1),
+	}
+}
+
+// Init counts key presses of the history, same as SQLiteStorage.GatherAll does.
+func (l *LiveCounter) Init(history iter.Seq[model.KeyEventWithTimestamp]) error {
+	counts := make(map[model.KeyPosition]int)
+
+	for event := range history {
+		if !event.Pressed {
+			counts[event.Position]++
+		}
+	}
+
+	l.lock.Lock()
+	l.counts = counts
+	l.lock.Unlock()
+
+	l.notify()
+
+	return nil
+}
+
+// Handle counts key releases, same as SQLiteStorage.GatherAll does.
+func (l *LiveCounter) Handle(event model.KeyEventWithTimestamp) {
+	if event.Pressed {
+		return
+	}
+
+	l.lock.Lock()
+	l.counts[event.Position]++
+	l.lock.Unlock()
+
+	l.notify()
+}
+
+// Seed adds key releases kept in rollups to what was counted.
+func (l *LiveCounter) Seed(rollup *db.Rollup) {
+	l.lock.Lock()
+	for _, key := range rollup.Keys {
+		l.counts[key.Position] += key.Count
+	}
+	l.lock.Unlock()
+
+	l.notify()
+}
+
+// Snapshot returns single-key "combos" with the amount of presses of each position.
+func (l *LiveCounter) Snapshot() db.Snapshot {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return liveSnapshot(maps.Clone(l.counts))
+}
+
+func (l *LiveCounter) Reset() {
+	l.lock.Lock()
+	l.counts = make(map[model.KeyPosition]int)
+	l.lock.Unlock()
+
+	l.notify()
+}
+
+// notify does not block the event loop if the interface has not caught up with previous update yet.
+func (l *LiveCounter) notify() {
+	select {
+	case l.updated <- struct{}{}:
+	default:
+	}
+}
+
+type liveSnapshot map[model.KeyPosition]int
+
+// Combos returns a single combo even for positions that were never pressed.
+func (s liveSnapshot) Combos(position model.KeyPosition) []model.Combo {
+	return []model.Combo{{Keys: []model.KeyPosition{position}, Pressed: s[position]}}
+}
+
+// Stats returns current counts in the same shape as Storage.GatherAll.
+func (l *LiveCounter) Stats() []model.MinimalKeyEvent {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	result := make([]model.MinimalKeyEvent, 0, len(l.counts))
+	for pos, count := range l.counts {
+		result = append(result, model.MinimalKeyEvent{Position: pos, Count: count})
+	}
+
+	return result
+}
+
+// Updated returns a channel that receives a value after new key presses were counted.
+func (l *LiveCounter) Updated() <-chan struct{} {
+	return l.updated
+}
@@ -0,0 +1,121 @@
+package tui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dasdy/glover/model"
+	cs "github.com/dasdy/glover/web/components"
+	"github.com/rivo/uniseg"
+)
+
+const (
+	cellWidth = 7
+	newline   = "\r\n" // Terminal is in raw mode, so carriage return is not implied
+	reset     = "\x1b[0m"
+	clearAll  = "\x1b[H\x1b[2J"
+)
+
+func background(count, maxVal int) string {
+	value := 0.0
+	if maxVal > 0 {
+		value = float64(count) / float64(maxVal)
+	}
+
+	c := cs.HeatColor(value)
+
+	return fmt.Sprintf("\x1b[48;2;%d;%d;%dm\x1b[38;2;15;23;42m", c.R, c.G, c.B)
+}
+
+// fit truncates or pads the string so it takes exactly width terminal columns.
+func fit(s string, width int) string {
+	var b strings.Builder
+
+	used := 0
+	gr := uniseg.NewGraphemes(s)
+
+	for gr.Next() {
+		w := gr.Width()
+		if used+w > width {
+			break
+		}
+
+		b.WriteString(gr.Str())
+
+		used += w
+	}
+
+	return b.String() + strings.Repeat(" ", width-used)
+}
+
+// grid places keys by their row and column in the layout. Missing keys are nil.
+func grid(c *cs.RenderContext) [][]*cs.Item {
+	rows := make([][]*cs.Item, c.TotalRows)
+	for i := range rows {
+		rows[i] = make([]*cs.Item, c.TotalCols)
+	}
+
+	for i := range c.Items {
+		item := &c.Items[i]
+		loc := item.Location
+
+		if loc.Row < 0 || loc.Row >= c.TotalRows || loc.Col < 0 || loc.Col >= c.TotalCols {
+			continue
+		}
+
+		rows[loc.Row][loc.Col] = item
+	}
+
+	return rows
+}
+
+// renderGrid draws every key as a two-line cell: label on top and the count below it.
+func renderGrid(b *strings.Builder, c *cs.RenderContext, selected model.KeyPosition) {
+	for _, row := range grid(c) {
+		for line := range 2 {
+			for _, item := range row {
+				if item == nil {
+					b.WriteString(strings.Repeat(" ", cellWidth))
+
+					continue
+				}
+
+				count, _ := strconv.Atoi(item.KeypressAmount)
+
+				text := item.KeyName
+				if line == 1 {
+					text = item.KeypressAmount
+				}
+
+				style := background(count, c.MaxVal)
+				if item.Position == selected {
+					style += "\x1b[1;7m"
+				}
+
+				b.WriteString(style)
+				b.WriteString(fit(text, cellWidth-1))
+				b.WriteString(reset)
+				b.WriteString(" ")
+			}
+
+			b.WriteString(newline)
+		}
+	}
+}
+
+func renderCombos(b *strings.Builder, title string, combos []string) {
+	b.WriteString(title)
+	b.WriteString(newline)
+
+	if len(combos) == 0 {
+		b.WriteString("  (none)")
+		b.WriteString(newline)
+	}
+
+	for _, c := range combos {
+		b.WriteString("  ")
+		b.WriteString(c)
+		b.WriteString(newline)
+	}
+}
@@ -0,0 +1,40 @@
+package tui
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+const (
+	enterAltScreen = "\x1b[?1049h\x1b[?25l"
+	leaveAltScreen = "\x1b[?25h\x1b[?1049l"
+)
+
+// RunInTerminal switches the terminal into raw mode and alternate screen for the duration of the app,
+// and restores it afterwards.
+func RunInTerminal(app *App, f *os.File) error {
+	fd := int(f.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("%s is not a terminal", f.Name())
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("could not switch terminal to raw mode: %w", err)
+	}
+
+	//nolint:errcheck
+	defer term.Restore(fd, state)
+
+	if _, err := io.WriteString(f, enterAltScreen); err != nil {
+		return fmt.Errorf("could not prepare terminal: %w", err)
+	}
+
+	//nolint:errcheck
+	defer io.WriteString(f, leaveAltScreen)
+
+	return app.Run(f, f)
+}
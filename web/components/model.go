@@ -8,6 +8,7 @@ type Item struct {
 	KeyName        string
 	KeypressAmount string
 	Highlight      bool
+	Fill           string // Baked-in fill color, used when rendering without colorize.js
 }
 
 type ComboConnection struct {
@@ -15,6 +16,13 @@ type ComboConnection struct {
 	ToPosition   model.KeyPosition
 	PressCount   int
 }
+
+// StaticPath is a pre-computed connection path for rendering without javascript.
+type StaticPath struct {
+	D           string
+	StrokeWidth string
+}
+
 type PageType string
 
 const (
@@ -29,6 +37,9 @@ const (
 	KeyCenterOffset   = KeySizeWithoutGap / 2
 )
 
+// NoHighlight is HighlightPosition of pages that do not highlight a key. Position 0 is a key as well.
+const NoHighlight model.KeyPosition = -1
+
 type RenderContext struct {
 	TotalCols int
 	TotalRows int
@@ -36,6 +47,14 @@ type RenderContext struct {
 	MaxVal    int
 	Page      PageType
 
-	HighlightPosition model.KeyPosition // The position being highlighted
+	HighlightPosition model.KeyPosition // The position being highlighted, NoHighlight on pages without one
 	ComboConnections  []ComboConnection // Top 5 combo connections for highlighted key
+
+	Static bool // Colors and connection paths are baked in, so svg can be viewed without colorize.js
+
+	Keyboard  string   // Keyboard the page is about, kept in links. Empty when no keyboards are configured
+	Keyboards []string // All keyboards to switch between
+
+	Source  string   // Source the page is about, kept in links. Empty shows all of them
+	Sources []string // All sources to switch between, when several storages are shown at once
 }
@@ -135,3 +135,16 @@ func TestRenderContext_ViewBoxSize(t *testing.T) {
 		})
 	}
 }
+
+func TestKeyboardLink(t *testing.T) {
+	assert.Equal(t, "/combo?position=3", components.KeyboardLink("/combo?position=3", ""))
+	assert.Equal(t, "/combo?position=3&keyboard=glove80", components.KeyboardLink("/combo?position=3", "glove80"))
+	assert.Equal(t, "/?keyboard=left+hand", components.KeyboardLink("/", "left hand"))
+
+	c := components.RenderContext{Keyboard: "numpad"}
+	assert.Equal(t, "/?keyboard=numpad", c.WithKeyboard("/"))
+
+	c.Source = "work/laptop"
+	assert.Equal(t, "/combo?position=3&keyboard=numpad&source=work%2Flaptop", c.WithKeyboard("/combo?position=3"))
+	assert.Equal(t, "/?source=home", components.SourceLink("/", "home"))
+}
@@ -0,0 +1,79 @@
+package components
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	ReportChartWidth  = 800
+	ReportChartHeight = 200
+)
+
+// ReportRow is a single labeled value in one of the report tables or charts.
+type ReportRow struct {
+	Label string
+	Count int
+}
+
+// ReportContext holds everything needed to render a standalone html report.
+type ReportContext struct {
+	Title        string
+	GeneratedAt  string
+	TotalPresses int
+
+	Heatmap      *RenderContext // Expected to have colors baked in
+	TopKeys      []ReportRow
+	TopCombos    []ReportRow
+	TopNeighbors []ReportRow
+	Daily        []ReportRow
+}
+
+func maxRowCount(rows []ReportRow) int {
+	result := 0
+
+	for _, r := range rows {
+		result = max(result, r.Count)
+	}
+
+	return result
+}
+
+// barPercent returns width of a horizontal bar, relative to the biggest row in the table.
+func barPercent(row ReportRow, rows []ReportRow) string {
+	maxVal := maxRowCount(rows)
+	if maxVal == 0 {
+		return "0%"
+	}
+
+	return fmt.Sprintf("%.1f%%", 100*float64(row.Count)/float64(maxVal))
+}
+
+// dailyBar describes a single bar of the per-day chart in svg coordinates.
+type dailyBar struct {
+	X, Y, Width, Height string
+	Title               string
+}
+
+func dailyBars(rows []ReportRow) []dailyBar {
+	maxVal := maxRowCount(rows)
+	if maxVal == 0 || len(rows) == 0 {
+		return nil
+	}
+
+	width := float64(ReportChartWidth) / float64(len(rows))
+	result := make([]dailyBar, 0, len(rows))
+
+	for i, r := range rows {
+		height := float64(ReportChartHeight) * float64(r.Count) / float64(maxVal)
+		result = append(result, dailyBar{
+			X:      strconv.FormatFloat(float64(i)*width, 'f', 2, 64),
+			Y:      strconv.FormatFloat(ReportChartHeight-height, 'f', 2, 64),
+			Width:  strconv.FormatFloat(max(width-1, 1), 'f', 2, 64),
+			Height: strconv.FormatFloat(height, 'f', 2, 64),
+			Title:  fmt.Sprintf("%s: %d", r.Label, r.Count),
+		})
+	}
+
+	return result
+}
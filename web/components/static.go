@@ -0,0 +1,147 @@
+package components
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+
+	"github.com/dasdy/glover/model"
+)
+
+const defaultKeyFill = "#e5e7eb"
+
+// heatGradient mirrors the default (reversed "chatgpt1") schema from colorize.js, so
+// exported images look the same as the web interface.
+var heatGradient = []color.RGBA{
+	{R: 0x1e, G: 0x3a, B: 0x8a, A: 0xff},
+	{R: 0x0e, G: 0xa5, B: 0xe9, A: 0xff},
+	{R: 0x38, G: 0xbd, B: 0xf8, A: 0xff},
+	{R: 0x7d, G: 0xd3, B: 0xfc, A: 0xff},
+	{R: 0xba, G: 0xe6, B: 0xfd, A: 0xff},
+	{R: 0xf0, G: 0xf9, B: 0xff, A: 0xff},
+}
+
+// HeatColor is a Go port of interpolateColor from colorize.js. Value is expected to be in [0, 1].
+func HeatColor(value float64) color.RGBA {
+	var r, g, b float64
+
+	switch {
+	case value >= 1:
+		c := heatGradient[len(heatGradient)-1]
+		r, g, b = float64(c.R), float64(c.G), float64(c.B)
+	case value <= 0 || math.IsNaN(value):
+		c := heatGradient[0]
+		r, g, b = float64(c.R), float64(c.G), float64(c.B)
+	default:
+		bucketSize := 1 / float64(len(heatGradient)-1)
+		n := int(math.Floor(value / bucketSize))
+		minVal := bucketSize * float64(n)
+		from, to := heatGradient[n], heatGradient[n+1]
+		t := (value - minVal) / bucketSize
+
+		r = float64(from.R) + (float64(to.R)-float64(from.R))*t
+		g = float64(from.G) + (float64(to.G)-float64(from.G))*t
+		b = float64(from.B) + (float64(to.B)-float64(from.B))*t
+	}
+
+	// Blend with white to reduce intensity, same as in the browser.
+	const blendFactor = 0.5
+
+	return color.RGBA{
+		R: uint8(math.Round(r + (255-r)*blendFactor)),
+		G: uint8(math.Round(g + (255-g)*blendFactor)),
+		B: uint8(math.Round(b + (255-b)*blendFactor)),
+		A: 0xff,
+	}
+}
+
+// HexColor formats color as #rrggbb string usable in svg attributes.
+func HexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// FillColor returns baked-in fill color of the key, or the neutral default one. In the
+// browser this value is overwritten by colorize.js anyway.
+func (i *Item) FillColor() string {
+	if i.Fill == "" {
+		return defaultKeyFill
+	}
+
+	return i.Fill
+}
+
+// BakeColors computes fill color of every key on the server side, the same way colorize() does it
+// in the browser. Values above clip get the "hottest" color. Non-positive clip means MaxVal is used.
+func (c *RenderContext) BakeColors(clip int) {
+	if clip <= 0 {
+		clip = c.MaxVal
+	}
+
+	c.Static = true
+
+	for i := range c.Items {
+		var presses float64
+
+		if _, err := fmt.Sscanf(c.Items[i].KeypressAmount, "%g", &presses); err != nil {
+			continue
+		}
+
+		value := 0.0
+		if clip > 0 {
+			value = presses / float64(clip)
+		}
+
+		c.Items[i].Fill = HexColor(HeatColor(value))
+	}
+}
+
+// KeyRectCenter calculates the center of the key rectangle in svg coordinates, applying the same
+// transformations as ToTransform does: rotation around the pivot point in key-local coordinates,
+// followed by the translation.
+func KeyRectCenter(l *model.Location) (float64, float64) {
+	x, y := float64(KeyCenterOffset), float64(KeyCenterOffset)
+
+	if l.R != 0 {
+		rx, ry := ToTransformOrigin(l)
+		x, y = RotatePoint(x, y, rx*KeySize, ry*KeySize, l.R)
+	}
+
+	return x + l.X*KeySize, y + l.Y*KeySize
+}
+
+// StaticConnectionPath builds the svg path between two keys, same shape as addConnectionPath in colorize.js.
+func StaticConnectionPath(from, to *model.Location) string {
+	fromX, fromY := KeyRectCenter(from)
+	toX, toY := KeyRectCenter(to)
+
+	midX := (fromX + toX) / 2
+	midY := (fromY+toY)/2 - 40
+
+	return fmt.Sprintf("M %.2f %.2f Q %.2f %.2f %.2f %.2f", fromX, fromY, midX, midY, toX, toY)
+}
+
+// StaticConnectionPaths resolves ComboConnections into svg paths. Connections to keys that are
+// not displayed are skipped.
+func (c *RenderContext) StaticConnectionPaths() []StaticPath {
+	locations := make(map[model.KeyPosition]*model.Location, len(c.Items))
+	for i := range c.Items {
+		locations[c.Items[i].Position] = &c.Items[i].Location
+	}
+
+	result := make([]StaticPath, 0, len(c.ComboConnections))
+
+	for i := range c.ComboConnections {
+		conn := &c.ComboConnections[i]
+
+		from, okFrom := locations[conn.FromPosition]
+		to, okTo := locations[conn.ToPosition]
+
+		if !okFrom || !okTo {
+			continue
+		}
+
+		result = append(result, StaticPath{D: StaticConnectionPath(from, to), StrokeWidth: KeyPathStrokeWidth(conn)})
+	}
+
+	return result
+}
@@ -0,0 +1,101 @@
+package components_test
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/dasdy/glover/model"
+	"github.com/dasdy/glover/web/components"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeatColor(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float64
+		want  color.RGBA
+	}{
+		{name: "zero is the coldest color", value: 0, want: color.RGBA{R: 0x8f, G: 0x9d, B: 0xc5, A: 0xff}},
+		{name: "negative is clamped", value: -1, want: color.RGBA{R: 0x8f, G: 0x9d, B: 0xc5, A: 0xff}},
+		{name: "one is the hottest color", value: 1, want: color.RGBA{R: 0xf8, G: 0xfc, B: 0xff, A: 0xff}},
+		{name: "above one is clamped", value: 5, want: color.RGBA{R: 0xf8, G: 0xfc, B: 0xff, A: 0xff}},
+		{name: "bucket border", value: 0.2, want: color.RGBA{R: 0x87, G: 0xd2, B: 0xf4, A: 0xff}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, components.HeatColor(tt.value))
+		})
+	}
+}
+
+func TestRenderContext_BakeColors(t *testing.T) {
+	t.Run("uses max value when clip is not set", func(t *testing.T) {
+		c := components.RenderContext{
+			MaxVal: 10,
+			Items: []components.Item{
+				{Position: 0, KeypressAmount: "0"},
+				{Position: 1, KeypressAmount: "10"},
+			},
+		}
+
+		c.BakeColors(0)
+
+		assert.True(t, c.Static)
+		assert.Equal(t, "#8f9dc5", c.Items[0].FillColor())
+		assert.Equal(t, "#f8fcff", c.Items[1].FillColor())
+	})
+
+	t.Run("clips values above threshold", func(t *testing.T) {
+		c := components.RenderContext{
+			MaxVal: 100,
+			Items:  []components.Item{{Position: 0, KeypressAmount: "50"}},
+		}
+
+		c.BakeColors(10)
+
+		assert.Equal(t, "#f8fcff", c.Items[0].FillColor())
+	})
+
+	t.Run("keeps default color when not baked", func(t *testing.T) {
+		item := components.Item{KeypressAmount: "50"}
+
+		assert.Equal(t, "#e5e7eb", item.FillColor())
+	})
+}
+
+func TestKeyRectCenter(t *testing.T) {
+	t.Run("not rotated key", func(t *testing.T) {
+		x, y := components.KeyRectCenter(&model.Location{X: 1, Y: 2})
+
+		assert.InDelta(t, 115, x, 0.001)
+		assert.InDelta(t, 195, y, 0.001)
+	})
+
+	t.Run("rotated around own center stays in place", func(t *testing.T) {
+		// Pivot is in key units relative to the key position, so (X + 35/80) is the center.
+		x, y := components.KeyRectCenter(&model.Location{X: 1, Y: 1, R: 90, Rx: 1 + 35.0/80, Ry: 1 + 35.0/80})
+
+		assert.InDelta(t, 115, x, 0.001)
+		assert.InDelta(t, 115, y, 0.001)
+	})
+}
+
+func TestRenderContext_StaticConnectionPaths(t *testing.T) {
+	c := components.RenderContext{
+		Items: []components.Item{
+			{Position: 1, Location: model.Location{X: 0, Y: 0}},
+			{Position: 2, Location: model.Location{X: 1, Y: 0}},
+		},
+		ComboConnections: []components.ComboConnection{
+			{FromPosition: 1, ToPosition: 2, PressCount: 100},
+			{FromPosition: 1, ToPosition: 3, PressCount: 5}, // Not displayed, skipped
+		},
+	}
+
+	paths := c.StaticConnectionPaths()
+
+	assert.Equal(t, []components.StaticPath{
+		{D: "M 35.00 35.00 Q 75.00 -5.00 115.00 35.00", StrokeWidth: "2.000000"},
+	}, paths)
+}
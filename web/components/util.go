@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log/slog"
 	"math"
+	"net/url"
+	"strings"
 
 	"github.com/dasdy/glover/model"
 )
@@ -44,8 +46,43 @@ func getSwitchModeButtonText(currentPageType PageType) string {
 	}
 }
 
+// WithKeyboard adds the keyboard and the source of the page to the link, so following it switches neither.
+func (c *RenderContext) WithKeyboard(link string) string {
+	return SourceLink(KeyboardLink(link, c.Keyboard), c.Source)
+}
+
+// KeyboardLink adds the keyboard to the link.
+func KeyboardLink(link string, keyboard string) string {
+	return withParam(link, "keyboard", keyboard)
+}
+
+// SourceLink adds the source to the link.
+func SourceLink(link string, source string) string {
+	return withParam(link, "source", source)
+}
+
+func withParam(link string, name string, value string) string {
+	if value == "" {
+		return link
+	}
+
+	separator := "?"
+	if strings.Contains(link, "?") {
+		separator = "&"
+	}
+
+	return link + separator + name + "=" + url.QueryEscape(value)
+}
+
 // Calculate how big coordinate space needs to be to fit all keys.
 func (c *RenderContext) ViewBoxSize() string {
+	width, height := c.ViewBoxDimensions()
+
+	return fmt.Sprintf("0 0 %d %d", width, height)
+}
+
+// ViewBoxDimensions returns width and height of the coordinate space used by ViewBoxSize.
+func (c *RenderContext) ViewBoxDimensions() (int, int) {
 	maxX := float64(c.TotalCols)
 	maxY := float64(c.TotalRows)
 
@@ -62,11 +99,7 @@ func (c *RenderContext) ViewBoxSize() string {
 	// TODO: figure out how to account for keys with Rx/Ry properly.
 	maxY += 2
 
-	return fmt.Sprintf(
-		"0 0 %d %d",
-		int(math.Ceil(KeySize*(maxX))),
-		int(math.Ceil(KeySize*(1+maxY))),
-	)
+	return int(math.Ceil(KeySize * (maxX))), int(math.Ceil(KeySize * (1 + maxY)))
 }
 
 // Attempt at a linear algebra. Seems to be correct, but for some reason the output is not what I expect.
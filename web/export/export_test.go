@@ -0,0 +1,110 @@
+package export_test
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/dasdy/glover/model"
+	"github.com/dasdy/glover/web/components"
+	"github.com/dasdy/glover/web/export"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sampleContext() *components.RenderContext {
+	return &components.RenderContext{
+		TotalCols: 2,
+		TotalRows: 1,
+		MaxVal:    10,
+		Items: []components.Item{
+			{Position: 1, KeyName: "A", KeypressAmount: "10", Highlight: true, Location: model.Location{X: 0, Y: 0}},
+			{Position: 2, KeyName: "B", KeypressAmount: "0", Location: model.Location{X: 1, Y: 0, R: 15, Rx: 1.5, Ry: 0.5}},
+		},
+		HighlightPosition: 1,
+		ComboConnections:  []components.ComboConnection{{FromPosition: 1, ToPosition: 2, PressCount: 10}},
+		Page:              components.PageTypeCombo,
+	}
+}
+
+func TestWriteSVG(t *testing.T) {
+	var buf bytes.Buffer
+
+	require.NoError(t, export.WriteSVG(&buf, sampleContext(), 0))
+
+	out := buf.String()
+
+	assert.True(t, strings.HasPrefix(out, "<?xml"))
+	assert.Contains(t, out, `xmlns="http://www.w3.org/2000/svg"`)
+	assert.Contains(t, out, `fill="#f8fcff"`)
+	assert.Contains(t, out, `fill="#8f9dc5"`)
+	assert.Contains(t, out, `<path d="M 35.00 35.00`)
+	assert.NotContains(t, out, "<script")
+
+	t.Run("key at position 0 can be highlighted", func(t *testing.T) {
+		c := sampleContext()
+		c.Items[0].Position = 0
+		c.HighlightPosition = 0
+		c.ComboConnections[0].FromPosition = 0
+
+		var buf bytes.Buffer
+
+		require.NoError(t, export.WriteSVG(&buf, c, 0))
+		assert.Contains(t, buf.String(), `<path d="M 35.00 35.00`)
+	})
+}
+
+func TestWritePNG(t *testing.T) {
+	t.Run("renders image with scaled size", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		require.NoError(t, export.WritePNG(&buf, sampleContext(), 0, 2))
+
+		img, err := png.Decode(&buf)
+		require.NoError(t, err)
+
+		// viewbox of the sample is 160x320 svg units
+		assert.Equal(t, 320, img.Bounds().Dx())
+		assert.Equal(t, 640, img.Bounds().Dy())
+
+		// middle of the first key's border is highlighted, and the background is white
+		r, g, b, _ := img.At(1, 70).RGBA()
+		assert.Equal(t, []uint32{0x6363, 0x6666, 0xf1f1}, []uint32{r, g, b})
+
+		r, g, b, _ = img.At(319, 639).RGBA()
+		assert.Equal(t, []uint32{0xffff, 0xffff, 0xffff}, []uint32{r, g, b})
+	})
+
+	t.Run("rejects non-positive scale", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		require.Error(t, export.WritePNG(&buf, sampleContext(), 0, 0))
+	})
+}
+
+func TestWriteReport(t *testing.T) {
+	var buf bytes.Buffer
+
+	heatmap := sampleContext()
+	heatmap.BakeColors(0)
+
+	report := &components.ReportContext{
+		Title:        "Test report",
+		GeneratedAt:  "2024-01-01 00:00:00",
+		TotalPresses: 42,
+		Heatmap:      heatmap,
+		TopKeys:      []components.ReportRow{{Label: "A", Count: 10}, {Label: "B", Count: 5}},
+		Daily:        []components.ReportRow{{Label: "2024-01-01", Count: 3}, {Label: "2024-01-02", Count: 0}},
+	}
+
+	require.NoError(t, export.WriteReport(&buf, report))
+
+	out := buf.String()
+
+	assert.True(t, strings.HasPrefix(out, "<!DOCTYPE html>"))
+	assert.Contains(t, out, "Total key presses: 42")
+	assert.Contains(t, out, "width: 50.0%")
+	assert.Contains(t, out, "<title>2024-01-01: 3</title>")
+	assert.NotContains(t, out, "/assets/")
+}
@@ -0,0 +1,270 @@
+package export
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/dasdy/glover/model"
+	cs "github.com/dasdy/glover/web/components"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+const (
+	keyCornerRadius = 5
+	cornerSegments  = 4
+	curveSegments   = 32
+)
+
+var (
+	keyStroke       = color.RGBA{R: 0xa1, G: 0xa1, B: 0xaa, A: 0xff}
+	highlightStroke = color.RGBA{R: 0x63, G: 0x66, B: 0xf1, A: 0xff}
+	connectionColor = color.NRGBA{R: 0x63, G: 0x66, B: 0xf1, A: 0xb3}
+	labelColor      = color.RGBA{R: 0x33, G: 0x41, B: 0x55, A: 0xff}
+	countColor      = color.RGBA{R: 0x0f, G: 0x17, B: 0x2a, A: 0xff}
+)
+
+type point struct {
+	X, Y float64
+}
+
+// canvas knows how to map svg coordinates of the heatmap onto the image.
+type canvas struct {
+	img   *image.RGBA
+	scale float64
+}
+
+func (c *canvas) fillPolygon(points []point, col color.Color) {
+	if len(points) < 3 {
+		return
+	}
+
+	bounds := c.img.Bounds()
+	r := vector.NewRasterizer(bounds.Dx(), bounds.Dy())
+
+	r.MoveTo(float32(points[0].X*c.scale), float32(points[0].Y*c.scale))
+
+	for _, p := range points[1:] {
+		r.LineTo(float32(p.X*c.scale), float32(p.Y*c.scale))
+	}
+
+	r.ClosePath()
+	r.Draw(c.img, bounds, image.NewUniform(col), image.Point{})
+}
+
+func (c *canvas) drawText(face font.Face, text string, p point, col color.Color, centered bool) {
+	d := &font.Drawer{Dst: c.img, Src: image.NewUniform(col), Face: face}
+
+	x := p.X * c.scale
+	if centered {
+		x -= float64(d.MeasureString(text)) / 64 / 2
+	}
+
+	d.Dot = fixed.Point26_6{X: fixed.Int26_6(x * 64), Y: fixed.Int26_6(p.Y * c.scale * 64)}
+	d.DrawString(text)
+}
+
+// keyTransform maps key-local coordinates into svg coordinates, same as ToTransform does.
+func keyTransform(l *model.Location) func(point) point {
+	rx, ry := cs.ToTransformOrigin(l)
+
+	return func(p point) point {
+		x, y := p.X, p.Y
+		if l.R != 0 {
+			x, y = cs.RotatePoint(x, y, rx*cs.KeySize, ry*cs.KeySize, l.R)
+		}
+
+		return point{X: x + l.X*cs.KeySize, Y: y + l.Y*cs.KeySize}
+	}
+}
+
+// roundedRect approximates a rounded rectangle in key-local coordinates by a polygon.
+func roundedRect(inset float64, transform func(point) point) []point {
+	lo, hi := inset, float64(cs.KeySizeWithoutGap)-inset
+	radius := max(keyCornerRadius-inset, 0)
+
+	corners := []struct {
+		cx, cy, startAngle float64
+	}{
+		{hi - radius, lo + radius, -math.Pi / 2},
+		{hi - radius, hi - radius, 0},
+		{lo + radius, hi - radius, math.Pi / 2},
+		{lo + radius, lo + radius, math.Pi},
+	}
+
+	result := make([]point, 0, len(corners)*(cornerSegments+1))
+
+	for _, corner := range corners {
+		for i := range cornerSegments + 1 {
+			angle := corner.startAngle + float64(i)*math.Pi/2/cornerSegments
+			result = append(result, transform(point{
+				X: corner.cx + radius*math.Cos(angle),
+				Y: corner.cy + radius*math.Sin(angle),
+			}))
+		}
+	}
+
+	return result
+}
+
+// strokeQuadratic builds a polygon around a quadratic bezier curve with the given width.
+func strokeQuadratic(from, ctrl, to point, width float64) []point {
+	left := make([]point, 0, curveSegments+1)
+	right := make([]point, 0, curveSegments+1)
+
+	for i := range curveSegments + 1 {
+		t := float64(i) / curveSegments
+		x := (1-t)*(1-t)*from.X + 2*(1-t)*t*ctrl.X + t*t*to.X
+		y := (1-t)*(1-t)*from.Y + 2*(1-t)*t*ctrl.Y + t*t*to.Y
+
+		// Derivative gives the direction of the curve; the normal is perpendicular to it.
+		dx := 2*(1-t)*(ctrl.X-from.X) + 2*t*(to.X-ctrl.X)
+		dy := 2*(1-t)*(ctrl.Y-from.Y) + 2*t*(to.Y-ctrl.Y)
+
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			continue
+		}
+
+		nx, ny := -dy/length*width/2, dx/length*width/2
+
+		left = append(left, point{X: x + nx, Y: y + ny})
+		right = append(right, point{X: x - nx, Y: y - ny})
+	}
+
+	for i := len(right) - 1; i >= 0; i-- {
+		left = append(left, right[i])
+	}
+
+	return left
+}
+
+func loadFace(size float64) (font.Face, error) {
+	parsed, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse font: %w", err)
+	}
+
+	face, err := opentype.NewFace(parsed, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("could not create font face: %w", err)
+	}
+
+	return face, nil
+}
+
+// RenderImage rasterizes the keyboard heatmap. The result follows the svg rendered by WriteSVG: same
+// layout, colors and connection paths. Scale of 1 means one pixel per svg unit.
+func RenderImage(c *cs.RenderContext, clip int, scale float64) (*image.RGBA, error) {
+	if scale <= 0 {
+		return nil, fmt.Errorf("scale should be positive, got %f", scale)
+	}
+
+	c.BakeColors(clip)
+
+	width, height := c.ViewBoxDimensions()
+	img := image.NewRGBA(image.Rect(0, 0, int(math.Ceil(float64(width)*scale)), int(math.Ceil(float64(height)*scale))))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	labelFace, err := loadFace(12 * scale)
+	if err != nil {
+		return nil, err
+	}
+	defer labelFace.Close()
+
+	countFace, err := loadFace(14 * scale)
+	if err != nil {
+		return nil, err
+	}
+	defer countFace.Close()
+
+	cv := &canvas{img: img, scale: scale}
+
+	for i := range c.Items {
+		item := &c.Items[i]
+		transform := keyTransform(&item.Location)
+
+		stroke, strokeWidth := keyStroke, 1.0
+		if item.Highlight {
+			stroke, strokeWidth = highlightStroke, 4.0
+		}
+
+		fill, err := parseHexColor(item.FillColor())
+		if err != nil {
+			return nil, err
+		}
+
+		// svg strokes are centered on the edge, so draw a slightly bigger shape first and cover it.
+		cv.fillPolygon(roundedRect(-strokeWidth/2, transform), stroke)
+		cv.fillPolygon(roundedRect(strokeWidth/2, transform), fill)
+
+		// Text is not rotated together with the key, but anchored at the same point.
+		cv.drawText(labelFace, item.KeyName, transform(point{X: 5, Y: 15}), labelColor, false)
+		cv.drawText(countFace, item.KeypressAmount,
+			transform(point{X: cs.KeyCenterOffset, Y: cs.KeyCenterOffset + 5}), countColor, true)
+	}
+
+	if c.HighlightPosition != cs.NoHighlight {
+		locations := make(map[model.KeyPosition]*model.Location, len(c.Items))
+		for i := range c.Items {
+			locations[c.Items[i].Position] = &c.Items[i].Location
+		}
+
+		for i := range c.ComboConnections {
+			conn := &c.ComboConnections[i]
+
+			from, okFrom := locations[conn.FromPosition]
+			to, okTo := locations[conn.ToPosition]
+
+			if !okFrom || !okTo {
+				continue
+			}
+
+			fromX, fromY := cs.KeyRectCenter(from)
+			toX, toY := cs.KeyRectCenter(to)
+			ctrl := point{X: (fromX + toX) / 2, Y: (fromY+toY)/2 - 40}
+
+			strokeWidth, err := strconv.ParseFloat(cs.KeyPathStrokeWidth(conn), 64)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse stroke width: %w", err)
+			}
+
+			cv.fillPolygon(strokeQuadratic(point{X: fromX, Y: fromY}, ctrl, point{X: toX, Y: toY}, strokeWidth), connectionColor)
+		}
+	}
+
+	return img, nil
+}
+
+// WritePNG rasterizes the keyboard heatmap and encodes it as png.
+func WritePNG(w io.Writer, c *cs.RenderContext, clip int, scale float64) error {
+	img, err := RenderImage(c, clip, scale)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(w, img); err != nil {
+		return fmt.Errorf("could not encode png: %w", err)
+	}
+
+	return nil
+}
+
+func parseHexColor(s string) (color.RGBA, error) {
+	var r, g, b uint8
+
+	if _, err := fmt.Sscanf(s, "#%02x%02x%02x", &r, &g, &b); err != nil {
+		return color.RGBA{}, fmt.Errorf("could not parse color %s: %w", s, err)
+	}
+
+	return color.RGBA{R: r, G: g, B: b, A: 0xff}, nil
+}
@@ -0,0 +1,157 @@
+package export
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dasdy/glover/db"
+	"github.com/dasdy/glover/layout"
+	"github.com/dasdy/glover/model"
+	cs "github.com/dasdy/glover/web/components"
+	"github.com/dasdy/glover/web/routes"
+)
+
+func comboLabel(names []string, keys []model.KeyPosition, separator string) string {
+	labels := make([]string, len(keys))
+	for i, k := range keys {
+		labels[i] = layout.KeyLabel(names, k)
+	}
+
+	return strings.Join(labels, separator)
+}
+
+func topRows(rows []cs.ReportRow, limit int) []cs.ReportRow {
+	slices.SortStableFunc(rows, func(a, b cs.ReportRow) int {
+		return cmp.Or(-cmp.Compare(a.Count, b.Count), cmp.Compare(a.Label, b.Label))
+	})
+
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	return rows
+}
+
+func comboRows(names []string, combos []model.Combo, separator string, limit int) []cs.ReportRow {
+	rows := make([]cs.ReportRow, 0, len(combos))
+	for _, c := range combos {
+		rows = append(rows, cs.ReportRow{Label: comboLabel(names, c.Keys, separator), Count: c.Pressed})
+	}
+
+	return topRows(rows, limit)
+}
+
+// inPressOrder reverses neighbor pairs: tracker reports them as {next, previous}.
+func inPressOrder(neighbors []model.Combo) []model.Combo {
+	result := make([]model.Combo, len(neighbors))
+
+	for i, n := range neighbors {
+		keys := slices.Clone(n.Keys)
+		slices.Reverse(keys)
+		result[i] = model.Combo{Keys: keys, Pressed: n.Pressed}
+	}
+
+	return result
+}
+
+// dailyRows counts key presses per calendar day, including days without any presses in between.
+// Days whose raw events were deleted count releases kept in rollups, there is one for every press.
+func dailyRows(ctx context.Context, storage db.Storage) ([]cs.ReportRow, error) {
+	counts := make(map[string]int)
+
+	var first, last time.Time
+
+	count := func(t time.Time, n int) {
+		day := t.Truncate(24 * time.Hour)
+		if first.IsZero() || day.Before(first) {
+			first = day
+		}
+
+		if day.After(last) {
+			last = day
+		}
+
+		counts[day.Format(time.DateOnly)] += n
+	}
+
+	if rollups, ok := storage.(db.RollupStorage); ok {
+		rollup, err := rollups.PrunedRollup(ctx, db.HistoryQuery{})
+		if err != nil {
+			return nil, fmt.Errorf("could not read rollups: %w", err)
+		}
+
+		for _, key := range rollup.Keys {
+			count(key.Bucket, key.Count)
+		}
+	}
+
+	for event, err := range storage.History(ctx, db.HistoryQuery{}) {
+		if err != nil {
+			return nil, fmt.Errorf("could not iterate over history: %w", err)
+		}
+
+		if event.Pressed {
+			count(event.Timestamp, 1)
+		}
+	}
+
+	if first.IsZero() {
+		return nil, nil
+	}
+
+	result := make([]cs.ReportRow, 0, len(counts))
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		label := day.Format(time.DateOnly)
+		result = append(result, cs.ReportRow{Label: label, Count: counts[label]})
+	}
+
+	return result, nil
+}
+
+// BuildReport gathers statistics, top combos and neighbors for the report. Trackers of the handler
+// are expected to be fully initialized. Limit applies to each of the tables.
+func BuildReport(ctx context.Context, h *routes.ServerHandler, limit int, now time.Time) (*cs.ReportContext, error) {
+	stats, err := h.GatherStats()
+	if err != nil {
+		return nil, fmt.Errorf("could not gather stats: %w", err)
+	}
+
+	heatmap := h.BuildStatsRenderContext(stats)
+	heatmap.BakeColors(0)
+
+	perKey := make(map[model.KeyPosition]int)
+	total := 0
+
+	for _, s := range stats {
+		perKey[s.Position] += s.Count
+		total += s.Count
+	}
+
+	keys := make([]cs.ReportRow, 0, len(perKey))
+	for pos, count := range perKey {
+		keys = append(keys, cs.ReportRow{Label: layout.KeyLabel(h.KeyNames, pos), Count: count})
+	}
+
+	positions := slices.Sorted(maps.Keys(h.LocationsOnGrid.Locations))
+
+	daily, err := dailyRows(ctx, h.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cs.ReportContext{
+		Title:        "Keyboard usage report",
+		GeneratedAt:  now.Format(time.DateTime),
+		TotalPresses: total,
+		Heatmap:      &heatmap,
+		TopKeys:      topRows(keys, limit),
+		TopCombos:    comboRows(h.KeyNames, db.GatherAllCombos(db.SnapshotOf(h.ComboTracker), positions), " + ", limit),
+		TopNeighbors: comboRows(h.KeyNames, inPressOrder(db.GatherAllCombos(db.SnapshotOf(h.NeighborTracker), positions)), " → ", limit),
+		Daily:        daily,
+	}, nil
+}
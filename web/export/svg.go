@@ -0,0 +1,40 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	cs "github.com/dasdy/glover/web/components"
+)
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>` + "\n"
+
+// WriteSVG renders keyboard heatmap into a self-contained svg document. Colors are baked into
+// the render context, clip has the same meaning as the slider in the web interface.
+func WriteSVG(w io.Writer, c *cs.RenderContext, clip int) error {
+	c.BakeColors(clip)
+
+	if _, err := io.WriteString(w, xmlHeader); err != nil {
+		return fmt.Errorf("could not write svg header: %w", err)
+	}
+
+	if err := cs.StaticKeyboardSvg(c).Render(context.Background(), w); err != nil {
+		return fmt.Errorf("could not render svg: %w", err)
+	}
+
+	return nil
+}
+
+// WriteReport renders a standalone html report.
+func WriteReport(w io.Writer, r *cs.ReportContext) error {
+	if _, err := io.WriteString(w, "<!DOCTYPE html>\n"); err != nil {
+		return fmt.Errorf("could not write report doctype: %w", err)
+	}
+
+	if err := cs.Report(r).Render(context.Background(), w); err != nil {
+		return fmt.Errorf("could not render report: %w", err)
+	}
+
+	return nil
+}
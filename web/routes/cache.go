@@ -0,0 +1,77 @@
+package routes
+
+import (
+	"sync"
+
+	"github.com/dasdy/glover/model"
+	cs "github.com/dasdy/glover/web/components"
+)
+
+type renderKey struct {
+	page     cs.PageType
+	position model.KeyPosition
+}
+
+type cachedRender struct {
+	version uint64
+	context cs.RenderContext
+}
+
+// RenderCache keeps render contexts of pages until the tracker they were built from counts new
+// events, so pages are not built again on every load.
+type RenderCache struct {
+	lock  sync.Mutex
+	pages map[renderKey]cachedRender
+}
+
+func NewRenderCache() *RenderCache {
+	return &RenderCache{pages: make(map[renderKey]cachedRender)}
+}
+
+// get returns the context of the page if it was built at the given version.
+func (c *RenderCache) get(key renderKey, version uint64) (cs.RenderContext, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cached, ok := c.pages[key]
+	if !ok || cached.version != version {
+		return cs.RenderContext{}, false
+	}
+
+	return cached.context, true
+}
+
+func (c *RenderCache) put(key renderKey, version uint64, context cs.RenderContext) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.pages[key] = cachedRender{version: version, context: context}
+}
+
+// cachedContext returns the cached context of the page, or builds it. Version is the version of
+// the tracker the page is built from, taken before build is called. Nothing is cached when there
+// is no cache, no version, or the position is not on the keyboard, so unknown positions in
+// requests do not grow the cache.
+func (s *ServerHandler) cachedContext(
+	page cs.PageType,
+	position model.KeyPosition,
+	version uint64,
+	versioned bool,
+	build func() cs.RenderContext,
+) cs.RenderContext {
+	key := renderKey{page: page, position: position}
+
+	_, known := s.LocationsOnGrid.Locations[position]
+	if s.Cache == nil || !versioned || (page != cs.PageTypeStats && !known) {
+		return build()
+	}
+
+	if context, ok := s.Cache.get(key, version); ok {
+		return context
+	}
+
+	context := build()
+	s.Cache.put(key, version, context)
+
+	return context
+}
@@ -7,6 +7,7 @@ import (
 	"slices"
 	"strconv"
 
+	"github.com/dasdy/glover/db"
 	"github.com/dasdy/glover/model"
 	cs "github.com/dasdy/glover/web/components"
 )
@@ -15,7 +16,8 @@ import (
 func (s *ServerHandler) BuildCombosRenderContext(combos []model.Combo, position model.KeyPosition) cs.RenderContext {
 	slog.Debug("Building combos context", "comboCount", len(combos))
 
-	// Sort combos by press count to get top 5
+	// Sort combos by press count to get top 5. Snapshots share them, so they are sorted in a copy.
+	combos = slices.Clone(combos)
 	slices.SortFunc(combos, func(a, b model.Combo) int {
 		return -cmp.Compare(a.Pressed, b.Pressed) // Negative to sort in descending order
 	})
@@ -119,8 +121,12 @@ func (s *ServerHandler) CombosHandle(w http.ResponseWriter, r *http.Request) {
 	}
 
 	positionCasted := model.KeyPosition(position)
-	combos := s.ComboTracker.GatherCombos(positionCasted)
+	version, versioned := db.VersionOf(s.ComboTracker)
 
-	renderContext := s.BuildCombosRenderContext(combos, positionCasted)
-	_ = SafeRenderTemplate(cs.HeatMap(&renderContext), w)
+	renderContext := s.cachedContext(cs.PageTypeCombo, positionCasted, version, versioned, func() cs.RenderContext {
+		combos := db.SnapshotOf(s.ComboTracker).Combos(positionCasted)
+
+		return s.BuildCombosRenderContext(combos, positionCasted)
+	})
+	_ = s.renderHeatMap(&renderContext, w)
 }
@@ -2,11 +2,14 @@ package routes_test
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/dasdy/glover/model"
 	"github.com/dasdy/glover/web/components"
+	"github.com/dasdy/glover/web/routes"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBuildCombosRenderContext(t *testing.T) {
@@ -67,3 +70,37 @@ func TestCombosHandle(t *testing.T) {
 		return &handler.MockComboTracker.ReturnCombos, &handler.MockComboTracker.CallCount, &handler.MockComboTracker.LastPosition
 	})
 }
+
+func TestCombosHandleCache(t *testing.T) {
+	handler := setupMockNeighborServerHandler()
+	tracker := &VersionedTrackerMock{TrackerMock: TrackerMock{
+		ReturnCombos: []model.Combo{{Keys: []model.KeyPosition{KeyA, KeyB}, Pressed: 5}},
+	}}
+	handler.ComboTracker = tracker
+	handler.Cache = routes.NewRenderCache()
+
+	request := func(position string) string {
+		w := httptest.NewRecorder()
+		handler.CombosHandle(w, httptest.NewRequest(http.MethodGet, "/combo?position="+position, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		return w.Body.String()
+	}
+
+	first := request("0")
+	assert.Equal(t, first, request("0"))
+	assert.Equal(t, 1, tracker.CallCount, "page is built once while nothing is counted")
+
+	request("1")
+	assert.Equal(t, 2, tracker.CallCount, "pages of other positions are cached separately")
+
+	tracker.CurrentVersion++
+	tracker.ReturnCombos = []model.Combo{{Keys: []model.KeyPosition{KeyA, KeyB}, Pressed: 6}}
+
+	assert.NotEqual(t, first, request("0"))
+	assert.Equal(t, 3, tracker.CallCount, "page is built again after new events")
+
+	request("42")
+	request("42")
+	assert.Equal(t, 5, tracker.CallCount, "positions that are not on the keyboard are not cached")
+}
@@ -10,15 +10,39 @@ import (
 	"github.com/a-h/templ"
 	"github.com/dasdy/glover/db"
 	"github.com/dasdy/glover/model"
+	cs "github.com/dasdy/glover/web/components"
 )
 
-// ServerHandler holds all dependencies needed for the web server handlers.
+// ServerHandler holds all dependencies needed for the web server handlers. Combo and neighbor
+// pages are empty if their tracker is nil. The stats page is counted by KeyCounter once it has
+// scanned the history, and queried from Storage otherwise. Pages are built on every request if
+// Cache is nil.
 type ServerHandler struct {
 	Storage         db.Storage
 	KeyNames        []string
 	ComboTracker    db.Tracker
 	NeighborTracker db.Tracker
+	KeyCounter      *db.KeyCounter
 	LocationsOnGrid *model.KeyboardLayout
+	Cache           *RenderCache
+
+	// Keyboard is the name of the keyboard the handler shows, Keyboards are names of all of them.
+	Keyboard  string
+	Keyboards []string
+	// Source is the source of events the handler shows, empty for all of them. Sources are names of
+	// all sources that can be picked.
+	Source  string
+	Sources []string
+}
+
+// renderHeatMap renders the page with links to other keyboards and sources.
+func (s *ServerHandler) renderHeatMap(c *cs.RenderContext, w http.ResponseWriter) error {
+	c.Keyboard = s.Keyboard
+	c.Keyboards = s.Keyboards
+	c.Source = s.Source
+	c.Sources = s.Sources
+
+	return SafeRenderTemplate(cs.HeatMap(c), w)
 }
 
 // SafeRenderTemplate safely renders a templ component to an http.ResponseWriter.
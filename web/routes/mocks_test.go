@@ -1,11 +1,13 @@
 package routes_test
 
 import (
+	"context"
 	"iter"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/dasdy/glover/db"
 	"github.com/dasdy/glover/model"
 	"github.com/dasdy/glover/web/routes"
 	"github.com/stretchr/testify/assert"
@@ -32,12 +34,12 @@ func (m *SimpleStorageMock) GatherAll() ([]model.MinimalKeyEvent, error) {
 	return m.ReturnStats, m.ReturnError
 }
 
-// Implement AllIterator method required by db.Storage interface with correct signature.
-func (m *SimpleStorageMock) AllIterator() (iter.Seq[model.KeyEventWithTimestamp], error) {
+// Implement History method required by db.Storage interface with correct signature.
+func (m *SimpleStorageMock) History(_ context.Context, _ db.HistoryQuery) iter.Seq2[db.HistoryEvent, error] {
 	// Return a simple iterator function that yields nothing
-	return func(_ func(model.KeyEventWithTimestamp) bool) {
+	return func(_ func(db.HistoryEvent, error) bool) {
 		// Empty iterator - no events to yield
-	}, nil
+	}
 }
 
 // Implement Close method required by db.Storage interface.
@@ -51,6 +53,12 @@ func (m *SimpleStorageMock) Store(_ *model.KeyEvent) error {
 	return nil
 }
 
+// Implement StoreEvent method required by db.Storage interface.
+func (m *SimpleStorageMock) StoreEvent(_ *model.KeyEventWithTimestamp) error {
+	// No-op for testing
+	return nil
+}
+
 // TrackerMock is a simple mock implementation of the Tracker interface.
 type TrackerMock struct {
 	ReturnCombos []model.Combo
@@ -58,17 +66,42 @@ type TrackerMock struct {
 	LastPosition model.KeyPosition
 }
 
-func (m *TrackerMock) HandleKeyNow(_ model.KeyPosition, _ bool, _ bool) {
+func (m *TrackerMock) Init(_ iter.Seq[model.KeyEventWithTimestamp]) error {
 	// No-op for testing
+	return nil
 }
 
-func (m *TrackerMock) GatherCombos(position model.KeyPosition) []model.Combo {
+func (m *TrackerMock) Handle(_ model.KeyEventWithTimestamp) {
+	// No-op for testing
+}
+
+func (m *TrackerMock) Reset() {
+	// No-op for testing
+}
+
+// Snapshot returns the mock itself, so calls of Combos are counted.
+func (m *TrackerMock) Snapshot() db.Snapshot {
+	return m
+}
+
+func (m *TrackerMock) Combos(position model.KeyPosition) []model.Combo {
 	m.CallCount++
 	m.LastPosition = position
 
 	return m.ReturnCombos
 }
 
+// VersionedTrackerMock is a TrackerMock that tells its version, so pages built from it are cached.
+type VersionedTrackerMock struct {
+	TrackerMock
+
+	CurrentVersion uint64
+}
+
+func (m *VersionedTrackerMock) Version() uint64 {
+	return m.CurrentVersion
+}
+
 // MockServerHandler helper struct for testing.
 type MockServerHandler struct {
 	routes.ServerHandler
@@ -190,13 +223,13 @@ func testHandlerWithMock(
 
 			// Verify the tracker was called when expected
 			if tc.shouldCallTracker {
-				assert.Equal(t, 1, *callCount, "GatherCombos should be called exactly once")
+				assert.Equal(t, 1, *callCount, "Combos should be called exactly once")
 				// If position parameter was provided and valid, check that it was passed to the tracker
 				if tc.queryParam == "position=1" {
 					assert.Equal(t, model.KeyPosition(1), *lastPosition)
 				}
 			} else {
-				assert.Equal(t, 0, *callCount, "GatherCombos should not be called")
+				assert.Equal(t, 0, *callCount, "Combos should not be called")
 			}
 		})
 	}
@@ -7,6 +7,7 @@ import (
 	"slices"
 	"strconv"
 
+	"github.com/dasdy/glover/db"
 	"github.com/dasdy/glover/model"
 	cs "github.com/dasdy/glover/web/components"
 )
@@ -68,7 +69,8 @@ func (s *ServerHandler) BuildNeighborsRenderContext(neighbors []model.Combo, pos
 	// Create combo connections for the top combos
 	connections := make([]cs.ComboConnection, 0, 5)
 
-	// Sort combos by press count to get top 5
+	// Sort combos by press count to get top 5. Snapshots share them, so they are sorted in a copy.
+	neighbors = slices.Clone(neighbors)
 	slices.SortFunc(neighbors, func(a, b model.Combo) int {
 		return -cmp.Compare(a.Pressed, b.Pressed) // Negative to sort in descending order
 	})
@@ -121,8 +123,12 @@ func (s *ServerHandler) NeighborsHandle(w http.ResponseWriter, r *http.Request)
 	}
 
 	positionCasted := model.KeyPosition(position)
-	neighbors := s.NeighborTracker.GatherCombos(positionCasted)
+	version, versioned := db.VersionOf(s.NeighborTracker)
 
-	renderContext := s.BuildNeighborsRenderContext(neighbors, positionCasted)
-	_ = SafeRenderTemplate(cs.HeatMap(&renderContext), w)
+	renderContext := s.cachedContext(cs.PageTypeNeighbors, positionCasted, version, versioned, func() cs.RenderContext {
+		neighbors := db.SnapshotOf(s.NeighborTracker).Combos(positionCasted)
+
+		return s.BuildNeighborsRenderContext(neighbors, positionCasted)
+	})
+	_ = s.renderHeatMap(&renderContext, w)
 }
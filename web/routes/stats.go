@@ -11,7 +11,6 @@ import (
 
 // BuildStatsRenderContext builds the render context for the stats page.
 func (s *ServerHandler) BuildStatsRenderContext(dbStats []model.MinimalKeyEvent) cs.RenderContext {
-	// TODO: this init leads to reading layout file on every request. Need to get rid of this somehow
 	groupedItems := InitEmptyMap(s.KeyNames, s.LocationsOnGrid.Locations)
 
 	maxVal := 0
@@ -42,13 +41,45 @@ func (s *ServerHandler) BuildStatsRenderContext(dbStats []model.MinimalKeyEvent)
 		})
 	}
 
-	return cs.RenderContext{TotalCols: s.LocationsOnGrid.Cols, TotalRows: s.LocationsOnGrid.Rows, Items: items, MaxVal: maxVal, Page: cs.PageTypeStats}
+	return cs.RenderContext{
+		TotalCols:         s.LocationsOnGrid.Cols,
+		TotalRows:         s.LocationsOnGrid.Rows,
+		Items:             items,
+		MaxVal:            maxVal,
+		Page:              cs.PageTypeStats,
+		HighlightPosition: cs.NoHighlight,
+	}
+}
+
+// GatherStats returns counts of every key. They are counted by KeyCounter once it has scanned the
+// history, and queried from Storage otherwise.
+func (s *ServerHandler) GatherStats() ([]model.MinimalKeyEvent, error) {
+	if s.KeyCounter != nil {
+		if stats, counted := s.KeyCounter.Stats(); counted {
+			return stats, nil
+		}
+	}
+
+	return s.Storage.GatherAll()
 }
 
 // StatsHandle handles requests to the stats page.
 func (s *ServerHandler) StatsHandle(w http.ResponseWriter, _ *http.Request) {
 	slog.Info("Handling stats page request")
 
+	if s.KeyCounter != nil {
+		version := s.KeyCounter.Version()
+
+		if curStats, counted := s.KeyCounter.Stats(); counted {
+			renderContext := s.cachedContext(cs.PageTypeStats, 0, version, true, func() cs.RenderContext {
+				return s.BuildStatsRenderContext(curStats)
+			})
+			_ = s.renderHeatMap(&renderContext, w)
+
+			return
+		}
+	}
+
 	curStats, err := s.Storage.GatherAll()
 	if err != nil {
 		slog.Error("Failed to get stats", "error", err)
@@ -63,5 +94,5 @@ func (s *ServerHandler) StatsHandle(w http.ResponseWriter, _ *http.Request) {
 
 	slog.Debug("Built render context")
 
-	_ = SafeRenderTemplate(cs.HeatMap(&renderContext), w)
+	_ = s.renderHeatMap(&renderContext, w)
 }
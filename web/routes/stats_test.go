@@ -6,10 +6,12 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/dasdy/glover/db"
 	"github.com/dasdy/glover/model"
 	"github.com/dasdy/glover/web/components"
 	"github.com/dasdy/glover/web/routes"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // setupMockServerHandler creates a mock server handler for testing.
@@ -141,3 +143,24 @@ func TestStatsHandle(t *testing.T) {
 		})
 	}
 }
+
+func TestStatsHandleUsesKeyCounter(t *testing.T) {
+	handler := setupMockServerHandler()
+	handler.MockStorage.ReturnStats = []model.MinimalKeyEvent{{Position: KeyA, Count: 5}}
+	handler.KeyCounter = db.NewKeyCounter()
+
+	handler.StatsHandle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, 1, handler.MockStorage.CallCount, "storage is queried until history is scanned")
+
+	require.NoError(t, handler.KeyCounter.Init(func(func(model.KeyEventWithTimestamp) bool) {}))
+	handler.KeyCounter.Handle(model.KeyEventWithTimestamp{Position: KeyB, Pressed: false})
+
+	w := httptest.NewRecorder()
+	handler.StatsHandle(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, handler.MockStorage.CallCount, "storage is not queried once history is scanned")
+
+	stats, err := handler.GatherStats()
+	require.NoError(t, err)
+	assert.Equal(t, []model.MinimalKeyEvent{{Position: KeyB, Count: 1}}, stats)
+}
@@ -1,11 +1,16 @@
 package web
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io/fs"
 	"log/slog"
+	"net"
 	"net/http"
+	"sync/atomic"
+	"time"
 
+	"github.com/dasdy/glover"
 	"github.com/dasdy/glover/db"
 	"github.com/dasdy/glover/layout"
 	"github.com/dasdy/glover/model"
@@ -23,8 +28,33 @@ func disableCacheInDevMode(dev bool, next http.Handler) http.Handler {
 	})
 }
 
-func loadLocationsOnGrid(infoJSONFile string) (*model.KeyboardLayout, error) {
-	reader, err := layout.OpenPath(infoJSONFile)
+// assetsFileSystem picks where css and js files are served from. Embedded files are used by default,
+// so the binary works from any directory. In dev mode files are read from disk to allow live-reload.
+func assetsFileSystem(dev bool, assetsDir string) http.FileSystem {
+	if assetsDir == "" && dev {
+		assetsDir = "assets"
+	}
+
+	if assetsDir != "" {
+		slog.Info("Serving assets from disk", "path", assetsDir)
+
+		return http.Dir(assetsDir)
+	}
+
+	assets, err := fs.Sub(glover.Assets, "assets")
+	if err != nil {
+		// Can only happen if embed directive is broken, which is a programming error.
+		panic(err)
+	}
+
+	return http.FS(assets)
+}
+
+// LoadLayout parses locations of keys of the info.json file, the embedded copy is used if the file does not exist.
+func LoadLayout(infoJSONFile string) (*model.KeyboardLayout, error) {
+	fsys, name := layout.Resolve(infoJSONFile, glover.Data)
+
+	reader, err := layout.Open(fsys, name)
 	if err != nil {
 		return nil, fmt.Errorf("could not open layout file %s. %w", infoJSONFile, err)
 	}
@@ -39,23 +69,22 @@ func loadLocationsOnGrid(infoJSONFile string) (*model.KeyboardLayout, error) {
 	return locationsParsed, nil
 }
 
-func BuildServer(storage db.Storage, comboTracker db.Tracker, neighborTracker db.Tracker, keymapFile string, infoFilePath string, dev bool) *http.ServeMux {
-	mux := http.NewServeMux()
-	// Serve the JS bundle.
-	mux.Handle("/assets/",
-		disableCacheInDevMode(dev,
-			http.StripPrefix("/assets",
-				http.FileServer(http.Dir("assets")))))
-
+// NewServerHandler parses layout files and builds handler with all dependencies. It is also
+// useful outside of the web server, e.g. to render heatmaps into files. Pages of trackers that are
+// not in the set are shown empty.
+func NewServerHandler(storage db.Storage, trackers *db.TrackerSet, keymapFile string, infoFilePath string) (*routes.ServerHandler, error) {
 	slog.Info("Parsing keyboard layout", "file", infoFilePath)
 
-	locationsParsed, err := loadLocationsOnGrid(infoFilePath)
+	locationsParsed, err := LoadLayout(infoFilePath)
 	if err != nil {
 		slog.Error("Failed to parse keyboard layout", "error", err, "file", infoFilePath)
-		log.Fatal(err)
+
+		return nil, err
 	}
 
-	keyNames, err := layout.GetKeyLabels(keymapFile)
+	keymapFS, keymapName := layout.Resolve(keymapFile, glover.Data)
+
+	keyNames, err := layout.GetKeyLabels(keymapFS, keymapName)
 	if err != nil {
 		slog.Error("Failed to parse keymap file", "error", err, "file", keymapFile)
 	}
@@ -65,28 +94,210 @@ func BuildServer(storage db.Storage, comboTracker db.Tracker, neighborTracker db
 		"rows", locationsParsed.Rows,
 		"cols", locationsParsed.Cols)
 
-	handler := routes.ServerHandler{
+	// The keys tracker can only be registered as a KeyCounter.
+	keyCounter, _ := trackers.Get(db.KeysTracker).(*db.KeyCounter)
+
+	return &routes.ServerHandler{
 		Storage:         storage,
 		KeyNames:        keyNames,
-		ComboTracker:    comboTracker,
-		NeighborTracker: neighborTracker,
+		ComboTracker:    trackers.Get(db.CombosTracker),
+		NeighborTracker: trackers.Get(db.NeighborsTracker),
+		KeyCounter:      keyCounter,
 		LocationsOnGrid: locationsParsed,
+		Cache:           routes.NewRenderCache(),
+	}, nil
+}
+
+// Keyboard is everything the interface needs to show one keyboard.
+type Keyboard struct {
+	Name         string
+	Storage      db.Storage
+	Trackers     *db.TrackerSet
+	KeymapFile   string
+	InfoJSONFile string
+	// Sources are views of the keyboard with events of one source each, e.g. of one of several storages
+	// shown at once. They are picked with the source query parameter.
+	Sources []Source
+}
+
+// Source is the part of the events of a keyboard that came from one source, with its own trackers.
+type Source struct {
+	Name     string
+	Storage  db.Storage
+	Trackers *db.TrackerSet
+}
+
+type handleFunc func(*routes.ServerHandler, http.ResponseWriter, *http.Request)
+
+// BuildServer serves pages of all keyboards. The keyboard is picked with the keyboard query parameter,
+// the first one is shown without it. Events of all sources are shown without the source parameter.
+func BuildServer(keyboards []Keyboard, dev bool, assetsDir string) (*http.ServeMux, error) {
+	mux := http.NewServeMux()
+	// Serve the JS bundle.
+	mux.Handle("/assets/",
+		disableCacheInDevMode(dev,
+			http.StripPrefix("/assets",
+				http.FileServer(assetsFileSystem(dev, assetsDir)))))
+
+	names := make([]string, len(keyboards))
+	for i, k := range keyboards {
+		names[i] = k.Name
+	}
+
+	// Handlers of every keyboard by the source they show, "" shows all of them.
+	handlers := make(map[string]map[string]*routes.ServerHandler, len(keyboards))
+
+	for _, k := range keyboards {
+		sources := make([]string, len(k.Sources))
+		for i, source := range k.Sources {
+			sources[i] = source.Name
+		}
+
+		views := append([]Source{{Storage: k.Storage, Trackers: k.Trackers}}, k.Sources...)
+		handlers[k.Name] = make(map[string]*routes.ServerHandler, len(views))
+
+		for _, view := range views {
+			handler, err := NewServerHandler(view.Storage, view.Trackers, k.KeymapFile, k.InfoJSONFile)
+			if err != nil {
+				return nil, fmt.Errorf("could not load keyboard '%s': %w", k.Name, err)
+			}
+
+			handler.Keyboard = k.Name
+			handler.Keyboards = names
+			handler.Source = view.Name
+			handler.Sources = sources
+			handlers[k.Name][view.Name] = handler
+		}
+	}
+
+	forKeyboard := func(handle handleFunc) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := r.URL.Query().Get("keyboard")
+			if name == "" {
+				name = names[0]
+			}
+
+			sources, ok := handlers[name]
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown keyboard '%s'", name), http.StatusNotFound)
+
+				return
+			}
+
+			source := r.URL.Query().Get("source")
+
+			handler, ok := sources[source]
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown source '%s'", source), http.StatusNotFound)
+
+				return
+			}
+
+			handle(handler, w, r)
+		})
 	}
-	mux.Handle("/combo", http.HandlerFunc(handler.CombosHandle))
-	mux.Handle("/neighbors", http.HandlerFunc(handler.NeighborsHandle))
-	mux.Handle("/", http.HandlerFunc(handler.StatsHandle))
 
-	return mux
+	mux.Handle("/combo", forKeyboard((*routes.ServerHandler).CombosHandle))
+	mux.Handle("/neighbors", forKeyboard((*routes.ServerHandler).NeighborsHandle))
+	mux.Handle("/", forKeyboard((*routes.ServerHandler).StatsHandle))
+
+	return mux, nil
+}
+
+// ShutdownTimeout is how long requests in progress are waited for when a server stops.
+const ShutdownTimeout = 5 * time.Second
+
+// Server is the web interface. Its pages can be rebuilt while it runs, e.g. after layout files changed.
+type Server struct {
+	server    *http.Server
+	handler   atomic.Pointer[http.ServeMux]
+	dev       bool
+	assetsDir string
 }
 
-func StartServer(port int, storage db.Storage, comboTracker db.Tracker, neighborTracker db.Tracker, keymapFile string, infoFilePath string, dev bool) {
-	slog.Info("Starting server", "port", port)
+// NewServer loads layouts of all keyboards, it fails if any of them can not be loaded.
+func NewServer(port int, keyboards []Keyboard, dev bool, assetsDir string) (*Server, error) {
+	s := &Server{dev: dev, assetsDir: assetsDir}
+
+	if err := s.Reload(keyboards); err != nil {
+		return nil, err
+	}
+
+	s.server = &http.Server{
+		Addr: fmt.Sprintf(":%d", port),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.handler.Load().ServeHTTP(w, r)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return s, nil
+}
 
-	err := http.ListenAndServe(
-		fmt.Sprintf(":%d", port),
-		BuildServer(storage, comboTracker, neighborTracker, keymapFile, infoFilePath, dev))
+// Reload loads layouts of keyboards again. Pages keep being served with the old ones if loading fails.
+// Trackers and storages are taken as they are, so nothing they counted is lost.
+func (s *Server) Reload(keyboards []Keyboard) error {
+	mux, err := BuildServer(keyboards, s.dev, s.assetsDir)
 	if err != nil {
-		slog.Error("Server failed to start", "error", err)
-		log.Fatal(err)
+		return err
+	}
+
+	s.handler.Store(mux)
+
+	return nil
+}
+
+// Handler serves pages with the layouts loaded last.
+func (s *Server) Handler() http.Handler {
+	return s.server.Handler
+}
+
+// Run serves pages until the context is done, then waits for requests in progress for ShutdownTimeout.
+func (s *Server) Run(ctx context.Context) error {
+	slog.Info("Starting server", "address", s.server.Addr)
+
+	return ServeUntilDone(ctx, s.server, ShutdownTimeout)
+}
+
+// RunOn is Run on a listener that is already open, e.g. a socket passed by systemd.
+func (s *Server) RunOn(ctx context.Context, listener net.Listener) error {
+	slog.Info("Starting server", "address", listener.Addr())
+
+	return serveUntilDone(ctx, s.server, listener.Addr().String(), func() error {
+		return s.server.Serve(listener)
+	}, ShutdownTimeout)
+}
+
+// ServeUntilDone runs the server until the context is done and shuts it down gracefully. It returns
+// nil after a shutdown, and the error if the server could not start.
+func ServeUntilDone(ctx context.Context, server *http.Server, timeout time.Duration) error {
+	return serveUntilDone(ctx, server, server.Addr, server.ListenAndServe, timeout)
+}
+
+func serveUntilDone(ctx context.Context, server *http.Server, address string, serve func() error, timeout time.Duration) error {
+	failed := make(chan error, 1)
+
+	go func() {
+		failed <- serve()
+	}()
+
+	select {
+	case err := <-failed:
+		return fmt.Errorf("server on %s failed: %w", address, err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		// Connections that did not finish in time are dropped.
+		server.Close()
+
+		return fmt.Errorf("could not stop server on %s in time: %w", address, err)
 	}
+
+	slog.Info("Server stopped", "address", address)
+
+	return nil
 }
@@ -1,10 +1,18 @@
 package web_test
 
 import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/dasdy/glover"
+	"github.com/dasdy/glover/db"
 	"github.com/dasdy/glover/layout"
 	"github.com/dasdy/glover/model"
+	"github.com/dasdy/glover/web"
 	"github.com/dasdy/glover/web/components"
 	"github.com/dasdy/glover/web/routes"
 	"github.com/stretchr/testify/assert"
@@ -14,7 +22,9 @@ import (
 func openKeymapFile(t *testing.T, filepath string) *model.KeyboardLayout {
 	t.Helper()
 
-	file, err := layout.OpenPath(filepath)
+	fsys, name := layout.Resolve(filepath, glover.Data)
+
+	file, err := layout.Open(fsys, name)
 
 	require.NoError(t, err)
 
@@ -28,7 +38,9 @@ func openKeymapFile(t *testing.T, filepath string) *model.KeyboardLayout {
 func loadKeyNames(t *testing.T, filepath string) []string {
 	t.Helper()
 
-	keyNames, err := layout.GetKeyLabels(filepath)
+	fsys, name := layout.Resolve(filepath, glover.Data)
+
+	keyNames, err := layout.GetKeyLabels(fsys, name)
 
 	assert.NoError(t, err)
 
@@ -66,3 +78,262 @@ func TestBuildCombosContext(t *testing.T) {
 		assert.Equal(t, components.PageTypeCombo, items.Page)
 	})
 }
+
+func TestBuildServerKeyboards(t *testing.T) {
+	storage, err := db.NewStorageFromPath(t.TempDir()+"/keyboards.sqlite", false)
+	require.NoError(t, err)
+
+	defer storage.Close()
+
+	keyboard := func(name string) web.Keyboard {
+		view := storage.ForKeyboard(name, false)
+		trackers, err := db.NewTrackerSet(db.DefaultTrackers()...)
+		require.NoError(t, err)
+
+		return web.Keyboard{
+			Name:         name,
+			Storage:      view,
+			Trackers:     trackers,
+			KeymapFile:   "data/glove80.keymap",
+			InfoJSONFile: "data/info.json",
+		}
+	}
+
+	server, err := web.BuildServer([]web.Keyboard{keyboard("glove80"), keyboard("numpad")}, false, "")
+	require.NoError(t, err)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+
+		return recorder
+	}
+
+	t.Run("first keyboard is shown by default", func(t *testing.T) {
+		response := get("/")
+		require.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `href="/?keyboard=numpad"`)
+		assert.Contains(t, response.Body.String(), `href="/combo?position=0&amp;keyboard=glove80"`)
+	})
+
+	t.Run("links keep the chosen keyboard", func(t *testing.T) {
+		response := get("/neighbors?position=3&keyboard=numpad")
+		require.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `href="/?keyboard=glove80"`)
+		assert.Contains(t, response.Body.String(), `href="/neighbors?position=0&amp;keyboard=numpad"`)
+	})
+
+	t.Run("unknown keyboard", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/?keyboard=missing").Code)
+	})
+}
+
+func TestBuildServerSources(t *testing.T) {
+	union, err := db.NewUnion(
+		db.UnionMember{Name: "home", Storage: db.NewMemoryStorage()},
+		db.UnionMember{Name: "work", Storage: db.NewMemoryStorage()})
+	require.NoError(t, err)
+
+	defer union.Close()
+
+	trackers := func() *db.TrackerSet {
+		set, err := db.NewTrackerSet(db.DefaultTrackers()...)
+		require.NoError(t, err)
+
+		return set
+	}
+
+	keyboard := web.Keyboard{
+		Storage:      union,
+		Trackers:     trackers(),
+		KeymapFile:   "data/glove80.keymap",
+		InfoJSONFile: "data/info.json",
+	}
+
+	for _, name := range union.Names() {
+		view, err := union.Only(name)
+		require.NoError(t, err)
+
+		keyboard.Sources = append(keyboard.Sources, web.Source{Name: name, Storage: view, Trackers: trackers()})
+	}
+
+	server, err := web.BuildServer([]web.Keyboard{keyboard}, false, "")
+	require.NoError(t, err)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+
+		return recorder
+	}
+
+	t.Run("all sources are shown by default", func(t *testing.T) {
+		response := get("/")
+		require.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `href="/?source=home"`)
+		assert.Contains(t, response.Body.String(), `href="/?source=work"`)
+	})
+
+	t.Run("links keep the chosen source", func(t *testing.T) {
+		response := get("/combo?position=3&source=work")
+		require.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `href="/"`)
+		assert.Contains(t, response.Body.String(), `href="/combo?position=0&amp;source=work"`)
+	})
+
+	t.Run("unknown source", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/?source=missing").Code)
+	})
+}
+
+func TestServerReload(t *testing.T) {
+	storage, err := db.NewStorageFromPath(t.TempDir()+"/reload.sqlite", false)
+	require.NoError(t, err)
+
+	defer storage.Close()
+
+	trackers, err := db.NewTrackerSet(db.DefaultTrackers()...)
+	require.NoError(t, err)
+
+	keyboard := web.Keyboard{
+		Name:         "glove80",
+		Storage:      storage,
+		Trackers:     trackers,
+		KeymapFile:   "data/glove80.keymap",
+		InfoJSONFile: "data/info.json",
+	}
+
+	server, err := web.NewServer(0, []web.Keyboard{keyboard}, false, "")
+	require.NoError(t, err)
+
+	status := func(url string) int {
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+
+		return recorder.Code
+	}
+
+	t.Run("broken layout keeps the old pages", func(t *testing.T) {
+		broken := keyboard
+		broken.Name = "numpad"
+		broken.InfoJSONFile = t.TempDir() + "/missing/info.json"
+
+		require.Error(t, server.Reload([]web.Keyboard{broken}))
+		assert.Equal(t, http.StatusOK, status("/?keyboard=glove80"))
+	})
+
+	t.Run("pages are rebuilt", func(t *testing.T) {
+		renamed := keyboard
+		renamed.Name = "numpad"
+
+		require.NoError(t, server.Reload([]web.Keyboard{renamed}))
+		assert.Equal(t, http.StatusOK, status("/?keyboard=numpad"))
+		assert.Equal(t, http.StatusNotFound, status("/?keyboard=glove80"))
+	})
+}
+
+func TestServeUntilDone(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	server := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			close(started)
+			<-release
+			w.WriteHeader(http.StatusTeapot)
+		}),
+		ReadHeaderTimeout: time.Second,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+
+	go func() { stopped <- web.ServeUntilDone(ctx, server, 5*time.Second) }()
+
+	t.Run("another server can not start on the same address", func(t *testing.T) {
+		require.Eventually(t, func() bool {
+			conn, err := net.Dial("tcp", addr)
+			if err == nil {
+				conn.Close()
+			}
+
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		err := web.ServeUntilDone(context.Background(), &http.Server{Addr: addr, ReadHeaderTimeout: time.Second}, time.Second)
+		require.Error(t, err)
+	})
+
+	t.Run("requests in progress are finished", func(t *testing.T) {
+		response := make(chan int)
+
+		go func() {
+			resp, err := http.Get("http://" + addr)
+			if err != nil {
+				response <- 0
+
+				return
+			}
+
+			resp.Body.Close()
+			response <- resp.StatusCode
+		}()
+
+		<-started
+		cancel()
+
+		// The server waits for the request instead of stopping right away.
+		select {
+		case <-stopped:
+			t.Fatal("server stopped before the request finished")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+
+		assert.Equal(t, http.StatusTeapot, <-response)
+		require.NoError(t, <-stopped)
+	})
+}
+
+func TestServerRunOn(t *testing.T) {
+	storage, err := db.NewStorageFromPath(t.TempDir()+"/runon.sqlite", false)
+	require.NoError(t, err)
+
+	defer storage.Close()
+
+	trackers, err := db.NewTrackerSet(db.DefaultTrackers()...)
+	require.NoError(t, err)
+
+	server, err := web.NewServer(0, []web.Keyboard{{
+		Storage:      storage,
+		Trackers:     trackers,
+		KeymapFile:   "data/glove80.keymap",
+		InfoJSONFile: "data/info.json",
+	}}, false, "")
+	require.NoError(t, err)
+
+	// A listener opened by someone else, like a socket passed by systemd.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+
+	go func() { stopped <- server.RunOn(ctx, listener) }()
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/assets/nonexistent.css")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	cancel()
+	require.NoError(t, <-stopped)
+}